@@ -32,6 +32,16 @@ type Client struct {
 	dec  *msgpack.Decoder
 }
 
+// The exit statuses of the commands the agent couldn't start, or that
+// couldn't read their input, telling the failures that trying again won't
+// fix from the transient ones.
+const (
+	ExitUsage       = 64 // the request is invalid
+	ExitNoInput     = 66 // the source to back up is missing or unreadable
+	ExitUnavailable = 69 // the repository couldn't be opened
+	ExitConfig      = 78 // the repository or its secret is misconfigured
+)
+
 var (
 	ErrWrongVersion = errors.New("agent is running with a different version of plakar")
 )
@@ -120,6 +130,20 @@ func NewClient(socketPath string, ignoreVersion bool) (*Client, error) {
 		time.Sleep(5 * time.Millisecond)
 	}
 
+	return newClient(conn, ignoreVersion)
+}
+
+// Dial connects to a server speaking the protocol of the agent, such as
+// the scheduler, without spawning it if it isn't running.
+func Dial(socketPath string, ignoreVersion bool) (*Client, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(conn, ignoreVersion)
+}
+
+func newClient(conn net.Conn, ignoreVersion bool) (*Client, error) {
 	encoder := msgpack.NewEncoder(conn)
 	decoder := msgpack.NewDecoder(conn)
 
@@ -130,6 +154,7 @@ func NewClient(socketPath string, ignoreVersion bool) (*Client, error) {
 	}
 
 	if err := c.handshake(ignoreVersion); err != nil {
+		conn.Close()
 		return nil, err
 	}
 
@@ -159,7 +184,12 @@ func (c *Client) SendCommand(ctx *appcontext.AppContext, name []string, cmd subc
 	if cmd.GetFlags()&subcommands.AgentSupport == 0 {
 		return 1, fmt.Errorf("command %v doesn't support execution through agent", strings.Join(name, " "))
 	}
+	return c.Execute(ctx, name, cmd, storeConfig)
+}
 
+// Execute runs the command at the other end of the connection, relaying
+// its input and output until it exits.
+func (c *Client) Execute(ctx *appcontext.AppContext, name []string, cmd subcommands.Subcommand, storeConfig map[string]string) (int, error) {
 	cmd.SetLogInfo(ctx.GetLogger().EnabledInfo)
 	cmd.SetLogTraces(ctx.GetLogger().EnabledTracing)
 
@@ -198,6 +228,9 @@ func (c *Client) SendCommand(ctx *appcontext.AppContext, name []string, cmd subc
 			fmt.Printf("%s", string(response.Data))
 		case "stderr":
 			fmt.Fprintf(os.Stderr, "%s", string(response.Data))
+		case "detach":
+			// the command keeps running in the agent
+			return 0, nil
 		case "exit":
 			var err error
 			if response.Err != "" {
@@ -1,19 +1,20 @@
 package api
 
 import (
-	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/metrics"
 	"github.com/PlakarKorp/plakar/utils"
 )
 
@@ -21,6 +22,14 @@ type uiserver struct {
 	store      storage.Store
 	config     storage.Configuration
 	repository *repository.Repository
+	jobs       *jobManager
+	events     *eventBroker
+	auth       *authenticator
+	stats      *repositoryStats
+
+	// restoreRoot is the directory the tokens without the admin scope
+	// restore to, they can't restore anywhere without one.
+	restoreRoot string
 
 	// XXX: Adding this for transition, it needs to go away. Some
 	// places we only have Repository and out of AppContext we
@@ -38,6 +47,12 @@ type Items[T any] struct {
 	Items []T `json:"items"`
 }
 
+type ItemsCursor[T any] struct {
+	Total int    `json:"total"`
+	Items []T    `json:"items"`
+	Next  string `json:"next,omitempty"`
+}
+
 type ItemsPage[T any] struct {
 	HasNext bool `json:"has_next"`
 	Items   []T  `json:"items"`
@@ -101,28 +116,6 @@ func (view APIView) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// TokenAuthMiddleware is a middleware that checks for the token in the request. If the token is empty, the middleware is a no-op.
-func TokenAuthMiddleware(token string) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if token != "" {
-				key := r.Header.Get("Authorization")
-				if key == "" {
-					handleError(w, r, authError("missing Authorization header"))
-					return
-				}
-
-				if subtle.ConstantTimeCompare([]byte(key), []byte("Bearer "+token)) == 0 {
-					handleError(w, r, authError("invalid token"))
-					return
-				}
-			}
-
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
 func (ui *uiserver) apiInfo(w http.ResponseWriter, r *http.Request) error {
 	authenticated := false
 	configuration := ui.config
@@ -137,12 +130,16 @@ func (ui *uiserver) apiInfo(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	id := requestIdentity(r)
+
 	res := &struct {
-		RepositoryId  string `json:"repository_id"`
-		Authenticated bool   `json:"authenticated"`
-		Version       string `json:"version"`
-		Browsable     bool   `json:"browsable"`
-		DemoMode      bool   `json:"demo_mode"`
+		RepositoryId  string   `json:"repository_id"`
+		Authenticated bool     `json:"authenticated"`
+		Version       string   `json:"version"`
+		Browsable     bool     `json:"browsable"`
+		DemoMode      bool     `json:"demo_mode"`
+		User          string   `json:"user,omitempty"`
+		Scopes        []string `json:"scopes,omitempty"`
 	}{
 		RepositoryId:  configuration.RepositoryID.String(),
 		Authenticated: authenticated,
@@ -150,73 +147,107 @@ func (ui *uiserver) apiInfo(w http.ResponseWriter, r *http.Request) error {
 		Browsable:     mode&storage.ModeRead != 0,
 		DemoMode:      isDemoMode,
 	}
+	if id != nil {
+		res.User = id.name
+		res.Scopes = id.scopes()
+	}
 	return json.NewEncoder(w).Encode(res)
 }
 
-func SetupRoutes(server *http.ServeMux, repo *repository.Repository, ctx *appcontext.AppContext, token string) {
+func SetupRoutes(server *http.ServeMux, repo *repository.Repository, ctx *appcontext.AppContext, token, restoreRoot string) {
 	ui := uiserver{
-		store:      repo.Store(),
-		config:     repo.Configuration(),
-		repository: repo,
-		ctx:        ctx,
+		store:       repo.Store(),
+		config:      repo.Configuration(),
+		repository:  repo,
+		jobs:        newJobManager(),
+		events:      newEventBroker(),
+		stats:       &repositoryStats{},
+		restoreRoot: restoreRoot,
+		ctx:         ctx,
+	}
+
+	// Without a configuration directory, as in the tests, there are no
+	// tokens besides the session one and nothing is audited.
+	var tokensFile, auditFile string
+	if ctx.ConfigDir != "" {
+		tokensFile = filepath.Join(ctx.ConfigDir, "tokens.yml")
+		auditFile = filepath.Join(ctx.ConfigDir, "audit.log")
 	}
+	ui.auth = newAuthenticator(token, tokensFile, newAuditLog(auditFile, ui.config.RepositoryID.String()))
 
-	authToken := TokenAuthMiddleware(token)
+	browse := ui.auth.require(utils.ScopeBrowse)
+	download := ui.auth.require(utils.ScopeDownload)
+	restore := ui.auth.require(utils.ScopeRestore)
+	admin := ui.auth.require(utils.ScopeAdmin)
 	urlSigner := NewSnapshotReaderURLSigner(&ui, token)
 
 	// Catch all API endpoint, called if no more specific API endpoint is found
-	server.Handle("/api/", JSONAPIView(func(w http.ResponseWriter, r *http.Request) error {
+	server.Handle("/api/", ui.auth.audited(JSONAPIView(func(w http.ResponseWriter, r *http.Request) error {
 		return &ApiError{
 			HttpCode: 404,
 			ErrCode:  "not-found",
 			Message:  "API endpoint not found",
 		}
-	}))
+	})))
 
 	isDemoMode, _ := strconv.ParseBool(os.Getenv("PLAKAR_DEMO_MODE"))
 
-	server.Handle("GET /api/info", authToken(JSONAPIView(ui.apiInfo)))
+	server.Handle("GET /api/info", browse(JSONAPIView(ui.apiInfo)))
+	server.Handle("GET /metrics", browse(metrics.Default.Handler(ui.collectRepository)))
 
 	// The demo mode is the read-only mode of the API available at demo.plakar.io. Disable the write operations.
 	if !isDemoMode {
-		server.Handle("POST /api/authentication/login/github", authToken(JSONAPIView(ui.servicesLoginGithub)))
-		server.Handle("POST /api/authentication/login/email", authToken(JSONAPIView(ui.servicesLoginEmail)))
-		server.Handle("POST /api/authentication/logout", authToken(JSONAPIView(ui.servicesLogout)))
-
-		server.Handle("POST /api/proxy/v1/account/notifications/set-status", authToken(JSONAPIView(ui.servicesProxy)))
-		server.Handle("PUT /api/proxy/v1/account/services/alerting", authToken(JSONAPIView(ui.servicesSetAlertingServiceConfiguration)))
-
-		server.Handle("POST /api/integrations/install", authToken(JSONAPIView(ui.integrationsInstall)))
-		server.Handle("DELETE /api/integrations/{id}", authToken(JSONAPIView(ui.integrationsUninstall)))
+		server.Handle("POST /api/authentication/login/github", admin(JSONAPIView(ui.servicesLoginGithub)))
+		server.Handle("POST /api/authentication/login/email", admin(JSONAPIView(ui.servicesLoginEmail)))
+		server.Handle("POST /api/authentication/logout", admin(JSONAPIView(ui.servicesLogout)))
+
+		server.Handle("POST /api/proxy/v1/account/notifications/set-status", admin(JSONAPIView(ui.servicesProxy)))
+		server.Handle("PUT /api/proxy/v1/account/services/alerting", admin(JSONAPIView(ui.servicesSetAlertingServiceConfiguration)))
+
+		server.Handle("POST /api/integrations/install", admin(JSONAPIView(ui.integrationsInstall)))
+		server.Handle("DELETE /api/integrations/{id}", admin(JSONAPIView(ui.integrationsUninstall)))
+
+		server.Handle("POST /api/jobs/backup", admin(JSONAPIView(ui.jobBackup)))
+		server.Handle("POST /api/jobs/restore", restore(JSONAPIView(ui.jobRestore)))
+		server.Handle("POST /api/jobs/check", admin(JSONAPIView(ui.jobCheck)))
+		server.Handle("POST /api/jobs/sync", admin(JSONAPIView(ui.jobSync)))
+		server.Handle("POST /api/jobs/prune", admin(JSONAPIView(ui.jobPrune)))
+		server.Handle("POST /api/jobs/{id}/cancel", restore(JSONAPIView(ui.jobCancel)))
 	}
 
-	server.Handle("GET /api/proxy/v1/account/me", authToken(JSONAPIView(ui.servicesProxy)))
-	server.Handle("GET /api/proxy/v1/account/notifications", authToken(JSONAPIView(ui.servicesProxy)))
-	server.Handle("GET /api/proxy/v1/account/services/alerting", authToken(JSONAPIView(ui.servicesGetAlertingServiceConfiguration)))
-	server.Handle("GET /api/proxy/v1/reporting/reports", authToken(JSONAPIView(ui.servicesProxy)))
-	server.Handle("GET /api/proxy/v1/integration", authToken(JSONAPIView(ui.servicesGetIntegration)))
-	server.Handle("GET /api/proxy/v1/integration/{id}", authToken(JSONAPIView(ui.servicesGetIntegrationId)))
-	server.Handle("GET /api/proxy/v1/integration/{id}/{path...}", authToken(JSONAPIView(ui.servicesGetIntegrationPath)))
-
-	server.Handle("GET /api/repository/info", authToken(JSONAPIView(ui.repositoryInfo)))
-	server.Handle("GET /api/repository/snapshots", authToken(JSONAPIView(ui.repositorySnapshots)))
-	server.Handle("GET /api/repository/locate-pathname", authToken(JSONAPIView(ui.repositoryLocatePathname)))
-	server.Handle("GET /api/repository/importer-types", authToken(JSONAPIView(ui.repositoryImporterTypes)))
-	server.Handle("GET /api/repository/states", authToken(JSONAPIView(ui.repositoryStates)))
-	server.Handle("GET /api/repository/state/{state}", authToken(JSONAPIView(ui.repositoryState)))
-
-	server.Handle("GET /api/snapshot/{snapshot}", authToken(JSONAPIView(ui.snapshotHeader)))
+	server.Handle("GET /api/proxy/v1/account/me", admin(JSONAPIView(ui.servicesProxy)))
+	server.Handle("GET /api/proxy/v1/account/notifications", admin(JSONAPIView(ui.servicesProxy)))
+	server.Handle("GET /api/proxy/v1/account/services/alerting", admin(JSONAPIView(ui.servicesGetAlertingServiceConfiguration)))
+	server.Handle("GET /api/proxy/v1/reporting/reports", admin(JSONAPIView(ui.servicesProxy)))
+	server.Handle("GET /api/proxy/v1/integration", admin(JSONAPIView(ui.servicesGetIntegration)))
+	server.Handle("GET /api/proxy/v1/integration/{id}", admin(JSONAPIView(ui.servicesGetIntegrationId)))
+	server.Handle("GET /api/proxy/v1/integration/{id}/{path...}", admin(JSONAPIView(ui.servicesGetIntegrationPath)))
+
+	server.Handle("GET /api/jobs", browse(JSONAPIView(ui.jobsList)))
+	server.Handle("GET /api/jobs/{id}", browse(JSONAPIView(ui.jobStatus)))
+	server.Handle("GET /api/events", browse(APIView(ui.eventsStream)))
+
+	server.Handle("GET /api/repository/info", browse(JSONAPIView(ui.repositoryInfo)))
+	server.Handle("GET /api/repository/snapshots", browse(JSONAPIView(ui.repositorySnapshots)))
+	server.Handle("GET /api/repository/locate-pathname", browse(JSONAPIView(ui.repositoryLocatePathname)))
+	server.Handle("GET /api/repository/importer-types", browse(JSONAPIView(ui.repositoryImporterTypes)))
+	server.Handle("GET /api/repository/states", browse(JSONAPIView(ui.repositoryStates)))
+	server.Handle("GET /api/repository/state/{state}", browse(JSONAPIView(ui.repositoryState)))
+
+	server.Handle("GET /api/snapshot/{snapshot}", browse(JSONAPIView(ui.snapshotHeader)))
 	server.Handle("GET /api/snapshot/reader/{snapshot_path...}", urlSigner.VerifyMiddleware(APIView(ui.snapshotReader)))
-	server.Handle("POST /api/snapshot/reader-sign-url/{snapshot_path...}", authToken(JSONAPIView(urlSigner.Sign)))
+	server.Handle("POST /api/snapshot/reader-sign-url/{snapshot_path...}", download(JSONAPIView(urlSigner.Sign)))
+
+	server.Handle("GET /api/snapshot/diff/{a}/{b}/{path...}", browse(JSONAPIView(ui.snapshotDiff)))
 
-	server.Handle("GET /api/snapshot/vfs/{snapshot_path...}", authToken(JSONAPIView(ui.snapshotVFSBrowse)))
-	server.Handle("GET /api/snapshot/vfs/children/{snapshot_path...}", authToken(JSONAPIView(ui.snapshotVFSChildren)))
-	server.Handle("GET /api/snapshot/vfs/chunks/{snapshot_path...}", authToken(JSONAPIView(ui.snapshotVFSChunks)))
-	server.Handle("GET /api/snapshot/vfs/search/{snapshot_path...}", authToken(JSONAPIView(ui.snapshotVFSSearch)))
-	server.Handle("GET /api/snapshot/vfs/errors/{snapshot_path...}", authToken(JSONAPIView(ui.snapshotVFSErrors)))
+	server.Handle("GET /api/snapshot/vfs/{snapshot_path...}", browse(JSONAPIView(ui.snapshotVFSBrowse)))
+	server.Handle("GET /api/snapshot/vfs/children/{snapshot_path...}", browse(JSONAPIView(ui.snapshotVFSChildren)))
+	server.Handle("GET /api/snapshot/vfs/chunks/{snapshot_path...}", browse(JSONAPIView(ui.snapshotVFSChunks)))
+	server.Handle("GET /api/snapshot/vfs/search/{snapshot_path...}", browse(JSONAPIView(ui.snapshotVFSSearch)))
+	server.Handle("GET /api/snapshot/vfs/errors/{snapshot_path...}", browse(JSONAPIView(ui.snapshotVFSErrors)))
 
-	server.Handle("POST /api/snapshot/vfs/downloader/{snapshot_path...}", authToken(JSONAPIView(ui.snapshotVFSDownloader)))
-	server.Handle("GET /api/snapshot/vfs/downloader-sign-url/{id}", JSONAPIView(ui.snapshotVFSDownloaderSigned))
+	server.Handle("POST /api/snapshot/vfs/downloader/{snapshot_path...}", download(JSONAPIView(ui.snapshotVFSDownloader)))
+	server.Handle("GET /api/snapshot/vfs/downloader-sign-url/{id}", ui.auth.audited(JSONAPIView(ui.snapshotVFSDownloaderSigned)))
 }
 
 func (ui *uiserver) reloadPlugins() {
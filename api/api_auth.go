@@ -0,0 +1,344 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+const (
+	// The users behind the session token of plakar ui, and behind every
+	// request when it runs with -no-auth. Token names can't start with
+	// an @.
+	sessionUser   = "@ui"
+	anonymousUser = "@anonymous"
+)
+
+// identity is the user behind a request, either a token of the
+// configuration or, when token is nil, the session token of plakar ui
+// which is granted every scope.
+type identity struct {
+	name  string
+	token *utils.APIToken
+}
+
+func (id *identity) username() string {
+	if id == nil {
+		return ""
+	}
+	return id.name
+}
+
+func (id *identity) scopes() []string {
+	if id.token == nil {
+		return utils.APITokenScopes
+	}
+	return id.token.Scopes
+}
+
+func (id *identity) hasScope(scope string) bool {
+	return id == nil || id.token == nil || id.token.HasScope(scope)
+}
+
+// checkPath refuses the paths outside of the prefixes the token is
+// restricted to. Handlers are only reached without an identity in the
+// tests, where nothing is restricted.
+func (id *identity) checkPath(pathname string) error {
+	if id == nil || id.token == nil || id.token.AllowsPath(pathname) {
+		return nil
+	}
+	return forbiddenError(fmt.Sprintf("path %q is out of the reach of the token", path.Clean("/"+pathname)))
+}
+
+// checkPaths is checkPath for a selection of an archive, where no path
+// at all stands for the whole snapshot.
+func (id *identity) checkPaths(pathnames []string) error {
+	if len(pathnames) == 0 {
+		return id.checkPath("/")
+	}
+	for _, pathname := range pathnames {
+		if err := id.checkPath(pathname); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type identityKey struct{}
+
+func requestIdentity(r *http.Request) *identity {
+	id, _ := r.Context().Value(identityKey{}).(*identity)
+	return id
+}
+
+// withIdentity attaches the identity to the request, and to its record
+// in the audit log.
+func withIdentity(r *http.Request, id *identity) *http.Request {
+	if rec, ok := r.Context().Value(auditKey{}).(*AuditRecord); ok {
+		rec.User = id.name
+	}
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+}
+
+// authenticator checks the requests against the session token of plakar
+// ui and the tokens of the configuration, which are reloaded whenever
+// plakar token changes them.
+type authenticator struct {
+	token      string
+	tokensFile string
+	audit      *auditLog
+
+	mtx     sync.Mutex
+	modTime time.Time
+	tokens  map[string]*utils.APIToken
+}
+
+func newAuthenticator(token, tokensFile string, audit *auditLog) *authenticator {
+	return &authenticator{
+		token:      token,
+		tokensFile: tokensFile,
+		audit:      audit,
+	}
+}
+
+func (a *authenticator) loadTokens() (map[string]*utils.APIToken, error) {
+	if a.tokensFile == "" {
+		return nil, nil
+	}
+
+	info, err := os.Stat(a.tokensFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.tokens == nil || !info.ModTime().Equal(a.modTime) {
+		cfg, err := utils.LoadTokensConfigFile(a.tokensFile)
+		if err != nil {
+			return nil, err
+		}
+		a.tokens, a.modTime = cfg.Tokens, info.ModTime()
+	}
+	return a.tokens, nil
+}
+
+func (a *authenticator) authenticate(r *http.Request) (*identity, error) {
+	if a.token == "" {
+		return &identity{name: anonymousUser}, nil
+	}
+
+	key := r.Header.Get("Authorization")
+	if key == "" {
+		return nil, authError("missing Authorization header")
+	}
+	secret, ok := strings.CutPrefix(key, "Bearer ")
+	if !ok {
+		return nil, authError("invalid token")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(a.token)) == 1 {
+		return &identity{name: sessionUser}, nil
+	}
+
+	tokens, err := a.loadTokens()
+	if err != nil {
+		return nil, err
+	}
+	for name, token := range tokens {
+		if !token.Matches(secret) {
+			continue
+		}
+		if token.Expired() {
+			return nil, authError("token expired")
+		}
+		return &identity{name: name, token: token}, nil
+	}
+	return nil, authError("invalid token")
+}
+
+// revalidate looks the user a link was signed for up again, as the token
+// may have been revoked or have expired since.
+func (a *authenticator) revalidate(name, scope string) (*identity, error) {
+	var id *identity
+	switch name {
+	case "":
+		if a.token != "" {
+			return nil, authError("link not bound to a user")
+		}
+		id = &identity{name: anonymousUser}
+	case sessionUser, anonymousUser:
+		id = &identity{name: name}
+	default:
+		tokens, err := a.loadTokens()
+		if err != nil {
+			return nil, err
+		}
+		token, ok := tokens[name]
+		if !ok {
+			return nil, authError("token revoked")
+		}
+		if token.Expired() {
+			return nil, authError("token expired")
+		}
+		id = &identity{name: name, token: token}
+	}
+
+	if !id.hasScope(scope) {
+		return nil, forbiddenError(fmt.Sprintf("token lacks the %s scope", scope))
+	}
+	return id, nil
+}
+
+// authorize lets the requests with the scope through, provided the
+// snapshot path they target is within the reach of their token.
+func (a *authenticator) authorize(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.authenticate(r)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+		r = withIdentity(r, id)
+
+		if !id.hasScope(scope) {
+			handleError(w, r, forbiddenError(fmt.Sprintf("token lacks the %s scope", scope)))
+			return
+		}
+
+		if snapshotPath := r.PathValue("snapshot_path"); snapshotPath != "" {
+			_, pathname := utils.ParseSnapshotID(snapshotPath)
+			if err := id.checkPath(pathname); err != nil {
+				handleError(w, r, err)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// require returns a middleware authorizing and auditing the requests.
+func (a *authenticator) require(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return a.audited(a.authorize(scope, next))
+	}
+}
+
+// AuditRecord is a line of the audit log, written for every request to
+// the API.
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	Repository string    `json:"repository"`
+	User       string    `json:"user"`
+	Remote     string    `json:"remote"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Duration   int64     `json:"duration_ms"`
+}
+
+type auditKey struct{}
+
+// auditLog appends the records as JSON lines to a file, which isn't
+// opened until the first request.
+type auditLog struct {
+	filename   string
+	repository string
+
+	mtx sync.Mutex
+	fp  *os.File
+}
+
+func newAuditLog(filename, repository string) *auditLog {
+	return &auditLog{filename: filename, repository: repository}
+}
+
+func (l *auditLog) write(rec *AuditRecord) error {
+	if l.filename == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.fp == nil {
+		l.fp, err = os.OpenFile(l.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = l.fp.Write(append(data, '\n'))
+	return err
+}
+
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *auditResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the Flush of the underlying
+// writer for the event stream.
+func (w *auditResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// audited records the requests once served, with the user that
+// withIdentity attached to them if they got that far.
+func (a *authenticator) audited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &AuditRecord{
+			Time:       time.Now(),
+			Repository: a.audit.repository,
+			User:       "-",
+			Remote:     r.RemoteAddr,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+		}
+		aw := &auditResponseWriter{ResponseWriter: w}
+
+		next.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), auditKey{}, rec)))
+
+		rec.Status = aw.status
+		if rec.Status == 0 {
+			rec.Status = http.StatusOK
+		}
+		rec.Duration = time.Since(rec.Time).Milliseconds()
+		if err := a.audit.write(rec); err != nil {
+			log.Printf("failed to write the audit log: %v", err)
+		}
+	})
+}
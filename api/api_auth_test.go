@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAuthenticator(t *testing.T) (*authenticator, map[string]string) {
+	dir := t.TempDir()
+	tokensFile := filepath.Join(dir, "tokens.yml")
+
+	cfg, err := utils.LoadTokensConfigFile(tokensFile)
+	require.NoError(t, err)
+
+	secrets := make(map[string]string)
+	add := func(name string, scopes, paths []string, expires time.Time) {
+		secret, token, err := utils.NewAPIToken(scopes, paths, expires)
+		require.NoError(t, err)
+		cfg.Add(name, token)
+		secrets[name] = secret
+	}
+	add("browser", []string{utils.ScopeBrowse}, nil, time.Time{})
+	add("alice", []string{utils.ScopeDownload}, []string{"/home/alice"}, time.Now().Add(time.Hour))
+	add("expired", []string{utils.ScopeAdmin}, nil, time.Now().Add(-time.Hour))
+	require.NoError(t, cfg.SaveToFile(tokensFile))
+
+	audit := newAuditLog(filepath.Join(dir, "audit.log"), "repo")
+	return newAuthenticator("session", tokensFile, audit), secrets
+}
+
+func TestAuthenticatorScopes(t *testing.T) {
+	auth, secrets := newTestAuthenticator(t)
+
+	mux := http.NewServeMux()
+	ok := JSONAPIView(func(w http.ResponseWriter, r *http.Request) error {
+		return json.NewEncoder(w).Encode(requestIdentity(r).username())
+	})
+	mux.Handle("GET /browse/{snapshot_path...}", auth.require(utils.ScopeBrowse)(ok))
+	mux.Handle("POST /download/{snapshot_path...}", auth.require(utils.ScopeDownload)(ok))
+	mux.Handle("POST /admin", auth.require(utils.ScopeAdmin)(ok))
+	signer := NewSnapshotReaderURLSigner(&uiserver{auth: auth}, "session")
+	mux.Handle("GET /reader/{snapshot_path...}", signer.VerifyMiddleware(ok))
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		key    string
+		status int
+	}{
+		{"no token", "GET", "/browse/abcd", "", http.StatusUnauthorized},
+		{"bad token", "GET", "/browse/abcd", "Bearer nope", http.StatusUnauthorized},
+		{"session", "POST", "/admin", "Bearer session", http.StatusOK},
+		{"browse", "GET", "/browse/abcd", "Bearer " + secrets["browser"], http.StatusOK},
+		{"browse cannot download", "POST", "/download/abcd", "Bearer " + secrets["browser"], http.StatusForbidden},
+		{"download includes browse", "GET", "/browse/abcd:/home/alice/a.txt", "Bearer " + secrets["alice"], http.StatusOK},
+		{"outside of the paths", "GET", "/browse/abcd:/home/bob", "Bearer " + secrets["alice"], http.StatusForbidden},
+		{"whole snapshot", "POST", "/download/abcd", "Bearer " + secrets["alice"], http.StatusForbidden},
+		{"not admin", "POST", "/admin", "Bearer " + secrets["alice"], http.StatusForbidden},
+		{"expired", "POST", "/admin", "Bearer " + secrets["expired"], http.StatusUnauthorized},
+		{"browse cannot read", "GET", "/reader/abcd:/a.txt", "Bearer " + secrets["browser"], http.StatusForbidden},
+		{"browse cannot read inline", "GET", "/reader/abcd:/a.txt?download=false", "Bearer " + secrets["browser"], http.StatusForbidden},
+		{"download reads", "GET", "/reader/abcd:/home/alice/a.txt", "Bearer " + secrets["alice"], http.StatusOK},
+		{"download reads within the paths", "GET", "/reader/abcd:/home/bob/a.txt", "Bearer " + secrets["alice"], http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.target, nil)
+			if test.key != "" {
+				req.Header.Set("Authorization", test.key)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			require.Equal(t, test.status, w.Code)
+		})
+	}
+}
+
+func TestAuthenticatorReload(t *testing.T) {
+	auth, secrets := newTestAuthenticator(t)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+secrets["browser"])
+	id, err := auth.authenticate(req)
+	require.NoError(t, err)
+	require.Equal(t, "browser", id.name)
+
+	cfg, err := utils.LoadTokensConfigFile(auth.tokensFile)
+	require.NoError(t, err)
+	cfg.Remove("browser")
+	require.NoError(t, cfg.SaveToFile(auth.tokensFile))
+	// make sure the modification time changes on coarse filesystems
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(auth.tokensFile, later, later))
+
+	_, err = auth.authenticate(req)
+	require.Error(t, err)
+	_, err = auth.revalidate("browser", utils.ScopeBrowse)
+	require.Error(t, err)
+
+	id, err = auth.revalidate("alice", utils.ScopeDownload)
+	require.NoError(t, err)
+	require.Error(t, id.checkPath("/etc"))
+	_, err = auth.revalidate("alice", utils.ScopeAdmin)
+	require.Error(t, err)
+
+	_, err = auth.revalidate("", utils.ScopeDownload)
+	require.Error(t, err)
+}
+
+func TestAuthenticatorNoAuth(t *testing.T) {
+	auth := newAuthenticator("", "", newAuditLog("", ""))
+
+	id, err := auth.authenticate(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err)
+	require.Equal(t, anonymousUser, id.name)
+	require.True(t, id.hasScope(utils.ScopeAdmin))
+	require.NoError(t, id.checkPath("/"))
+}
+
+func TestAuditLog(t *testing.T) {
+	auth, secrets := newTestAuthenticator(t)
+
+	handler := auth.require(utils.ScopeBrowse)(JSONAPIView(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	}))
+
+	req := httptest.NewRequest("GET", "/api/snapshot/vfs/abcd?offset=10", nil)
+	req.Header.Set("Authorization", "Bearer "+secrets["browser"])
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/api/repository/info", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	fp, err := os.Open(auth.audit.filename)
+	require.NoError(t, err)
+	defer fp.Close()
+
+	info, err := fp.Stat()
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		var rec AuditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		records = append(records, rec)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, records, 2)
+
+	require.Equal(t, "browser", records[0].User)
+	require.Equal(t, "repo", records[0].Repository)
+	require.Equal(t, "GET", records[0].Method)
+	require.Equal(t, "/api/snapshot/vfs/abcd", records[0].Path)
+	require.Equal(t, http.StatusOK, records[0].Status)
+
+	require.Equal(t, "-", records[1].User)
+	require.Equal(t, http.StatusUnauthorized, records[1].Status)
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"cmp"
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+)
+
+var (
+	ErrInvalidCursor = errors.New("Invalid cursor")
+	ErrInvalidType   = errors.New("Invalid type, valid values are regular, directory, symlink, device, pipe, socket and file")
+)
+
+// childrenFilter selects the entries of a directory listing.
+type childrenFilter struct {
+	name    string
+	types   []string
+	exts    []string
+	minSize int64
+	maxSize int64
+	after   time.Time
+	before  time.Time
+}
+
+// queryParamList returns the values of a parameter that is either
+// repeated or given as a comma-separated list.
+func queryParamList(r *http.Request, param string) []string {
+	var values []string
+	for _, value := range r.URL.Query()[param] {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+func queryParamToTime(r *http.Request, param string) (time.Time, error) {
+	str := r.URL.Query().Get(param)
+	if str == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return time.Time{}, parameterError(param, InvalidArgument, err)
+	}
+	return t, nil
+}
+
+func parseChildrenFilter(r *http.Request) (*childrenFilter, error) {
+	var err error
+	filter := &childrenFilter{}
+
+	filter.name = r.URL.Query().Get("name")
+	if _, err := path.Match(filter.name, ""); err != nil {
+		return nil, parameterError("name", InvalidArgument, err)
+	}
+
+	for _, typ := range queryParamList(r, "type") {
+		switch typ {
+		case "regular", "directory", "symlink", "device", "pipe", "socket", "file":
+			filter.types = append(filter.types, typ)
+		default:
+			return nil, parameterError("type", InvalidArgument, ErrInvalidType)
+		}
+	}
+
+	for _, ext := range queryParamList(r, "ext") {
+		filter.exts = append(filter.exts, "."+strings.ToLower(strings.TrimPrefix(ext, ".")))
+	}
+
+	if filter.minSize, err = QueryParamToInt64(r, "min_size", 0, 0); err != nil {
+		return nil, err
+	}
+	if filter.maxSize, err = QueryParamToInt64(r, "max_size", 0, -1); err != nil {
+		return nil, err
+	}
+	if filter.after, err = queryParamToTime(r, "mtime_after"); err != nil {
+		return nil, err
+	}
+	if filter.before, err = queryParamToTime(r, "mtime_before"); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func (filter *childrenFilter) match(info *objects.FileInfo) bool {
+	if filter.name != "" {
+		if ok, _ := path.Match(filter.name, info.Name()); !ok {
+			return false
+		}
+	}
+	if len(filter.types) != 0 && !slices.Contains(filter.types, info.Type()) {
+		return false
+	}
+	if len(filter.exts) != 0 && !slices.Contains(filter.exts, strings.ToLower(path.Ext(info.Name()))) {
+		return false
+	}
+	if info.Size() < filter.minSize {
+		return false
+	}
+	if filter.maxSize >= 0 && info.Size() > filter.maxSize {
+		return false
+	}
+	if !filter.after.IsZero() && info.ModTime().Before(filter.after) {
+		return false
+	}
+	if !filter.before.IsZero() && !info.ModTime().Before(filter.before) {
+		return false
+	}
+	return true
+}
+
+// compareFileInfos orders two entries of a directory by the given sort
+// keys, as returned by objects.ParseFileInfoSortKeys, falling back to the
+// name which is unique in a directory.
+func compareFileInfos(a, b *objects.FileInfo, sortKeys []string) int {
+	for _, key := range sortKeys {
+		field, desc := strings.CutPrefix(key, "-")
+
+		var c int
+		switch field {
+		case "Name":
+			c = strings.Compare(a.Lname, b.Lname)
+		case "Size":
+			c = cmp.Compare(a.Lsize, b.Lsize)
+		case "Mode":
+			c = cmp.Compare(a.Lmode, b.Lmode)
+		case "ModTime":
+			c = a.LmodTime.Compare(b.LmodTime)
+		case "Dev":
+			c = cmp.Compare(a.Ldev, b.Ldev)
+		case "Ino":
+			c = cmp.Compare(a.Lino, b.Lino)
+		case "Uid":
+			c = cmp.Compare(a.Luid, b.Luid)
+		case "Gid":
+			c = cmp.Compare(a.Lgid, b.Lgid)
+		case "Nlink":
+			c = cmp.Compare(a.Lnlink, b.Lnlink)
+		case "Username":
+			c = strings.Compare(a.Lusername, b.Lusername)
+		case "Groupname":
+			c = strings.Compare(a.Lgroupname, b.Lgroupname)
+		}
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a.Lname, b.Lname)
+}
+
+// childrenCursor is where a listing resumes: right after the last entry
+// returned, in the order it was sorted in.
+type childrenCursor struct {
+	Sort string           `json:"sort"`
+	Last objects.FileInfo `json:"last"`
+}
+
+func encodeChildrenCursor(sortKeys []string, last *objects.FileInfo) (string, error) {
+	data, err := json.Marshal(childrenCursor{Sort: strings.Join(sortKeys, ","), Last: *last})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeChildrenCursor(token string, sortKeys []string) (*objects.FileInfo, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor childrenCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Sort != strings.Join(sortKeys, ",") {
+		return nil, fmt.Errorf("%w: it was returned for another sort order", ErrInvalidCursor)
+	}
+	return &cursor.Last, nil
+}
+
+// firstEntries keeps the n first entries it is given in the listing
+// order, so that a page can be sorted without holding the whole
+// directory in memory.
+type firstEntries struct {
+	sortKeys []string
+	n        int
+	entries  []*vfs.Entry
+}
+
+func newFirstEntries(sortKeys []string, n int) *firstEntries {
+	return &firstEntries{sortKeys: sortKeys, n: n}
+}
+
+func (f *firstEntries) compare(a, b *vfs.Entry) int {
+	return compareFileInfos(a.Stat(), b.Stat(), f.sortKeys)
+}
+
+// the heap is ordered with the last entry on top, to evict it first
+func (f *firstEntries) Len() int           { return len(f.entries) }
+func (f *firstEntries) Less(i, j int) bool { return f.compare(f.entries[i], f.entries[j]) > 0 }
+func (f *firstEntries) Swap(i, j int)      { f.entries[i], f.entries[j] = f.entries[j], f.entries[i] }
+func (f *firstEntries) Push(x any)         { f.entries = append(f.entries, x.(*vfs.Entry)) }
+func (f *firstEntries) Pop() any {
+	last := f.entries[len(f.entries)-1]
+	f.entries = f.entries[:len(f.entries)-1]
+	return last
+}
+
+func (f *firstEntries) add(entry *vfs.Entry) {
+	if f.n <= 0 {
+		return
+	}
+	if len(f.entries) < f.n {
+		heap.Push(f, entry)
+	} else if f.compare(entry, f.entries[0]) < 0 {
+		f.entries[0] = entry
+		heap.Fix(f, 0)
+	}
+}
+
+func (f *firstEntries) sorted() []*vfs.Entry {
+	slices.SortFunc(f.entries, f.compare)
+	return f.entries
+}
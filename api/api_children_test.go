@@ -0,0 +1,148 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func childEntry(name string, size int64, mode os.FileMode, mtime time.Time) *vfs.Entry {
+	return &vfs.Entry{
+		ParentPath: "/dir",
+		FileInfo:   objects.NewFileInfo(name, size, mode, mtime, 0, 0, 0, 0, 1),
+	}
+}
+
+func childrenRequest(t *testing.T, query string) *http.Request {
+	req, err := http.NewRequest("GET", "/api/snapshot/vfs/children/abc:/dir?"+query, nil)
+	require.NoError(t, err)
+	return req
+}
+
+func TestChildrenFilter(t *testing.T) {
+	now := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	report := childEntry("Report.PDF", 2048, 0644, now.Add(-48*time.Hour)).Stat()
+	notes := childEntry("notes.txt", 10, 0644, now).Stat()
+	photos := childEntry("photos", 4096, os.ModeDir|0755, now).Stat()
+	link := childEntry("latest.txt", 9, os.ModeSymlink|0777, now).Stat()
+
+	tests := []struct {
+		query   string
+		matches []*objects.FileInfo
+	}{
+		{"", []*objects.FileInfo{report, notes, photos, link}},
+		{"name=*.txt", []*objects.FileInfo{notes, link}},
+		{"type=regular", []*objects.FileInfo{report, notes}},
+		{"type=directory,symlink", []*objects.FileInfo{photos, link}},
+		{"type=directory&type=symlink", []*objects.FileInfo{photos, link}},
+		{"ext=pdf", []*objects.FileInfo{report}},
+		{"ext=.txt&type=regular", []*objects.FileInfo{notes}},
+		{"min_size=100", []*objects.FileInfo{report, photos}},
+		{"min_size=10&max_size=2048", []*objects.FileInfo{report, notes}},
+		{"mtime_before=2025-07-01T00:00:00Z", []*objects.FileInfo{report}},
+		{"mtime_after=2025-07-01T00:00:00Z", []*objects.FileInfo{notes, photos, link}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			filter, err := parseChildrenFilter(childrenRequest(t, test.query))
+			require.NoError(t, err)
+
+			var matches []*objects.FileInfo
+			for _, info := range []*objects.FileInfo{report, notes, photos, link} {
+				if filter.match(info) {
+					matches = append(matches, info)
+				}
+			}
+			require.Equal(t, test.matches, matches)
+		})
+	}
+}
+
+func TestChildrenFilterErrors(t *testing.T) {
+	for _, query := range []string{
+		"name=[",
+		"type=fifo",
+		"min_size=-1",
+		"max_size=big",
+		"mtime_after=yesterday",
+	} {
+		t.Run(query, func(t *testing.T) {
+			_, err := parseChildrenFilter(childrenRequest(t, query))
+			require.Error(t, err)
+			require.IsType(t, &ApiError{}, err)
+			param, _, _ := strings.Cut(query, "=")
+			require.Contains(t, err.(*ApiError).Params, param)
+		})
+	}
+}
+
+func TestChildrenPages(t *testing.T) {
+	now := time.Now()
+	var entries []*vfs.Entry
+	for i, name := range []string{"e", "b", "d", "a", "c", "f"} {
+		entries = append(entries, childEntry(name, int64(i%3), 0644, now.Add(time.Duration(i)*time.Second)))
+	}
+
+	list := func(sort string, limit int) []string {
+		sortKeys, err := objects.ParseFileInfoSortKeys(sort)
+		require.NoError(t, err)
+
+		var names []string
+		var cursor *objects.FileInfo
+		for {
+			first := newFirstEntries(sortKeys, limit+1)
+			for _, entry := range entries {
+				if cursor == nil || compareFileInfos(entry.Stat(), cursor, sortKeys) > 0 {
+					first.add(entry)
+				}
+			}
+
+			page := first.sorted()
+			more := len(page) > limit
+			if more {
+				page = page[:limit]
+			}
+			for _, entry := range page {
+				names = append(names, entry.Name())
+			}
+			if !more {
+				return names
+			}
+
+			token, err := encodeChildrenCursor(sortKeys, page[len(page)-1].Stat())
+			require.NoError(t, err)
+			cursor, err = decodeChildrenCursor(token, sortKeys)
+			require.NoError(t, err)
+		}
+	}
+
+	require.Equal(t, []string{"a", "b", "c", "d", "e", "f"}, list("Name", 4))
+	require.Equal(t, []string{"f", "e", "d", "c", "b", "a"}, list("-Name", 2))
+	require.Equal(t, []string{"e", "b", "d", "a", "c", "f"}, list("ModTime", 1))
+	require.Equal(t, []string{"a", "e", "b", "c", "d", "f"}, list("Size", 5))
+	require.Equal(t, []string{"f", "d", "c", "b", "e", "a"}, list("-Size,-Name", 3))
+}
+
+func TestChildrenCursor(t *testing.T) {
+	info := objects.NewFileInfo("a", 1, 0644, time.Now().UTC(), 0, 0, 0, 0, 1)
+
+	token, err := encodeChildrenCursor([]string{"-Size"}, &info)
+	require.NoError(t, err)
+
+	last, err := decodeChildrenCursor(token, []string{"-Size"})
+	require.NoError(t, err)
+	require.True(t, last.Equal(&info))
+
+	_, err = decodeChildrenCursor(token, []string{"Size"})
+	require.ErrorIs(t, err, ErrInvalidCursor)
+
+	_, err = decodeChildrenCursor("not a cursor", []string{"Name"})
+	require.ErrorIs(t, err, ErrInvalidCursor)
+}
@@ -0,0 +1,294 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"iter"
+	"net/http"
+	"path"
+	"slices"
+
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+)
+
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+	DiffMetadata = "metadata"
+)
+
+// DiffEntry is an entry that differs between two snapshots, A being the
+// entry in the first snapshot and B the one in the second.
+type DiffEntry struct {
+	Path      string     `json:"path"`
+	Change    string     `json:"change"`
+	Fields    []string   `json:"fields,omitempty"`
+	SizeDelta int64      `json:"size_delta"`
+	A         *vfs.Entry `json:"a,omitempty"`
+	B         *vfs.Entry `json:"b,omitempty"`
+}
+
+// entrySize is the size of a file, or of the whole content of a
+// directory.
+func entrySize(entry *vfs.Entry) int64 {
+	if entry.IsDir() && entry.Summary != nil {
+		return int64(entry.Summary.Directory.Size + entry.Summary.Below.Size)
+	}
+	return entry.Size()
+}
+
+// changedFields lists what differs between two versions of an entry. The
+// device, inode and link count are left out as they change from one
+// backup to another without the file being touched.
+func changedFields(a, b *vfs.Entry) []string {
+	var fields []string
+
+	sa, sb := a.Stat(), b.Stat()
+	if sa.Mode().Type() != sb.Mode().Type() {
+		fields = append(fields, "type")
+	} else if a.Object != b.Object {
+		fields = append(fields, "content")
+	} else if a.SymlinkTarget != b.SymlinkTarget {
+		fields = append(fields, "target")
+	}
+
+	if sa.Mode()&^fs.ModeType != sb.Mode()&^fs.ModeType {
+		fields = append(fields, "mode")
+	}
+	if !sa.ModTime().Equal(sb.ModTime()) {
+		fields = append(fields, "mtime")
+	}
+	if sa.Uid() != sb.Uid() || sa.Username() != sb.Username() {
+		fields = append(fields, "owner")
+	}
+	if sa.Gid() != sb.Gid() || sa.Groupname() != sb.Groupname() {
+		fields = append(fields, "group")
+	}
+	if !slices.Equal(a.ExtendedAttributes, b.ExtendedAttributes) {
+		fields = append(fields, "xattrs")
+	}
+	return fields
+}
+
+// diffEntry compares two versions of the entry at pathname, either of
+// them being nil if it doesn't exist, and returns nil if they are the
+// same.
+func diffEntry(pathname string, a, b *vfs.Entry) *DiffEntry {
+	switch {
+	case a == nil && b == nil:
+		return nil
+	case a == nil:
+		return &DiffEntry{Path: pathname, Change: DiffAdded, SizeDelta: entrySize(b), B: b}
+	case b == nil:
+		return &DiffEntry{Path: pathname, Change: DiffRemoved, SizeDelta: -entrySize(a), A: a}
+	}
+
+	fields := changedFields(a, b)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	change := DiffMetadata
+	switch fields[0] {
+	case "type", "content", "target":
+		change = DiffModified
+	}
+	return &DiffEntry{
+		Path:      pathname,
+		Change:    change,
+		Fields:    fields,
+		SizeDelta: entrySize(b) - entrySize(a),
+		A:         a,
+		B:         b,
+	}
+}
+
+// snapshotDiff walks two versions of a directory, one level at a time, so
+// that only the directories being compared are held in memory.
+type snapshotDiff struct {
+	recursive bool
+	children1 func(dir string) ([]*vfs.Entry, error)
+	children2 func(dir string) ([]*vfs.Entry, error)
+}
+
+func vfsChildren(fsc *vfs.Filesystem) func(string) ([]*vfs.Entry, error) {
+	return func(dir string) ([]*vfs.Entry, error) {
+		iter, err := fsc.Children(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		var children []*vfs.Entry
+		for child, err := range iter {
+			if err != nil {
+				return nil, err
+			}
+			// These might be huge and we don't need them here.
+			if child.ResolvedObject != nil {
+				child.ResolvedObject.Chunks = nil
+			}
+			children = append(children, child)
+		}
+		return children, nil
+	}
+}
+
+// entries returns the differences between a and b, the two versions of
+// pathname, in path order. Added and removed directories are reported
+// as a single entry.
+func (d *snapshotDiff) entries(pathname string, a, b *vfs.Entry) iter.Seq2[*DiffEntry, error] {
+	return func(yield func(*DiffEntry, error) bool) {
+		if a != nil && b != nil && a.IsDir() && b.IsDir() {
+			if _, err := d.walk(pathname, yield); err != nil {
+				yield(nil, err)
+			}
+			return
+		}
+		if entry := diffEntry(pathname, a, b); entry != nil {
+			yield(entry, nil)
+		}
+	}
+}
+
+func (d *snapshotDiff) walk(dir string, yield func(*DiffEntry, error) bool) (bool, error) {
+	children1, err := d.children1(dir)
+	if err != nil {
+		return false, err
+	}
+	children2, err := d.children2(dir)
+	if err != nil {
+		return false, err
+	}
+
+	byName1 := make(map[string]*vfs.Entry, len(children1))
+	byName2 := make(map[string]*vfs.Entry, len(children2))
+	var names []string
+	for _, child := range children1 {
+		byName1[child.Name()] = child
+		names = append(names, child.Name())
+	}
+	for _, child := range children2 {
+		if _, ok := byName1[child.Name()]; !ok {
+			names = append(names, child.Name())
+		}
+		byName2[child.Name()] = child
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		a, b := byName1[name], byName2[name]
+		pathname := path.Join(dir, name)
+
+		if entry := diffEntry(pathname, a, b); entry != nil {
+			if !yield(entry, nil) {
+				return false, nil
+			}
+		}
+
+		if d.recursive && a != nil && b != nil && a.IsDir() && b.IsDir() {
+			if cont, err := d.walk(pathname, yield); err != nil || !cont {
+				return cont, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// lookupEntry is like GetEntry, but returns a nil entry if it doesn't
+// exist.
+func lookupEntry(fsc *vfs.Filesystem, pathname string) (*vfs.Entry, error) {
+	entry, err := fsc.GetEntry(pathname)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return entry, err
+}
+
+func (ui *uiserver) snapshotDiff(w http.ResponseWriter, r *http.Request) error {
+	snapshotID1, err := SnapshotIDParam(r, ui.repository, "a")
+	if err != nil {
+		return err
+	}
+	snapshotID2, err := SnapshotIDParam(r, ui.repository, "b")
+	if err != nil {
+		return err
+	}
+
+	offset, err := QueryParamToInt64(r, "offset", 0, 0)
+	if err != nil {
+		return err
+	}
+
+	limit, err := QueryParamToInt64(r, "limit", 1, 50)
+	if err != nil {
+		return err
+	}
+
+	recursive := r.URL.Query().Get("recursive") == "true"
+
+	pathname := path.Clean("/" + r.PathValue("path"))
+	if err := requestIdentity(r).checkPath(pathname); err != nil {
+		return err
+	}
+
+	snap1, err := loadsnap(ui.repository, snapshotID1)
+	if err != nil {
+		return err
+	}
+	fs1, err := snap1.Filesystem()
+	if err != nil {
+		return err
+	}
+
+	snap2, err := loadsnap(ui.repository, snapshotID2)
+	if err != nil {
+		return err
+	}
+	fs2, err := snap2.Filesystem()
+	if err != nil {
+		return err
+	}
+
+	a, err := lookupEntry(fs1, pathname)
+	if err != nil {
+		return err
+	}
+	b, err := lookupEntry(fs2, pathname)
+	if err != nil {
+		return err
+	}
+	if a == nil && b == nil {
+		return fs.ErrNotExist
+	}
+
+	diff := &snapshotDiff{
+		recursive: recursive,
+		children1: vfsChildren(fs1),
+		children2: vfsChildren(fs2),
+	}
+
+	items := ItemsPage[*DiffEntry]{
+		Items: []*DiffEntry{},
+	}
+
+	// the walk stops at the first entry past the page, which tells
+	// whether there's a next page of results.
+	var i int64
+	for entry, err := range diff.entries(pathname, a, b) {
+		if err != nil {
+			return err
+		}
+		if i >= offset+limit {
+			items.HasNext = true
+			break
+		}
+		if i >= offset {
+			items.Items = append(items.Items, entry)
+		}
+		i++
+	}
+
+	return json.NewEncoder(w).Encode(items)
+}
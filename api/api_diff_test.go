@@ -0,0 +1,125 @@
+package api
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+type diffTree map[string][]*vfs.Entry
+
+func (tree diffTree) add(pathname string, mode os.FileMode, size int64, object byte) *vfs.Entry {
+	entry := &vfs.Entry{
+		ParentPath: path.Dir(pathname),
+		FileInfo:   objects.NewFileInfo(path.Base(pathname), size, mode, time.Unix(1700000000, 0), 0, 0, 1000, 1000, 1),
+	}
+	if mode.IsRegular() {
+		entry.Object = objects.MAC{object}
+	}
+	tree[entry.ParentPath] = append(tree[entry.ParentPath], entry)
+	return entry
+}
+
+func (tree diffTree) children(dir string) ([]*vfs.Entry, error) {
+	return tree[dir], nil
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	tree1, tree2 := diffTree{}, diffTree{}
+
+	tree1.add("/same.txt", 0644, 10, 1)
+	tree2.add("/same.txt", 0644, 10, 1)
+
+	tree1.add("/edited.txt", 0644, 10, 2)
+	tree2.add("/edited.txt", 0644, 25, 3)
+
+	tree1.add("/chmod.sh", 0644, 10, 4)
+	tree2.add("/chmod.sh", 0755, 10, 4)
+
+	tree1.add("/gone.txt", 0644, 7, 5)
+	tree2.add("/new.txt", 0644, 8, 6)
+
+	tree1.add("/dir", os.ModeDir|0755, 0, 0)
+	tree2.add("/dir", os.ModeDir|0755, 0, 0)
+	tree1.add("/dir/inner.txt", 0644, 1, 7)
+	tree2.add("/dir/inner.txt", 0644, 2, 8)
+
+	tree1.add("/kind", 0644, 3, 9)
+	tree2.add("/kind", os.ModeDir|0755, 0, 0)
+
+	root := &vfs.Entry{FileInfo: objects.NewFileInfo("/", 0, os.ModeDir|0755, time.Now(), 0, 0, 0, 0, 1)}
+	changes := func(recursive bool, offset, limit int) []string {
+		diff := &snapshotDiff{recursive: recursive, children1: tree1.children, children2: tree2.children}
+
+		var changes []string
+		i := 0
+		for entry, err := range diff.entries("/", root, root) {
+			require.NoError(t, err)
+			if i >= offset+limit {
+				break
+			}
+			if i >= offset {
+				changes = append(changes, entry.Change+" "+entry.Path)
+			}
+			i++
+		}
+		return changes
+	}
+
+	require.Equal(t, []string{
+		"metadata /chmod.sh",
+		"modified /edited.txt",
+		"removed /gone.txt",
+		"modified /kind",
+		"added /new.txt",
+	}, changes(false, 0, 10))
+
+	require.Equal(t, []string{
+		"metadata /chmod.sh",
+		"modified /dir/inner.txt",
+		"modified /edited.txt",
+		"removed /gone.txt",
+	}, changes(true, 0, 4))
+	require.Equal(t, []string{
+		"modified /kind",
+		"added /new.txt",
+	}, changes(true, 4, 4))
+}
+
+func TestDiffEntry(t *testing.T) {
+	tree := diffTree{}
+	a := tree.add("/file", 0644, 100, 1)
+
+	require.Nil(t, diffEntry("/file", a, a))
+
+	entry := diffEntry("/file", nil, a)
+	require.Equal(t, DiffAdded, entry.Change)
+	require.Equal(t, int64(100), entry.SizeDelta)
+
+	entry = diffEntry("/file", a, nil)
+	require.Equal(t, DiffRemoved, entry.Change)
+	require.Equal(t, int64(-100), entry.SizeDelta)
+
+	b := *a
+	b.FileInfo.Lsize = 40
+	b.FileInfo.LmodTime = time.Unix(1800000000, 0)
+	b.Object = objects.MAC{2}
+	entry = diffEntry("/file", a, &b)
+	require.Equal(t, DiffModified, entry.Change)
+	require.Equal(t, []string{"content", "mtime"}, entry.Fields)
+	require.Equal(t, int64(-60), entry.SizeDelta)
+
+	c := *a
+	c.FileInfo.Luid = 0
+	c.FileInfo.Lino = 42
+	c.ExtendedAttributes = []string{"user.comment"}
+	entry = diffEntry("/file", a, &c)
+	require.Equal(t, DiffMetadata, entry.Change)
+	require.Equal(t, []string{"owner", "xattrs"}, entry.Fields)
+	require.Zero(t, entry.SizeDelta)
+}
@@ -57,3 +57,11 @@ func authError(reason string) *ApiError {
 		Message:  reason,
 	}
 }
+
+func forbiddenError(reason string) *ApiError {
+	return &ApiError{
+		HttpCode: http.StatusForbidden,
+		ErrCode:  "forbidden",
+		Message:  reason,
+	}
+}
@@ -0,0 +1,360 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PlakarKorp/kloset/events"
+)
+
+const (
+	// Events are dropped for the clients that don't keep up, rather than
+	// slowing the jobs down.
+	eventSubscriberBuffer = 1024
+
+	eventProgressInterval  = time.Second
+	eventKeepaliveInterval = 15 * time.Second
+)
+
+// StreamEvent is an event of a job as sent to the clients of /api/events.
+// Type is the name of the event, e.g. FileOK or PathError, or one of
+// JobStart and JobDone, Progress, sent every second with the status of
+// the running jobs, and Dropped, telling how many events a slow client
+// missed.
+type StreamEvent struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	Job         string    `json:"job,omitempty"`
+	Kind        string    `json:"kind,omitempty"`
+	Snapshot    string    `json:"snapshot,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	Message     string    `json:"message,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	MAC         string    `json:"mac,omitempty"`
+	Files       uint64    `json:"files,omitempty"`
+	Directories uint64    `json:"directories,omitempty"`
+	Dropped     uint64    `json:"dropped,omitempty"`
+	Status      *Job      `json:"status,omitempty"`
+
+	scope string
+}
+
+// reach is the path the event is about, or the one its job may go
+// through for the events that aren't about a path.
+func (ev *StreamEvent) reach() string {
+	switch {
+	case ev.Path != "":
+		return ev.Path
+	case ev.scope != "":
+		return ev.scope
+	default:
+		return "/"
+	}
+}
+
+func (ev *StreamEvent) snapshot(snapshotID [32]byte) *StreamEvent {
+	if snapshotID != ([32]byte{}) {
+		ev.Snapshot = fmt.Sprintf("%x", snapshotID)
+	}
+	return ev
+}
+
+func (ev *StreamEvent) object(snapshotID, mac [32]byte) *StreamEvent {
+	ev.MAC = fmt.Sprintf("%x", mac)
+	return ev.snapshot(snapshotID)
+}
+
+func (ev *StreamEvent) path(snapshotID [32]byte, pathname, message string) *StreamEvent {
+	ev.Path = pathname
+	ev.Message = message
+	return ev.snapshot(snapshotID)
+}
+
+// newStreamEvent converts an event of the bus, returning nil for the ones
+// it doesn't know about.
+func newStreamEvent(j *job, event any) *StreamEvent {
+	ev := &StreamEvent{Job: j.ID, Kind: j.Kind, scope: j.scope}
+
+	switch event := event.(type) {
+	case events.Start:
+		ev.Type, ev.Time = "Start", event.Timestamp
+	case events.Done:
+		ev.Type, ev.Time = "Done", event.Timestamp
+	case events.Warning:
+		ev.Type, ev.Time, ev.Message = "Warning", event.Timestamp, event.Message
+		ev.snapshot(event.SnapshotID)
+	case events.Error:
+		ev.Type, ev.Time, ev.Message = "Error", event.Timestamp, event.Message
+		ev.snapshot(event.SnapshotID)
+	case events.StartImporter:
+		ev.Type, ev.Time = "StartImporter", event.Timestamp
+		ev.snapshot(event.SnapshotID)
+	case events.DoneImporter:
+		ev.Type, ev.Time = "DoneImporter", event.Timestamp
+		ev.Files, ev.Directories, ev.Size = event.NumFiles, event.NumDirectories, int64(event.Size)
+		ev.snapshot(event.SnapshotID)
+	case events.Path:
+		ev.Type, ev.Time = "Path", event.Timestamp
+		ev.path(event.SnapshotID, event.Pathname, "")
+	case events.PathError:
+		ev.Type, ev.Time = "PathError", event.Timestamp
+		ev.path(event.SnapshotID, event.Pathname, event.Message)
+	case events.Directory:
+		ev.Type, ev.Time = "Directory", event.Timestamp
+		ev.path(event.SnapshotID, event.Pathname, "")
+	case events.DirectoryOK:
+		ev.Type, ev.Time = "DirectoryOK", event.Timestamp
+		ev.path(event.SnapshotID, event.Pathname, "")
+	case events.DirectoryError:
+		ev.Type, ev.Time = "DirectoryError", event.Timestamp
+		ev.path(event.SnapshotID, event.Pathname, event.Message)
+	case events.DirectoryMissing:
+		ev.Type, ev.Time = "DirectoryMissing", event.Timestamp
+		ev.path(event.SnapshotID, event.Pathname, "")
+	case events.DirectoryCorrupted:
+		ev.Type, ev.Time = "DirectoryCorrupted", event.Timestamp
+		ev.path(event.SnapshotID, event.Pathname, "")
+	case events.File:
+		ev.Type, ev.Time = "File", event.Timestamp
+		ev.path(event.SnapshotID, event.Pathname, "")
+	case events.FileOK:
+		ev.Type, ev.Time, ev.Size = "FileOK", event.Timestamp, event.Size
+		ev.path(event.SnapshotID, event.Pathname, "")
+	case events.FileError:
+		ev.Type, ev.Time = "FileError", event.Timestamp
+		ev.path(event.SnapshotID, event.Pathname, event.Message)
+	case events.FileMissing:
+		ev.Type, ev.Time = "FileMissing", event.Timestamp
+		ev.path(event.SnapshotID, event.Pathname, "")
+	case events.FileCorrupted:
+		ev.Type, ev.Time = "FileCorrupted", event.Timestamp
+		ev.path(event.SnapshotID, event.Pathname, "")
+	case events.Object:
+		ev.Type, ev.Time = "Object", event.Timestamp
+		ev.object(event.SnapshotID, event.MAC)
+	case events.ObjectOK:
+		ev.Type, ev.Time = "ObjectOK", event.Timestamp
+		ev.object(event.SnapshotID, event.MAC)
+	case events.ObjectMissing:
+		ev.Type, ev.Time = "ObjectMissing", event.Timestamp
+		ev.object(event.SnapshotID, event.MAC)
+	case events.ObjectCorrupted:
+		ev.Type, ev.Time = "ObjectCorrupted", event.Timestamp
+		ev.object(event.SnapshotID, event.MAC)
+	case events.Chunk:
+		ev.Type, ev.Time = "Chunk", event.Timestamp
+		ev.object(event.SnapshotID, event.MAC)
+	case events.ChunkOK:
+		ev.Type, ev.Time = "ChunkOK", event.Timestamp
+		ev.object(event.SnapshotID, event.MAC)
+	case events.ChunkMissing:
+		ev.Type, ev.Time = "ChunkMissing", event.Timestamp
+		ev.object(event.SnapshotID, event.MAC)
+	case events.ChunkCorrupted:
+		ev.Type, ev.Time = "ChunkCorrupted", event.Timestamp
+		ev.object(event.SnapshotID, event.MAC)
+	default:
+		return nil
+	}
+	return ev
+}
+
+// eventFilter selects the events sent to a client, an empty filter
+// selecting all of them that are within the reach of its token.
+type eventFilter struct {
+	id       *identity
+	jobs     []string
+	kinds    []string
+	snapshot string
+	types    []string
+}
+
+func parseEventFilter(r *http.Request) (*eventFilter, error) {
+	filter := &eventFilter{
+		id:       requestIdentity(r),
+		jobs:     queryParamList(r, "job"),
+		kinds:    queryParamList(r, "kind"),
+		snapshot: strings.ToLower(r.URL.Query().Get("snapshot")),
+		types:    queryParamList(r, "type"),
+	}
+	if strings.Trim(filter.snapshot, "0123456789abcdef") != "" {
+		return nil, parameterError("snapshot", InvalidArgument, ErrInvalidID)
+	}
+	return filter, nil
+}
+
+func (filter *eventFilter) match(ev *StreamEvent) bool {
+	if filter.id.checkPath(ev.reach()) != nil {
+		return false
+	}
+	if len(filter.jobs) != 0 && !slices.Contains(filter.jobs, ev.Job) {
+		return false
+	}
+	if len(filter.kinds) != 0 && !slices.Contains(filter.kinds, ev.Kind) {
+		return false
+	}
+	if filter.snapshot != "" && !strings.HasPrefix(ev.Snapshot, filter.snapshot) {
+		return false
+	}
+	if len(filter.types) != 0 && !slices.Contains(filter.types, ev.Type) {
+		return false
+	}
+	return true
+}
+
+// newJobStreamEvent reports the status of a job, without its output.
+func newJobStreamEvent(typ string, j Job) *StreamEvent {
+	j.Output = nil
+	return &StreamEvent{
+		Type:     typ,
+		Time:     time.Now(),
+		Job:      j.ID,
+		Kind:     j.Kind,
+		Snapshot: j.Progress.Snapshot,
+		Status:   &j,
+		scope:    j.scope,
+	}
+}
+
+type eventSubscriber struct {
+	filter  *eventFilter
+	ch      chan *StreamEvent
+	dropped atomic.Uint64
+}
+
+// eventBroker fans the events of the jobs out to the clients of
+// /api/events.
+type eventBroker struct {
+	mtx         sync.Mutex
+	subscribers map[*eventSubscriber]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subscribers: make(map[*eventSubscriber]struct{}),
+	}
+}
+
+func (b *eventBroker) subscribe(filter *eventFilter) *eventSubscriber {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	sub := &eventSubscriber{
+		filter: filter,
+		ch:     make(chan *StreamEvent, eventSubscriberBuffer),
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (b *eventBroker) unsubscribe(sub *eventSubscriber) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	delete(b.subscribers, sub)
+}
+
+func (b *eventBroker) active() bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return len(b.subscribers) != 0
+}
+
+// publish hands an event to the clients interested in it, without ever
+// waiting on them as the job emitting it is blocked meanwhile.
+func (b *eventBroker) publish(ev *StreamEvent) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	for sub := range b.subscribers {
+		if !sub.filter.match(ev) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, ev *StreamEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
+
+func (ui *uiserver) eventsStream(w http.ResponseWriter, r *http.Request) error {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		return err
+	}
+
+	rc := http.NewResponseController(w)
+
+	sub := ui.events.subscribe(filter)
+	defer ui.events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return nil
+	}
+
+	progress := time.NewTicker(eventProgressInterval)
+	defer progress.Stop()
+	keepalive := time.NewTicker(eventKeepaliveInterval)
+	defer keepalive.Stop()
+
+	// errors past this point can't be reported to the client anymore.
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+
+		case ev := <-sub.ch:
+			if err := writeStreamEvent(w, ev); err != nil {
+				return nil
+			}
+
+		case <-progress.C:
+			if dropped := sub.dropped.Swap(0); dropped != 0 {
+				ev := &StreamEvent{Type: "Dropped", Time: time.Now(), Dropped: dropped}
+				if err := writeStreamEvent(w, ev); err != nil {
+					return nil
+				}
+			}
+			for _, j := range ui.jobs.list() {
+				if j.Status != JobRunning {
+					continue
+				}
+				ev := newJobStreamEvent("Progress", j)
+				if !filter.match(ev) {
+					continue
+				}
+				if err := writeStreamEvent(w, ev); err != nil {
+					return nil
+				}
+			}
+
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/events"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamEvent(t *testing.T) {
+	j := newJob("backup", "", func() {})
+	snapshotID := [32]byte{0xab, 0xcd}
+
+	ev := newStreamEvent(j, events.FileOKEvent(snapshotID, "/etc/passwd", 1234))
+	require.Equal(t, "FileOK", ev.Type)
+	require.Equal(t, j.ID, ev.Job)
+	require.Equal(t, "backup", ev.Kind)
+	require.Equal(t, fmt.Sprintf("%x", snapshotID), ev.Snapshot)
+	require.Equal(t, "/etc/passwd", ev.Path)
+	require.Equal(t, int64(1234), ev.Size)
+
+	ev = newStreamEvent(j, events.PathErrorEvent(snapshotID, "/root", "permission denied"))
+	require.Equal(t, "PathError", ev.Type)
+	require.Equal(t, "permission denied", ev.Message)
+
+	ev = newStreamEvent(j, events.ObjectCorruptedEvent(snapshotID, [32]byte{1}))
+	require.Equal(t, "ObjectCorrupted", ev.Type)
+	require.Equal(t, fmt.Sprintf("%x", [32]byte{1}), ev.MAC)
+
+	ev = newStreamEvent(j, events.StartEvent())
+	require.Equal(t, "Start", ev.Type)
+	require.Empty(t, ev.Snapshot)
+
+	require.Nil(t, newStreamEvent(j, "not an event"))
+}
+
+func TestEventFilter(t *testing.T) {
+	ev := &StreamEvent{Type: "FileOK", Job: "j1", Kind: "backup", Snapshot: "abcd"}
+
+	tests := []struct {
+		query string
+		match bool
+	}{
+		{"", true},
+		{"job=j1", true},
+		{"job=j2", false},
+		{"job=j2,j1", true},
+		{"kind=check", false},
+		{"kind=backup&type=FileOK,FileError", true},
+		{"type=PathError", false},
+		{"snapshot=AB", true},
+		{"snapshot=abce", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/api/events?"+test.query, nil)
+			require.NoError(t, err)
+			filter, err := parseEventFilter(req)
+			require.NoError(t, err)
+			require.Equal(t, test.match, filter.match(ev))
+		})
+	}
+
+	req, err := http.NewRequest("GET", "/api/events?snapshot=xyz", nil)
+	require.NoError(t, err)
+	_, err = parseEventFilter(req)
+	require.IsType(t, &ApiError{}, err)
+}
+
+func TestEventFilterPaths(t *testing.T) {
+	alice := &identity{name: "alice", token: &utils.APIToken{
+		Scopes: []string{utils.ScopeBrowse},
+		Paths:  []string{"/home/alice"},
+	}}
+	restore := newJob("restore", "/home/alice/docs", func() {})
+	backup := newJob("backup", "", func() {})
+
+	tests := []struct {
+		name  string
+		ev    *StreamEvent
+		match bool
+	}{
+		{"path within", newStreamEvent(backup, events.FileOKEvent([32]byte{}, "/home/alice/a.txt", 1)), true},
+		{"path out", newStreamEvent(backup, events.FileOKEvent([32]byte{}, "/home/bob/a.txt", 1)), false},
+		{"no path", newStreamEvent(backup, events.StartEvent()), false},
+		{"no path in a job within", newStreamEvent(restore, events.StartEvent()), true},
+		{"status", newJobStreamEvent("Progress", backup.status()), false},
+		{"status within", newJobStreamEvent("Progress", restore.status()), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := &eventFilter{id: alice}
+			require.Equal(t, test.match, filter.match(test.ev))
+
+			// neither the session token nor the unrestricted ones filter
+			filter = &eventFilter{id: &identity{name: "bob", token: &utils.APIToken{
+				Scopes: []string{utils.ScopeBrowse},
+			}}}
+			require.True(t, filter.match(test.ev))
+			filter = &eventFilter{}
+			require.True(t, filter.match(test.ev))
+		})
+	}
+}
+
+func TestEventBroker(t *testing.T) {
+	b := newEventBroker()
+	require.False(t, b.active())
+
+	sub := b.subscribe(&eventFilter{jobs: []string{"j1"}})
+	require.True(t, b.active())
+
+	b.publish(&StreamEvent{Type: "FileOK", Job: "j2"})
+	b.publish(&StreamEvent{Type: "FileOK", Job: "j1", Path: "/a"})
+	require.Len(t, sub.ch, 1)
+	require.Equal(t, "/a", (<-sub.ch).Path)
+
+	// a client that doesn't keep up misses events rather than blocking
+	for range eventSubscriberBuffer + 5 {
+		b.publish(&StreamEvent{Type: "FileOK", Job: "j1"})
+	}
+	require.Len(t, sub.ch, eventSubscriberBuffer)
+	require.Equal(t, uint64(5), sub.dropped.Load())
+
+	b.unsubscribe(sub)
+	require.False(t, b.active())
+}
+
+func TestEventsStream(t *testing.T) {
+	ui := &uiserver{jobs: newJobManager(), events: newEventBroker()}
+	running := newJob("backup", "", func() {})
+	ui.jobs.add(running)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := ui.eventsStream(w, r); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/events?job="+running.ID, nil)
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	require.Eventually(t, ui.events.active, 5*time.Second, 10*time.Millisecond)
+	ui.events.publish(&StreamEvent{Type: "FileOK", Job: "another"})
+	ui.events.publish(newStreamEvent(running, events.FileOKEvent([32]byte{}, "/file", 10)))
+
+	scanner := bufio.NewScanner(res.Body)
+	next := func() *StreamEvent {
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var ev StreamEvent
+			require.NoError(t, json.Unmarshal([]byte(data), &ev))
+			return &ev
+		}
+		require.NoError(t, scanner.Err())
+		return nil
+	}
+
+	ev := next()
+	require.Equal(t, "FileOK", ev.Type)
+	require.Equal(t, "/file", ev.Path)
+
+	ev = next()
+	require.Equal(t, "Progress", ev.Type)
+	require.Equal(t, running.ID, ev.Job)
+	require.Equal(t, JobRunning, ev.Status.Status)
+}
@@ -0,0 +1,620 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/events"
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/logging"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/subcommands/backup"
+	"github.com/PlakarKorp/plakar/subcommands/check"
+	"github.com/PlakarKorp/plakar/subcommands/prune"
+	"github.com/PlakarKorp/plakar/subcommands/restore"
+	psync "github.com/PlakarKorp/plakar/subcommands/sync"
+	"github.com/PlakarKorp/plakar/task"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+
+	// Finished jobs are kept for their status to be polled, the oldest
+	// ones are forgotten past this number.
+	maxFinishedJobs = 100
+
+	// Only the last lines of the output of a job are kept.
+	maxJobOutputLines = 200
+)
+
+var (
+	ErrJobNotFound     = errors.New("Job not found")
+	ErrJobFinished     = errors.New("Job is already finished")
+	ErrInvalidBody     = errors.New("Invalid request body")
+	ErrInvalidPolicy   = errors.New("Policy not found")
+	ErrInvalidSyncDir  = errors.New("Invalid direction, valid values are to, from and with")
+	ErrEmptyPruneRules = errors.New("No policy or filter specified, not going to prune everything")
+)
+
+type JobProgress struct {
+	Snapshot    string  `json:"snapshot,omitempty"`
+	Path        string  `json:"path,omitempty"`
+	Files       uint64  `json:"files"`
+	Directories uint64  `json:"directories"`
+	Bytes       int64   `json:"bytes"`
+	Throughput  float64 `json:"throughput"`
+	Errors      uint64  `json:"errors"`
+}
+
+// at records where the job is at, a check or a sync going through several
+// snapshots.
+func (progress *JobProgress) at(snapshotID [32]byte, pathname string) {
+	if snapshotID != ([32]byte{}) {
+		progress.Snapshot = fmt.Sprintf("%x", snapshotID)
+	}
+	progress.Path = pathname
+}
+
+type Job struct {
+	ID         string      `json:"id"`
+	Kind       string      `json:"kind"`
+	Status     string      `json:"status"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt time.Time   `json:"finished_at"`
+	ExitCode   int         `json:"exit_code"`
+	Error      string      `json:"error,omitempty"`
+	Progress   JobProgress `json:"progress"`
+	Output     []string    `json:"output"`
+
+	// scope is the path of the snapshots the job is restricted to, if
+	// any, to tell which tokens may follow it.
+	scope string
+}
+
+// reach is the path the job may go through, the whole snapshots for the
+// jobs that aren't restricted to one.
+func (j *Job) reach() string {
+	if j.scope == "" {
+		return "/"
+	}
+	return j.scope
+}
+
+// job is a command run in the background on behalf of an API client. It
+// is also the writer its output goes to.
+type job struct {
+	mtx sync.Mutex
+	Job
+
+	partial  []byte
+	cancel   context.CancelFunc
+	canceled bool
+	done     chan struct{}
+}
+
+func newJob(kind, scope string, cancel context.CancelFunc) *job {
+	return &job{
+		Job: Job{
+			ID:        uuid.NewString(),
+			Kind:      kind,
+			Status:    JobRunning,
+			StartedAt: time.Now(),
+			Output:    []string{},
+			scope:     scope,
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+func (j *job) Write(p []byte) (int, error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	j.partial = append(j.partial, p...)
+	for {
+		i := bytes.IndexByte(j.partial, '\n')
+		if i < 0 {
+			break
+		}
+		j.addLine(string(j.partial[:i]))
+		j.partial = j.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// addLine must be called with j.mtx held.
+func (j *job) addLine(line string) {
+	j.Output = append(j.Output, strings.TrimSuffix(line, "\r"))
+	if len(j.Output) > maxJobOutputLines {
+		j.Output = j.Output[len(j.Output)-maxJobOutputLines:]
+	}
+}
+
+func (j *job) handle(event any) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	progress := &j.Progress
+	switch event := event.(type) {
+	case events.Path:
+		progress.at(event.SnapshotID, event.Pathname)
+	case events.FileOK:
+		progress.Files++
+		progress.Bytes += event.Size
+		progress.at(event.SnapshotID, event.Pathname)
+	case events.DirectoryOK:
+		progress.Directories++
+		progress.at(event.SnapshotID, event.Pathname)
+	case events.PathError, events.FileError, events.DirectoryError,
+		events.FileCorrupted, events.DirectoryCorrupted, events.FileMissing, events.DirectoryMissing:
+		progress.Errors++
+	}
+}
+
+// watch updates the progress of the job from the events of its context,
+// and forwards them to the broker, until they are closed.
+func (j *job) watch(receiver *events.Receiver, broker *eventBroker) {
+	listener := receiver.Listen()
+	go func() {
+		for event := range listener {
+			j.handle(event)
+			if broker.active() {
+				if ev := newStreamEvent(j, event); ev != nil {
+					broker.publish(ev)
+				}
+			}
+		}
+	}()
+}
+
+func (j *job) finish(status int, err error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if len(j.partial) != 0 {
+		j.addLine(string(j.partial))
+		j.partial = nil
+	}
+
+	j.FinishedAt = time.Now()
+	j.ExitCode = status
+	if err != nil {
+		j.Error = err.Error()
+	} else if status != 0 {
+		j.Error = fmt.Sprintf("exit status %d", status)
+	}
+
+	switch {
+	case j.canceled:
+		j.Status = JobCanceled
+	case status != 0 || err != nil:
+		j.Status = JobFailed
+	default:
+		j.Status = JobSucceeded
+	}
+	close(j.done)
+}
+
+func (j *job) stop() error {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if j.Status != JobRunning {
+		return ErrJobFinished
+	}
+	j.canceled = true
+	j.cancel()
+	return nil
+}
+
+func (j *job) finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// status returns a copy of the job that is safe to encode while it runs.
+func (j *job) status() Job {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	res := j.Job
+	res.Output = slices.Clone(j.Output)
+
+	end := j.FinishedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	if elapsed := end.Sub(j.StartedAt).Seconds(); elapsed > 0 {
+		res.Progress.Throughput = float64(j.Progress.Bytes) / elapsed
+	}
+	return res
+}
+
+// jobManager keeps track of the running jobs, and of the last finished
+// ones.
+type jobManager struct {
+	mtx  sync.Mutex
+	jobs []*job
+}
+
+func newJobManager() *jobManager {
+	return &jobManager{}
+}
+
+func (m *jobManager) add(j *job) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.jobs = append(m.jobs, j)
+
+	finished := 0
+	for _, j := range m.jobs {
+		if j.finished() {
+			finished++
+		}
+	}
+	m.jobs = slices.DeleteFunc(m.jobs, func(j *job) bool {
+		if finished > maxFinishedJobs && j.finished() {
+			finished--
+			return true
+		}
+		return false
+	})
+}
+
+func (m *jobManager) get(id string) (*job, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for _, j := range m.jobs {
+		if j.ID == id {
+			return j, nil
+		}
+	}
+	return nil, jobNotFoundError()
+}
+
+func jobNotFoundError() error {
+	return &ApiError{
+		HttpCode: http.StatusNotFound,
+		ErrCode:  "not-found",
+		Message:  ErrJobNotFound.Error(),
+	}
+}
+
+// list returns the jobs, the most recent first.
+func (m *jobManager) list() []Job {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	res := make([]Job, 0, len(m.jobs))
+	for i := len(m.jobs) - 1; i >= 0; i-- {
+		res = append(res, m.jobs[i].status())
+	}
+	return res
+}
+
+// startJob runs a command the same way the agent does, in a context of
+// its own so that it can be canceled and its events told apart from the
+// ones of the other jobs.
+func (ui *uiserver) startJob(kind, scope string, cmd subcommands.Subcommand) (*job, error) {
+	ctx := appcontext.NewAppContextFrom(ui.ctx)
+	ctx.SetSecret(ui.ctx.GetSecret())
+
+	j := newJob(kind, scope, ctx.Cancel)
+	ctx.Stdin = strings.NewReader("")
+	ctx.Stdout = j
+	ctx.Stderr = j
+	ctx.SetLogger(logging.NewLogger(ctx.Stdout, ctx.Stderr))
+
+	// The repository is opened again over the same store, to have
+	// the snapshots send their events to the job context.
+	serializedConfig, err := ui.store.Open(ctx)
+	if err != nil {
+		ctx.Close()
+		return nil, err
+	}
+	repo, err := repository.New(ctx.GetInner(), ctx.GetSecret(), ui.store, serializedConfig)
+	if err != nil {
+		ctx.Close()
+		return nil, err
+	}
+
+	if synccmd, ok := cmd.(*psync.Sync); ok {
+		if err := synccmd.SetupPeerSecret(ctx); err != nil {
+			ctx.Close()
+			return nil, parameterError("peer", InvalidArgument, err)
+		}
+	}
+
+	j.watch(ctx.Events(), ui.events)
+	ui.jobs.add(j)
+	ui.events.publish(newJobStreamEvent("JobStart", j.status()))
+
+	go func() {
+		defer ctx.Close()
+		j.finish(task.RunCommand(ctx, cmd, repo, "@ui"))
+		ui.events.publish(newJobStreamEvent("JobDone", j.status()))
+	}()
+
+	return j, nil
+}
+
+func decodeJobRequest(r *http.Request, req any) error {
+	if r.ContentLength == 0 {
+		return nil
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return &ApiError{
+			HttpCode: http.StatusBadRequest,
+			ErrCode:  "bad-request",
+			Message:  fmt.Sprintf("%s: %s", ErrInvalidBody, err),
+		}
+	}
+	return nil
+}
+
+func (ui *uiserver) replyJob(w http.ResponseWriter, kind, scope string, cmd subcommands.Subcommand) error {
+	j, err := ui.startJob(kind, scope, cmd)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	return json.NewEncoder(w).Encode(Item[Job]{Item: j.status()})
+}
+
+type BackupJobRequest struct {
+	Path     string            `json:"path"`
+	Job      string            `json:"job"`
+	Tags     []string          `json:"tags"`
+	Excludes []string          `json:"excludes"`
+	Options  map[string]string `json:"options"`
+	Check    bool              `json:"check"`
+}
+
+func (ui *uiserver) jobBackup(w http.ResponseWriter, r *http.Request) error {
+	var req BackupJobRequest
+	if err := decodeJobRequest(r, &req); err != nil {
+		return err
+	}
+	if req.Path == "" {
+		return parameterError("path", MissingArgument, ErrMissingField)
+	}
+
+	cmd := &backup.Backup{}
+	cmd.Path = req.Path
+	cmd.Job = req.Job
+	cmd.Tags = req.Tags
+	cmd.Excludes = req.Excludes
+	cmd.OptCheck = req.Check
+	cmd.Concurrency = uint64(ui.ctx.MaxConcurrency)
+	cmd.PackfileTempStorage = "memory"
+	cmd.Quiet = true
+	cmd.Opts = make(map[string]string)
+	for k, v := range req.Options {
+		cmd.Opts[k] = v
+	}
+
+	return ui.replyJob(w, "backup", "", cmd)
+}
+
+type RestoreJobRequest struct {
+	Snapshot        string `json:"snapshot"`
+	Job             string `json:"job"`
+	Tag             string `json:"tag"`
+	Target          string `json:"target"`
+	SkipPermissions bool   `json:"skip_permissions"`
+}
+
+func (ui *uiserver) jobRestore(w http.ResponseWriter, r *http.Request) error {
+	var req RestoreJobRequest
+	if err := decodeJobRequest(r, &req); err != nil {
+		return err
+	}
+	id := requestIdentity(r)
+	target, err := ui.restoreTarget(id, req.Target)
+	if err != nil {
+		return err
+	}
+	_, pathname := utils.ParseSnapshotID(req.Snapshot)
+	if err := id.checkPath(pathname); err != nil {
+		return err
+	}
+
+	cmd := &restore.Restore{}
+	cmd.Target = target
+	cmd.OptJob = req.Job
+	cmd.OptTag = req.Tag
+	cmd.OptSkipPermissions = req.SkipPermissions
+	cmd.Concurrency = uint64(ui.ctx.MaxConcurrency)
+	cmd.Quiet = true
+	if req.Snapshot != "" {
+		cmd.Snapshots = []string{req.Snapshot}
+	}
+
+	return ui.replyJob(w, "restore", pathname, cmd)
+}
+
+// restoreTarget tells where a restore goes: anywhere on the server for
+// the admins, and only below the restore root for the other tokens, the
+// target being taken relative to it.
+func (ui *uiserver) restoreTarget(id *identity, target string) (string, error) {
+	if id.hasScope(utils.ScopeAdmin) && target != "" {
+		return target, nil
+	}
+	if ui.restoreRoot == "" {
+		// there's no working directory to default to on the server
+		if id.hasScope(utils.ScopeAdmin) {
+			return "", parameterError("target", MissingArgument, ErrMissingField)
+		}
+		return "", forbiddenError(fmt.Sprintf("token lacks the %s scope to restore without a restore root", utils.ScopeAdmin))
+	}
+	return filepath.Join(ui.restoreRoot, filepath.Clean("/"+target)), nil
+}
+
+type CheckJobRequest struct {
+	Snapshots []string `json:"snapshots"`
+	Job       string   `json:"job"`
+	Latest    bool     `json:"latest"`
+	Fast      bool     `json:"fast"`
+	NoVerify  bool     `json:"no_verify"`
+}
+
+func (ui *uiserver) jobCheck(w http.ResponseWriter, r *http.Request) error {
+	var req CheckJobRequest
+	if err := decodeJobRequest(r, &req); err != nil {
+		return err
+	}
+
+	cmd := &check.Check{}
+	cmd.LocateOptions = locate.NewDefaultLocateOptions(
+		locate.WithJob(req.Job),
+		locate.WithLatest(req.Latest),
+	)
+	cmd.Snapshots = req.Snapshots
+	cmd.FastCheck = req.Fast
+	cmd.NoVerify = req.NoVerify
+	cmd.Concurrency = uint64(ui.ctx.MaxConcurrency)
+	cmd.Quiet = true
+
+	return ui.replyJob(w, "check", "", cmd)
+}
+
+type SyncJobRequest struct {
+	Snapshot  string `json:"snapshot"`
+	Direction string `json:"direction"`
+	Peer      string `json:"peer"`
+}
+
+func (ui *uiserver) jobSync(w http.ResponseWriter, r *http.Request) error {
+	var req SyncJobRequest
+	if err := decodeJobRequest(r, &req); err != nil {
+		return err
+	}
+	switch req.Direction {
+	case "to", "from", "with":
+	case "":
+		return parameterError("direction", MissingArgument, ErrMissingField)
+	default:
+		return parameterError("direction", InvalidArgument, ErrInvalidSyncDir)
+	}
+	if req.Peer == "" {
+		return parameterError("peer", MissingArgument, ErrMissingField)
+	}
+
+	cmd := &psync.Sync{}
+	cmd.SrcLocateOptions = locate.NewDefaultLocateOptions()
+	if req.Snapshot != "" {
+		cmd.SrcLocateOptions.Filters.IDs = []string{req.Snapshot}
+	}
+	cmd.Direction = req.Direction
+	cmd.PeerRepositoryLocation = req.Peer
+	cmd.PackfileTempStorage = "memory"
+
+	return ui.replyJob(w, "sync", "", cmd)
+}
+
+type PruneJobRequest struct {
+	Policy string `json:"policy"`
+	Job    string `json:"job"`
+	Apply  bool   `json:"apply"`
+}
+
+func (ui *uiserver) jobPrune(w http.ResponseWriter, r *http.Request) error {
+	var req PruneJobRequest
+	if err := decodeJobRequest(r, &req); err != nil {
+		return err
+	}
+
+	cmd := &prune.Prune{}
+	cmd.Apply = req.Apply
+	cmd.LocateOptions = locate.NewDefaultLocateOptions()
+	if req.Policy != "" {
+		policies, err := utils.LoadPolicyConfigFile(filepath.Join(ui.ctx.ConfigDir, "policies.yml"))
+		if err != nil {
+			return err
+		}
+		if !policies.Has(req.Policy) {
+			return parameterError("policy", InvalidArgument, ErrInvalidPolicy)
+		}
+		policies.ApplyConfig(req.Policy, cmd.LocateOptions)
+	}
+	if req.Job != "" {
+		cmd.LocateOptions.Filters.Job = req.Job
+	}
+	if cmd.LocateOptions.Empty() {
+		return parameterError("policy", MissingArgument, ErrEmptyPruneRules)
+	}
+
+	return ui.replyJob(w, "prune", "", cmd)
+}
+
+func (ui *uiserver) jobsList(w http.ResponseWriter, r *http.Request) error {
+	id := requestIdentity(r)
+	jobs := slices.DeleteFunc(ui.jobs.list(), func(j Job) bool {
+		return id.checkPath(j.reach()) != nil
+	})
+	return json.NewEncoder(w).Encode(Items[Job]{Total: len(jobs), Items: jobs})
+}
+
+// requestJob returns the job of the request, the ones going through
+// paths out of the reach of the token being as good as missing.
+func (ui *uiserver) requestJob(r *http.Request) (*job, error) {
+	j, err := ui.jobs.get(r.PathValue("id"))
+	if err != nil {
+		return nil, err
+	}
+	if requestIdentity(r).checkPath(j.reach()) != nil {
+		return nil, jobNotFoundError()
+	}
+	return j, nil
+}
+
+func (ui *uiserver) jobStatus(w http.ResponseWriter, r *http.Request) error {
+	j, err := ui.requestJob(r)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(Item[Job]{Item: j.status()})
+}
+
+func (ui *uiserver) jobCancel(w http.ResponseWriter, r *http.Request) error {
+	j, err := ui.requestJob(r)
+	if err != nil {
+		return err
+	}
+	// the restore scope is enough to cancel a restore, not the other jobs
+	if j.Kind != "restore" && !requestIdentity(r).hasScope(utils.ScopeAdmin) {
+		return forbiddenError(fmt.Sprintf("token lacks the %s scope", utils.ScopeAdmin))
+	}
+	if err := j.stop(); err != nil {
+		return &ApiError{
+			HttpCode: http.StatusConflict,
+			ErrCode:  "conflict",
+			Message:  err.Error(),
+		}
+	}
+	return json.NewEncoder(w).Encode(Item[Job]{Item: j.status()})
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/events"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobOutput(t *testing.T) {
+	j := newJob("backup", "", func() {})
+
+	fmt.Fprintf(j, "first line\nsecond ")
+	fmt.Fprintf(j, "line\r\nunfinished")
+	require.Equal(t, []string{"first line", "second line"}, j.status().Output)
+
+	for i := range maxJobOutputLines {
+		fmt.Fprintf(j, "\nline %d", i)
+	}
+	j.finish(0, nil)
+
+	output := j.status().Output
+	require.Len(t, output, maxJobOutputLines)
+	require.Equal(t, "line 0", output[0])
+	require.Equal(t, fmt.Sprintf("line %d", maxJobOutputLines-1), output[len(output)-1])
+}
+
+func TestJobProgress(t *testing.T) {
+	j := newJob("backup", "", func() {})
+	snapshotID := [32]byte{0xab}
+
+	j.handle(events.PathEvent(snapshotID, "/"))
+	j.handle(events.FileOKEvent(snapshotID, "/a", 10))
+	j.handle(events.FileOKEvent(snapshotID, "/b", 32))
+	j.handle(events.FileErrorEvent(snapshotID, "/c", "permission denied"))
+	j.handle(events.DirectoryOKEvent(snapshotID, "/"))
+
+	progress := j.status().Progress
+	require.Equal(t, fmt.Sprintf("%x", snapshotID), progress.Snapshot)
+	require.Equal(t, "/", progress.Path)
+	require.Equal(t, uint64(2), progress.Files)
+	require.Equal(t, uint64(1), progress.Directories)
+	require.Equal(t, int64(42), progress.Bytes)
+	require.Equal(t, uint64(1), progress.Errors)
+}
+
+func TestJobStatus(t *testing.T) {
+	j := newJob("check", "", func() {})
+	require.Equal(t, JobRunning, j.status().Status)
+	j.finish(0, nil)
+	require.Equal(t, JobSucceeded, j.status().Status)
+	require.False(t, j.status().FinishedAt.IsZero())
+	require.ErrorIs(t, j.stop(), ErrJobFinished)
+
+	j = newJob("check", "", func() {})
+	j.finish(1, errors.New("corrupted snapshot"))
+	require.Equal(t, JobFailed, j.status().Status)
+	require.Equal(t, 1, j.status().ExitCode)
+	require.Equal(t, "corrupted snapshot", j.status().Error)
+
+	canceled := false
+	j = newJob("restore", "", func() { canceled = true })
+	require.NoError(t, j.stop())
+	require.True(t, canceled)
+	j.finish(1, errors.New("context canceled"))
+	require.Equal(t, JobCanceled, j.status().Status)
+}
+
+func TestJobManager(t *testing.T) {
+	m := newJobManager()
+
+	running := newJob("backup", "", func() {})
+	m.add(running)
+	var first *job
+	for i := range maxFinishedJobs + 10 {
+		j := newJob("check", "", func() {})
+		if i == 0 {
+			first = j
+		}
+		m.add(j)
+		j.finish(0, nil)
+	}
+	last := newJob("sync", "", func() {})
+	m.add(last)
+
+	jobs := m.list()
+	require.Len(t, jobs, maxFinishedJobs+2)
+	require.Equal(t, last.ID, jobs[0].ID)
+	require.Equal(t, running.ID, jobs[len(jobs)-1].ID)
+
+	j, err := m.get(running.ID)
+	require.NoError(t, err)
+	require.Same(t, running, j)
+
+	_, err = m.get(first.ID)
+	require.Error(t, err)
+	require.IsType(t, &ApiError{}, err)
+	require.Equal(t, 404, err.(*ApiError).HttpCode)
+
+	_, err = m.get(strings.Repeat("0", 36))
+	require.Error(t, err)
+}
+
+func TestJobsPaths(t *testing.T) {
+	ui := &uiserver{jobs: newJobManager()}
+	backup := newJob("backup", "", func() {})
+	ui.jobs.add(backup)
+	restore := newJob("restore", "/home/alice", func() {})
+	ui.jobs.add(restore)
+
+	alice := &identity{name: "alice", token: &utils.APIToken{
+		Scopes: []string{utils.ScopeRestore},
+		Paths:  []string{"/home/alice"},
+	}}
+
+	list := func(id *identity) []string {
+		req := httptest.NewRequest("GET", "/api/jobs", nil)
+		if id != nil {
+			req = withIdentity(req, id)
+		}
+		rec := httptest.NewRecorder()
+		require.NoError(t, ui.jobsList(rec, req))
+
+		var res Items[Job]
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		require.Equal(t, len(res.Items), res.Total)
+		ids := []string{}
+		for _, j := range res.Items {
+			ids = append(ids, j.ID)
+		}
+		return ids
+	}
+	require.Equal(t, []string{restore.ID, backup.ID}, list(nil))
+	require.Equal(t, []string{restore.ID}, list(alice))
+
+	status := func(id *identity, jobID string) error {
+		req := httptest.NewRequest("GET", "/api/jobs/"+jobID, nil)
+		req.SetPathValue("id", jobID)
+		req = withIdentity(req, id)
+		return ui.jobStatus(httptest.NewRecorder(), req)
+	}
+	require.NoError(t, status(alice, restore.ID))
+	err := status(alice, backup.ID)
+	require.IsType(t, &ApiError{}, err)
+	require.Equal(t, 404, err.(*ApiError).HttpCode)
+}
+
+func TestRestoreTarget(t *testing.T) {
+	admin := &identity{name: "admin", token: &utils.APIToken{Scopes: []string{utils.ScopeAdmin}}}
+	restorer := &identity{name: "restorer", token: &utils.APIToken{Scopes: []string{utils.ScopeRestore}}}
+
+	ui := &uiserver{}
+	target, err := ui.restoreTarget(admin, "/var/restore")
+	require.NoError(t, err)
+	require.Equal(t, "/var/restore", target)
+	target, err = ui.restoreTarget(nil, "/var/restore")
+	require.NoError(t, err)
+	require.Equal(t, "/var/restore", target)
+
+	_, err = ui.restoreTarget(admin, "")
+	require.IsType(t, &ApiError{}, err)
+	require.Equal(t, 400, err.(*ApiError).HttpCode)
+	_, err = ui.restoreTarget(restorer, "/var/restore")
+	require.IsType(t, &ApiError{}, err)
+	require.Equal(t, 403, err.(*ApiError).HttpCode)
+
+	ui = &uiserver{restoreRoot: "/srv/restore"}
+	for _, test := range []struct{ target, expect string }{
+		{"", "/srv/restore"},
+		{"alice", "/srv/restore/alice"},
+		{"/etc", "/srv/restore/etc"},
+		{"../../etc", "/srv/restore/etc"},
+		{"alice/../../../etc", "/srv/restore/etc"},
+	} {
+		target, err := ui.restoreTarget(restorer, test.target)
+		require.NoError(t, err)
+		require.Equal(t, test.expect, target)
+	}
+
+	// the admins still restore wherever they like
+	target, err = ui.restoreTarget(admin, "/var/restore")
+	require.NoError(t, err)
+	require.Equal(t, "/var/restore", target)
+	target, err = ui.restoreTarget(admin, "")
+	require.NoError(t, err)
+	require.Equal(t, "/srv/restore", target)
+}
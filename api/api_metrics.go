@@ -0,0 +1,175 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/metrics"
+)
+
+// metricsRefreshInterval is how often the states of the store are checked
+// for changes to refresh the repository metrics.
+const metricsRefreshInterval = time.Minute
+
+type snapshotStats struct {
+	job       string
+	timestamp time.Time
+	size      int64
+}
+
+// repositoryStats caches the figures exposed about the repository, so
+// that scraping them costs nothing. They are refreshed in the background
+// when the states of the store change, loading only the new snapshots.
+type repositoryStats struct {
+	once sync.Once
+
+	// repo is opened again over the same store, as for the jobs, so that
+	// rebuilding its state doesn't disturb the handlers reading the one
+	// of the UI. Only the refreshes use it, and states.
+	repo   *repository.Repository
+	states []objects.MAC
+
+	mtx         sync.Mutex
+	ready       bool
+	snapshots   map[objects.MAC]snapshotStats
+	storageSize int64
+}
+
+// refreshStats updates the repository metrics unless the states of the
+// store are the same as when they were last refreshed.
+func (ui *uiserver) refreshStats() error {
+	stats := ui.stats
+
+	if stats.repo == nil {
+		serializedConfig, err := ui.store.Open(ui.ctx)
+		if err != nil {
+			return err
+		}
+		repo, err := repository.New(ui.ctx.GetInner(), ui.ctx.GetSecret(), ui.store, serializedConfig)
+		if err != nil {
+			return err
+		}
+		stats.repo = repo
+	}
+
+	states, err := stats.repo.GetStates()
+	if err != nil {
+		return fmt.Errorf("unable to list states: %w", err)
+	}
+	slices.SortFunc(states, func(a, b objects.MAC) int { return bytes.Compare(a[:], b[:]) })
+	if stats.states != nil && slices.Equal(states, stats.states) {
+		return nil
+	}
+
+	if err := stats.repo.RebuildState(); err != nil {
+		return fmt.Errorf("unable to rebuild state: %w", err)
+	}
+
+	snapshots := make(map[objects.MAC]snapshotStats)
+	for snapshotID := range stats.repo.ListSnapshots() {
+		if s, ok := stats.snapshots[snapshotID]; ok {
+			snapshots[snapshotID] = s
+			continue
+		}
+
+		snap, err := snapshot.Load(stats.repo, snapshotID)
+		if err != nil {
+			continue
+		}
+		summary := snap.Header.GetSource(0).Summary
+		snapshots[snapshotID] = snapshotStats{
+			job:       snap.Header.Job,
+			timestamp: snap.Header.Timestamp,
+			size:      int64(summary.Directory.Size + summary.Below.Size),
+		}
+		snap.Close()
+	}
+
+	storageSize, err := ui.store.Size(ui.ctx)
+	if err != nil {
+		return fmt.Errorf("unable to compute storage size: %w", err)
+	}
+
+	stats.mtx.Lock()
+	defer stats.mtx.Unlock()
+	stats.states = states
+	stats.snapshots = snapshots
+	stats.storageSize = storageSize
+	stats.ready = true
+	return nil
+}
+
+// refreshStatsLoop refreshes the repository metrics until the UI stops.
+func (ui *uiserver) refreshStatsLoop() {
+	ticker := time.NewTicker(metricsRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := ui.refreshStats(); err != nil {
+			ui.ctx.GetLogger().Warn("failed to refresh the repository metrics: %s", err)
+		}
+		select {
+		case <-ui.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collectRepository exposes the size of the repository, its snapshot count
+// and when each job last produced a snapshot, as of the last refresh. The
+// first scrape starts the refreshes, and they are missing until the first
+// one completes.
+func (ui *uiserver) collectRepository(w *metrics.Writer) error {
+	stats := ui.stats
+	stats.once.Do(func() { go ui.refreshStatsLoop() })
+
+	stats.mtx.Lock()
+	defer stats.mtx.Unlock()
+	if !stats.ready {
+		return nil
+	}
+
+	location, err := ui.repository.Location()
+	if err != nil {
+		return fmt.Errorf("unable to get storage location: %w", err)
+	}
+	repository := metrics.Label{Name: "repository", Value: location}
+
+	logicalSize := int64(0)
+	lastSnapshot := make(map[string]time.Time)
+	for _, s := range stats.snapshots {
+		logicalSize += s.size
+		if s.timestamp.After(lastSnapshot[s.job]) {
+			lastSnapshot[s.job] = s.timestamp
+		}
+	}
+
+	w.Gauge("plakar_repository_snapshots", "Snapshots in the repository.",
+		float64(len(stats.snapshots)), repository)
+	w.Gauge("plakar_repository_logical_size_bytes", "Size of the data backed up in the repository.",
+		float64(logicalSize), repository)
+	if stats.storageSize != -1 {
+		w.Gauge("plakar_repository_storage_size_bytes", "Size of the repository on its storage.",
+			float64(stats.storageSize), repository)
+	}
+
+	jobs := make([]string, 0, len(lastSnapshot))
+	for job := range lastSnapshot {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+	for _, job := range jobs {
+		w.Timestamp("plakar_repository_last_snapshot_timestamp_seconds", "When the job last produced a snapshot.",
+			lastSnapshot[job], repository, metrics.Label{Name: "job", Value: job})
+	}
+
+	return nil
+}
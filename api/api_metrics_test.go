@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/metrics"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectRepository(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/file.txt", 0644, "hello"),
+	})
+	snap.Close()
+
+	ui := &uiserver{
+		store:      repo.Store(),
+		repository: repo,
+		stats:      &repositoryStats{},
+		ctx:        ctx,
+	}
+	// no refreshes in the background
+	ui.stats.once.Do(func() {})
+
+	location, err := repo.Location()
+	require.NoError(t, err)
+	gather := func() string {
+		data, err := metrics.NewRegistry().Gather(ui.collectRepository)
+		require.NoError(t, err)
+		return string(data)
+	}
+	snapshots := func(n int) string {
+		return fmt.Sprintf("plakar_repository_snapshots{repository=%q} %d\n", location, n)
+	}
+
+	// nothing until the first refresh
+	require.NotContains(t, gather(), "plakar_repository_snapshots")
+
+	require.NoError(t, ui.refreshStats())
+	out := gather()
+	require.Contains(t, out, snapshots(1))
+	require.Contains(t, out, "plakar_repository_last_snapshot_timestamp_seconds{")
+
+	// the cache is kept while the states don't change
+	states := ui.stats.states
+	require.NoError(t, ui.refreshStats())
+	require.Equal(t, states, ui.stats.states)
+	require.Contains(t, gather(), snapshots(1))
+
+	snap = ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("other.txt", 0644, "world"),
+	})
+	snap.Close()
+
+	require.NoError(t, ui.refreshStats())
+	require.Contains(t, gather(), snapshots(2))
+}
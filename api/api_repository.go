@@ -308,6 +308,9 @@ func (ui *uiserver) repositoryLocatePathname(w http.ResponseWriter, r *http.Requ
 	if err != nil {
 		return err
 	}
+	if err := requestIdentity(r).checkPath(resource); err != nil {
+		return err
+	}
 
 	sortKeys, err := QueryParamToSortKeys(r, "sort", "Timestamp")
 	if err != nil {
@@ -60,7 +60,7 @@ func _Test_RepositoryConfiguration(t *testing.T) {
 
 	var noToken string
 	mux := http.NewServeMux()
-	SetupRoutes(mux, repo, ctx, noToken)
+	SetupRoutes(mux, repo, ctx, noToken, "")
 
 	req, err := http.NewRequest("GET", "/api/repository/configuration", nil)
 	require.NoError(t, err, "creating request")
@@ -345,7 +345,7 @@ func _Test_RepositorySnapshots(t *testing.T) {
 
 			var noToken string
 			mux := http.NewServeMux()
-			SetupRoutes(mux, repo, ctx, noToken)
+			SetupRoutes(mux, repo, ctx, noToken, "")
 
 			req, err := http.NewRequest("GET", "/api/repository/snapshots", nil)
 			require.NoError(t, err, "creating request")
@@ -451,7 +451,7 @@ func _Test_RepositorySnapshotsErrors(t *testing.T) {
 
 			var noToken string
 			mux := http.NewServeMux()
-			SetupRoutes(mux, repo, ctx, noToken)
+			SetupRoutes(mux, repo, ctx, noToken, "")
 
 			req, err := http.NewRequest("GET", fmt.Sprintf("/api/repository/snapshots?%s", c.params), nil)
 			require.NoError(t, err, "creating request")
@@ -531,7 +531,7 @@ func _Test_RepositoryStates(t *testing.T) {
 
 			var noToken string
 			mux := http.NewServeMux()
-			SetupRoutes(mux, repo, ctx, noToken)
+			SetupRoutes(mux, repo, ctx, noToken, "")
 
 			req, err := http.NewRequest("GET", "/api/repository/states", nil)
 			require.NoError(t, err, "creating request")
@@ -605,7 +605,7 @@ func _Test_RepositoryState(t *testing.T) {
 
 			var noToken string
 			mux := http.NewServeMux()
-			SetupRoutes(mux, repo, ctx, noToken)
+			SetupRoutes(mux, repo, ctx, noToken, "")
 
 			req, err := http.NewRequest("GET", fmt.Sprintf("/api/repository/state/%s", c.stateId), nil)
 			require.NoError(t, err, "creating request")
@@ -685,7 +685,7 @@ func Test_RepositoryStateErrors(t *testing.T) {
 
 			var noToken string
 			mux := http.NewServeMux()
-			SetupRoutes(mux, repo, ctx, noToken)
+			SetupRoutes(mux, repo, ctx, noToken, "")
 
 			req, err := http.NewRequest("GET", fmt.Sprintf("/api/repository/state/%s", c.stateId), nil)
 			require.NoError(t, err, "creating request")
@@ -20,6 +20,7 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/header"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/alecthomas/chroma/formatters"
 	"github.com/alecthomas/chroma/lexers"
 	"github.com/alecthomas/chroma/styles"
@@ -32,6 +33,7 @@ type downloadSignedUrl struct {
 	snapshotID [32]byte
 	rebase     bool
 	files      []string
+	user       string
 }
 
 var snapcache = lru.New[[32]byte, *snapshot.Snapshot](30, nil)
@@ -228,6 +230,7 @@ func NewSnapshotReaderURLSigner(ui *uiserver, token string) SnapshotReaderURLSig
 type SnapshotSignedURLClaims struct {
 	SnapshotID string `json:"snapshot_id"`
 	Path       string `json:"path"`
+	User       string `json:"user,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -242,6 +245,7 @@ func (signer SnapshotReaderURLSigner) Sign(w http.ResponseWriter, r *http.Reques
 	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, SnapshotSignedURLClaims{
 		SnapshotID: snapshotId,
 		Path:       path,
+		User:       requestIdentity(r).username(),
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(now.Add(2 * time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(now),
@@ -264,15 +268,20 @@ func (signer SnapshotReaderURLSigner) Sign(w http.ResponseWriter, r *http.Reques
 }
 
 // VerifyMiddleware is a middleware that checks if the request to read the file
-// content is authorized. It checks if the ?signature query parameter is valid.
-// If it is not valid, it falls back to the Authorization header.
+// content is authorized. It checks if the ?signature query parameter is valid,
+// and that the user it was signed for still has the download scope, which
+// the content always requires, whether it is displayed or downloaded. If it
+// is not valid, it falls back to the Authorization header.
 func (signer SnapshotReaderURLSigner) VerifyMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	auth := signer.ui.auth
+	return auth.audited(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := utils.ScopeDownload
+
 		signature := r.URL.Query().Get("signature")
 
 		// No signature provided, fall back to Authorization header
 		if signature == "" {
-			TokenAuthMiddleware(signer.token)(next).ServeHTTP(w, r)
+			auth.authorize(scope, next).ServeHTTP(w, r)
 			return
 		}
 
@@ -299,22 +308,33 @@ func (signer SnapshotReaderURLSigner) VerifyMiddleware(next http.Handler) http.H
 		}
 		snapshotId := fmt.Sprintf("%0x", snapshotID32[:])
 
-		if claims, ok := jwtToken.Claims.(*SnapshotSignedURLClaims); ok {
-			if claims.Path != path {
-				handleError(w, r, authError("invalid URL path"))
-				return
-			}
-			if claims.SnapshotID != snapshotId {
-				handleError(w, r, authError("invalid URL snapshot"))
-				return
-			}
-		} else {
+		claims, ok := jwtToken.Claims.(*SnapshotSignedURLClaims)
+		if !ok {
 			handleError(w, r, authError("invalid URL signature"))
 			return
 		}
+		if claims.Path != path {
+			handleError(w, r, authError("invalid URL path"))
+			return
+		}
+		if claims.SnapshotID != snapshotId {
+			handleError(w, r, authError("invalid URL snapshot"))
+			return
+		}
+
+		id, err := auth.revalidate(claims.User, scope)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+		r = withIdentity(r, id)
+		if err := id.checkPath(path); err != nil {
+			handleError(w, r, err)
+			return
+		}
 
 		next.ServeHTTP(w, r)
-	})
+	}))
 }
 
 func (ui *uiserver) snapshotVFSBrowse(w http.ResponseWriter, r *http.Request) error {
@@ -368,7 +388,22 @@ func (ui *uiserver) snapshotVFSChildren(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		return parameterError("sort", InvalidArgument, err)
 	}
-	_ = sortKeys
+
+	filter, err := parseChildrenFilter(r)
+	if err != nil {
+		return err
+	}
+
+	var cursor *objects.FileInfo
+	if str := r.URL.Query().Get("cursor"); str != "" {
+		if offset != 0 {
+			return parameterError("offset", InvalidArgument, errors.New("cannot be used along with a cursor"))
+		}
+		cursor, err = decodeChildrenCursor(str, sortKeys)
+		if err != nil {
+			return parameterError("cursor", InvalidArgument, err)
+		}
+	}
 
 	snap, err := loadsnap(ui.repository, snapshotID32)
 	if err != nil {
@@ -396,8 +431,7 @@ func (ui *uiserver) snapshotVFSChildren(w http.ResponseWriter, r *http.Request)
 		return nil
 	}
 
-	items := Items[*vfs.Entry]{
-		Total: int(fsinfo.Summary.Directory.Children),
+	items := ItemsCursor[*vfs.Entry]{
 		Items: make([]*vfs.Entry, 0),
 	}
 	iter, err := fsinfo.Getdents(fs)
@@ -405,8 +439,9 @@ func (ui *uiserver) snapshotVFSChildren(w http.ResponseWriter, r *http.Request)
 		return err
 	}
 
-	// The first returned item is ".." unless we're at the root
-	if fsinfo.Path() != "/" {
+	// The first returned item is ".." unless we're at the root or
+	// resuming from a cursor
+	if fsinfo.Path() != "/" && cursor == nil {
 		if offset == 0 {
 			parent, err := fs.GetEntry(path.Dir(entrypath))
 			if err != nil {
@@ -428,17 +463,18 @@ func (ui *uiserver) snapshotVFSChildren(w http.ResponseWriter, r *http.Request)
 		limit = int64(fsinfo.Summary.Directory.Children)
 	}
 
-	var i int64
-	for child := range iter {
-		if child == nil {
-			break
+	// one more entry than asked for tells whether there is a next page
+	first := newFirstEntries(sortKeys, int(offset+limit+1))
+	for child, err := range iter {
+		if err != nil {
+			return err
 		}
-		if i < offset {
-			i++
+		if !filter.match(child.Stat()) {
 			continue
 		}
-		if i >= limit+offset {
-			break
+		items.Total++
+		if cursor != nil && compareFileInfos(child.Stat(), cursor, sortKeys) <= 0 {
+			continue
 		}
 
 		// These might be huge and we don't need them in this
@@ -447,9 +483,20 @@ func (ui *uiserver) snapshotVFSChildren(w http.ResponseWriter, r *http.Request)
 			child.ResolvedObject.Chunks = nil
 		}
 
-		items.Items = append(items.Items, child)
-		i++
+		first.add(child)
 	}
+
+	children := first.sorted()
+	children = children[min(int(offset), len(children)):]
+	if int64(len(children)) > limit {
+		children = children[:limit]
+		items.Next, err = encodeChildrenCursor(sortKeys, children[len(children)-1].Stat())
+		if err != nil {
+			return err
+		}
+	}
+	items.Items = append(items.Items, children...)
+
 	return json.NewEncoder(w).Encode(items)
 }
 
@@ -669,6 +716,15 @@ func (ui *uiserver) snapshotVFSDownloader(w http.ResponseWriter, r *http.Request
 		return parameterError("BODY", InvalidArgument, err)
 	}
 
+	var pathnames []string
+	for _, item := range query.Items {
+		pathnames = append(pathnames, item.Pathname)
+	}
+	user := requestIdentity(r)
+	if err := user.checkPaths(pathnames); err != nil {
+		return err
+	}
+
 	if _, err = loadsnap(ui.repository, snapshotID32); err != nil {
 		return nil
 	}
@@ -682,10 +738,8 @@ func (ui *uiserver) snapshotVFSDownloader(w http.ResponseWriter, r *http.Request
 		url := downloadSignedUrl{
 			snapshotID: snapshotID32,
 			rebase:     query.Rebase,
-		}
-
-		for _, item := range query.Items {
-			url.files = append(url.files, item.Pathname)
+			files:      pathnames,
+			user:       user.username(),
 		}
 
 		downloadSignedUrls.Add(id, url)
@@ -710,6 +764,17 @@ func (ui *uiserver) snapshotVFSDownloaderSigned(w http.ResponseWriter, r *http.R
 		}
 	}
 
+	// The link is not authenticated by itself: the token it was made
+	// with must still be allowed to download what it covers.
+	user, err := ui.auth.revalidate(link.user, utils.ScopeDownload)
+	if err != nil {
+		return err
+	}
+	r = withIdentity(r, user)
+	if err := user.checkPaths(link.files); err != nil {
+		return err
+	}
+
 	snap, err := loadsnap(ui.repository, link.snapshotID)
 	if err != nil {
 		return err
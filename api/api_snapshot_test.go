@@ -165,7 +165,7 @@ func _TestSnapshotHeader(t *testing.T) {
 
 			var noToken string
 			mux := http.NewServeMux()
-			SetupRoutes(mux, repo, ctx, noToken)
+			SetupRoutes(mux, repo, ctx, noToken, "")
 
 			req, err := http.NewRequest("GET", fmt.Sprintf("/api/snapshot/%s", c.snapshotId), nil)
 			require.NoError(t, err, "creating request")
@@ -245,7 +245,7 @@ func TestSnapshotHeaderErrors(t *testing.T) {
 
 			var noToken string
 			mux := http.NewServeMux()
-			SetupRoutes(mux, repo, ctx, noToken)
+			SetupRoutes(mux, repo, ctx, noToken, "")
 
 			req, err := http.NewRequest("GET", fmt.Sprintf("/api/snapshot/%s", c.snapshotId), nil)
 			require.NoError(t, err, "creating request")
@@ -310,7 +310,7 @@ func _TestSnapshotSign(t *testing.T) {
 
 			token := "test-token"
 			mux := http.NewServeMux()
-			SetupRoutes(mux, repo, ctx, token)
+			SetupRoutes(mux, repo, ctx, token, "")
 
 			// retrieve a valid jwt token before calling the read
 			req, err := http.NewRequest("POST", fmt.Sprintf("/api/snapshot/reader-sign-url/%s", c.snapshotPath), nil)
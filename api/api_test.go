@@ -29,7 +29,7 @@ func TestNewRouter(t *testing.T) {
 	mux := http.NewServeMux()
 	// Make sure SetupRoutes doesn't panic, which happens when invalid routes
 	// are registered
-	SetupRoutes(mux, repo, ctx, token)
+	SetupRoutes(mux, repo, ctx, token, "")
 }
 
 func TestAuthMiddleware(t *testing.T) {
@@ -69,7 +69,7 @@ func TestAuthMiddleware(t *testing.T) {
 	}
 	token := "test-token"
 	mux := http.NewServeMux()
-	SetupRoutes(mux, repo, ctx, token)
+	SetupRoutes(mux, repo, ctx, token, "")
 
 	req, err := http.NewRequest("GET", "/api/info", nil)
 	if err != nil {
@@ -135,7 +135,7 @@ func Test_UnknownEndpoint(t *testing.T) {
 	}
 	token := ""
 	mux := http.NewServeMux()
-	SetupRoutes(mux, repo, ctx, token)
+	SetupRoutes(mux, repo, ctx, token, "")
 
 	req, err := http.NewRequest("GET", "/api/unknown_endpoint", nil)
 	if err != nil {
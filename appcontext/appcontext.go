@@ -13,6 +13,7 @@ type AppContext struct {
 
 	cookies *cookies.Manager `msgpack:"-"`
 	plugins *plugins.Manager `msgpack:"-"`
+	detach  func()           `msgpack:"-"`
 
 	ConfigDir string
 	secret    []byte
@@ -76,6 +77,22 @@ func (c *AppContext) GetPlugins() *plugins.Manager {
 	return c.plugins
 }
 
+// SetDetach sets the function releasing the client a command is run
+// for by the agent.
+func (c *AppContext) SetDetach(detach func()) {
+	c.detach = detach
+}
+
+// Detach releases the client of the command, which keeps running in
+// the agent. It returns false if the command isn't run by the agent.
+func (c *AppContext) Detach() bool {
+	if c.detach == nil {
+		return false
+	}
+	c.detach()
+	return true
+}
+
 func (c *AppContext) ReloadConfig() error {
 	cfg, err := utils.LoadConfig(c.ConfigDir)
 	if err != nil {
@@ -26,6 +26,7 @@ import (
 	"github.com/PlakarKorp/plakar/agent"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/cookies"
+	"github.com/PlakarKorp/plakar/output"
 	"github.com/PlakarKorp/plakar/plugins"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/task"
@@ -33,6 +34,7 @@ import (
 	"github.com/denisbrodbeck/machineid"
 	"github.com/google/uuid"
 
+	_ "github.com/PlakarKorp/plakar/network/client"
 	_ "github.com/PlakarKorp/plakar/subcommands/agent"
 	_ "github.com/PlakarKorp/plakar/subcommands/archive"
 	_ "github.com/PlakarKorp/plakar/subcommands/backup"
@@ -55,6 +57,7 @@ import (
 	_ "github.com/PlakarKorp/plakar/subcommands/pkg"
 	_ "github.com/PlakarKorp/plakar/subcommands/prune"
 	_ "github.com/PlakarKorp/plakar/subcommands/ptar"
+	_ "github.com/PlakarKorp/plakar/subcommands/report"
 	_ "github.com/PlakarKorp/plakar/subcommands/restore"
 	_ "github.com/PlakarKorp/plakar/subcommands/rm"
 	_ "github.com/PlakarKorp/plakar/subcommands/scheduler"
@@ -122,6 +125,8 @@ func entryPoint() int {
 	var opt_agentless bool
 	var opt_enableSecurityCheck bool
 	var opt_disableSecurityCheck bool
+	var opt_json bool
+	var opt_format string
 
 	flag.StringVar(&opt_configdir, "config", opt_configDefault, "configuration directory")
 	flag.IntVar(&opt_cpuCount, "cpu", opt_cpuDefault, "limit the number of usable cores")
@@ -134,6 +139,8 @@ func entryPoint() int {
 	flag.BoolVar(&opt_agentless, "no-agent", false, "run without agent")
 	flag.BoolVar(&opt_enableSecurityCheck, "enable-security-check", false, "enable update check")
 	flag.BoolVar(&opt_disableSecurityCheck, "disable-security-check", false, "disable update check")
+	flag.BoolVar(&opt_json, "json", false, "output records in JSON, same as -format json")
+	flag.StringVar(&opt_format, "format", output.FormatText, "output format (text, json, ndjson, csv)")
 
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [OPTIONS] [at REPOSITORY] COMMAND [COMMAND_OPTIONS]...\n", flag.CommandLine.Name())
@@ -263,10 +270,24 @@ func entryPoint() int {
 		return 1
 	}
 
+	format, err := output.ParseFormat(opt_format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
+		return 1
+	}
+	if opt_json {
+		if format != output.FormatText && format != output.FormatJSON {
+			fmt.Fprintf(os.Stderr, "%s: -json conflicts with -format %s\n", flag.CommandLine.Name(), format)
+			return 1
+		}
+		format = output.FormatJSON
+	}
+
 	logger := logging.NewLogger(os.Stdout, os.Stderr)
 
-	// start logging
-	if !opt_quiet {
+	// start logging, the informational messages would be mixed with
+	// the records on the standard output.
+	if !opt_quiet && format == output.FormatText {
 		logger.EnableInfo()
 	}
 	if opt_trace != "" {
@@ -320,6 +341,12 @@ func entryPoint() int {
 		return 1
 	}
 
+	if format != output.FormatText && cmd.GetFlags()&subcommands.FormatSupport == 0 {
+		fmt.Fprintf(os.Stderr, "%s: %s does not support the %s output format\n",
+			flag.CommandLine.Name(), strings.Join(name, " "), format)
+		return 1
+	}
+
 	// try to get the passphrase from env and store config so that it's
 	// available to subcommands like create.
 	passphrase, err := getPassphraseFromEnv(ctx, storeConfig)
@@ -395,6 +422,7 @@ func entryPoint() int {
 
 	cmd.SetCWD(ctx.CWD)
 	cmd.SetCommandLine(ctx.CommandLine)
+	cmd.SetFormat(format)
 
 	c := make(chan os.Signal, 1)
 	go func() {
@@ -0,0 +1,361 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package metrics keeps counters, gauges and histograms and exposes them in
+// the Prometheus text format.
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// DurationBuckets are histogram buckets, in seconds, suited to task
+// durations ranging from seconds to hours.
+var DurationBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 7200, 14400, 43200}
+
+// Default is the registry of the metrics of the running process.
+var Default = NewRegistry()
+
+type Registry struct {
+	mtx      sync.Mutex
+	families []*family
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+
+	mtx    sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	counts []uint64
+	sum    float64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(name, help, typ string, buckets []float64, labels []string) *family {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for _, f := range r.families {
+		if f.name == name {
+			panic(fmt.Sprintf("metrics: %s registered twice", name))
+		}
+	}
+
+	f := &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+// get returns the series for the given label values, creating it if needed.
+// Must be called with f.mtx held.
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.buckets != nil {
+			s.counts = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a value that only goes up, such as a number of runs.
+type Counter struct {
+	f *family
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{f: r.register(name, help, "counter", nil, labels)}
+}
+
+func (c *Counter) Add(v float64, values ...string) {
+	c.f.mtx.Lock()
+	defer c.f.mtx.Unlock()
+	c.f.get(values).value += v
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Gauge is a value that goes up and down, such as a number of requests in
+// flight or a timestamp.
+type Gauge struct {
+	f *family
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{f: r.register(name, help, "gauge", nil, labels)}
+}
+
+func (g *Gauge) Set(v float64, values ...string) {
+	g.f.mtx.Lock()
+	defer g.f.mtx.Unlock()
+	g.f.get(values).value = v
+}
+
+func (g *Gauge) Add(v float64, values ...string) {
+	g.f.mtx.Lock()
+	defer g.f.mtx.Unlock()
+	g.f.get(values).value += v
+}
+
+// Histogram counts observations, such as durations, in buckets.
+type Histogram struct {
+	f *family
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{f: r.register(name, help, "histogram", buckets, labels)}
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.f.mtx.Lock()
+	defer h.f.mtx.Unlock()
+
+	s := h.f.get(values)
+	s.counts[sort.SearchFloat64s(h.f.buckets, v)]++
+	s.sum += v
+}
+
+// Label is a label attached to a sample produced by a Collector.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Collector produces samples computed when the metrics are scraped, from
+// state that is not kept in the registry.
+type Collector func(w *Writer) error
+
+// Writer gathers the samples produced by collectors, grouped by metric.
+type Writer struct {
+	order   []string
+	headers map[string]string
+	samples map[string]*bytes.Buffer
+}
+
+func newWriter() *Writer {
+	return &Writer{
+		headers: make(map[string]string),
+		samples: make(map[string]*bytes.Buffer),
+	}
+}
+
+func (w *Writer) add(name, help, typ string, value float64, labels []Label) {
+	buf, ok := w.samples[name]
+	if !ok {
+		w.order = append(w.order, name)
+		w.headers[name] = header(name, help, typ)
+		buf = &bytes.Buffer{}
+		w.samples[name] = buf
+	}
+	writeSample(buf, name, labels, value)
+}
+
+func (w *Writer) Gauge(name, help string, value float64, labels ...Label) {
+	w.add(name, help, "gauge", value, labels)
+}
+
+func (w *Writer) Counter(name, help string, value float64, labels ...Label) {
+	w.add(name, help, "counter", value, labels)
+}
+
+// Timestamp exposes t as seconds since the epoch, or nothing if it is the
+// zero time.
+func (w *Writer) Timestamp(name, help string, t time.Time, labels ...Label) {
+	if t.IsZero() {
+		return
+	}
+	w.Gauge(name, help, float64(t.UnixNano())/1e9, labels...)
+}
+
+func header(name, help, typ string) string {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	return fmt.Sprintf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeSample(buf *bytes.Buffer, name string, labels []Label, value float64) {
+	buf.WriteString(name)
+	if len(labels) != 0 {
+		buf.WriteByte('{')
+		for i, label := range labels {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(label.Name)
+			buf.WriteString(`="`)
+			buf.WriteString(strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(label.Value))
+			buf.WriteByte('"')
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatValue(value))
+	buf.WriteByte('\n')
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (f *family) write(buf *bytes.Buffer) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if len(f.series) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf.WriteString(header(f.name, f.help, f.typ))
+	for _, key := range keys {
+		s := f.series[key]
+		labels := make([]Label, len(f.labels))
+		for i, name := range f.labels {
+			labels[i] = Label{Name: name, Value: s.values[i]}
+		}
+
+		if f.buckets == nil {
+			writeSample(buf, f.name, labels, s.value)
+			continue
+		}
+
+		var count uint64
+		for i, bound := range f.buckets {
+			count += s.counts[i]
+			writeSample(buf, f.name+"_bucket", append(labels, Label{"le", formatValue(bound)}), float64(count))
+		}
+		count += s.counts[len(f.buckets)]
+		writeSample(buf, f.name+"_bucket", append(labels, Label{"le", "+Inf"}), float64(count))
+		writeSample(buf, f.name+"_sum", labels, s.sum)
+		writeSample(buf, f.name+"_count", labels, float64(count))
+	}
+}
+
+// Gather renders the metrics of the registry followed by the samples of the
+// collectors.
+func (r *Registry) Gather(collectors ...Collector) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s", header("plakar_build_info", "Version of the running plakar.", "gauge"))
+	writeSample(&buf, "plakar_build_info", []Label{{"version", utils.GetVersion()}}, 1)
+
+	r.mtx.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mtx.Unlock()
+	for _, f := range families {
+		f.write(&buf)
+	}
+
+	w := newWriter()
+	for _, collect := range collectors {
+		if err := collect(w); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range w.order {
+		buf.WriteString(w.headers[name])
+		buf.Write(w.samples[name].Bytes())
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Handler serves the metrics of the registry and of the collectors.
+func (r *Registry) Handler(collectors ...Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, err := r.Gather(collectors...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(data)
+	})
+}
+
+// Serve exposes the metrics of the default registry and of the collectors
+// at /metrics on addr, until ctx is done.
+func Serve(ctx context.Context, addr string, collectors ...Collector) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", Default.Handler(collectors...))
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go server.Serve(listener)
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	runs := r.NewCounter("test_runs_total", "Runs.", "kind")
+	inflight := r.NewGauge("test_inflight", "In flight.")
+	duration := r.NewHistogram("test_duration_seconds", "Durations.", []float64{10, 1}, "kind")
+
+	runs.Inc("backup")
+	runs.Add(2, "check")
+	inflight.Add(1)
+	inflight.Add(1)
+	inflight.Add(-1)
+	duration.Observe(0.5, "backup")
+	duration.Observe(1, "backup")
+	duration.Observe(30, "backup")
+
+	data, err := r.Gather()
+	require.NoError(t, err)
+	out := string(data)
+
+	require.Contains(t, out, "# TYPE test_runs_total counter\n")
+	require.Contains(t, out, `test_runs_total{kind="backup"} 1`+"\n")
+	require.Contains(t, out, `test_runs_total{kind="check"} 2`+"\n")
+	require.Contains(t, out, "test_inflight 1\n")
+	require.Contains(t, out, `test_duration_seconds_bucket{kind="backup",le="1"} 2`+"\n")
+	require.Contains(t, out, `test_duration_seconds_bucket{kind="backup",le="10"} 2`+"\n")
+	require.Contains(t, out, `test_duration_seconds_bucket{kind="backup",le="+Inf"} 3`+"\n")
+	require.Contains(t, out, `test_duration_seconds_sum{kind="backup"} 31.5`+"\n")
+	require.Contains(t, out, `test_duration_seconds_count{kind="backup"} 3`+"\n")
+
+	require.Panics(t, func() { r.NewGauge("test_inflight", "Again.") })
+	require.Panics(t, func() { runs.Inc() })
+}
+
+func TestCollectors(t *testing.T) {
+	r := NewRegistry()
+
+	handler := r.Handler(func(w *Writer) error {
+		w.Gauge("test_size_bytes", "Size.", 42, Label{"repository", `/var/"backups"`})
+		w.Timestamp("test_last_success_timestamp_seconds", "Last success.", time.Time{})
+		w.Timestamp("test_last_run_timestamp_seconds", "Last run.", time.Unix(1700000000, 0))
+		w.Gauge("test_size_bytes", "Size.", 7, Label{"repository", "/tmp"})
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4"))
+
+	out := rec.Body.String()
+	require.Contains(t, out, "plakar_build_info{version=")
+	require.Equal(t, 1, strings.Count(out, "# TYPE test_size_bytes gauge"))
+	require.Contains(t, out, `test_size_bytes{repository="/var/\"backups\""} 42`+"\ntest_size_bytes{repository=\"/tmp\"} 7\n")
+	require.NotContains(t, out, "test_last_success_timestamp_seconds")
+	require.Contains(t, out, "test_last_run_timestamp_seconds 1.7e+09\n")
+
+	failing := r.Handler(func(w *Writer) error { return errors.New("boom") })
+	rec = httptest.NewRecorder()
+	failing.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+}
@@ -0,0 +1,449 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package client implements the store talking to a plakar server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/network"
+)
+
+func init() {
+	storage.Register("plakar+http", 0, NewStore)
+	storage.Register("plakar+https", 0, NewStore)
+}
+
+// Store reaches a store exposed by plakar server. Its configuration
+// accepts:
+//
+//   - token: the bearer token to authenticate with
+//   - tls_ca: a file of CA certificates to verify the server against
+//   - tls_fingerprint: the SHA-256 fingerprint of the server certificate,
+//     to pin a self-signed certificate instead of verifying it
+//   - tls_cert, tls_key: a client certificate for mTLS
+//   - repository: the name of the repository on a server hosting several,
+//     unless the location already ends with /r/<name>
+type Store struct {
+	location   string
+	endpoint   string
+	repository string
+	token      string
+	client     *http.Client
+
+	// policy is what the server told us we can do when opened
+	policy network.Policy
+
+	// version of the protocol negotiated when opened
+	version int
+}
+
+func NewStore(ctx context.Context, proto string, config map[string]string) (storage.Store, error) {
+	location := config["location"]
+	endpoint := strings.TrimSuffix(strings.TrimPrefix(location, "plakar+"), "/")
+
+	repository := config["repository"]
+	if repository != "" {
+		endpoint += "/r/" + url.PathEscape(repository)
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		location:   location,
+		endpoint:   endpoint,
+		repository: repository,
+		token:      config["token"],
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}, nil
+}
+
+func newTLSConfig(config map[string]string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if path, ok := config["tls_ca"]; ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no certificate found", path)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cert, ok := config["tls_cert"]; ok {
+		pair, err := tls.LoadX509KeyPair(cert, config["tls_key"])
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+
+	if fingerprint, ok := config["tls_fingerprint"]; ok {
+		expected, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", ""))
+		if err != nil || len(expected) != sha256.Size {
+			return nil, fmt.Errorf("invalid tls_fingerprint, must be a hex SHA-256")
+		}
+
+		// the pinned certificate replaces the usual verification
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("no server certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if subtle.ConstantTimeCompare(sum[:], expected) != 1 {
+				return fmt.Errorf("server certificate fingerprint %x does not match", sum)
+			}
+			return nil
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+func (s *Store) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	r, err := http.NewRequestWithContext(ctx, method, s.endpoint+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		r.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	return r, nil
+}
+
+// do sends a request and turns the unsuccessful responses into errors.
+func (s *Store) do(r *http.Request) (*http.Response, error) {
+	resp, err := s.client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if resp.StatusCode == http.StatusInsufficientStorage {
+			detail := strings.TrimPrefix(strings.TrimSpace(string(msg)), network.ErrQuotaExceeded.Error()+": ")
+			return nil, fmt.Errorf("%s: %w: %s", s.location, network.ErrQuotaExceeded, detail)
+		}
+		return nil, fmt.Errorf("%s %s: %s: %s", r.Method, r.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// call sends a version 1 request to the server and decodes its response.
+func (s *Store) call(ctx context.Context, method, path string, req, res any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	r, err := s.newRequest(ctx, method, path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+func remoteError(msg string) error {
+	if msg == "" {
+		return nil
+	}
+	return errors.New(msg)
+}
+
+// canDelete fails early when the server said it won't allow deletions.
+// Servers predating policies don't advertise a permission and are left to
+// decide by themselves.
+func (s *Store) canDelete() error {
+	if s.policy.Permission == "" || s.policy.Delete {
+		return nil
+	}
+	if s.policy.AppendOnly {
+		return fmt.Errorf("%s is append-only, deletions must be done locally on the server", s.location)
+	}
+	return fmt.Errorf("%s does not allow deletions", s.location)
+}
+
+func (s *Store) Create(ctx context.Context, config []byte) error {
+	return fmt.Errorf("creating a repository through plakar server is not supported")
+}
+
+func (s *Store) Open(ctx context.Context) ([]byte, error) {
+	var res network.ResOpen
+	req := network.ReqOpen{Repository: s.repository, Version: network.ProtocolVersion}
+	if err := s.call(ctx, "GET", "/", req, &res); err != nil {
+		return nil, err
+	}
+	if err := remoteError(res.Err); err != nil {
+		return nil, err
+	}
+	s.policy = res.Policy
+	s.version = max(res.Version, network.ProtocolV1)
+	return res.Configuration, nil
+}
+
+func (s *Store) Location(ctx context.Context) (string, error) {
+	return s.location, nil
+}
+
+func (s *Store) Mode(ctx context.Context) (storage.Mode, error) {
+	return storage.ModeRead | storage.ModeWrite, nil
+}
+
+func (s *Store) Size(ctx context.Context) (int64, error) {
+	return -1, nil
+}
+
+func (s *Store) GetStates(ctx context.Context) ([]objects.MAC, error) {
+	if s.version >= network.ProtocolV2 {
+		return s.list(ctx, "state")
+	}
+
+	var res network.ResGetStates
+	if err := s.call(ctx, "GET", "/states", network.ReqGetStates{}, &res); err != nil {
+		return nil, err
+	}
+	return res.MACs, remoteError(res.Err)
+}
+
+func (s *Store) PutState(ctx context.Context, mac objects.MAC, rd io.Reader) (int64, error) {
+	if s.version >= network.ProtocolV2 {
+		return s.put(ctx, "state", mac, rd)
+	}
+
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return 0, err
+	}
+
+	var res network.ResPutState
+	if err := s.call(ctx, "PUT", "/state", network.ReqPutState{MAC: mac, Data: data}, &res); err != nil {
+		return 0, err
+	}
+	if err := remoteError(res.Err); err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+func (s *Store) GetState(ctx context.Context, mac objects.MAC) (io.ReadCloser, error) {
+	if s.version >= network.ProtocolV2 {
+		return s.get(ctx, "state", mac)
+	}
+
+	var res network.ResGetState
+	if err := s.call(ctx, "GET", "/state", network.ReqGetState{MAC: mac}, &res); err != nil {
+		return nil, err
+	}
+	if err := remoteError(res.Err); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(res.Data)), nil
+}
+
+func (s *Store) DeleteState(ctx context.Context, mac objects.MAC) error {
+	if err := s.canDelete(); err != nil {
+		return err
+	}
+	if s.version >= network.ProtocolV2 {
+		return s.delete(ctx, "state", mac)
+	}
+
+	var res network.ResDeleteState
+	if err := s.call(ctx, "DELETE", "/state", network.ReqDeleteState{MAC: mac}, &res); err != nil {
+		return err
+	}
+	return remoteError(res.Err)
+}
+
+func (s *Store) GetPackfiles(ctx context.Context) ([]objects.MAC, error) {
+	if s.version >= network.ProtocolV2 {
+		return s.list(ctx, "packfile")
+	}
+
+	var res network.ResGetPackfiles
+	if err := s.call(ctx, "GET", "/packfiles", network.ReqGetPackfiles{}, &res); err != nil {
+		return nil, err
+	}
+	return res.MACs, remoteError(res.Err)
+}
+
+func (s *Store) PutPackfile(ctx context.Context, mac objects.MAC, rd io.Reader) (int64, error) {
+	if s.version >= network.ProtocolV2 {
+		return s.put(ctx, "packfile", mac, rd)
+	}
+
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return 0, err
+	}
+
+	var res network.ResPutPackfile
+	if err := s.call(ctx, "PUT", "/packfile", network.ReqPutPackfile{MAC: mac, Data: data}, &res); err != nil {
+		return 0, err
+	}
+	if err := remoteError(res.Err); err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+func (s *Store) GetPackfile(ctx context.Context, mac objects.MAC) (io.ReadCloser, error) {
+	if s.version >= network.ProtocolV2 {
+		return s.get(ctx, "packfile", mac)
+	}
+
+	var res network.ResGetPackfile
+	if err := s.call(ctx, "GET", "/packfile", network.ReqGetPackfile{MAC: mac}, &res); err != nil {
+		return nil, err
+	}
+	if err := remoteError(res.Err); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(res.Data)), nil
+}
+
+func (s *Store) GetPackfileBlob(ctx context.Context, mac objects.MAC, offset uint64, length uint32) (io.ReadCloser, error) {
+	if s.version >= network.ProtocolV2 {
+		return s.getRange(ctx, mac, offset, length)
+	}
+
+	var res network.ResGetPackfileBlob
+	req := network.ReqGetPackfileBlob{MAC: mac, Offset: offset, Length: length}
+	if err := s.call(ctx, "GET", "/packfile/blob", req, &res); err != nil {
+		return nil, err
+	}
+	if err := remoteError(res.Err); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(res.Data)), nil
+}
+
+func (s *Store) DeletePackfile(ctx context.Context, mac objects.MAC) error {
+	if err := s.canDelete(); err != nil {
+		return err
+	}
+	if s.version >= network.ProtocolV2 {
+		return s.delete(ctx, "packfile", mac)
+	}
+
+	var res network.ResDeletePackfile
+	if err := s.call(ctx, "DELETE", "/packfile", network.ReqDeletePackfile{MAC: mac}, &res); err != nil {
+		return err
+	}
+	return remoteError(res.Err)
+}
+
+func (s *Store) GetLocks(ctx context.Context) ([]objects.MAC, error) {
+	if s.version >= network.ProtocolV2 {
+		return s.list(ctx, "lock")
+	}
+
+	var res network.ResGetLocks
+	if err := s.call(ctx, "GET", "/locks", network.ReqGetLocks{}, &res); err != nil {
+		return nil, err
+	}
+	return res.Locks, remoteError(res.Err)
+}
+
+func (s *Store) PutLock(ctx context.Context, lockID objects.MAC, rd io.Reader) (int64, error) {
+	if s.version >= network.ProtocolV2 {
+		return s.put(ctx, "lock", lockID, rd)
+	}
+
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return 0, err
+	}
+
+	var res network.ResPutLock
+	if err := s.call(ctx, "PUT", "/lock", network.ReqPutLock{Mac: lockID, Data: data}, &res); err != nil {
+		return 0, err
+	}
+	if err := remoteError(res.Err); err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+func (s *Store) GetLock(ctx context.Context, lockID objects.MAC) (io.ReadCloser, error) {
+	if s.version >= network.ProtocolV2 {
+		return s.get(ctx, "lock", lockID)
+	}
+
+	var res network.ResGetLock
+	if err := s.call(ctx, "GET", "/lock", network.ReqGetLock{Mac: lockID}, &res); err != nil {
+		return nil, err
+	}
+	if err := remoteError(res.Err); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(res.Data)), nil
+}
+
+func (s *Store) DeleteLock(ctx context.Context, lockID objects.MAC) error {
+	if s.version >= network.ProtocolV2 {
+		return s.delete(ctx, "lock", lockID)
+	}
+
+	var res network.ResDeleteLock
+	if err := s.call(ctx, "DELETE", "/lock", network.ReqDeleteLock{Mac: lockID}, &res); err != nil {
+		return err
+	}
+	return remoteError(res.Err)
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	s.client.CloseIdleConnections()
+	return nil
+}
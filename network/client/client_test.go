@@ -0,0 +1,284 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/network"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer serves the packfiles of version 2 of the protocol from memory,
+// or only version 1 when legacy is set.
+type fakeServer struct {
+	token  string
+	legacy bool
+
+	// ranges tells whether Range requests are honoured
+	ranges bool
+
+	mu        sync.Mutex
+	packfiles map[objects.MAC][]byte
+	lengths   []int64
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{
+		ranges:    true,
+		packfiles: make(map[objects.MAC][]byte),
+	}
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.token != "" && r.Header.Get("Authorization") != "Bearer "+f.token {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/":
+		version := network.ProtocolV2
+		if f.legacy {
+			version = 0
+		}
+		json.NewEncoder(w).Encode(network.ResOpen{
+			Configuration: []byte("config"),
+			Policy:        network.Policy{Permission: "full", Delete: true},
+			Version:       version,
+		})
+
+	case "/packfile":
+		var req network.ReqGetPackfile
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(network.ResGetPackfile{Data: f.packfiles[req.MAC]})
+
+	case "/v2/packfiles":
+		if f.legacy {
+			http.NotFound(w, r)
+			return
+		}
+		macs := make([]objects.MAC, 0, len(f.packfiles))
+		for mac := range f.packfiles {
+			macs = append(macs, mac)
+		}
+		json.NewEncoder(w).Encode(macs)
+
+	case "/v2/packfile":
+		if f.legacy {
+			http.NotFound(w, r)
+			return
+		}
+		var mac objects.MAC
+		raw, err := hex.DecodeString(r.Header.Get(network.MACHeader))
+		if err != nil || len(raw) != len(mac) {
+			http.Error(w, "invalid mac", http.StatusBadRequest)
+			return
+		}
+		copy(mac[:], raw)
+
+		switch r.Method {
+		case "PUT":
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			f.packfiles[mac] = data
+			f.lengths = append(f.lengths, r.ContentLength)
+		case "DELETE":
+			delete(f.packfiles, mac)
+		default:
+			data, ok := f.packfiles[mac]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			if !f.ranges {
+				r.Header.Del("Range")
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func openStore(t *testing.T, config map[string]string) *Store {
+	store, err := NewStore(context.Background(), "plakar+http", config)
+	require.NoError(t, err)
+	_, err = store.Open(context.Background())
+	require.NoError(t, err)
+	return store.(*Store)
+}
+
+func TestAuth(t *testing.T) {
+	fake := newFakeServer()
+	fake.token = "secret"
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	store, err := NewStore(context.Background(), "plakar+http", map[string]string{
+		"location": "plakar+" + ts.URL,
+	})
+	require.NoError(t, err)
+	_, err = store.Open(context.Background())
+	require.ErrorContains(t, err, "401")
+
+	store, err = NewStore(context.Background(), "plakar+http", map[string]string{
+		"location": "plakar+" + ts.URL,
+		"token":    "wrong",
+	})
+	require.NoError(t, err)
+	_, err = store.Open(context.Background())
+	require.ErrorContains(t, err, "invalid token")
+
+	cl := openStore(t, map[string]string{
+		"location": "plakar+" + ts.URL,
+		"token":    "secret",
+	})
+	require.Equal(t, "full", cl.policy.Permission)
+	require.Equal(t, network.ProtocolV2, cl.version)
+}
+
+func TestTLSFingerprint(t *testing.T) {
+	ts := httptest.NewTLSServer(newFakeServer())
+	defer ts.Close()
+
+	sum := sha256.Sum256(ts.Certificate().Raw)
+	store, err := NewStore(context.Background(), "plakar+https", map[string]string{
+		"location":        "plakar+" + ts.URL,
+		"tls_fingerprint": hex.EncodeToString(sum[:]),
+	})
+	require.NoError(t, err)
+	_, err = store.Open(context.Background())
+	require.NoError(t, err)
+
+	store, err = NewStore(context.Background(), "plakar+https", map[string]string{
+		"location":        "plakar+" + ts.URL,
+		"tls_fingerprint": strings.Repeat("00", sha256.Size),
+	})
+	require.NoError(t, err)
+	_, err = store.Open(context.Background())
+	require.ErrorContains(t, err, "does not match")
+
+	// without pinning, the self-signed certificate is rejected
+	store, err = NewStore(context.Background(), "plakar+https", map[string]string{
+		"location": "plakar+" + ts.URL,
+	})
+	require.NoError(t, err)
+	_, err = store.Open(context.Background())
+	require.Error(t, err)
+
+	_, err = NewStore(context.Background(), "plakar+https", map[string]string{
+		"location":        "plakar+" + ts.URL,
+		"tls_fingerprint": "abcd",
+	})
+	require.ErrorContains(t, err, "invalid tls_fingerprint")
+}
+
+func TestStreaming(t *testing.T) {
+	fake := newFakeServer()
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	ctx := context.Background()
+	store := openStore(t, map[string]string{"location": "plakar+" + ts.URL})
+
+	data := []byte("0123456789abcdef")
+	mac := objects.MAC{1}
+
+	n, err := store.PutPackfile(ctx, mac, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+
+	// a reader of unknown length is streamed in chunks
+	n, err = store.PutPackfile(ctx, objects.MAC{2}, io.MultiReader(bytes.NewReader(data)))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, []int64{int64(len(data)), -1}, fake.lengths)
+
+	macs, err := store.GetPackfiles(ctx)
+	require.NoError(t, err)
+	slices.SortFunc(macs, func(a, b objects.MAC) int { return bytes.Compare(a[:], b[:]) })
+	require.Equal(t, []objects.MAC{{1}, {2}}, macs)
+
+	rd, err := store.GetPackfile(ctx, mac)
+	require.NoError(t, err)
+	got, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.NoError(t, rd.Close())
+	require.Equal(t, data, got)
+
+	for _, ranges := range []bool{true, false} {
+		fake.ranges = ranges
+		rd, err = store.GetPackfileBlob(ctx, mac, 4, 6)
+		require.NoError(t, err)
+		got, err = io.ReadAll(rd)
+		require.NoError(t, err)
+		require.NoError(t, rd.Close())
+		require.Equal(t, data[4:10], got, "ranges: %v", ranges)
+	}
+
+	require.NoError(t, store.DeletePackfile(ctx, mac))
+	_, err = store.GetPackfile(ctx, mac)
+	require.ErrorContains(t, err, "404")
+}
+
+func TestLegacyProtocol(t *testing.T) {
+	fake := newFakeServer()
+	fake.legacy = true
+	fake.packfiles[objects.MAC{1}] = []byte("0123456789")
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	store := openStore(t, map[string]string{"location": "plakar+" + ts.URL})
+	require.Equal(t, network.ProtocolV1, store.version)
+
+	rd, err := store.GetPackfile(context.Background(), objects.MAC{1})
+	require.NoError(t, err)
+	got, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, []byte("0123456789"), got)
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			json.NewEncoder(w).Encode(network.ResOpen{Version: network.ProtocolV2})
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, fmt.Sprintf("%s: 10 bytes left", network.ErrQuotaExceeded), http.StatusInsufficientStorage)
+	}))
+	defer ts.Close()
+
+	location := "plakar+" + ts.URL
+	store := openStore(t, map[string]string{"location": location})
+
+	_, err := store.PutPackfile(context.Background(), objects.MAC{1}, bytes.NewReader([]byte("data")))
+	require.ErrorIs(t, err, network.ErrQuotaExceeded)
+	require.EqualError(t, err, location+": quota exceeded: 10 bytes left")
+
+	_, err = store.PutState(context.Background(), objects.MAC{1}, bytes.NewReader([]byte("data")))
+	require.ErrorIs(t, err, network.ErrQuotaExceeded)
+}
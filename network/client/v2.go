@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/network"
+)
+
+// Version 2 of the protocol streams the objects as raw bodies, naming them
+// in the network.MACHeader header.
+
+type countingReader struct {
+	rd io.Reader
+	n  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.rd.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (s *Store) objectRequest(ctx context.Context, method, kind string, mac objects.MAC, body io.Reader) (*http.Request, error) {
+	r, err := s.newRequest(ctx, method, "/v2/"+kind, body)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set(network.MACHeader, hex.EncodeToString(mac[:]))
+	return r, nil
+}
+
+func (s *Store) list(ctx context.Context, kind string) ([]objects.MAC, error) {
+	r, err := s.newRequest(ctx, "GET", "/v2/"+kind+"s", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var macs []objects.MAC
+	if err := json.NewDecoder(resp.Body).Decode(&macs); err != nil {
+		return nil, err
+	}
+	return macs, nil
+}
+
+func (s *Store) get(ctx context.Context, kind string, mac objects.MAC) (io.ReadCloser, error) {
+	r, err := s.objectRequest(ctx, "GET", kind, mac, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(r)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *Store) getRange(ctx context.Context, mac objects.MAC, offset uint64, length uint32) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(&io.LimitedReader{}), nil
+	}
+
+	r, err := s.objectRequest(ctx, "GET", "packfile", mac, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+uint64(length)-1))
+
+	resp, err := s.do(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		// an intermediary dropped the range, skip to it
+		if _, err := io.CopyN(io.Discard, resp.Body, int64(offset)); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	return readCloser{io.LimitReader(resp.Body, int64(length)), resp.Body}, nil
+}
+
+func (s *Store) put(ctx context.Context, kind string, mac objects.MAC, rd io.Reader) (int64, error) {
+	counter := &countingReader{rd: rd}
+	r, err := s.objectRequest(ctx, "PUT", kind, mac, counter)
+	if err != nil {
+		return 0, err
+	}
+	r.Header.Set("Content-Type", "application/octet-stream")
+	if length, ok := contentLength(rd); ok {
+		r.ContentLength = length
+	}
+
+	resp, err := s.do(r)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return counter.n, nil
+}
+
+// contentLength returns the length of the readers that know it, so that
+// the server gets it upfront.
+func contentLength(rd io.Reader) (int64, bool) {
+	if lr, ok := rd.(interface{ Len() int }); ok {
+		return int64(lr.Len()), true
+	}
+	return 0, false
+}
+
+func (s *Store) delete(ctx context.Context, kind string, mac objects.MAC) error {
+	r, err := s.objectRequest(ctx, "DELETE", kind, mac, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(r)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
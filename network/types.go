@@ -1,10 +1,30 @@
 package network
 
 import (
+	"errors"
+	"time"
+
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/google/uuid"
 )
 
+// Versions of the protocol spoken with plakar server. Version 1 carries the
+// data inside JSON bodies, version 2 streams raw bodies and names the
+// object in the MACHeader header. The version is negotiated when opening
+// the store, and servers which don't answer with one only speak version 1.
+const (
+	ProtocolV1      = 1
+	ProtocolV2      = 2
+	ProtocolVersion = ProtocolV2
+
+	MACHeader = "X-Plakar-Mac"
+)
+
+// ErrQuotaExceeded is returned when storing an object would exceed the
+// quota of the repository, which servers report with the HTTP status
+// 507 Insufficient Storage.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
 type Request struct {
 	Uuid    uuid.UUID
 	Type    string
@@ -22,13 +42,44 @@ type ResCreate struct {
 
 type ReqOpen struct {
 	Repository string
+	Version    int
 }
 
 type ResOpen struct {
 	Configuration []byte
+	Policy        Policy
+	Version       int
 	Err           string
 }
 
+// ResIndex lists the repositories hosted by a server which the client can
+// access. It is what GET / returns when no repository is named, and sets
+// Err so that clients expecting a ResOpen fail with a helpful message.
+type ResIndex struct {
+	Repositories []RepositoryInfo
+	Err          string
+}
+
+type RepositoryInfo struct {
+	Name   string
+	Policy Policy
+}
+
+// Policy tells a client what the server lets it do on the store.
+type Policy struct {
+	// Permission is the one granted to the client: read, append or full.
+	Permission string
+
+	// AppendOnly is set when states and packfiles can never be deleted
+	// nor overwritten.
+	AppendOnly bool
+
+	// Delete is set when the client may delete states and packfiles
+	// older than Retention.
+	Delete    bool
+	Retention time.Duration
+}
+
 // states
 type ReqGetStates struct {
 }
@@ -137,3 +188,29 @@ type ReqDeleteLock struct {
 type ResDeleteLock struct {
 	Err string
 }
+
+// Usage
+type ReqGetUsage struct{}
+
+// Quota caps what the clients may store in a repository, zero meaning no
+// limit. Objects counts both states and packfiles.
+type Quota struct {
+	Bytes   int64
+	Objects int64
+}
+
+type UsageSample struct {
+	Time      time.Time
+	Bytes     int64
+	Packfiles int
+	States    int
+}
+
+type ResGetUsage struct {
+	Current UsageSample
+	Quota   Quota
+
+	// History samples the usage at most hourly, from the oldest.
+	History []UsageSample
+	Err     string
+}
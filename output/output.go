@@ -0,0 +1,121 @@
+// Package output writes the results of the read-only commands as
+// records, in one of the machine-readable formats, so that scripts
+// don't have to parse the text output.
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// ParseFormat validates the name of an output format.
+func ParseFormat(format string) (string, error) {
+	switch format {
+	case "":
+		return FormatText, nil
+	case FormatText, FormatJSON, FormatNDJSON, FormatCSV:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+// Structured returns false if the format is the usual text output
+// rather than records.
+func Structured(format string) bool {
+	return format != "" && format != FormatText
+}
+
+// Record is a result that can be encoded. It is marshalled as is in
+// JSON, and as a row of the given columns in CSV.
+type Record interface {
+	Columns() []string
+	Row() []string
+}
+
+// Encoder writes records to w. In JSON, the records are written as an
+// array which is only terminated by Close, in NDJSON one per line and in
+// CSV one per row after a header line.
+type Encoder struct {
+	w      io.Writer
+	format string
+	csv    *csv.Writer
+	count  int
+}
+
+func NewEncoder(w io.Writer, format string) *Encoder {
+	enc := &Encoder{
+		w:      w,
+		format: format,
+	}
+	if format == FormatCSV {
+		enc.csv = csv.NewWriter(w)
+	}
+	return enc
+}
+
+func (enc *Encoder) Structured() bool {
+	return Structured(enc.format)
+}
+
+func (enc *Encoder) Encode(rec Record) error {
+	defer func() { enc.count++ }()
+
+	switch enc.format {
+	case FormatJSON, FormatNDJSON:
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if enc.format == FormatJSON {
+			sep := ",\n"
+			if enc.count == 0 {
+				sep = "[\n"
+			}
+			_, err = fmt.Fprintf(enc.w, "%s%s", sep, data)
+		} else {
+			_, err = fmt.Fprintf(enc.w, "%s\n", data)
+		}
+		return err
+
+	case FormatCSV:
+		if enc.count == 0 {
+			if err := enc.csv.Write(rec.Columns()); err != nil {
+				return err
+			}
+		}
+		if err := enc.csv.Write(rec.Row()); err != nil {
+			return err
+		}
+		enc.csv.Flush()
+		return enc.csv.Error()
+
+	default:
+		return fmt.Errorf("records can't be written in %s format", enc.format)
+	}
+}
+
+// Close terminates the output, it must be called once all the records
+// are written, even if there were none or writing them stopped on an
+// error, so that the JSON array is still valid.
+func (enc *Encoder) Close() error {
+	if enc.format != FormatJSON {
+		return nil
+	}
+	var err error
+	if enc.count == 0 {
+		_, err = fmt.Fprintln(enc.w, "[]")
+	} else {
+		_, err = fmt.Fprintln(enc.w, "\n]")
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFormat(t *testing.T) {
+	for _, format := range []string{"text", "json", "ndjson", "csv"} {
+		got, err := ParseFormat(format)
+		require.NoError(t, err)
+		require.Equal(t, format, got)
+	}
+
+	got, err := ParseFormat("")
+	require.NoError(t, err)
+	require.Equal(t, FormatText, got)
+
+	_, err = ParseFormat("yaml")
+	require.Error(t, err)
+}
+
+func TestEncoderJSON(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf, FormatJSON)
+	require.True(t, enc.Structured())
+	require.NoError(t, enc.Encode(&LocateHit{Snapshot: "abcd", ShortID: "ab", Path: "/a"}))
+	require.NoError(t, enc.Encode(&LocateHit{Snapshot: "abcd", ShortID: "ab", Path: "/b"}))
+	require.NoError(t, enc.Close())
+
+	var hits []LocateHit
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &hits))
+	require.Equal(t, []LocateHit{
+		{Snapshot: "abcd", ShortID: "ab", Path: "/a"},
+		{Snapshot: "abcd", ShortID: "ab", Path: "/b"},
+	}, hits)
+
+	buf.Reset()
+	enc = NewEncoder(buf, FormatJSON)
+	require.NoError(t, enc.Close())
+	require.Equal(t, "[]\n", buf.String())
+}
+
+func TestEncoderJSONError(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	list := func() (err error) {
+		enc := NewEncoder(buf, FormatJSON)
+		defer func() {
+			if cerr := enc.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+		if err := enc.Encode(&LocateHit{Snapshot: "abcd", ShortID: "ab", Path: "/a"}); err != nil {
+			return err
+		}
+		return errors.New("could not get pathname")
+	}
+	require.EqualError(t, list(), "could not get pathname")
+
+	var hits []LocateHit
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &hits))
+	require.Equal(t, []LocateHit{{Snapshot: "abcd", ShortID: "ab", Path: "/a"}}, hits)
+}
+
+func TestEncoderNDJSON(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf, FormatNDJSON)
+	require.NoError(t, enc.Encode(&CheckResult{Snapshot: "abcd", Path: "/", Status: CheckOK}))
+	require.NoError(t, enc.Encode(&CheckResult{Snapshot: "ef01", Path: "/", Status: CheckFailed, Error: "boom"}))
+	require.NoError(t, enc.Close())
+
+	require.Equal(t, `{"snapshot":"abcd","short_id":"","path":"/","status":"ok","signature":""}
+{"snapshot":"ef01","short_id":"","path":"/","status":"failed","signature":"","error":"boom"}
+`, buf.String())
+}
+
+func TestEncoderCSV(t *testing.T) {
+	mtime := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf, FormatCSV)
+	require.NoError(t, enc.Encode(&Entry{
+		Path:    "/etc/passwd",
+		Name:    "passwd",
+		Type:    FileType(0o644),
+		Mode:    os.FileMode(0o644).String(),
+		Size:    1024,
+		ModTime: mtime,
+		User:    "root",
+		Group:   "wheel",
+		Nlink:   1,
+	}))
+	require.NoError(t, enc.Close())
+
+	require.Equal(t, "snapshot,path,name,type,mode,size,mtime,uid,gid,user,group,nlink,target\n"+
+		",/etc/passwd,passwd,file,-rw-r--r--,1024,2025-03-01T12:00:00Z,0,0,root,wheel,1,\n", buf.String())
+}
+
+func TestEncoderText(t *testing.T) {
+	enc := NewEncoder(bytes.NewBuffer(nil), FormatText)
+	require.False(t, enc.Structured())
+	require.Error(t, enc.Encode(&LocateHit{}))
+}
+
+func TestFileType(t *testing.T) {
+	require.Equal(t, "file", FileType(0o644))
+	require.Equal(t, "directory", FileType(os.ModeDir|0o755))
+	require.Equal(t, "symlink", FileType(os.ModeSymlink|0o777))
+	require.Equal(t, "pipe", FileType(os.ModeNamedPipe|0o600))
+	require.Equal(t, "socket", FileType(os.ModeSocket|0o600))
+	require.Equal(t, "device", FileType(os.ModeDevice|os.ModeCharDevice|0o600))
+}
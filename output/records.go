@@ -0,0 +1,216 @@
+package output
+
+import (
+	"encoding/hex"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/snapshot/header"
+)
+
+// The records below are part of the interface of plakar: fields may be
+// added, but not renamed or removed. Times are in RFC 3339 format, in
+// UTC, durations in seconds and sizes in bytes.
+
+// Snapshot describes a snapshot, as listed by ls and info.
+type Snapshot struct {
+	ID                string    `json:"id"`
+	ShortID           string    `json:"short_id"`
+	Timestamp         time.Time `json:"timestamp"`
+	Duration          float64   `json:"duration"`
+	Name              string    `json:"name"`
+	Category          string    `json:"category"`
+	Environment       string    `json:"environment"`
+	Perimeter         string    `json:"perimeter"`
+	Job               string    `json:"job"`
+	Tags              []string  `json:"tags"`
+	Hostname          string    `json:"hostname"`
+	ImporterType      string    `json:"importer_type"`
+	ImporterOrigin    string    `json:"importer_origin"`
+	ImporterDirectory string    `json:"importer_directory"`
+	Size              uint64    `json:"size"`
+	Files             uint64    `json:"files"`
+	Directories       uint64    `json:"directories"`
+	Symlinks          uint64    `json:"symlinks"`
+	Errors            uint64    `json:"errors"`
+}
+
+func NewSnapshot(hdr *header.Header) *Snapshot {
+	indexID := hdr.GetIndexID()
+	source := hdr.GetSource(0)
+	summary := source.Summary
+
+	tags := hdr.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return &Snapshot{
+		ID:                hex.EncodeToString(indexID[:]),
+		ShortID:           hex.EncodeToString(hdr.GetIndexShortID()),
+		Timestamp:         hdr.Timestamp.UTC(),
+		Duration:          hdr.Duration.Seconds(),
+		Name:              hdr.Name,
+		Category:          hdr.Category,
+		Environment:       hdr.Environment,
+		Perimeter:         hdr.Perimeter,
+		Job:               hdr.Job,
+		Tags:              tags,
+		Hostname:          hdr.GetContext("Hostname"),
+		ImporterType:      source.Importer.Type,
+		ImporterOrigin:    source.Importer.Origin,
+		ImporterDirectory: source.Importer.Directory,
+		Size:              summary.Directory.Size + summary.Below.Size,
+		Files:             summary.Directory.Files + summary.Below.Files,
+		Directories:       summary.Directory.Directories + summary.Below.Directories,
+		Symlinks:          summary.Directory.Symlinks + summary.Below.Symlinks,
+		Errors:            summary.Directory.Errors + summary.Below.Errors,
+	}
+}
+
+func (s *Snapshot) Columns() []string {
+	return []string{"id", "short_id", "timestamp", "duration", "name",
+		"category", "environment", "perimeter", "job", "tags", "hostname",
+		"importer_type", "importer_origin", "importer_directory", "size",
+		"files", "directories", "symlinks", "errors"}
+}
+
+func (s *Snapshot) Row() []string {
+	return []string{s.ID, s.ShortID, formatTime(s.Timestamp),
+		strconv.FormatFloat(s.Duration, 'f', -1, 64), s.Name, s.Category,
+		s.Environment, s.Perimeter, s.Job, strings.Join(s.Tags, ","),
+		s.Hostname, s.ImporterType, s.ImporterOrigin, s.ImporterDirectory,
+		formatUint(s.Size), formatUint(s.Files), formatUint(s.Directories),
+		formatUint(s.Symlinks), formatUint(s.Errors)}
+}
+
+// Entry describes a file of a snapshot, as listed by ls.
+type Entry struct {
+	Snapshot string    `json:"snapshot"`
+	Path     string    `json:"path"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Mode     string    `json:"mode"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	UID      uint64    `json:"uid"`
+	GID      uint64    `json:"gid"`
+	User     string    `json:"user"`
+	Group    string    `json:"group"`
+	Nlink    uint16    `json:"nlink"`
+	Target   string    `json:"target,omitempty"`
+}
+
+func (e *Entry) Columns() []string {
+	return []string{"snapshot", "path", "name", "type", "mode", "size",
+		"mtime", "uid", "gid", "user", "group", "nlink", "target"}
+}
+
+func (e *Entry) Row() []string {
+	return []string{e.Snapshot, e.Path, e.Name, e.Type, e.Mode,
+		strconv.FormatInt(e.Size, 10), formatTime(e.ModTime),
+		formatUint(e.UID), formatUint(e.GID), e.User, e.Group,
+		formatUint(uint64(e.Nlink)), e.Target}
+}
+
+// FileType names the type of a file of the given mode, as reported in
+// the type field of the records.
+func FileType(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode&fs.ModeNamedPipe != 0:
+		return "pipe"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	default:
+		return "file"
+	}
+}
+
+// LocateHit is a file matching a pattern given to locate.
+type LocateHit struct {
+	Snapshot string `json:"snapshot"`
+	ShortID  string `json:"short_id"`
+	Path     string `json:"path"`
+}
+
+func (l *LocateHit) Columns() []string {
+	return []string{"snapshot", "short_id", "path"}
+}
+
+func (l *LocateHit) Row() []string {
+	return []string{l.Snapshot, l.ShortID, l.Path}
+}
+
+// The changes reported by diff.
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+	DiffType     = "type"
+)
+
+// DiffEntry is a difference between two versions of a file: one only
+// in the second snapshot is added, one only in the first is removed,
+// one which is a directory on one side only changed type and a file
+// whose content differs is modified, with its unified diff.
+type DiffEntry struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Diff   string `json:"diff,omitempty"`
+}
+
+func (d *DiffEntry) Columns() []string {
+	return []string{"path", "change", "from", "to", "diff"}
+}
+
+func (d *DiffEntry) Row() []string {
+	return []string{d.Path, d.Change, d.From, d.To, d.Diff}
+}
+
+// The statuses of the checks and of the signatures.
+const (
+	CheckOK     = "ok"
+	CheckFailed = "failed"
+
+	SignatureVerified = "verified"
+	SignatureFailed   = "failed"
+)
+
+// CheckResult is the outcome of the check of a path of a snapshot. The
+// signature is empty when it wasn't checked.
+type CheckResult struct {
+	Snapshot  string `json:"snapshot"`
+	ShortID   string `json:"short_id"`
+	Path      string `json:"path"`
+	Status    string `json:"status"`
+	Signature string `json:"signature"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (c *CheckResult) Columns() []string {
+	return []string{"snapshot", "short_id", "path", "status", "signature",
+		"error"}
+}
+
+func (c *CheckResult) Row() []string {
+	return []string{c.Snapshot, c.ShortID, c.Path, c.Status, c.Signature,
+		c.Error}
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatUint(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
@@ -0,0 +1,63 @@
+//go:build linux || darwin
+
+package plakarfs
+
+import (
+	"container/list"
+)
+
+// chunkCache keeps the most recently used chunks of a file, up to a
+// number of bytes.
+type chunkCache struct {
+	size    int64
+	used    int64
+	order   *list.List
+	entries map[int]*list.Element
+}
+
+type cachedChunk struct {
+	idx  int
+	data []byte
+}
+
+func newChunkCache(size int64) *chunkCache {
+	return &chunkCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[int]*list.Element),
+	}
+}
+
+func (c *chunkCache) get(idx int) ([]byte, bool) {
+	elem, ok := c.entries[idx]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cachedChunk).data, true
+}
+
+// put adds a chunk, evicting the least recently used ones to make room
+// for it.
+func (c *chunkCache) put(idx int, data []byte) {
+	if elem, ok := c.entries[idx]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	for c.used+int64(len(data)) > c.size && c.order.Len() != 0 {
+		last := c.order.Back()
+		chunk := c.order.Remove(last).(*cachedChunk)
+		delete(c.entries, chunk.idx)
+		c.used -= int64(len(chunk.data))
+	}
+
+	c.entries[idx] = c.order.PushFront(&cachedChunk{idx: idx, data: data})
+	c.used += int64(len(data))
+}
+
+func (c *chunkCache) reset() {
+	c.order.Init()
+	clear(c.entries)
+	c.used = 0
+}
@@ -0,0 +1,38 @@
+//go:build linux || darwin
+
+package plakarfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkCache(t *testing.T) {
+	c := newChunkCache(10)
+
+	c.put(0, []byte("abcd"))
+	c.put(1, []byte("efgh"))
+	data, ok := c.get(0)
+	require.True(t, ok)
+	require.Equal(t, []byte("abcd"), data)
+
+	// chunk 1 is the least recently used one
+	c.put(2, []byte("ijkl"))
+	_, ok = c.get(1)
+	require.False(t, ok)
+	_, ok = c.get(0)
+	require.True(t, ok)
+	require.Equal(t, int64(8), c.used)
+
+	// a chunk larger than the cache is still kept until the next one
+	c.put(3, make([]byte, 20))
+	require.Equal(t, 1, c.order.Len())
+	_, ok = c.get(3)
+	require.True(t, ok)
+
+	c.reset()
+	_, ok = c.get(3)
+	require.False(t, ok)
+	require.Zero(t, c.used)
+}
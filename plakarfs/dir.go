@@ -6,7 +6,6 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
-	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -26,38 +25,48 @@ type Dir struct {
 	repo     *repository.Repository
 	snap     *snapshot.Snapshot
 	vfs      *vfs.Filesystem
+	fs       *FS
+}
+
+// isRoot reports whether d is the root of a filesystem exposing the
+// whole repository.
+func (d *Dir) isRoot() bool {
+	return d.parent == nil && d.snap == nil
 }
 
 func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
-	if d.name == "/" {
+	defer d.fs.attr(a)
+
+	if d.isRoot() {
 		d.fullpath = d.name
 		a.Inode = 1
 		a.Mode = os.ModeDir | 0o700
 		a.Uid = uint32(os.Geteuid())
 		a.Gid = uint32(os.Getgid())
-	} else if d.parent.name == "/" {
-		snapshotID, err := hex.DecodeString(d.name)
-		if err != nil {
-			return err
-		}
-		if len(snapshotID) != 32 {
-			return fmt.Errorf("invalid snapshot id length %d", len(snapshotID))
-		}
-		snap, err := snapshot.Load(d.repo, objects.MAC(snapshotID))
+	} else if d.parent != nil && d.parent.isRoot() {
+		snapshotID, err := parseSnapshotID(d.name)
 		if err != nil {
 			return err
 		}
-		snapfs, err := snap.Filesystem()
-		if err != nil {
-			return err
+		if d.snap == nil {
+			snap, err := snapshot.Load(d.repo, snapshotID)
+			if err != nil {
+				return err
+			}
+			snapfs, err := snap.Filesystem()
+			if err != nil {
+				snap.Close()
+				return err
+			}
+
+			d.snap = snap
+			d.vfs = snapfs
 		}
-
-		d.snap = snap
+		snap := d.snap
 		d.repo = d.parent.repo
-		d.vfs = snapfs
 		d.fullpath = "/"
 
-		a.Inode = rand.Uint64()
+		a.Inode = snapshotInode(snapshotID)
 		a.Mode = os.ModeDir | 0o700
 		a.Uid = uint32(os.Geteuid())
 		a.Gid = uint32(os.Getgid())
@@ -66,12 +75,16 @@ func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
 		a.Atime = snap.Header.Timestamp
 		a.Size = snap.Header.GetSource(0).Summary.Directory.Size + snap.Header.GetSource(0).Summary.Below.Size
 	} else {
-		d.snap = d.parent.snap
-		d.repo = d.parent.repo
-		d.vfs = d.parent.vfs
-		d.fullpath = d.parent.fullpath + "/" + d.name
-
-		d.fullpath = filepath.Clean(d.fullpath)
+		// the root of a filesystem exposing a single snapshot has
+		// no parent to inherit from.
+		if d.parent != nil {
+			d.snap = d.parent.snap
+			d.repo = d.parent.repo
+			d.vfs = d.parent.vfs
+			d.fullpath = d.parent.fullpath + "/" + d.name
+
+			d.fullpath = filepath.Clean(d.fullpath)
+		}
 
 		fi, err := d.vfs.GetEntry(d.fullpath)
 		if err != nil {
@@ -82,51 +95,65 @@ func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
 			panic(fmt.Sprintf("unexpected type %T", fi))
 		}
 
-		a.Rdev = uint32(fi.Stat().Dev())
-		a.Inode = fi.Stat().Ino()
-		a.Mode = fi.Stat().Mode()
-		a.Uid = uint32(fi.Stat().Uid())
-		a.Gid = uint32(fi.Stat().Gid())
-		a.Ctime = fi.Stat().ModTime()
-		a.Mtime = fi.Stat().ModTime()
-		a.Size = uint64(fi.Stat().Size())
+		setEntryAttr(a, d.snap.Header.Identifier, fi)
 	}
 	return nil
 }
 
-func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
-	if d.name == "/" {
-		return &Dir{parent: d, name: name, repo: d.repo}, nil
-	} else if d.parent.name == "/" {
-		return &Dir{parent: d, name: name}, nil
-	} else {
-		cleanpath := filepath.Clean(d.fullpath + "/" + name)
-		entry, err := d.vfs.GetEntry(cleanpath)
+func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	resp.EntryValid = d.fs.opts.EntryTTL
+	return d.lookup(req.Name)
+}
+
+func (d *Dir) lookup(name string) (fs.Node, error) {
+	if d.isRoot() {
+		if isView(name) {
+			return &ViewDir{fs: d.fs, path: []string{name}}, nil
+		}
+		snapshotID, err := parseSnapshotID(name)
 		if err != nil {
+			return nil, syscall.ENOENT
+		}
+		if _, err := d.fs.index.lookup(snapshotID); err != nil {
 			return nil, err
 		}
+		return &Dir{parent: d, name: name, repo: d.repo, fs: d.fs}, nil
+	}
 
-		if entry.Stat().IsDir() {
-			return &Dir{parent: d, name: name}, nil
-		}
+	cleanpath := filepath.Clean(d.fullpath + "/" + name)
+	entry, err := d.vfs.GetEntry(cleanpath)
+	if err != nil {
+		return nil, syscall.ENOENT
+	}
+
+	switch {
+	case entry.Stat().IsDir():
+		return &Dir{parent: d, name: name, fs: d.fs}, nil
+	case entry.Stat().Mode()&os.ModeSymlink != 0:
+		return &Symlink{parent: d, name: name}, nil
+	default:
 		return &File{parent: d, name: name}, nil
 	}
 }
 
 func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
-	if d.name == "/" {
-
-		d.repo.RebuildState()
-
-		snapshotIDs, err := d.repo.GetSnapshots()
+	if d.isRoot() {
+		headers, err := d.fs.index.snapshots()
 		if err != nil {
 			return nil, err
 		}
-		dirDirs := make([]fuse.Dirent, 0)
-		for idx, snapshotID := range snapshotIDs {
+		dirDirs := make([]fuse.Dirent, 0, len(headers)+len(views))
+		for _, hdr := range headers {
+			dirDirs = append(dirDirs, fuse.Dirent{
+				Inode: snapshotInode(hdr.Identifier),
+				Name:  fmt.Sprintf("%x", hdr.Identifier),
+				Type:  fuse.DT_Dir,
+			})
+		}
+		for _, view := range views {
 			dirDirs = append(dirDirs, fuse.Dirent{
-				Inode: uint64(idx),
-				Name:  fmt.Sprintf("%x", snapshotID),
+				Inode: viewInode([]string{view}),
+				Name:  view,
 				Type:  fuse.DT_Dir,
 			})
 		}
@@ -145,15 +172,49 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 		}
 
 		dirEnt := fuse.Dirent{
-			Inode: entry.Stat().Ino(),
+			Inode: entryInode(d.snap.Header.Identifier, entry),
 			Name:  entry.Name(),
-			Type:  fuse.DT_File,
-		}
-		if entry.Stat().IsDir() {
-			dirEnt.Type = fuse.DT_Dir
+			Type:  direntType(entry.Stat().Mode()),
 		}
 
 		dirDirs = append(dirDirs, dirEnt)
 	}
 	return dirDirs, nil
 }
+
+func parseSnapshotID(name string) (objects.MAC, error) {
+	snapshotID, err := hex.DecodeString(name)
+	if err != nil {
+		return objects.MAC{}, err
+	}
+	if len(snapshotID) != len(objects.MAC{}) {
+		return objects.MAC{}, fmt.Errorf("invalid snapshot id length %d", len(snapshotID))
+	}
+	return objects.MAC(snapshotID), nil
+}
+
+func (d *Dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	// the root of the filesystem isn't part of a snapshot
+	if d.vfs == nil {
+		return nil
+	}
+
+	entry, err := d.vfs.GetEntry(d.fullpath)
+	if err != nil {
+		return syscall.ENOENT
+	}
+	resp.Append(entry.ExtendedAttributes...)
+	return nil
+}
+
+func (d *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	if d.vfs == nil {
+		return fuse.ErrNoXattr
+	}
+
+	entry, err := d.vfs.GetEntry(d.fullpath)
+	if err != nil {
+		return syscall.ENOENT
+	}
+	return getxattr(d.vfs, entry, req, resp)
+}
@@ -0,0 +1,95 @@
+//go:build linux || darwin
+
+package plakarfs
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/anacrolix/fuse"
+)
+
+// entryInode derives the inode of an entry of a snapshot from the
+// device and inode it had when backed up, so that hardlinks share it,
+// while entries of different snapshots don't collide.
+func entryInode(snapshotID objects.MAC, entry *vfs.Entry) uint64 {
+	h := fnv.New64a()
+	h.Write(snapshotID[:])
+	if entry.Stat().Ino() != 0 {
+		var buf [16]byte
+		binary.BigEndian.PutUint64(buf[:8], entry.Stat().Dev())
+		binary.BigEndian.PutUint64(buf[8:], entry.Stat().Ino())
+		h.Write(buf[:])
+	} else {
+		// some importers have no notion of inodes
+		h.Write([]byte(entry.Path()))
+	}
+	return h.Sum64()
+}
+
+// direntType returns the type of the directory entry of a file of the
+// given mode.
+func direntType(mode os.FileMode) fuse.DirentType {
+	switch {
+	case mode.IsDir():
+		return fuse.DT_Dir
+	case mode&os.ModeSymlink != 0:
+		return fuse.DT_Link
+	case mode&os.ModeNamedPipe != 0:
+		return fuse.DT_FIFO
+	case mode&os.ModeSocket != 0:
+		return fuse.DT_Socket
+	case mode&os.ModeCharDevice != 0:
+		return fuse.DT_Char
+	case mode&os.ModeDevice != 0:
+		return fuse.DT_Block
+	default:
+		return fuse.DT_File
+	}
+}
+
+func setEntryAttr(a *fuse.Attr, snapshotID objects.MAC, entry *vfs.Entry) {
+	st := entry.Stat()
+
+	a.Inode = entryInode(snapshotID, entry)
+	a.Mode = st.Mode()
+	a.Uid = uint32(st.Uid())
+	a.Gid = uint32(st.Gid())
+	a.Ctime = st.ModTime()
+	a.Mtime = st.ModTime()
+	a.Atime = st.ModTime()
+	a.Size = uint64(st.Size())
+	a.Nlink = uint32(st.Nlink())
+	if a.Nlink == 0 {
+		a.Nlink = 1
+	}
+	if entry.SymlinkTarget != "" {
+		a.Size = uint64(len(entry.SymlinkTarget))
+	}
+	// the device numbers of device nodes aren't recorded in the
+	// snapshots, so they are left to zero.
+}
+
+// getxattr reads the value of an extended attribute of an entry.
+func getxattr(fsc *vfs.Filesystem, entry *vfs.Entry, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	if !slices.Contains(entry.ExtendedAttributes, req.Name) {
+		return fuse.ErrNoXattr
+	}
+
+	rd, err := entry.Xattr(fsc, req.Name)
+	if err != nil {
+		return fuse.ErrNoXattr
+	}
+
+	value, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	resp.Xattr = value
+	return nil
+}
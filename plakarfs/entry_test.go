@@ -0,0 +1,51 @@
+//go:build linux || darwin
+
+package plakarfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/anacrolix/fuse"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEntry(name string, mode os.FileMode, dev, ino uint64) *vfs.Entry {
+	return &vfs.Entry{
+		ParentPath: "/etc",
+		FileInfo: objects.FileInfo{
+			Lname: name,
+			Lmode: mode,
+			Ldev:  dev,
+			Lino:  ino,
+		},
+	}
+}
+
+func TestEntryInode(t *testing.T) {
+	snap1 := objects.MAC{1}
+	snap2 := objects.MAC{2}
+
+	file := newTestEntry("passwd", 0o644, 1, 42)
+	link := newTestEntry("passwd.link", 0o644, 1, 42)
+	other := newTestEntry("passwd", 0o644, 2, 42)
+	require.Equal(t, entryInode(snap1, file), entryInode(snap1, link))
+	require.NotEqual(t, entryInode(snap1, file), entryInode(snap1, other))
+	require.NotEqual(t, entryInode(snap1, file), entryInode(snap2, file))
+
+	noino1 := newTestEntry("a", 0o644, 0, 0)
+	noino2 := newTestEntry("b", 0o644, 0, 0)
+	require.NotEqual(t, entryInode(snap1, noino1), entryInode(snap1, noino2))
+}
+
+func TestDirentType(t *testing.T) {
+	require.Equal(t, fuse.DT_File, direntType(0o644))
+	require.Equal(t, fuse.DT_Dir, direntType(os.ModeDir|0o755))
+	require.Equal(t, fuse.DT_Link, direntType(os.ModeSymlink|0o777))
+	require.Equal(t, fuse.DT_FIFO, direntType(os.ModeNamedPipe|0o600))
+	require.Equal(t, fuse.DT_Socket, direntType(os.ModeSocket|0o600))
+	require.Equal(t, fuse.DT_Char, direntType(os.ModeDevice|os.ModeCharDevice|0o600))
+	require.Equal(t, fuse.DT_Block, direntType(os.ModeDevice|0o600))
+}
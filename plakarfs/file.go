@@ -5,16 +5,17 @@ package plakarfs
 import (
 	"context"
 	"fmt"
-	"io"
 	"path/filepath"
 	"syscall"
 
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/anacrolix/fuse"
+	"github.com/anacrolix/fuse/fs"
 )
 
-// File implements both Node and Handle for the hello file.
+// File is a file of a snapshot, read through the handles it opens, or
+// one of its fifos, sockets and device nodes.
 type File struct {
 	parent   *Dir
 	name     string
@@ -24,6 +25,8 @@ type File struct {
 }
 
 func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
+	defer f.parent.fs.attr(a)
+
 	f.repo = f.parent.repo
 	f.vfs = f.parent.vfs
 	f.fullpath = f.parent.fullpath + "/" + f.name
@@ -39,22 +42,49 @@ func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
 		panic(fmt.Sprintf("unexpected type %T", entry))
 	}
 
-	a.Rdev = uint32(entry.Stat().Dev())
-	a.Inode = entry.Stat().Ino()
-	a.Mode = entry.Stat().Mode()
-	a.Uid = uint32(entry.Stat().Uid())
-	a.Gid = uint32(entry.Stat().Gid())
-	a.Ctime = entry.Stat().ModTime()
-	a.Mtime = entry.Stat().ModTime()
-	a.Size = uint64(entry.Stat().Size())
-	a.Nlink = uint32(entry.Stat().Nlink())
+	setEntryAttr(a, f.parent.snap.Header.Identifier, entry)
 	return nil
 }
 
-func (f *File) ReadAll(ctx context.Context) ([]byte, error) {
-	rd, err := f.parent.snap.NewReader(f.fullpath)
+// Open returns a handle reading the content of the file on demand,
+// rather than loading all of it in memory.
+func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, syscall.EROFS
+	}
+
+	entry, err := f.vfs.GetEntry(f.fullpath)
+	if err != nil {
+		return nil, syscall.ENOENT
+	}
+
+	// opening the entry resolves its object, whose chunks are then
+	// fetched as they are read.
+	file, err := entry.Open(f.vfs)
 	if err != nil {
 		return nil, err
 	}
-	return io.ReadAll(rd)
+	file.Close()
+
+	// the content of a snapshot never changes
+	resp.Flags |= fuse.OpenKeepCache
+
+	return newFileHandle(f.repo, entry.ResolvedObject), nil
+}
+
+func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	entry, err := f.vfs.GetEntry(f.fullpath)
+	if err != nil {
+		return syscall.ENOENT
+	}
+	resp.Append(entry.ExtendedAttributes...)
+	return nil
+}
+
+func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	entry, err := f.vfs.GetEntry(f.fullpath)
+	if err != nil {
+		return syscall.ENOENT
+	}
+	return getxattr(f.vfs, entry, req, resp)
 }
@@ -3,21 +3,119 @@
 package plakarfs
 
 import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/anacrolix/fuse"
 	"github.com/anacrolix/fuse/fs"
 )
 
+// Options controls how the repository is exposed.
+type Options struct {
+	// Snapshot, when set, restricts the filesystem to the directory
+	// at Path in that snapshot, exposed at the root.
+	Snapshot objects.MAC
+	Path     string
+
+	// UID and GID, when not negative, own all the files, otherwise
+	// UIDMap and GIDMap translate the owners recorded in the
+	// snapshots.
+	UID    int
+	GID    int
+	UIDMap map[uint32]uint32
+	GIDMap map[uint32]uint32
+
+	// AttrTTL and EntryTTL are how long the kernel may cache the
+	// attributes and the lookups of the files.
+	AttrTTL  time.Duration
+	EntryTTL time.Duration
+
+	// RefreshInterval is how long the list of snapshots is kept.
+	RefreshInterval time.Duration
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		UID:             -1,
+		GID:             -1,
+		AttrTTL:         time.Minute,
+		EntryTTL:        time.Minute,
+		RefreshInterval: DefaultRefreshInterval,
+	}
+}
+
 type FS struct {
-	repo *repository.Repository
+	repo  *repository.Repository
+	opts  *Options
+	index *snapshotIndex
 }
 
-func NewFS(repo *repository.Repository, mountpoint string) *FS {
+func NewFS(repo *repository.Repository, mountpoint string, opts *Options) *FS {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
 	fs := &FS{
-		repo: repo,
+		repo:  repo,
+		opts:  opts,
+		index: newSnapshotIndex(repo, opts.RefreshInterval),
 	}
 	return fs
 }
 
 func (f *FS) Root() (fs.Node, error) {
-	return &Dir{name: "/", repo: f.repo}, nil
+	if f.opts.Snapshot == (objects.MAC{}) {
+		return &Dir{name: "/", repo: f.repo, fs: f}, nil
+	}
+
+	snap, err := snapshot.Load(f.repo, f.opts.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	snapfs, err := snap.Filesystem()
+	if err != nil {
+		snap.Close()
+		return nil, err
+	}
+
+	fullpath := path.Clean("/" + f.opts.Path)
+	entry, err := snapfs.GetEntry(fullpath)
+	if err != nil {
+		snap.Close()
+		return nil, err
+	}
+	if !entry.Stat().IsDir() {
+		snap.Close()
+		return nil, fmt.Errorf("%s: not a directory", fullpath)
+	}
+
+	return &Dir{
+		name:     path.Base(fullpath),
+		fullpath: fullpath,
+		repo:     f.repo,
+		snap:     snap,
+		vfs:      snapfs,
+		fs:       f,
+	}, nil
+}
+
+// attr applies the ownership and caching options to the attributes of
+// a node.
+func (f *FS) attr(a *fuse.Attr) {
+	if uid, ok := f.opts.UIDMap[a.Uid]; ok {
+		a.Uid = uid
+	}
+	if gid, ok := f.opts.GIDMap[a.Gid]; ok {
+		a.Gid = gid
+	}
+	if f.opts.UID >= 0 {
+		a.Uid = uint32(f.opts.UID)
+	}
+	if f.opts.GID >= 0 {
+		a.Gid = uint32(f.opts.GID)
+	}
+	a.Valid = f.opts.AttrTTL
 }
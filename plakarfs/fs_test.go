@@ -0,0 +1,35 @@
+//go:build linux || darwin
+
+package plakarfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anacrolix/fuse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSAttr(t *testing.T) {
+	opts := DefaultOptions()
+	opts.UIDMap = map[uint32]uint32{1000: 2000}
+	opts.GID = 100
+	opts.AttrTTL = 5 * time.Second
+	fs := NewFS(nil, "/mnt", opts)
+
+	a := fuse.Attr{Uid: 1000, Gid: 1000}
+	fs.attr(&a)
+	require.Equal(t, uint32(2000), a.Uid)
+	require.Equal(t, uint32(100), a.Gid)
+	require.Equal(t, 5*time.Second, a.Valid)
+
+	a = fuse.Attr{Uid: 0, Gid: 0}
+	fs.attr(&a)
+	require.Equal(t, uint32(0), a.Uid)
+	require.Equal(t, uint32(100), a.Gid)
+
+	opts.UID = 33
+	a = fuse.Attr{Uid: 1000}
+	fs.attr(&a)
+	require.Equal(t, uint32(33), a.Uid)
+}
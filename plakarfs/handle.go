@@ -0,0 +1,141 @@
+//go:build linux || darwin
+
+package plakarfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/anacrolix/fuse"
+)
+
+const (
+	// Sequential reads fetch this much past the chunk they need, so that
+	// streaming a file takes few round trips to the repository.
+	readAheadSize = 4 << 20
+
+	// The chunks kept around by every open file.
+	handleCacheSize = 32 << 20
+)
+
+// fileHandle reads a file of a snapshot at any offset, fetching only the
+// chunks covering each read.
+type fileHandle struct {
+	repo   *repository.Repository
+	object *objects.Object
+
+	// offsets[i] is where the chunk i starts in the file, the last one
+	// being the size of the file.
+	offsets []int64
+
+	mtx   sync.Mutex
+	cache *chunkCache
+	next  int64 // offset following the last read
+}
+
+func newFileHandle(repo *repository.Repository, object *objects.Object) *fileHandle {
+	h := &fileHandle{
+		repo:    repo,
+		object:  object,
+		offsets: []int64{0},
+		cache:   newChunkCache(handleCacheSize),
+	}
+	if object != nil {
+		for _, chunk := range object.Chunks {
+			h.offsets = append(h.offsets, h.offsets[len(h.offsets)-1]+int64(chunk.Length))
+		}
+	}
+	return h
+}
+
+func (h *fileHandle) size() int64 {
+	return h.offsets[len(h.offsets)-1]
+}
+
+// chunkAt returns the index of the chunk holding the byte at off, which
+// must be within the file.
+func (h *fileHandle) chunkAt(off int64) int {
+	return sort.Search(len(h.offsets)-1, func(i int) bool {
+		return h.offsets[i+1] > off
+	})
+}
+
+// fetch returns the chunk idx, loading it along with the chunks that
+// follow it up to limit bytes if it isn't cached.
+func (h *fileHandle) fetch(idx int, limit int64) ([]byte, error) {
+	if data, ok := h.cache.get(idx); ok {
+		return data, nil
+	}
+
+	var first []byte
+	var fetched int64
+	i := idx
+	for data, err := range h.repo.GetObjectContent(h.object, idx, uint32(limit)) {
+		if err != nil {
+			return nil, err
+		}
+		if len(data) != int(h.object.Chunks[i].Length) {
+			return nil, fmt.Errorf("chunk %d has length %d, expected %d", i, len(data), h.object.Chunks[i].Length)
+		}
+		if i == idx {
+			first = data
+		}
+		h.cache.put(i, data)
+
+		// the repository doesn't always stop at limit by itself
+		fetched += int64(len(data))
+		i++
+		if fetched >= limit || i >= len(h.object.Chunks) {
+			break
+		}
+	}
+
+	if first == nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return first, nil
+}
+
+func (h *fileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	end := min(req.Offset+int64(req.Size), h.size())
+
+	// only read ahead when the file is read sequentially
+	limit := int64(1)
+	if req.Offset == h.next {
+		limit = readAheadSize
+	}
+
+	for off := req.Offset; off < end; {
+		idx := h.chunkAt(off)
+		data, err := h.fetch(idx, limit)
+		if err != nil {
+			return err
+		}
+
+		start := off - h.offsets[idx]
+		n := min(int64(len(data))-start, end-off)
+		resp.Data = append(resp.Data, data[start:start+n]...)
+		off += n
+	}
+
+	if end > req.Offset {
+		h.next = end
+	}
+	return nil
+}
+
+func (h *fileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	h.cache.reset()
+	return nil
+}
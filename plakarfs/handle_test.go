@@ -0,0 +1,150 @@
+//go:build linux || darwin
+
+package plakarfs
+
+import (
+	"context"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/anacrolix/fuse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileHandleChunkAt(t *testing.T) {
+	h := newFileHandle(nil, &objects.Object{
+		Chunks: []objects.Chunk{{Length: 10}, {Length: 5}, {Length: 20}},
+	})
+	require.Equal(t, []int64{0, 10, 15, 35}, h.offsets)
+	require.Equal(t, int64(35), h.size())
+
+	require.Equal(t, 0, h.chunkAt(0))
+	require.Equal(t, 0, h.chunkAt(9))
+	require.Equal(t, 1, h.chunkAt(10))
+	require.Equal(t, 1, h.chunkAt(14))
+	require.Equal(t, 2, h.chunkAt(15))
+	require.Equal(t, 2, h.chunkAt(34))
+
+	// files without content have no object
+	require.Zero(t, newFileHandle(nil, nil).size())
+}
+
+// backupContent backs up content as a file of a snapshot, and returns the
+// object holding it, which must span several chunks.
+func backupContent(t *testing.T, content []byte) (*repository.Repository, *objects.Object) {
+	repo, _ := ptesting.GenerateRepository(t, nil, nil, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("file", 0644, string(content)),
+	})
+	defer snap.Close()
+
+	snapfs, err := snap.Filesystem()
+	require.NoError(t, err)
+	entry, err := snapfs.GetEntry("/file")
+	require.NoError(t, err)
+	file, err := entry.Open(snapfs)
+	require.NoError(t, err)
+	file.Close()
+
+	require.Greater(t, len(entry.ResolvedObject.Chunks), 3)
+	return repo, entry.ResolvedObject
+}
+
+func readHandle(t *testing.T, h *fileHandle, off int64, size int) []byte {
+	var resp fuse.ReadResponse
+	err := h.Read(context.Background(), &fuse.ReadRequest{Offset: off, Size: size}, &resp)
+	require.NoError(t, err)
+	return resp.Data
+}
+
+func TestFileHandleRead(t *testing.T) {
+	content := make([]byte, 10<<20)
+	rnd := rand.New(rand.NewSource(1))
+	rnd.Read(content)
+	repo, object := backupContent(t, content)
+	size := int64(len(content))
+
+	// sequential reads
+	h := newFileHandle(repo, object)
+	require.Equal(t, size, h.size())
+	var data []byte
+	for off := int64(0); off < size; off += 128 << 10 {
+		data = append(data, readHandle(t, h, off, 128<<10)...)
+	}
+	require.Equal(t, content, data)
+
+	// random reads
+	h = newFileHandle(repo, object)
+	for range 50 {
+		off := rnd.Int63n(size)
+		n := rnd.Intn(2 << 20)
+		require.Equal(t, content[off:min(off+int64(n), size)], readHandle(t, h, off, n))
+	}
+
+	// reads crossing chunk boundaries
+	h = newFileHandle(repo, object)
+	boundary := h.offsets[1]
+	require.Equal(t, content[boundary-10:boundary+10], readHandle(t, h, boundary-10, 20))
+	boundary = h.offsets[2]
+	require.Equal(t, content[boundary-1:], readHandle(t, h, boundary-1, int(size)))
+
+	// reads past the end of the file
+	require.Equal(t, content[size-5:], readHandle(t, h, size-5, 100))
+	require.Empty(t, readHandle(t, h, size, 100))
+	require.Empty(t, readHandle(t, h, size+10, 100))
+}
+
+func TestFileHandleReadAhead(t *testing.T) {
+	content := make([]byte, 10<<20)
+	rand.New(rand.NewSource(2)).Read(content)
+	repo, object := backupContent(t, content)
+
+	cached := func(h *fileHandle, idx int) bool {
+		_, ok := h.cache.get(idx)
+		return ok
+	}
+
+	// the first read is sequential, and fetches the chunks that follow
+	h := newFileHandle(repo, object)
+	require.Equal(t, content[:1], readHandle(t, h, 0, 1))
+	require.True(t, cached(h, 0))
+	require.True(t, cached(h, 1))
+
+	// a read elsewhere only fetches the chunk it needs
+	h = newFileHandle(repo, object)
+	off := h.offsets[1]
+	require.Equal(t, content[off:off+1], readHandle(t, h, off, 1))
+	require.True(t, cached(h, 1))
+	require.False(t, cached(h, 0))
+	require.False(t, cached(h, 2))
+
+	// and reading on from there reads ahead again
+	require.Equal(t, content[off+1:off+2], readHandle(t, h, off+1, 1))
+	require.False(t, cached(h, 2))
+	next := h.offsets[2]
+	require.Equal(t, content[off+2:next+1], readHandle(t, h, off+2, int(next-off-1)))
+	require.True(t, cached(h, 3))
+}
+
+func TestFileHandleChunkLength(t *testing.T) {
+	content := make([]byte, 10<<20)
+	rand.New(rand.NewSource(3)).Read(content)
+	repo, object := backupContent(t, content)
+
+	// an object whose chunks don't have the length it records
+	corrupted := *object
+	corrupted.Chunks = slices.Clone(object.Chunks)
+	corrupted.Chunks[1].Length++
+
+	h := newFileHandle(repo, &corrupted)
+	_, err := h.fetch(1, 1)
+	require.ErrorContains(t, err, "chunk 1 has length")
+
+	var resp fuse.ReadResponse
+	err = h.Read(context.Background(), &fuse.ReadRequest{Offset: h.offsets[1], Size: 10}, &resp)
+	require.ErrorContains(t, err, "chunk 1 has length")
+}
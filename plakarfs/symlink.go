@@ -0,0 +1,61 @@
+//go:build linux || darwin
+
+package plakarfs
+
+import (
+	"context"
+	"path/filepath"
+	"syscall"
+
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/anacrolix/fuse"
+)
+
+// Symlink is a symbolic link of a snapshot, pointing to the target it
+// had when backed up.
+type Symlink struct {
+	parent   *Dir
+	name     string
+	fullpath string
+	vfs      *vfs.Filesystem
+}
+
+func (l *Symlink) Attr(ctx context.Context, a *fuse.Attr) error {
+	defer l.parent.fs.attr(a)
+
+	l.vfs = l.parent.vfs
+	l.fullpath = filepath.Clean(l.parent.fullpath + "/" + l.name)
+
+	entry, err := l.vfs.GetEntry(l.fullpath)
+	if err != nil {
+		return syscall.ENOENT
+	}
+
+	setEntryAttr(a, l.parent.snap.Header.Identifier, entry)
+	return nil
+}
+
+func (l *Symlink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	entry, err := l.vfs.GetEntry(l.fullpath)
+	if err != nil {
+		return "", syscall.ENOENT
+	}
+	return entry.SymlinkTarget, nil
+}
+
+func (l *Symlink) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	entry, err := l.vfs.GetEntry(l.fullpath)
+	if err != nil {
+		return syscall.ENOENT
+	}
+	resp.Append(entry.ExtendedAttributes...)
+	return nil
+}
+
+func (l *Symlink) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	entry, err := l.vfs.GetEntry(l.fullpath)
+	if err != nil {
+		return syscall.ENOENT
+	}
+	return getxattr(l.vfs, entry, req, resp)
+}
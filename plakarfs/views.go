@@ -0,0 +1,377 @@
+//go:build linux || darwin
+
+package plakarfs
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/anacrolix/fuse"
+	"github.com/anacrolix/fuse/fs"
+)
+
+// DefaultRefreshInterval is how long the list of snapshots is kept
+// before the state of the repository is rebuilt to pick new ones up.
+const DefaultRefreshInterval = time.Minute
+
+// views are the directories at the root of the filesystem grouping the
+// snapshots by one of their properties. Their leaves are links to the
+// snapshots at the root, so that their trees are shared.
+var views = []string{"by-date", "by-host", "by-job", "by-tag"}
+
+func isView(name string) bool {
+	for _, view := range views {
+		if view == name {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotIndex holds the headers of the snapshots of the repository,
+// from which the views are built. It is refreshed once it is older
+// than its interval, rather than on every listing.
+type snapshotIndex struct {
+	repo     *repository.Repository
+	interval time.Duration
+
+	mtx       sync.Mutex
+	refreshed time.Time
+	headers   map[objects.MAC]*header.Header
+	sorted    []*header.Header
+}
+
+func newSnapshotIndex(repo *repository.Repository, interval time.Duration) *snapshotIndex {
+	return &snapshotIndex{
+		repo:     repo,
+		interval: interval,
+		headers:  make(map[objects.MAC]*header.Header),
+	}
+}
+
+// snapshots returns the headers of the snapshots, oldest first.
+func (idx *snapshotIndex) snapshots() ([]*header.Header, error) {
+	idx.mtx.Lock()
+	defer idx.mtx.Unlock()
+
+	if !idx.refreshed.IsZero() && time.Since(idx.refreshed) < idx.interval {
+		return idx.sorted, nil
+	}
+
+	if err := idx.repo.RebuildState(); err != nil {
+		return nil, err
+	}
+
+	snapshotIDs, err := idx.repo.GetSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[objects.MAC]*header.Header, len(snapshotIDs))
+	for _, snapshotID := range snapshotIDs {
+		if hdr, ok := idx.headers[snapshotID]; ok {
+			headers[snapshotID] = hdr
+			continue
+		}
+
+		snap, err := snapshot.Load(idx.repo, snapshotID)
+		if err != nil {
+			// a snapshot that can't be loaded is left out rather
+			// than failing the whole listing.
+			continue
+		}
+		headers[snapshotID] = snap.Header
+		snap.Close()
+	}
+
+	sorted := make([]*header.Header, 0, len(headers))
+	for _, hdr := range headers {
+		sorted = append(sorted, hdr)
+	}
+	sortHeaders(sorted)
+
+	idx.headers = headers
+	idx.sorted = sorted
+	idx.refreshed = time.Now()
+	return sorted, nil
+}
+
+func (idx *snapshotIndex) lookup(snapshotID objects.MAC) (*header.Header, error) {
+	if _, err := idx.snapshots(); err != nil {
+		return nil, err
+	}
+
+	idx.mtx.Lock()
+	defer idx.mtx.Unlock()
+
+	hdr, ok := idx.headers[snapshotID]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return hdr, nil
+}
+
+func sortHeaders(headers []*header.Header) {
+	sort.Slice(headers, func(i, j int) bool {
+		if headers[i].Timestamp.Equal(headers[j].Timestamp) {
+			return string(headers[i].Identifier[:]) < string(headers[j].Identifier[:])
+		}
+		return headers[i].Timestamp.Before(headers[j].Timestamp)
+	})
+}
+
+// snapshotInode derives the inode of the root of a snapshot from its
+// identifier, so that it is the same across listings and mounts.
+func snapshotInode(snapshotID objects.MAC) uint64 {
+	return binary.BigEndian.Uint64(snapshotID[:8])
+}
+
+// viewInode derives the inode of a node of a view from its path.
+func viewInode(path []string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte("plakarfs:/" + strings.Join(path, "/")))
+	return h.Sum64()
+}
+
+// viewEntry is an entry of a directory of a view: either another
+// directory, or a link to a snapshot when snapshot is set.
+type viewEntry struct {
+	name     string
+	snapshot *header.Header
+	mtime    time.Time
+}
+
+// viewKeys returns the paths under which a snapshot appears in a view,
+// the last element of each being the name of its link.
+func viewKeys(view string, hdr *header.Header) [][]string {
+	shortID := fmt.Sprintf("%x", hdr.GetIndexShortID())
+
+	switch view {
+	case "by-date":
+		ts := hdr.Timestamp.Local()
+		return [][]string{{
+			fmt.Sprintf("%04d", ts.Year()),
+			fmt.Sprintf("%02d", ts.Month()),
+			fmt.Sprintf("%02d", ts.Day()),
+			shortID,
+		}}
+	case "by-job":
+		if job := viewName(hdr.Job); job != "" {
+			return [][]string{{job, "latest"}, {job, shortID}}
+		}
+	case "by-tag":
+		var keys [][]string
+		for _, tag := range hdr.Tags {
+			if tag := viewName(tag); tag != "" {
+				keys = append(keys, []string{tag, shortID})
+			}
+		}
+		return keys
+	case "by-host":
+		host := hdr.GetContext("Hostname")
+		if host == "" && len(hdr.Sources) != 0 {
+			host = hdr.GetSource(0).Importer.Origin
+		}
+		if host := viewName(host); host != "" {
+			return [][]string{{host, shortID}}
+		}
+	}
+	return nil
+}
+
+// viewName turns a property of a snapshot into a valid file name, or
+// returns "" if it can't be one.
+func viewName(name string) string {
+	name = strings.ReplaceAll(name, "/", "_")
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// buildView returns the entries of the directory at path, whose first
+// element is the name of the view, given the snapshots sorted oldest
+// first. It returns false if there is no such directory.
+func buildView(headers []*header.Header, path []string) ([]viewEntry, bool) {
+	if len(path) == 0 || !isView(path[0]) {
+		return nil, false
+	}
+
+	depth := len(path) - 1
+	found := depth == 0
+	entries := make(map[string]viewEntry)
+	for _, hdr := range headers {
+		for _, key := range viewKeys(path[0], hdr) {
+			if len(key) <= depth || !equalPrefix(key, path[1:]) {
+				continue
+			}
+			found = true
+
+			entry := viewEntry{name: key[depth], mtime: hdr.Timestamp}
+			if len(key) == depth+1 {
+				entry.snapshot = hdr
+			}
+			// the headers are sorted, so the newest snapshot wins,
+			// which is what latest points to.
+			entries[entry.name] = entry
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	ret := make([]viewEntry, 0, len(entries))
+	for _, entry := range entries {
+		ret = append(ret, entry)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].name < ret[j].name
+	})
+	return ret, true
+}
+
+func equalPrefix(key, prefix []string) bool {
+	for i := range prefix {
+		if key[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ViewDir is a directory of a view.
+type ViewDir struct {
+	fs   *FS
+	path []string
+}
+
+func (v *ViewDir) entries() ([]viewEntry, error) {
+	headers, err := v.fs.index.snapshots()
+	if err != nil {
+		return nil, err
+	}
+	entries, ok := buildView(headers, v.path)
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return entries, nil
+}
+
+func (v *ViewDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	defer v.fs.attr(a)
+
+	entries, err := v.entries()
+	if err != nil {
+		return err
+	}
+
+	a.Inode = viewInode(v.path)
+	a.Mode = os.ModeDir | 0o500
+	a.Uid = uint32(os.Geteuid())
+	a.Gid = uint32(os.Getgid())
+	var mtime time.Time
+	for _, entry := range entries {
+		if entry.mtime.After(mtime) {
+			mtime = entry.mtime
+		}
+	}
+	a.Ctime = mtime
+	a.Mtime = mtime
+	a.Atime = mtime
+	return nil
+}
+
+func (v *ViewDir) child(name string) []string {
+	path := make([]string, len(v.path), len(v.path)+1)
+	copy(path, v.path)
+	return append(path, name)
+}
+
+func (v *ViewDir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	resp.EntryValid = v.fs.opts.EntryTTL
+	name := req.Name
+
+	entries, err := v.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.name != name {
+			continue
+		}
+		if entry.snapshot == nil {
+			return &ViewDir{fs: v.fs, path: v.child(name)}, nil
+		}
+		return &ViewLink{
+			fs:       v.fs,
+			path:     v.child(name),
+			snapshot: entry.snapshot,
+		}, nil
+	}
+	return nil, syscall.ENOENT
+}
+
+func (v *ViewDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	entries, err := v.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	dirents := make([]fuse.Dirent, 0, len(entries))
+	for _, entry := range entries {
+		dirent := fuse.Dirent{
+			Inode: viewInode(v.child(entry.name)),
+			Name:  entry.name,
+			Type:  fuse.DT_Dir,
+		}
+		if entry.snapshot != nil {
+			dirent.Type = fuse.DT_Link
+		}
+		dirents = append(dirents, dirent)
+	}
+	return dirents, nil
+}
+
+// ViewLink is a leaf of a view, linking to a snapshot at the root.
+type ViewLink struct {
+	fs       *FS
+	path     []string
+	snapshot *header.Header
+}
+
+// target is relative so that the link resolves wherever the
+// filesystem is mounted.
+func (l *ViewLink) target() string {
+	return strings.Repeat("../", len(l.path)-1) + fmt.Sprintf("%x", l.snapshot.Identifier)
+}
+
+func (l *ViewLink) Attr(ctx context.Context, a *fuse.Attr) error {
+	defer l.fs.attr(a)
+
+	a.Inode = viewInode(l.path)
+	a.Mode = os.ModeSymlink | 0o777
+	a.Uid = uint32(os.Geteuid())
+	a.Gid = uint32(os.Getgid())
+	a.Size = uint64(len(l.target()))
+	a.Ctime = l.snapshot.Timestamp
+	a.Mtime = l.snapshot.Timestamp
+	a.Atime = l.snapshot.Timestamp
+	return nil
+}
+
+func (l *ViewLink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	return l.target(), nil
+}
@@ -0,0 +1,86 @@
+//go:build linux || darwin
+
+package plakarfs
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHeader(id byte, ts time.Time, job, host string, tags ...string) *header.Header {
+	hdr := header.NewHeader("test", objects.MAC{id})
+	hdr.Timestamp = ts
+	hdr.Job = job
+	hdr.Tags = tags
+	hdr.SetContext("Hostname", host)
+	return hdr
+}
+
+func viewNames(entries []viewEntry) []string {
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.name)
+	}
+	return names
+}
+
+func TestBuildView(t *testing.T) {
+	day := time.Date(2025, 10, 15, 12, 0, 0, 0, time.Local)
+	headers := []*header.Header{
+		newTestHeader(0xaa, day, "nightly", "alpha", "prod", "a/b"),
+		newTestHeader(0xbb, day.Add(time.Hour), "nightly", "beta", "prod"),
+		newTestHeader(0xcc, day.AddDate(0, 1, 0), "weekly", "alpha"),
+	}
+	sortHeaders(headers)
+
+	entries, ok := buildView(headers, []string{"by-date"})
+	require.True(t, ok)
+	require.Equal(t, []string{"2025"}, viewNames(entries))
+
+	entries, ok = buildView(headers, []string{"by-date", "2025"})
+	require.True(t, ok)
+	require.Equal(t, []string{"10", "11"}, viewNames(entries))
+	require.Nil(t, entries[0].snapshot)
+
+	entries, ok = buildView(headers, []string{"by-date", "2025", "10", "15"})
+	require.True(t, ok)
+	require.Equal(t, []string{"aa000000", "bb000000"}, viewNames(entries))
+	require.Equal(t, headers[0], entries[0].snapshot)
+
+	entries, ok = buildView(headers, []string{"by-job", "nightly"})
+	require.True(t, ok)
+	require.Equal(t, []string{"aa000000", "bb000000", "latest"}, viewNames(entries))
+	require.Equal(t, objects.MAC{0xbb}, entries[2].snapshot.Identifier)
+
+	entries, ok = buildView(headers, []string{"by-tag"})
+	require.True(t, ok)
+	require.Equal(t, []string{"a_b", "prod"}, viewNames(entries))
+
+	entries, ok = buildView(headers, []string{"by-host", "alpha"})
+	require.True(t, ok)
+	require.Equal(t, []string{"aa000000", "cc000000"}, viewNames(entries))
+
+	_, ok = buildView(headers, []string{"by-host", "gamma"})
+	require.False(t, ok)
+	_, ok = buildView(headers, []string{"by-date", "2025", "10", "15", "aa000000"})
+	require.False(t, ok)
+	_, ok = buildView(headers, []string{"by-name"})
+	require.False(t, ok)
+}
+
+func TestViewInodes(t *testing.T) {
+	require.Equal(t, viewInode([]string{"by-tag", "prod"}), viewInode([]string{"by-tag", "prod"}))
+	require.NotEqual(t, viewInode([]string{"by-tag", "prod"}), viewInode([]string{"by-job", "prod"}))
+	require.Equal(t, uint64(0xaa00000000000000), snapshotInode(objects.MAC{0xaa}))
+
+	link := &ViewLink{
+		path:     []string{"by-job", "nightly", "latest"},
+		snapshot: newTestHeader(0xbb, time.Now(), "nightly", "beta"),
+	}
+	require.Equal(t, "../../bb"+strings.Repeat("00", 31), link.target())
+}
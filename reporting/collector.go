@@ -0,0 +1,203 @@
+package reporting
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/events"
+)
+
+const (
+	// Only the first errors of a task are detailed in its report, the
+	// others are only counted.
+	maxReportErrors = 100
+
+	memorySampleInterval = 500 * time.Millisecond
+)
+
+// Collector gathers the statistics of a task from the events it emits and
+// samples the resources it uses while it runs.
+type Collector struct {
+	mtx sync.Mutex
+
+	files       uint64
+	directories uint64
+	bytesRead   int64
+
+	errorCount int
+	errors     []ReportPathError
+
+	checkedFiles     uint64
+	checkedObjects   uint64
+	corrupted        []string
+	missing          []string
+	corruptedObjects []string
+	missingObjects   []string
+
+	startCPU   time.Duration
+	cpuTime    time.Duration
+	peakMemory uint64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	sampled  chan struct{}
+	done     chan struct{}
+}
+
+// NewCollector starts collecting the events sent to receiver, until it is
+// closed.
+func NewCollector(receiver *events.Receiver) *Collector {
+	c := &Collector{
+		startCPU: cpuTime(),
+		stop:     make(chan struct{}),
+		sampled:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	// The receiver blocks until every listener reads an event, so keep
+	// draining until it is closed, even once the task is over.
+	listener := receiver.Listen()
+	go func() {
+		defer close(c.done)
+		for event := range listener {
+			c.handle(event)
+		}
+	}()
+
+	go c.sampleMemory()
+
+	return c
+}
+
+func (c *Collector) handle(event any) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	switch event := event.(type) {
+	case events.DoneImporter:
+		c.files += event.NumFiles
+		c.directories += event.NumDirectories
+	case events.FileOK:
+		c.checkedFiles++
+		c.bytesRead += event.Size
+	case events.PathError:
+		c.addError(event.Pathname, event.Message)
+	case events.FileError:
+		c.addError(event.Pathname, event.Message)
+	case events.DirectoryError:
+		c.addError(event.Pathname, event.Message)
+	case events.ObjectOK:
+		c.checkedObjects++
+	case events.FileCorrupted:
+		c.corrupted = appendCapped(c.corrupted, event.Pathname)
+	case events.DirectoryCorrupted:
+		c.corrupted = appendCapped(c.corrupted, event.Pathname)
+	case events.FileMissing:
+		c.missing = appendCapped(c.missing, event.Pathname)
+	case events.DirectoryMissing:
+		c.missing = appendCapped(c.missing, event.Pathname)
+	case events.ObjectCorrupted:
+		c.corruptedObjects = appendCapped(c.corruptedObjects, fmt.Sprintf("%x", event.MAC))
+	case events.ChunkCorrupted:
+		c.corruptedObjects = appendCapped(c.corruptedObjects, fmt.Sprintf("%x", event.MAC))
+	case events.ObjectMissing:
+		c.missingObjects = appendCapped(c.missingObjects, fmt.Sprintf("%x", event.MAC))
+	case events.ChunkMissing:
+		c.missingObjects = appendCapped(c.missingObjects, fmt.Sprintf("%x", event.MAC))
+	}
+}
+
+func (c *Collector) addError(pathname, message string) {
+	c.errorCount++
+	if len(c.errors) < maxReportErrors {
+		c.errors = append(c.errors, ReportPathError{Path: pathname, Message: message})
+	}
+}
+
+func appendCapped(list []string, item string) []string {
+	if len(list) >= maxReportErrors {
+		return list
+	}
+	return append(list, item)
+}
+
+// sampleMemory tracks the memory held by the process until the collector
+// is stopped.
+func (c *Collector) sampleMemory() {
+	defer close(c.sampled)
+
+	ticker := time.NewTicker(memorySampleInterval)
+	defer ticker.Stop()
+
+	for {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+
+		c.mtx.Lock()
+		c.peakMemory = max(c.peakMemory, stats.Sys-stats.HeapReleased)
+		c.mtx.Unlock()
+
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop ends the resources accounting, it must be called once the task is
+// over and before reading the statistics.
+func (c *Collector) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		<-c.sampled
+
+		c.mtx.Lock()
+		c.cpuTime = cpuTime() - c.startCPU
+		c.mtx.Unlock()
+	})
+}
+
+// Backup returns the statistics of a backup, BytesWritten is left to the
+// caller as it is not reported through events.
+func (c *Collector) Backup() *ReportBackup {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return &ReportBackup{
+		Files:       c.files,
+		Directories: c.directories,
+		BytesRead:   c.bytesRead,
+		ErrorCount:  c.errorCount,
+		Errors:      append([]ReportPathError(nil), c.errors...),
+	}
+}
+
+// Check returns the statistics of a check.
+func (c *Collector) Check() *ReportCheck {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return &ReportCheck{
+		Files:            c.checkedFiles,
+		Objects:          c.checkedObjects,
+		Corrupted:        append([]string(nil), c.corrupted...),
+		Missing:          append([]string(nil), c.missing...),
+		CorruptedObjects: append([]string(nil), c.corruptedObjects...),
+		MissingObjects:   append([]string(nil), c.missingObjects...),
+	}
+}
+
+// Resources returns the resources used by the process while the collector
+// ran, which include those of the tasks running concurrently in the agent.
+func (c *Collector) Resources() *ReportResources {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return &ReportResources{
+		PeakMemory:     c.peakMemory,
+		ProcessCPUTime: c.cpuTime,
+	}
+}
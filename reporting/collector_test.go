@@ -0,0 +1,51 @@
+package reporting
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector(t *testing.T) {
+	receiver := events.New()
+	collector := NewCollector(receiver)
+
+	receiver.Send(events.FileOK{Pathname: "/etc/hosts", Size: 100})
+	receiver.Send(events.FileOK{Pathname: "/etc/passwd", Size: 28})
+	receiver.Send(events.FileError{Pathname: "/etc/shadow", Message: "permission denied"})
+	for i := range maxReportErrors + 10 {
+		receiver.Send(events.PathError{Pathname: fmt.Sprintf("/tmp/%d", i), Message: "vanished"})
+	}
+	receiver.Send(events.DoneImporter{NumFiles: 3, NumDirectories: 1})
+	receiver.Send(events.ObjectOK{})
+	receiver.Send(events.ObjectCorrupted{MAC: [32]byte{0xab}})
+	receiver.Send(events.FileCorrupted{Pathname: "/etc/hosts"})
+	receiver.Send(events.ChunkMissing{MAC: [32]byte{0xcd}})
+
+	collector.Stop()
+	receiver.Close()
+	<-collector.done
+
+	backup := collector.Backup()
+	require.Equal(t, uint64(3), backup.Files)
+	require.Equal(t, uint64(1), backup.Directories)
+	require.Equal(t, int64(128), backup.BytesRead)
+	require.Equal(t, maxReportErrors+11, backup.ErrorCount)
+	require.Len(t, backup.Errors, maxReportErrors)
+	require.Equal(t, ReportPathError{Path: "/etc/shadow", Message: "permission denied"}, backup.Errors[0])
+
+	check := collector.Check()
+	require.Equal(t, uint64(2), check.Files)
+	require.Equal(t, uint64(1), check.Objects)
+	require.Equal(t, []string{"/etc/hosts"}, check.Corrupted)
+	require.Len(t, check.CorruptedObjects, 1)
+	require.Equal(t, "ab", check.CorruptedObjects[0][:2])
+	require.Len(t, check.MissingObjects, 1)
+
+	require.NotZero(t, collector.Resources().PeakMemory)
+
+	// stopping twice is harmless
+	collector.Stop()
+}
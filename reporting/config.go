@@ -0,0 +1,127 @@
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// ConfigFile is the name of the file, in the configuration directory,
+// declaring the local emitters.
+const ConfigFile = "reporting.yml"
+
+// Config lists the emitters reports are sent to in addition to the alerting
+// service.
+type Config struct {
+	Emitters []EmitterConfig `yaml:"emitters"`
+}
+
+// EmitterConfig describes a local emitter. Only the fields relevant to its
+// type are used.
+type EmitterConfig struct {
+	Name   string       `yaml:"name,omitempty"`
+	Type   string       `yaml:"type"`
+	Status []TaskStatus `yaml:"status,omitempty"`
+
+	// webhook
+	URL      string            `yaml:"url,omitempty"`
+	Headers  map[string]string `yaml:"headers,omitempty"`
+	Template string            `yaml:"template,omitempty"`
+
+	// smtp
+	Host     string   `yaml:"host,omitempty"`
+	Port     int      `yaml:"port,omitempty"`
+	Username string   `yaml:"username,omitempty"`
+	Password string   `yaml:"password,omitempty"`
+	From     string   `yaml:"from,omitempty"`
+	To       []string `yaml:"to,omitempty"`
+	Subject  string   `yaml:"subject,omitempty"`
+
+	// exec
+	Command string   `yaml:"command,omitempty"`
+	Args    []string `yaml:"args,omitempty"`
+}
+
+// LoadConfigFile reads the emitters configuration, a missing file yields an
+// empty configuration.
+func LoadConfigFile(filename string) (*Config, error) {
+	var cfg Config
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &cfg, nil
+		}
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	for i := range cfg.Emitters {
+		if _, err := cfg.Emitters[i].NewEmitter(); err != nil {
+			return nil, fmt.Errorf("%s: emitter #%d: %w", filename, i+1, err)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// EmitterName identifies the emitter at index i of the configuration in the
+// report spool, by its name if it has one.
+func (c *EmitterConfig) EmitterName(i int) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return fmt.Sprintf("%s[%d]", c.Type, i)
+}
+
+// NewEmitter builds the emitter described by the configuration.
+func (c *EmitterConfig) NewEmitter() (Emitter, error) {
+	for _, status := range c.Status {
+		switch status {
+		case StatusOK, StatusWarning, StatusFailed:
+		default:
+			return nil, fmt.Errorf("unknown status %q", status)
+		}
+	}
+
+	var emitter Emitter
+	var err error
+	switch c.Type {
+	case "webhook":
+		emitter, err = NewWebhookEmitter(c.URL, c.Headers, c.Template)
+	case "smtp":
+		emitter, err = NewSMTPEmitter(c.Host, c.Port, c.Username, c.Password, c.From, c.To, c.Subject)
+	case "exec":
+		emitter, err = NewExecEmitter(c.Command, c.Args)
+	default:
+		return nil, fmt.Errorf("unknown emitter type %q", c.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.Status) == 0 {
+		return emitter, nil
+	}
+	return &FilterEmitter{Emitter: emitter, Status: c.Status}, nil
+}
+
+// FilterEmitter only forwards the reports of tasks ending with one of the
+// given statuses.
+type FilterEmitter struct {
+	Emitter Emitter
+	Status  []TaskStatus
+}
+
+func (emitter *FilterEmitter) Emit(ctx context.Context, report *Report) error {
+	if report.Task == nil || !slices.Contains(emitter.Status, report.Task.Status) {
+		return nil
+	}
+	return emitter.Emitter.Emit(ctx, report)
+}
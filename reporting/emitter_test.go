@@ -0,0 +1,228 @@
+package reporting
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testReport(status TaskStatus) *Report {
+	return &Report{
+		Timestamp: time.Date(2025, 10, 16, 2, 30, 0, 0, time.UTC),
+		Task: &ReportTask{
+			Type:         "backup",
+			Name:         "etc",
+			Status:       status,
+			ErrorMessage: `disk "full"`,
+		},
+	}
+}
+
+func TestWebhookEmitter(t *testing.T) {
+	var body []byte
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		auth = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	emitter, err := NewWebhookEmitter(server.URL, map[string]string{"Authorization": "Bearer secret"},
+		`{"text": {{printf "%s %s: %s" .Task.Type .Task.Name .Task.ErrorMessage | json}}}`)
+	require.NoError(t, err)
+	require.NoError(t, emitter.Emit(context.Background(), testReport(StatusFailed)))
+	require.Equal(t, "Bearer secret", auth)
+	require.JSONEq(t, `{"text": "backup etc: disk \"full\""}`, string(body))
+
+	emitter, err = NewWebhookEmitter(server.URL, nil, "")
+	require.NoError(t, err)
+	require.NoError(t, emitter.Emit(context.Background(), testReport(StatusOK)))
+	var report Report
+	require.NoError(t, json.Unmarshal(body, &report))
+	require.Equal(t, "etc", report.Task.Name)
+
+	emitter, err = NewWebhookEmitter(server.URL, nil, `{"text": {{.Task.Name}}}`)
+	require.NoError(t, err)
+	require.Error(t, emitter.Emit(context.Background(), testReport(StatusOK)))
+
+	_, err = NewWebhookEmitter("", nil, "")
+	require.Error(t, err)
+}
+
+func TestExecEmitter(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "report")
+
+	emitter, err := NewExecEmitter("sh", []string{"-c", `cat > "$0"; printf '\n%s' "$PLAKAR_TASK_STATUS" >> "$0"`, output})
+	require.NoError(t, err)
+	require.NoError(t, emitter.Emit(context.Background(), testReport(StatusWarning)))
+
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	lines := strings.Split(string(data), "\n")
+	require.Len(t, lines, 2)
+	var report Report
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &report))
+	require.Equal(t, "etc", report.Task.Name)
+	require.Equal(t, "WARNING", lines[1])
+
+	emitter, err = NewExecEmitter("sh", []string{"-c", "echo oops; exit 1"})
+	require.NoError(t, err)
+	err = emitter.Emit(context.Background(), testReport(StatusOK))
+	require.ErrorContains(t, err, "oops")
+}
+
+// smtpServer accepts a single SMTP session on a local port and hands the
+// message it receives to the channel. It never answers when mute is set.
+func smtpServer(t *testing.T, mute bool) (int, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if mute {
+			io.Copy(io.Discard, conn)
+			return
+		}
+
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch strings.ToUpper(strings.Fields(line)[0]) {
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				messages <- string(data)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("250 ok")
+			}
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr).Port, messages
+}
+
+func TestSMTPEmitter(t *testing.T) {
+	port, messages := smtpServer(t, false)
+	emitter, err := NewSMTPEmitter("127.0.0.1", port, "", "", "plakar@example.com",
+		[]string{"ops@example.com"}, "")
+	require.NoError(t, err)
+
+	require.NoError(t, emitter.Emit(context.Background(), testReport(StatusFailed)))
+	msg := <-messages
+	require.Contains(t, msg, "Subject: [plakar] backup etc: FAILURE\n")
+	require.Contains(t, msg, "To: ops@example.com\n")
+
+	emitter, err = NewSMTPEmitter("mail.example.com", 0, "", "", "plakar@example.com",
+		[]string{"ops@example.com"}, "")
+	require.NoError(t, err)
+	require.Equal(t, "mail.example.com:25", emitter.addr)
+
+	_, err = NewSMTPEmitter("mail.example.com", 25, "", "", "plakar@example.com", nil, "")
+	require.Error(t, err)
+}
+
+func TestSMTPEmitterTimeout(t *testing.T) {
+	port, _ := smtpServer(t, true)
+	emitter, err := NewSMTPEmitter("127.0.0.1", port, "", "", "plakar@example.com",
+		[]string{"ops@example.com"}, "")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = emitter.Emit(ctx, testReport(StatusFailed))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+type countingEmitter struct {
+	count int
+}
+
+func (emitter *countingEmitter) Emit(ctx context.Context, report *Report) error {
+	emitter.count++
+	return nil
+}
+
+func TestFilterEmitter(t *testing.T) {
+	counter := &countingEmitter{}
+	emitter := &FilterEmitter{Emitter: counter, Status: []TaskStatus{StatusFailed, StatusWarning}}
+
+	require.NoError(t, emitter.Emit(context.Background(), testReport(StatusOK)))
+	require.NoError(t, emitter.Emit(context.Background(), testReport(StatusWarning)))
+	require.NoError(t, emitter.Emit(context.Background(), testReport(StatusFailed)))
+	require.Equal(t, 2, counter.count)
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg, err := LoadConfigFile(filepath.Join(dir, ConfigFile))
+	require.NoError(t, err)
+	require.Empty(t, cfg.Emitters)
+
+	filename := filepath.Join(dir, ConfigFile)
+	require.NoError(t, os.WriteFile(filename, []byte(`
+emitters:
+  - type: webhook
+    url: https://hooks.example.com/plakar
+    status: [FAILURE, WARNING]
+  - type: exec
+    command: /usr/local/bin/notify
+`), 0600))
+	cfg, err = LoadConfigFile(filename)
+	require.NoError(t, err)
+	require.Len(t, cfg.Emitters, 2)
+
+	emitter, err := cfg.Emitters[0].NewEmitter()
+	require.NoError(t, err)
+	require.IsType(t, &FilterEmitter{}, emitter)
+	emitter, err = cfg.Emitters[1].NewEmitter()
+	require.NoError(t, err)
+	require.IsType(t, &ExecEmitter{}, emitter)
+
+	require.NoError(t, os.WriteFile(filename, []byte(`
+emitters:
+  - type: pigeon
+`), 0600))
+	_, err = LoadConfigFile(filename)
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(filename, []byte(`
+emitters:
+  - type: exec
+    command: /bin/true
+    status: [BROKEN]
+`), 0600))
+	_, err = LoadConfigFile(filename)
+	require.Error(t, err)
+}
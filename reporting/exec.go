@@ -0,0 +1,55 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ExecEmitter runs a command for every report, which it is given as JSON on
+// its standard input. The task type, name and status are also available in
+// the environment.
+type ExecEmitter struct {
+	command string
+	args    []string
+}
+
+func NewExecEmitter(command string, args []string) (*ExecEmitter, error) {
+	if command == "" {
+		return nil, fmt.Errorf("missing command")
+	}
+	return &ExecEmitter{
+		command: command,
+		args:    args,
+	}, nil
+}
+
+func (emitter *ExecEmitter) Emit(ctx context.Context, report *Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %s", err)
+	}
+
+	cmd := exec.CommandContext(ctx, emitter.command, emitter.args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = os.Environ()
+	if report.Task != nil {
+		cmd.Env = append(cmd.Env,
+			"PLAKAR_TASK_TYPE="+report.Task.Type,
+			"PLAKAR_TASK_NAME="+report.Task.Name,
+			"PLAKAR_TASK_STATUS="+string(report.Task.Status))
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("%s: %w: %s", emitter.command, err, msg)
+		}
+		return fmt.Errorf("%s: %w", emitter.command, err)
+	}
+	return nil
+}
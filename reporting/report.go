@@ -35,13 +35,68 @@ type ReportTask struct {
 	Status       TaskStatus    `json:"status"`
 	ErrorCode    TaskErrorCode `json:"error_code"`
 	ErrorMessage string        `json:"error_message"`
+	Attempt      int           `json:"attempt,omitempty"`
+}
+
+type ReportPathError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+type ReportBackup struct {
+	Files        uint64            `json:"files"`
+	Directories  uint64            `json:"directories"`
+	BytesRead    int64             `json:"bytes_read"`
+	BytesWritten int64             `json:"bytes_written"`
+	ErrorCount   int               `json:"error_count"`
+	Errors       []ReportPathError `json:"errors,omitempty"`
+}
+
+type ReportCheck struct {
+	Files            uint64   `json:"files"`
+	Objects          uint64   `json:"objects"`
+	Corrupted        []string `json:"corrupted,omitempty"`
+	Missing          []string `json:"missing,omitempty"`
+	CorruptedObjects []string `json:"corrupted_objects,omitempty"`
+	MissingObjects   []string `json:"missing_objects,omitempty"`
+}
+
+type ReportSync struct {
+	Count     int      `json:"count"`
+	Snapshots []string `json:"snapshots,omitempty"`
+}
+
+type ReportRm struct {
+	Count     int      `json:"count"`
+	Snapshots []string `json:"snapshots,omitempty"`
+}
+
+type ReportMaintenance struct {
+	ColouredPackfiles int   `json:"coloured_packfiles"`
+	OrphanedPackfiles int   `json:"orphaned_packfiles"`
+	SweptPackfiles    int   `json:"swept_packfiles"`
+	RemovedBlobs      int   `json:"removed_blobs"`
+	ReclaimedBytes    int64 `json:"reclaimed_bytes"`
+}
+
+// ReportResources holds the resources used by the process while the task
+// ran, including those of the tasks running concurrently in the agent.
+type ReportResources struct {
+	PeakMemory     uint64        `json:"peak_memory"`
+	ProcessCPUTime time.Duration `json:"process_cpu_time"`
 }
 
 type Report struct {
-	Timestamp  time.Time         `json:"timestamp"`
-	Task       *ReportTask       `json:"report_task,omitempty"`
-	Repository *ReportRepository `json:"report_repository,omitempty"`
-	Snapshot   *ReportSnapshot   `json:"report_snapshot,omitempty"`
+	Timestamp   time.Time          `json:"timestamp"`
+	Task        *ReportTask        `json:"report_task,omitempty"`
+	Repository  *ReportRepository  `json:"report_repository,omitempty"`
+	Snapshot    *ReportSnapshot    `json:"report_snapshot,omitempty"`
+	Backup      *ReportBackup      `json:"report_backup,omitempty"`
+	Check       *ReportCheck       `json:"report_check,omitempty"`
+	Sync        *ReportSync        `json:"report_sync,omitempty"`
+	Rm          *ReportRm          `json:"report_rm,omitempty"`
+	Maintenance *ReportMaintenance `json:"report_maintenance,omitempty"`
+	Resources   *ReportResources   `json:"report_resources,omitempty"`
 
 	repo     *repository.Repository `json:"-"`
 	logger   *logging.Logger        `json:"-"`
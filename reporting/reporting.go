@@ -4,6 +4,10 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -20,23 +24,58 @@ type Emitter interface {
 	Emit(ctx context.Context, report *Report) error
 }
 
+const (
+	// A report being delivered is left alone by other reporters for that
+	// long.
+	spoolLease = 5 * time.Minute
+
+	// Timeout of a single delivery to an emitter.
+	emitTimeout = time.Minute
+
+	// How often the spool is scanned for reports to retry.
+	spoolInterval = 30 * time.Second
+
+	// Retries are spaced exponentially, up to that delay.
+	maxRetryDelay = time.Hour
+
+	// Reports still undelivered after that long are dropped.
+	spoolExpiry = 7 * 24 * time.Hour
+)
+
 type Reporter struct {
-	ctx             *appcontext.AppContext
-	reportCount     atomic.Int32
-	reports         chan *Report
-	stop            chan any
-	done            chan any
-	emitter         Emitter
-	emitter_timeout time.Time
+	ctx              *appcontext.AppContext
+	spool            *Spool
+	reportCount      atomic.Int32
+	reports          chan *Report
+	stop             chan any
+	done             chan any
+	workerDone       chan any
+	emittersMtx      sync.Mutex
+	emitters         map[string]Emitter
+	emitters_timeout time.Time
 }
 
-func NewReporter(ctx *appcontext.AppContext) *Reporter {
+// SpoolDir returns where the reports waiting to be delivered are kept.
+func SpoolDir(ctx *appcontext.AppContext) string {
+	return filepath.Join(ctx.CacheDir, "reports")
+}
+
+func newReporter(ctx *appcontext.AppContext) *Reporter {
 	r := &Reporter{
-		ctx:     ctx,
-		reports: make(chan *Report, 100),
-		stop:    make(chan any),
-		done:    make(chan any),
+		ctx:        ctx,
+		reports:    make(chan *Report, 100),
+		stop:       make(chan any),
+		done:       make(chan any),
+		workerDone: make(chan any),
+	}
+	if ctx.CacheDir != "" {
+		r.spool = NewSpool(SpoolDir(ctx))
 	}
+	return r
+}
+
+func NewReporter(ctx *appcontext.AppContext) *Reporter {
+	r := newReporter(ctx)
 
 	go func() {
 		var rp *Report
@@ -62,56 +101,245 @@ func NewReporter(ctx *appcontext.AppContext) *Reporter {
 		close(r.done)
 	}()
 
+	go r.retryWorker()
+
 	return r
 }
 
+// Process spools the report and makes a first attempt at delivering it to
+// every emitter at once. Failed deliveries are left to the retry worker.
 func (reporter *Reporter) Process(report *Report) {
 	if report.ignore {
 		return
 	}
 
-	attempts := 3
-	backoffUnit := time.Minute
-	for i := range attempts {
-		err := reporter.getEmitter().Emit(reporter.ctx, report)
-		if err == nil {
+	emitters := reporter.getEmitters()
+	if len(emitters) == 0 {
+		return
+	}
+
+	pending := make([]string, 0, len(emitters))
+	for name := range emitters {
+		pending = append(pending, name)
+	}
+	sort.Strings(pending)
+
+	entry, err := reporter.spool.Add(report, pending, spoolLease)
+	if err != nil {
+		reporter.ctx.GetLogger().Warn("failed to spool report: %s", err)
+	}
+	reporter.deliver(entry, emitters)
+}
+
+// deliver sends the report of the entry to its pending emitters, and either
+// removes the entry or schedules its next attempt.
+func (reporter *Reporter) deliver(entry *SpoolEntry, emitters map[string]Emitter) {
+	var mtx sync.Mutex
+	var failed, errs []string
+
+	var wg sync.WaitGroup
+	for _, name := range entry.Pending {
+		emitter, ok := emitters[name]
+		if !ok {
+			reporter.ctx.GetLogger().Warn("dropping report %s for emitter %s which is no longer configured", entry.ID, name)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(reporter.ctx, emitTimeout)
+			defer cancel()
+
+			if err := emitter.Emit(ctx, entry.Report); err != nil {
+				mtx.Lock()
+				failed = append(failed, name)
+				errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+				mtx.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(failed) == 0 {
+		if err := reporter.spool.Remove(entry.ID); err != nil {
+			reporter.ctx.GetLogger().Warn("failed to remove spooled report: %s", err)
+		}
+		return
+	}
+
+	sort.Strings(failed)
+	sort.Strings(errs)
+	entry.Pending = failed
+	entry.Attempts++
+	entry.LastError = strings.Join(errs, "; ")
+
+	if time.Since(entry.Created) > spoolExpiry {
+		reporter.ctx.GetLogger().Error("failed to emit report %s after %d attempts, giving up: %s",
+			entry.ID, entry.Attempts, entry.LastError)
+		if err := reporter.spool.Remove(entry.ID); err != nil {
+			reporter.ctx.GetLogger().Warn("failed to remove spooled report: %s", err)
+		}
+		return
+	}
+
+	delay := retryDelay(entry.Attempts)
+	entry.NextAttempt = time.Now().Add(delay)
+	reporter.ctx.GetLogger().Warn("failed to emit report: %s, retrying in %s", entry.LastError, delay)
+	if err := reporter.spool.Save(entry); err != nil {
+		reporter.ctx.GetLogger().Warn("failed to spool report: %s", err)
+	}
+}
+
+func retryDelay(attempts int) time.Duration {
+	if attempts > 10 {
+		return maxRetryDelay
+	}
+	return min(time.Minute<<(attempts-1), maxRetryDelay)
+}
+
+// retryWorker periodically retries the spooled reports that are due,
+// starting with those left by previous runs.
+func (reporter *Reporter) retryWorker() {
+	defer close(reporter.workerDone)
+
+	if reporter.spool == nil {
+		return
+	}
+
+	ticker := time.NewTicker(spoolInterval)
+	defer ticker.Stop()
+
+	for {
+		reporter.retry(false)
+
+		select {
+		case <-reporter.ctx.Done():
 			return
+		case <-reporter.stop:
+			return
+		case <-ticker.C:
 		}
-		reporter.ctx.GetLogger().Warn("failed to emit report: %s", err)
-		time.Sleep(backoffUnit << i)
 	}
-	reporter.ctx.GetLogger().Error("failed to emit report after %d attempts", attempts)
+}
+
+func (reporter *Reporter) stopping() bool {
+	select {
+	case <-reporter.stop:
+		return true
+	case <-reporter.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// retry delivers the spooled reports that are due, or all of them if force
+// is set, and returns how many remain undelivered.
+func (reporter *Reporter) retry(force bool) (int, error) {
+	entries, err := reporter.spool.List()
+	if err != nil {
+		return 0, err
+	}
+
+	remaining := 0
+	for _, entry := range entries {
+		if reporter.stopping() {
+			return remaining, reporter.ctx.Err()
+		}
+		if !force && entry.NextAttempt.After(time.Now()) {
+			remaining++
+			continue
+		}
+		if err := reporter.spool.Claim(entry, spoolLease); err != nil {
+			continue
+		}
+
+		reporter.deliver(entry, reporter.getEmitters())
+		if _, err := reporter.spool.Load(entry.ID); err == nil {
+			remaining++
+		}
+	}
+	return remaining, nil
+}
+
+// Flush attempts to deliver all the spooled reports right away, regardless
+// of their next due attempt. It returns how many remain undelivered.
+func Flush(ctx *appcontext.AppContext) (int, error) {
+	reporter := newReporter(ctx)
+	if reporter.spool == nil {
+		return 0, nil
+	}
+	return reporter.retry(true)
 }
 
 func (reporter *Reporter) StopAndWait() {
 	close(reporter.stop)
 	<-reporter.done
+	<-reporter.workerDone
 }
 
-func (reporter *Reporter) getEmitter() Emitter {
-	// Check if emitter should be reloaded
-	if reporter.emitter != nil && reporter.emitter_timeout.After(time.Now()) {
-		return reporter.emitter
+func (reporter *Reporter) getEmitters() map[string]Emitter {
+	reporter.emittersMtx.Lock()
+	defer reporter.emittersMtx.Unlock()
+
+	// Check if emitters should be reloaded
+	if reporter.emitters != nil && reporter.emitters_timeout.After(time.Now()) {
+		return reporter.emitters
 	}
 
-	// By default do nothing
-	reporter.emitter = &NullEmitter{}
-	reporter.emitter_timeout = time.Now().Add(time.Minute)
+	reporter.emitters = reporter.localEmitters()
+	reporter.emitters_timeout = time.Now().Add(time.Minute)
 
+	if emitter := reporter.serviceEmitter(); emitter != nil {
+		reporter.emitters["alerting"] = emitter
+	}
+	return reporter.emitters
+}
+
+// localEmitters returns the emitters declared in the configuration
+// directory, by name.
+func (reporter *Reporter) localEmitters() map[string]Emitter {
+	emitters := make(map[string]Emitter)
+	if reporter.ctx.ConfigDir == "" {
+		return emitters
+	}
+
+	cfg, err := LoadConfigFile(filepath.Join(reporter.ctx.ConfigDir, ConfigFile))
+	if err != nil {
+		reporter.ctx.GetLogger().Warn("failed to load reporting configuration: %v", err)
+		return emitters
+	}
+
+	for i := range cfg.Emitters {
+		emitter, err := cfg.Emitters[i].NewEmitter()
+		if err != nil {
+			// already validated by LoadConfigFile
+			continue
+		}
+		emitters[cfg.Emitters[i].EmitterName(i)] = emitter
+	}
+	return emitters
+}
+
+// serviceEmitter returns the emitter to the alerting service, if the user is
+// logged in and has enabled it.
+func (reporter *Reporter) serviceEmitter() Emitter {
 	// Check if user is logged
 	token, _ := reporter.ctx.GetCookies().GetAuthToken()
 	if token == "" {
-		return reporter.emitter
+		return nil
 	}
 
 	sc := services.NewServiceConnector(reporter.ctx, token)
 	enabled, err := sc.GetServiceStatus("alerting")
 	if err != nil {
 		reporter.ctx.GetLogger().Warn("failed to check alerting service: %v", err)
-		return reporter.emitter
+		return nil
 	}
 	if !enabled {
-		return reporter.emitter
+		return nil
 	}
 
 	// User is logged and alerting service is enabled
@@ -120,11 +348,10 @@ func (reporter *Reporter) getEmitter() Emitter {
 		url = PLAKAR_API_URL
 	}
 
-	reporter.emitter = &HttpEmitter{
+	return &HttpEmitter{
 		url:   url,
 		token: token,
 	}
-	return reporter.emitter
 }
 
 func (reporter *Reporter) NewReport() *Report {
@@ -150,6 +377,11 @@ func (report *Report) TaskStart(kind string, name string) {
 	}
 }
 
+// WithAttempt records which attempt of a retried task the report is about.
+func (report *Report) WithAttempt(attempt int) {
+	report.Task.Attempt = attempt
+}
+
 func (report *Report) WithRepositoryName(name string) {
 	if report.Repository != nil {
 		report.logger.Warn("already has a repository")
@@ -184,6 +416,30 @@ func (report *Report) WithSnapshot(snapshot *snapshot.Snapshot) {
 	}
 }
 
+func (report *Report) WithBackup(stats *ReportBackup) {
+	report.Backup = stats
+}
+
+func (report *Report) WithCheck(stats *ReportCheck) {
+	report.Check = stats
+}
+
+func (report *Report) WithSync(stats *ReportSync) {
+	report.Sync = stats
+}
+
+func (report *Report) WithRm(stats *ReportRm) {
+	report.Rm = stats
+}
+
+func (report *Report) WithMaintenance(stats *ReportMaintenance) {
+	report.Maintenance = stats
+}
+
+func (report *Report) WithResources(resources *ReportResources) {
+	report.Resources = resources
+}
+
 func (report *Report) TaskDone() {
 	report.taskEnd(StatusOK, 0, "")
 }
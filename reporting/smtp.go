@@ -0,0 +1,181 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const defaultMailSubject = "[plakar] {{.Task.Type}} {{.Task.Name}}: {{.Task.Status}}"
+
+// SMTPEmitter mails reports through an SMTP relay. STARTTLS is used when the
+// server offers it, and authentication when a username is set.
+type SMTPEmitter struct {
+	host    string
+	addr    string
+	auth    smtp.Auth
+	from    string
+	to      []string
+	subject *template.Template
+	dialer  net.Dialer
+}
+
+func NewSMTPEmitter(host string, port int, username, password, from string, to []string, subject string) (*SMTPEmitter, error) {
+	if host == "" {
+		return nil, fmt.Errorf("missing host")
+	}
+	if from == "" {
+		return nil, fmt.Errorf("missing sender")
+	}
+	if len(to) == 0 {
+		return nil, fmt.Errorf("missing recipients")
+	}
+	if port == 0 {
+		port = 25
+	}
+	if subject == "" {
+		subject = defaultMailSubject
+	}
+
+	t, err := template.New("subject").Funcs(templateFuncs).Parse(subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject: %w", err)
+	}
+
+	emitter := &SMTPEmitter{
+		host:    host,
+		addr:    net.JoinHostPort(host, strconv.Itoa(port)),
+		from:    from,
+		to:      to,
+		subject: t,
+	}
+	if username != "" {
+		emitter.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return emitter, nil
+}
+
+func (emitter *SMTPEmitter) message(report *Report) ([]byte, error) {
+	var subject bytes.Buffer
+	if report.Task != nil {
+		if err := emitter.subject.Execute(&subject, report); err != nil {
+			return nil, fmt.Errorf("failed to execute subject template: %w", err)
+		}
+	} else {
+		subject.WriteString("[plakar] report")
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode report: %s", err)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", emitter.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(emitter.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(&msg, "Date: %s\r\n", report.Timestamp.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&msg, "\r\n")
+
+	if task := report.Task; task != nil {
+		fmt.Fprintf(&msg, "Task:     %s %s\r\n", task.Type, task.Name)
+		fmt.Fprintf(&msg, "Status:   %s\r\n", task.Status)
+		fmt.Fprintf(&msg, "Started:  %s\r\n", task.StartTime.Format(time.RFC3339))
+		fmt.Fprintf(&msg, "Duration: %s\r\n", task.Duration.Round(time.Millisecond))
+		if task.ErrorMessage != "" {
+			fmt.Fprintf(&msg, "Error:    %s\r\n", task.ErrorMessage)
+		}
+		fmt.Fprintf(&msg, "\r\n")
+	}
+	msg.WriteString(strings.ReplaceAll(string(data), "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	return msg.Bytes(), nil
+}
+
+// Emit sends the report within the deadline of ctx: the connection is
+// closed as soon as ctx is done, which aborts the SMTP dialog.
+func (emitter *SMTPEmitter) Emit(ctx context.Context, report *Report) error {
+	msg, err := emitter.message(report)
+	if err != nil {
+		return err
+	}
+
+	conn, err := emitter.dialer.DialContext(ctx, "tcp", emitter.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := emitter.send(conn, msg); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// send is smtp.SendMail on an established connection.
+func (emitter *SMTPEmitter) send(conn net.Conn, msg []byte) error {
+	c, err := smtp.NewClient(conn, emitter.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Hello("localhost"); err != nil {
+		return err
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: emitter.host}); err != nil {
+			return err
+		}
+	}
+	if emitter.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("%s does not support authentication", emitter.addr)
+		}
+		if err := c.Auth(emitter.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(emitter.from); err != nil {
+		return err
+	}
+	for _, rcpt := range emitter.to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,171 @@
+package reporting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SpoolEntry is a report waiting to be delivered to some of the emitters.
+type SpoolEntry struct {
+	ID          string    `json:"id"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	Pending     []string  `json:"pending"`
+	Report      *Report   `json:"report"`
+}
+
+// Spool keeps the reports that are not delivered yet on disk, one file per
+// report, so that they survive restarts and emitter outages. A nil spool
+// keeps nothing.
+type Spool struct {
+	dir string
+}
+
+func NewSpool(dir string) *Spool {
+	return &Spool{dir: dir}
+}
+
+func (spool *Spool) path(id string) string {
+	return filepath.Join(spool.dir, id+".json")
+}
+
+// validID guards against identifiers escaping the spool directory.
+func validID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.ContainsAny(id, `/\`)
+}
+
+// Add spools a new report which has yet to be delivered to the pending
+// emitters, and claims it for the duration of lease.
+func (spool *Spool) Add(report *Report, pending []string, lease time.Duration) (*SpoolEntry, error) {
+	now := time.Now()
+	entry := &SpoolEntry{
+		ID:          fmt.Sprintf("%d-%08x", now.UnixNano(), rand.Uint32()),
+		Created:     now,
+		NextAttempt: now.Add(lease),
+		Pending:     pending,
+		Report:      report,
+	}
+	return entry, spool.Save(entry)
+}
+
+// Save atomically writes the entry.
+func (spool *Spool) Save(entry *SpoolEntry) error {
+	if spool == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	if err := os.MkdirAll(spool.dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(spool.dir, entry.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), spool.path(entry.ID))
+}
+
+// Load reads the entry id.
+func (spool *Spool) Load(id string) (*SpoolEntry, error) {
+	if spool == nil || !validID(id) {
+		return nil, os.ErrNotExist
+	}
+
+	data, err := os.ReadFile(spool.path(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var entry SpoolEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to decode report %s: %w", id, err)
+	}
+	return &entry, nil
+}
+
+// Remove deletes the entry id, it is not an error if it is already gone.
+func (spool *Spool) Remove(id string) error {
+	if spool == nil || !validID(id) {
+		return nil
+	}
+
+	err := os.Remove(spool.path(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List returns the spooled entries, oldest first. Unreadable entries are
+// skipped.
+func (spool *Spool) List() ([]*SpoolEntry, error) {
+	if spool == nil {
+		return nil, nil
+	}
+
+	files, err := os.ReadDir(spool.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []*SpoolEntry
+	for _, file := range files {
+		id, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok || file.IsDir() {
+			continue
+		}
+		entry, err := spool.Load(id)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Created.Before(entries[j].Created)
+	})
+	return entries, nil
+}
+
+// Claim marks the entry as being delivered for the duration of lease, so
+// that other reporters sharing the spool leave it alone meanwhile. It fails
+// if the entry is gone or was claimed in the meantime.
+func (spool *Spool) Claim(entry *SpoolEntry, lease time.Duration) error {
+	current, err := spool.Load(entry.ID)
+	if err != nil {
+		return err
+	}
+	if !current.NextAttempt.Equal(entry.NextAttempt) {
+		return fmt.Errorf("report %s is already being delivered", entry.ID)
+	}
+
+	*entry = *current
+	entry.NextAttempt = time.Now().Add(lease)
+	return spool.Save(entry)
+}
@@ -0,0 +1,56 @@
+package reporting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool(t *testing.T) {
+	spool := NewSpool(filepath.Join(t.TempDir(), "reports"))
+
+	entries, err := spool.List()
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	first, err := spool.Add(testReport(StatusFailed), []string{"alerting", "webhook[0]"}, time.Minute)
+	require.NoError(t, err)
+	second, err := spool.Add(testReport(StatusOK), []string{"exec[1]"}, 0)
+	require.NoError(t, err)
+
+	entries, err = spool.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, first.ID, entries[0].ID)
+	require.Equal(t, []string{"alerting", "webhook[0]"}, entries[0].Pending)
+	require.Equal(t, "etc", entries[0].Report.Task.Name)
+	require.Equal(t, StatusFailed, entries[0].Report.Task.Status)
+
+	// a claim fails once another reporter took the entry
+	entry, stale := entries[1], *entries[1]
+	require.NoError(t, spool.Claim(entry, time.Minute))
+	require.Error(t, spool.Claim(&stale, time.Minute))
+	require.True(t, entry.NextAttempt.After(time.Now()))
+
+	require.NoError(t, spool.Remove(second.ID))
+	require.NoError(t, spool.Remove(second.ID))
+	require.Error(t, spool.Claim(entry, time.Minute))
+
+	_, err = spool.Load("../" + filepath.Base(first.ID))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	entries, err = spool.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	var none *Spool
+	entry, err = none.Add(testReport(StatusOK), []string{"alerting"}, time.Minute)
+	require.NoError(t, err)
+	require.NotEmpty(t, entry.ID)
+	entries, err = none.List()
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
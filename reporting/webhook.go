@@ -0,0 +1,93 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"text/template"
+
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// WebhookEmitter posts reports to an arbitrary URL. The body is the report
+// encoded as JSON, unless a template producing another JSON document is
+// given.
+type WebhookEmitter struct {
+	url      string
+	headers  map[string]string
+	template *template.Template
+	client   http.Client
+}
+
+// templateFuncs are available to the webhook and mail templates, "json"
+// encodes its argument so that it can be safely embedded in a JSON body.
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func NewWebhookEmitter(url string, headers map[string]string, tmpl string) (*WebhookEmitter, error) {
+	if url == "" {
+		return nil, fmt.Errorf("missing url")
+	}
+
+	emitter := &WebhookEmitter{
+		url:     url,
+		headers: headers,
+	}
+	if tmpl != "" {
+		t, err := template.New("webhook").Funcs(templateFuncs).Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		emitter.template = t
+	}
+	return emitter, nil
+}
+
+func (emitter *WebhookEmitter) body(report *Report) ([]byte, error) {
+	if emitter.template == nil {
+		return json.Marshal(report)
+	}
+
+	var buf bytes.Buffer
+	if err := emitter.template.Execute(&buf, report); err != nil {
+		return nil, fmt.Errorf("failed to execute template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template did not produce valid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+func (emitter *WebhookEmitter) Emit(ctx context.Context, report *Report) error {
+	data, err := emitter.body(report)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", emitter.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("plakar/%s (%s/%s)", utils.VERSION, runtime.GOOS, runtime.GOARCH))
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range emitter.headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := emitter.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if 200 <= res.StatusCode && res.StatusCode < 300 {
+		return nil
+	}
+	return fmt.Errorf("request failed with status %s", res.Status)
+}
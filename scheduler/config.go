@@ -18,26 +18,37 @@ type Configuration struct {
 }
 
 type AgentConfig struct {
-	Reporting   bool                `yaml:"reporting"`
-	Maintenance []MaintenanceConfig `validate:"dive"`
-	Tasks       []Task              `mapstructure:"tasks" validate:"dive"`
+	Reporting          bool                `yaml:"reporting"`
+	Timezone           string              `yaml:"timezone"`
+	MaxConcurrentTasks int                 `mapstructure:"max_concurrent_tasks" validate:"gte=0"`
+	Windows            []Window            `validate:"dive"`
+	Maintenance        []MaintenanceConfig `validate:"dive"`
+	Tasks              []Task              `mapstructure:"tasks" validate:"dive"`
 }
 
 type Task struct {
 	Name       string `validate:"required"`
 	Repository string `validate:"required"`
+	Priority   int
+	Retry      *RetryConfig
 
 	Backup  *BackupConfig
 	Check   []CheckConfig   `validate:"dive"`
 	Restore []RestoreConfig `validate:"dive"`
 	Sync    []SyncConfig    `validate:"dive"`
+	Prune   []PruneConfig   `validate:"dive"`
 }
 
 type BackupConfig struct {
 	Name       string
 	Tags       []string
 	Path       string        `validate:"required"`
-	Interval   time.Duration `validate:"required"`
+	Interval   time.Duration `validate:"required_without_all=Schedule After OnSuccess OnFailure,excluded_with=Schedule"`
+	Schedule   *Schedule
+	Catchup    CatchupPolicy
+	After      string
+	OnSuccess  string `mapstructure:"on_success"`
+	OnFailure  string `mapstructure:"on_failure"`
 	Check      BackupConfigCheck
 	Retention  time.Duration
 	Ignore     []string
@@ -74,17 +85,27 @@ type BackupConfigCheck struct {
 }
 
 type CheckConfig struct {
-	Path     string `validate:"required"`
-	Since    string
-	Before   string
-	Interval time.Duration `validate:"required"`
-	Latest   bool
+	Path      string `validate:"required"`
+	Since     string
+	Before    string
+	Interval  time.Duration `validate:"required_without_all=Schedule After OnSuccess OnFailure,excluded_with=Schedule"`
+	Schedule  *Schedule
+	Catchup   CatchupPolicy
+	After     string
+	OnSuccess string `mapstructure:"on_success"`
+	OnFailure string `mapstructure:"on_failure"`
+	Latest    bool
 }
 
 type RestoreConfig struct {
-	Path     string        `validate:"required"`
-	Target   string        `validate:"required"`
-	Interval time.Duration `validate:"required"`
+	Path      string        `validate:"required"`
+	Target    string        `validate:"required"`
+	Interval  time.Duration `validate:"required_without_all=Schedule After OnSuccess OnFailure,excluded_with=Schedule"`
+	Schedule  *Schedule
+	Catchup   CatchupPolicy
+	After     string
+	OnSuccess string `mapstructure:"on_success"`
+	OnFailure string `mapstructure:"on_failure"`
 }
 
 type SyncDirection string
@@ -119,6 +140,37 @@ func SyncDirectionDecodeHook() mapstructure.DecodeHookFunc {
 	}
 }
 
+// CatchupPolicy tells what to do with the runs of a task that were missed
+// while the scheduler was not running or the host was asleep.
+type CatchupPolicy string
+
+const (
+	CatchupOnce CatchupPolicy = "once"
+	CatchupSkip CatchupPolicy = "skip"
+	CatchupAll  CatchupPolicy = "all"
+)
+
+// CatchupDecodeHook is a mapstructure decode hook to force the catch-up
+// policy to be one of "once", "skip" or "all".
+func CatchupDecodeHook() mapstructure.DecodeHookFunc {
+	return func(
+		from reflect.Type,
+		to reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if from.Kind() == reflect.String && to == reflect.TypeOf(CatchupPolicy("")) {
+			s := strings.TrimSpace(data.(string))
+			switch s {
+			case "once", "skip", "all":
+				return CatchupPolicy(s), nil
+			default:
+				return nil, fmt.Errorf("invalid catchup policy %q; must be one of: once, skip, all", s)
+			}
+		}
+		return data, nil
+	}
+}
+
 func DurationDecodeHook() mapstructure.DecodeHookFunc {
 	return func(
 		from reflect.Type,
@@ -145,16 +197,70 @@ func DurationDecodeHook() mapstructure.DecodeHookFunc {
 	}
 }
 
+// ScheduleDecodeHook is a mapstructure decode hook turning a "schedule:
+// <spec>" string into a Schedule. References to named windows are resolved
+// once the whole configuration is decoded.
+func ScheduleDecodeHook() mapstructure.DecodeHookFunc {
+	return func(
+		from reflect.Type,
+		to reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if to != reflect.TypeOf(&Schedule{}) && to != reflect.TypeOf(Schedule{}) {
+			return data, nil
+		}
+
+		// The hook is called again on the pointed-to value.
+		if from == reflect.TypeOf(&Schedule{}) || from == reflect.TypeOf(Schedule{}) {
+			return data, nil
+		}
+
+		if from.Kind() != reflect.String {
+			return nil, fmt.Errorf("Expected schedule to be a string, got invalid data %T", data)
+		}
+
+		schedule, err := ParseSchedule(data.(string))
+		if err != nil {
+			return nil, err
+		}
+		if to.Kind() == reflect.Ptr {
+			return schedule, nil
+		}
+		return *schedule, nil
+	}
+}
+
 type SyncConfig struct {
 	Peer      string        `validate:"required"`
 	Direction SyncDirection `validate:"required"`
-	Interval  time.Duration `validate:"required"`
+	Interval  time.Duration `validate:"required_without_all=Schedule After OnSuccess OnFailure,excluded_with=Schedule"`
+	Schedule  *Schedule
+	Catchup   CatchupPolicy
+	After     string
+	OnSuccess string `mapstructure:"on_success"`
+	OnFailure string `mapstructure:"on_failure"`
+}
+
+// PruneConfig removes the snapshots of the task that are not retained by a
+// policy from policies.yml, as "plakar prune -policy" would.
+type PruneConfig struct {
+	Policy    string        `validate:"required"`
+	Interval  time.Duration `validate:"required_without_all=Schedule After OnSuccess OnFailure,excluded_with=Schedule"`
+	Schedule  *Schedule
+	Catchup   CatchupPolicy
+	After     string
+	OnSuccess string `mapstructure:"on_success"`
+	OnFailure string `mapstructure:"on_failure"`
 }
 
 type MaintenanceConfig struct {
-	Interval   time.Duration `validate:"required"`
+	Interval   time.Duration `validate:"required_without=Schedule,excluded_with=Schedule"`
+	Schedule   *Schedule
+	Catchup    CatchupPolicy
 	Retention  time.Duration `validate:"required"`
 	Repository string        `validate:"required"`
+	Priority   int
+	Retry      *RetryConfig
 }
 
 func NewConfiguration() *Configuration {
@@ -174,6 +280,21 @@ func ParseConfigFile(filename string) (*Configuration, error) {
 		return nil, fmt.Errorf("failed to read configuration file: %w", err)
 	}
 
+	return parseConfig(file)
+}
+
+func ParseConfigBytes(configBytes []byte) (*Configuration, error) {
+	file := viper.New()
+	file.SetConfigType("yaml")
+
+	if err := file.ReadConfig(bytes.NewReader(configBytes)); err != nil {
+		return nil, fmt.Errorf("failed to read configuration data: %w", err)
+	}
+
+	return parseConfig(file)
+}
+
+func parseConfig(file *viper.Viper) (*Configuration, error) {
 	var config Configuration
 
 	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
@@ -181,7 +302,9 @@ func ParseConfigFile(filename string) (*Configuration, error) {
 		DecodeHook: mapstructure.ComposeDecodeHookFunc(
 			BackupConfigCheckDecodeHook(),
 			SyncDirectionDecodeHook(),
+			CatchupDecodeHook(),
 			DurationDecodeHook(),
+			ScheduleDecodeHook(),
 		),
 		ErrorUnused: true, // errors out if there are extra/unmapped keys
 	})
@@ -206,8 +329,8 @@ func ParseConfigFile(filename string) (*Configuration, error) {
 
 	validate.RegisterStructValidation(func(sl validator.StructLevel) {
 		obj := sl.Current().Interface().(Task)
-		if obj.Backup == nil && len(obj.Check) == 0 && len(obj.Restore) == 0 && len(obj.Sync) == 0 {
-			sl.ReportError(obj, "Task", "Task", "atleastone", "at least one of Backup, Check, Restore, or Sync must be set")
+		if obj.Backup == nil && len(obj.Check) == 0 && len(obj.Restore) == 0 && len(obj.Sync) == 0 && len(obj.Prune) == 0 {
+			sl.ReportError(obj, "Task", "Task", "atleastone", "at least one of Backup, Check, Restore, Sync, or Prune must be set")
 		}
 	}, Task{})
 
@@ -215,57 +338,99 @@ func ParseConfigFile(filename string) (*Configuration, error) {
 		return nil, fmt.Errorf("validating config: %w", err)
 	}
 
+	if err := config.resolveSchedules(); err != nil {
+		return nil, fmt.Errorf("validating config: %w", err)
+	}
+
+	if _, err := config.buildJobs(); err != nil {
+		return nil, fmt.Errorf("validating config: %w", err)
+	}
+
 	return &config, nil
 }
 
-func ParseConfigBytes(configBytes []byte) (*Configuration, error) {
-	file := viper.New()
-	file.SetConfigType("yaml")
+// resolveSchedules binds the schedules referring to a named window, and
+// applies the agent timezone to those that didn't specify one.
+func (config *Configuration) resolveSchedules() error {
+	location := time.Local
+	if config.Agent.Timezone != "" {
+		loc, err := time.LoadLocation(config.Agent.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone %q: %w", config.Agent.Timezone, err)
+		}
+		location = loc
+	}
 
-	if err := file.ReadConfig(bytes.NewReader(configBytes)); err != nil {
-		return nil, fmt.Errorf("failed to read configuration data: %w", err)
+	windows := make(map[string]*Window)
+	for i := range config.Agent.Windows {
+		w := &config.Agent.Windows[i]
+		if _, exists := windows[w.Name]; exists {
+			return fmt.Errorf("duplicate window %q", w.Name)
+		}
+		if err := w.setup(location); err != nil {
+			return err
+		}
+		windows[w.Name] = w
 	}
 
-	var config Configuration
+	resolve := func(schedule **Schedule) error {
+		s := *schedule
+		if s == nil {
+			return nil
+		}
 
-	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
-		Result: &config,
-		DecodeHook: mapstructure.ComposeDecodeHookFunc(
-			BackupConfigCheckDecodeHook(),
-			SyncDirectionDecodeHook(),
-			DurationDecodeHook(),
-		),
-		ErrorUnused: true, // errors out if there are extra/unmapped keys
-	})
-	if err != nil {
-		return nil, fmt.Errorf("creating decoder: %w", err)
+		if s.window != "" {
+			w, ok := windows[s.window]
+			if !ok {
+				return fmt.Errorf("schedule %q: unknown window %q", s.spec, s.window)
+			}
+			*schedule = w.schedule(s.spec)
+			return nil
+		}
+
+		if s.location == nil {
+			s.location = location
+		}
+		if s.Next(time.Now()).IsZero() {
+			return fmt.Errorf("schedule %q never fires", s.spec)
+		}
+		return nil
 	}
 
-	if err := decoder.Decode(file.AllSettings()); err != nil {
-		return nil, fmt.Errorf("decoding config: %w", err)
+	for i := range config.Agent.Maintenance {
+		if err := resolve(&config.Agent.Maintenance[i].Schedule); err != nil {
+			return err
+		}
 	}
 
-	// Set default values for SyncConfig.Direction.
 	for i := range config.Agent.Tasks {
-		for j := range config.Agent.Tasks[i].Sync {
-			if config.Agent.Tasks[i].Sync[j].Direction == "" {
-				config.Agent.Tasks[i].Sync[j].Direction = SyncDirectionTo
+		task := &config.Agent.Tasks[i]
+		if task.Backup != nil {
+			if err := resolve(&task.Backup.Schedule); err != nil {
+				return err
 			}
 		}
-	}
-
-	validate := validator.New(validator.WithRequiredStructEnabled())
-
-	validate.RegisterStructValidation(func(sl validator.StructLevel) {
-		obj := sl.Current().Interface().(Task)
-		if obj.Backup == nil && len(obj.Check) == 0 && len(obj.Restore) == 0 && len(obj.Sync) == 0 {
-			sl.ReportError(obj, "Task", "Task", "atleastone", "at least one of Backup, Check, Restore, or Sync must be set")
+		for j := range task.Check {
+			if err := resolve(&task.Check[j].Schedule); err != nil {
+				return err
+			}
+		}
+		for j := range task.Restore {
+			if err := resolve(&task.Restore[j].Schedule); err != nil {
+				return err
+			}
+		}
+		for j := range task.Sync {
+			if err := resolve(&task.Sync[j].Schedule); err != nil {
+				return err
+			}
+		}
+		for j := range task.Prune {
+			if err := resolve(&task.Prune[j].Schedule); err != nil {
+				return err
+			}
 		}
-	}, Task{})
-
-	if err := validate.Struct(config); err != nil {
-		return nil, fmt.Errorf("validating config: %w", err)
 	}
 
-	return &config, nil
+	return nil
 }
@@ -0,0 +1,137 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+)
+
+// TaskInfo describes a job and its state, as reported to the scheduler
+// clients.
+type TaskInfo struct {
+	ID           string
+	Task         string
+	Kind         string
+	Repository   string
+	Trigger      string
+	Paused       bool
+	Running      bool
+	Queued       bool
+	LastRun      time.Time
+	LastDuration time.Duration
+	LastSuccess  time.Time
+	LastAttempts int
+	LastStatus   JobStatus
+	LastError    string
+	NextDue      time.Time
+}
+
+// Tasks lists the configured jobs along with their state.
+func (s *Scheduler) Tasks() []TaskInfo {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var tasks []TaskInfo
+	for _, j := range s.config.jobs() {
+		info := TaskInfo{
+			ID:         j.id,
+			Task:       j.task,
+			Kind:       j.kind,
+			Repository: j.repository,
+			Trigger:    j.trigger(),
+		}
+		if r, ok := s.runners[j.id]; ok {
+			info.Running = r.job.running.Load()
+			info.Queued = r.job.queued.Load()
+		}
+		if js, ok := s.state.Get(j.id); ok {
+			info.Paused = js.Paused
+			info.LastRun = js.LastRun
+			info.LastDuration = js.LastDuration
+			info.LastSuccess = js.LastSuccess
+			info.LastAttempts = js.LastAttempts
+			info.LastStatus = js.LastStatus
+			info.LastError = js.LastError
+			info.NextDue = js.NextDue
+		}
+		tasks = append(tasks, info)
+	}
+	return tasks
+}
+
+// lookup returns the runners matching name, which is either a job id such as
+// "mytask/backup" or a task name, in which case all its jobs match.
+func (s *Scheduler) lookup(name string) ([]*runner, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if !s.started {
+		return nil, fmt.Errorf("scheduler is not running")
+	}
+
+	if r, ok := s.runners[name]; ok {
+		return []*runner{r}, nil
+	}
+
+	var runners []*runner
+	for _, r := range s.runners {
+		if r.job.task == name {
+			runners = append(runners, r)
+		}
+	}
+	if len(runners) == 0 {
+		return nil, fmt.Errorf("no such task: %s", name)
+	}
+	return runners, nil
+}
+
+// Trigger runs the matching jobs immediately, regardless of their schedule
+// and of them being paused. It fails if one of them is already running.
+func (s *Scheduler) Trigger(name string) error {
+	runners, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range runners {
+		if r.job.running.Load() {
+			return fmt.Errorf("%s: already running", r.job.id)
+		}
+	}
+
+	for _, r := range runners {
+		select {
+		case r.trigger <- struct{}{}:
+		default:
+			// a trigger is already pending
+		}
+	}
+	return nil
+}
+
+// Pause prevents the matching jobs from running when they are due, until
+// they are resumed. It is persisted across restarts.
+func (s *Scheduler) Pause(name string) error {
+	return s.setPaused(name, true)
+}
+
+// Resume reverts a previous Pause.
+func (s *Scheduler) Resume(name string) error {
+	return s.setPaused(name, false)
+}
+
+func (s *Scheduler) setPaused(name string, paused bool) error {
+	runners, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range runners {
+		err := s.state.Update(r.job.id, func(js *JobState) {
+			js.Paused = paused
+		})
+		if err != nil {
+			return fmt.Errorf("failed to save scheduler state: %w", err)
+		}
+	}
+	return nil
+}
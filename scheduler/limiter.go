@@ -0,0 +1,162 @@
+package scheduler
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// limiter queues the jobs before they run. It bounds the number of jobs
+// running at once and serializes the accesses to a repository: jobs reading
+// or adding to it share it, while those removing data need it for
+// themselves. Waiting jobs are granted by decreasing priority, then in
+// arrival order, and a job never overtakes an earlier one waiting on the
+// same repository.
+type limiter struct {
+	mtx     sync.Mutex
+	max     int // 0 means unlimited
+	running int
+	seq     uint64
+	waiters []*waiter
+	repos   map[string]*repoUsage
+}
+
+type repoUsage struct {
+	shared    int
+	exclusive bool
+}
+
+type waiter struct {
+	repository string
+	exclusive  bool
+	priority   int
+	seq        uint64
+	granted    chan struct{}
+}
+
+func newLimiter(max int) *limiter {
+	return &limiter{
+		max:   max,
+		repos: make(map[string]*repoUsage),
+	}
+}
+
+// setMax changes the number of jobs allowed to run at once.
+func (l *limiter) setMax(max int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.max = max
+	l.dispatch()
+}
+
+// acquire waits for the job to be allowed to run on repository and returns
+// the function to call once it is done. It fails if ctx is done first.
+func (l *limiter) acquire(ctx context.Context, repository string, exclusive bool, priority int) (func(), error) {
+	l.mtx.Lock()
+	l.seq++
+	w := &waiter{
+		repository: repository,
+		exclusive:  exclusive,
+		priority:   priority,
+		seq:        l.seq,
+		granted:    make(chan struct{}),
+	}
+	l.waiters = append(l.waiters, w)
+	sort.SliceStable(l.waiters, func(i, j int) bool {
+		if l.waiters[i].priority != l.waiters[j].priority {
+			return l.waiters[i].priority > l.waiters[j].priority
+		}
+		return l.waiters[i].seq < l.waiters[j].seq
+	})
+	l.dispatch()
+	l.mtx.Unlock()
+
+	select {
+	case <-w.granted:
+	case <-ctx.Done():
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+
+		select {
+		case <-w.granted:
+			// granted in the meantime, give it back
+			l.release(w)
+		default:
+			l.remove(w)
+			l.dispatch()
+		}
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mtx.Lock()
+			defer l.mtx.Unlock()
+			l.release(w)
+		})
+	}, nil
+}
+
+// dispatch grants the waiters that can run. Must be called with l.mtx held.
+func (l *limiter) dispatch() {
+	blocked := make(map[string]bool)
+
+	for i := 0; i < len(l.waiters); {
+		if l.max > 0 && l.running >= l.max {
+			return
+		}
+
+		w := l.waiters[i]
+		if blocked[w.repository] {
+			i++
+			continue
+		}
+
+		usage := l.repos[w.repository]
+		if usage == nil {
+			usage = &repoUsage{}
+			l.repos[w.repository] = usage
+		}
+		if usage.exclusive || (w.exclusive && usage.shared > 0) {
+			blocked[w.repository] = true
+			i++
+			continue
+		}
+
+		if w.exclusive {
+			usage.exclusive = true
+		} else {
+			usage.shared++
+		}
+		l.running++
+		l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+		close(w.granted)
+	}
+}
+
+// release returns the slot held by a granted waiter. Must be called with
+// l.mtx held.
+func (l *limiter) release(w *waiter) {
+	usage := l.repos[w.repository]
+	if w.exclusive {
+		usage.exclusive = false
+	} else {
+		usage.shared--
+	}
+	if !usage.exclusive && usage.shared == 0 {
+		delete(l.repos, w.repository)
+	}
+	l.running--
+	l.dispatch()
+}
+
+func (l *limiter) remove(w *waiter) {
+	for i, other := range l.waiters {
+		if other == w {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// acquireAsync queues a request and returns the channel receiving its
+// release function once granted.
+func acquireAsync(t *testing.T, l *limiter, repository string, exclusive bool, priority int) chan func() {
+	ch := make(chan func(), 1)
+	go func() {
+		release, err := l.acquire(context.Background(), repository, exclusive, priority)
+		require.NoError(t, err)
+		ch <- release
+	}()
+
+	// wait for the request to be queued or granted
+	require.Eventually(t, func() bool {
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		for _, w := range l.waiters {
+			if w.repository == repository && w.exclusive == exclusive && w.priority == priority {
+				return true
+			}
+		}
+		return len(ch) == 1
+	}, time.Second, time.Millisecond)
+	return ch
+}
+
+func granted(ch chan func()) bool {
+	return len(ch) == 1
+}
+
+func TestLimiterRepository(t *testing.T) {
+	l := newLimiter(0)
+
+	backup1 := acquireAsync(t, l, "repo", false, 0)
+	backup2 := acquireAsync(t, l, "repo", false, 0)
+	require.True(t, granted(backup1))
+	require.True(t, granted(backup2))
+
+	// maintenance waits for the backups, and later backups for it
+	maintenance := acquireAsync(t, l, "repo", true, 0)
+	backup3 := acquireAsync(t, l, "repo", false, 0)
+	other := acquireAsync(t, l, "other", false, 0)
+	require.False(t, granted(maintenance))
+	require.False(t, granted(backup3))
+	require.True(t, granted(other))
+
+	(<-backup1)()
+	require.False(t, granted(maintenance))
+	(<-backup2)()
+	require.Eventually(t, func() bool { return granted(maintenance) }, time.Second, time.Millisecond)
+	require.False(t, granted(backup3))
+
+	(<-maintenance)()
+	require.Eventually(t, func() bool { return granted(backup3) }, time.Second, time.Millisecond)
+}
+
+func TestLimiterPriority(t *testing.T) {
+	l := newLimiter(1)
+
+	first := acquireAsync(t, l, "a", false, 0)
+	require.True(t, granted(first))
+
+	low := acquireAsync(t, l, "b", false, 0)
+	high := acquireAsync(t, l, "c", false, 10)
+	require.False(t, granted(low))
+	require.False(t, granted(high))
+
+	(<-first)()
+	require.Eventually(t, func() bool { return granted(high) }, time.Second, time.Millisecond)
+	require.False(t, granted(low))
+
+	(<-high)()
+	require.Eventually(t, func() bool { return granted(low) }, time.Second, time.Millisecond)
+
+	// raising the limit lets queued jobs through
+	release := <-low
+	blocker := acquireAsync(t, l, "a", false, 0)
+	queued := acquireAsync(t, l, "b", false, 0)
+	require.False(t, granted(queued))
+	l.setMax(0)
+	require.Eventually(t, func() bool { return granted(queued) }, time.Second, time.Millisecond)
+	release()
+	(<-blocker)()
+	(<-queued)()
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := newLimiter(1)
+
+	release, err := l.acquire(context.Background(), "repo", true, 0)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = l.acquire(ctx, "repo", false, 0)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Empty(t, l.waiters)
+
+	release()
+	release, err = l.acquire(context.Background(), "repo", false, 0)
+	require.NoError(t, err)
+	release()
+	require.Zero(t, l.running)
+	require.Empty(t, l.repos)
+}
@@ -0,0 +1,47 @@
+package scheduler
+
+import (
+	"github.com/PlakarKorp/plakar/metrics"
+)
+
+var jobRuns = metrics.Default.NewCounter("plakar_scheduler_runs_total",
+	"Runs of the scheduled jobs, retries included, by outcome.", "task", "kind", "status")
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Collect exposes the state of the jobs, so that a missing or failing job
+// can be alerted on.
+func (s *Scheduler) Collect(w *metrics.Writer) error {
+	for _, info := range s.Tasks() {
+		labels := []metrics.Label{
+			{Name: "task", Value: info.Task},
+			{Name: "kind", Value: info.Kind},
+			{Name: "repository", Value: info.Repository},
+		}
+
+		w.Timestamp("plakar_scheduler_job_last_success_timestamp_seconds",
+			"When the job last succeeded.", info.LastSuccess, labels...)
+		w.Timestamp("plakar_scheduler_job_last_run_timestamp_seconds",
+			"When the job last ran.", info.LastRun, labels...)
+		w.Timestamp("plakar_scheduler_job_next_due_timestamp_seconds",
+			"When the job is due next.", info.NextDue, labels...)
+		if !info.LastRun.IsZero() {
+			w.Gauge("plakar_scheduler_job_last_duration_seconds",
+				"How long the last run of the job took, retries included.", info.LastDuration.Seconds(), labels...)
+			w.Gauge("plakar_scheduler_job_last_run_success",
+				"Whether the last run of the job succeeded.", boolValue(info.LastStatus == JobSuccess), labels...)
+		}
+		w.Gauge("plakar_scheduler_job_running",
+			"Whether the job is running.", boolValue(info.Running), labels...)
+		w.Gauge("plakar_scheduler_job_queued",
+			"Whether the job waits for a slot to run.", boolValue(info.Queued), labels...)
+		w.Gauge("plakar_scheduler_job_paused",
+			"Whether the job is paused.", boolValue(info.Paused), labels...)
+	}
+	return nil
+}
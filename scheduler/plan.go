@@ -0,0 +1,308 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// nextRun returns when a task configured either with a fixed interval or with
+// a calendar schedule should run next.
+func nextRun(interval time.Duration, schedule *Schedule, from time.Time) time.Time {
+	if schedule != nil {
+		return schedule.Next(from)
+	}
+	return from.Add(interval)
+}
+
+// job is a single schedulable step of a task: its backup, one of its checks,
+// restores or syncs, or a repository maintenance.
+type job struct {
+	id         string
+	task       string
+	kind       string
+	repository string
+	interval   time.Duration
+	schedule   *Schedule
+	catchup    CatchupPolicy
+
+	// Jobs of higher priority are run first when the scheduler is busy.
+	// Exclusive jobs remove data and cannot run along with any other job
+	// on the same repository.
+	priority  int
+	exclusive bool
+	retry     *RetryConfig
+
+	// A job following another step of its task has no schedule of its own,
+	// it runs once that step has completed with the expected outcome.
+	after      string
+	condition  condition
+	successors []*job
+
+	taskset *Task
+	config  any
+	run     func(attempt int) error
+	running atomic.Bool
+	queued  atomic.Bool
+}
+
+// condition is the outcome of a step that a job following it waits for.
+type condition string
+
+const (
+	conditionAlways  condition = "always"
+	conditionSuccess condition = "success"
+	conditionFailure condition = "failure"
+)
+
+func (c condition) matches(err error) bool {
+	switch c {
+	case conditionSuccess:
+		return err == nil
+	case conditionFailure:
+		return err != nil
+	default:
+		return true
+	}
+}
+
+// dependent reports whether the job runs after another step rather than on
+// its own schedule.
+func (j *job) dependent() bool {
+	return j.after != ""
+}
+
+func (j *job) next(from time.Time) time.Time {
+	if j.dependent() {
+		return time.Time{}
+	}
+	return nextRun(j.interval, j.schedule, from)
+}
+
+// trigger describes when the job runs, it is used to notice configuration
+// changes invalidating a persisted due date.
+func (j *job) trigger() string {
+	switch {
+	case !j.dependent():
+	case j.condition == conditionSuccess:
+		return "on success of " + j.after
+	case j.condition == conditionFailure:
+		return "on failure of " + j.after
+	default:
+		return "after " + j.after
+	}
+
+	if j.schedule != nil {
+		return j.schedule.String()
+	}
+	return j.interval.String()
+}
+
+func newJob(taskset *Task, task, kind, repository string, interval time.Duration, schedule *Schedule, catchup CatchupPolicy, config any) *job {
+	if catchup == "" {
+		catchup = CatchupOnce
+	}
+	return &job{
+		id:         task + "/" + kind,
+		task:       task,
+		kind:       kind,
+		repository: repository,
+		interval:   interval,
+		schedule:   schedule,
+		catchup:    catchup,
+		taskset:    taskset,
+		config:     config,
+	}
+}
+
+// jobs flattens the configuration into the list of its schedulable steps.
+// The configuration is expected to have been validated by buildJobs.
+func (config *Configuration) jobs() []*job {
+	jobs, _ := config.buildJobs()
+	return jobs
+}
+
+// buildJobs flattens the configuration into the list of its schedulable
+// steps, and links the steps of each task to those following them.
+func (config *Configuration) buildJobs() ([]*job, error) {
+	var jobs []*job
+
+	for i := range config.Agent.Maintenance {
+		cfg := &config.Agent.Maintenance[i]
+		j := newJob(nil, "maintenance", fmt.Sprintf("maintenance[%d]", i), cfg.Repository,
+			cfg.Interval, cfg.Schedule, cfg.Catchup, cfg)
+		j.priority = cfg.Priority
+		j.exclusive = true
+		j.retry = cfg.Retry
+		jobs = append(jobs, j)
+	}
+
+	for i := range config.Agent.Tasks {
+		task := &config.Agent.Tasks[i]
+
+		var steps []*job
+		add := func(kind string, interval time.Duration, schedule *Schedule, catchup CatchupPolicy,
+			after, onSuccess, onFailure string, cfg any) error {
+			j := newJob(task, task.Name, kind, task.Repository, interval, schedule, catchup, cfg)
+			j.priority = task.Priority
+			j.retry = task.Retry
+			switch cfg := cfg.(type) {
+			case *BackupConfig:
+				// obsolete backups are removed after the new one is done
+				j.exclusive = cfg.Retention != 0
+			case *PruneConfig:
+				j.exclusive = true
+			}
+
+			n := 0
+			for _, dep := range []struct {
+				step      string
+				condition condition
+			}{
+				{after, conditionAlways},
+				{onSuccess, conditionSuccess},
+				{onFailure, conditionFailure},
+			} {
+				if dep.step != "" {
+					j.after, j.condition = dep.step, dep.condition
+					n++
+				}
+			}
+			if n > 1 {
+				return fmt.Errorf("%s: only one of after, on_success or on_failure may be set", j.id)
+			}
+			if j.dependent() && (interval != 0 || schedule != nil) {
+				return fmt.Errorf("%s: a step running after another one cannot have an interval or a schedule", j.id)
+			}
+
+			steps = append(steps, j)
+			return nil
+		}
+
+		var err error
+		if cfg := task.Backup; cfg != nil {
+			err = add("backup", cfg.Interval, cfg.Schedule, cfg.Catchup,
+				cfg.After, cfg.OnSuccess, cfg.OnFailure, cfg)
+		}
+		for j := 0; err == nil && j < len(task.Check); j++ {
+			cfg := &task.Check[j]
+			err = add(fmt.Sprintf("check[%d]", j), cfg.Interval, cfg.Schedule, cfg.Catchup,
+				cfg.After, cfg.OnSuccess, cfg.OnFailure, cfg)
+		}
+		for j := 0; err == nil && j < len(task.Restore); j++ {
+			cfg := &task.Restore[j]
+			err = add(fmt.Sprintf("restore[%d]", j), cfg.Interval, cfg.Schedule, cfg.Catchup,
+				cfg.After, cfg.OnSuccess, cfg.OnFailure, cfg)
+		}
+		for j := 0; err == nil && j < len(task.Sync); j++ {
+			cfg := &task.Sync[j]
+			err = add(fmt.Sprintf("sync[%d]", j), cfg.Interval, cfg.Schedule, cfg.Catchup,
+				cfg.After, cfg.OnSuccess, cfg.OnFailure, cfg)
+		}
+		for j := 0; err == nil && j < len(task.Prune); j++ {
+			cfg := &task.Prune[j]
+			err = add(fmt.Sprintf("prune[%d]", j), cfg.Interval, cfg.Schedule, cfg.Catchup,
+				cfg.After, cfg.OnSuccess, cfg.OnFailure, cfg)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := linkSteps(steps); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, steps...)
+	}
+
+	return jobs, nil
+}
+
+// linkSteps attaches the steps of a task to the step they follow, which is
+// designated by its kind as in "backup" or "check[1]", or without the index
+// when the task has a single step of that kind.
+func linkSteps(steps []*job) error {
+	byKind := make(map[string]*job)
+	count := make(map[string]int)
+	for _, j := range steps {
+		byKind[j.kind] = j
+		base, _, _ := strings.Cut(j.kind, "[")
+		count[base]++
+		if count[base] == 1 {
+			byKind[base] = j
+		} else {
+			delete(byKind, base)
+		}
+	}
+
+	for _, j := range steps {
+		if !j.dependent() {
+			continue
+		}
+		prev, ok := byKind[j.after]
+		if !ok {
+			if count[j.after] > 1 {
+				return fmt.Errorf("%s: step %q is ambiguous, use %s[N]", j.id, j.after, j.after)
+			}
+			return fmt.Errorf("%s: no such step %q in task %s", j.id, j.after, j.task)
+		}
+		if prev == j {
+			return fmt.Errorf("%s: a step cannot follow itself", j.id)
+		}
+		j.after = prev.kind
+		prev.successors = append(prev.successors, j)
+	}
+
+	// every chain must start from a scheduled step
+	for _, j := range steps {
+		seen := map[*job]bool{j: true}
+		for p := j; p.dependent(); {
+			p = byKind[p.after]
+			if seen[p] {
+				return fmt.Errorf("%s: circular dependency between steps", j.id)
+			}
+			seen[p] = true
+		}
+	}
+
+	return nil
+}
+
+// PlannedTask holds the upcoming run times of a single task. Steps running
+// after another one have no run times, After describes what they wait for.
+type PlannedTask struct {
+	Task       string
+	Kind       string
+	Repository string
+	After      string
+	Runs       []time.Time
+}
+
+// Plan computes the next count run times of every configured task, as if the
+// scheduler was started at from.
+func (config *Configuration) Plan(from time.Time, count int) []PlannedTask {
+	var planned []PlannedTask
+
+	for _, j := range config.jobs() {
+		p := PlannedTask{
+			Task:       j.task,
+			Kind:       j.kind,
+			Repository: j.repository,
+		}
+		if j.dependent() {
+			p.After = j.trigger()
+		}
+
+		t := from
+		for range count {
+			t = j.next(t)
+			if t.IsZero() {
+				break
+			}
+			p.Runs = append(p.Runs, t)
+		}
+		planned = append(planned, p)
+	}
+
+	return planned
+}
@@ -0,0 +1,168 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStepDependencies(t *testing.T) {
+	config, err := ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: etc
+      repository: /var/backups
+      backup:
+        path: /etc
+        interval: 24h
+      check:
+        - path: /
+          on_success: backup
+      sync:
+        - peer: /mnt/offsite
+          on_success: check
+      prune:
+        - policy: weekly
+          after: sync[0]
+        - policy: panic
+          on_failure: backup
+`))
+	require.NoError(t, err)
+
+	jobs := config.jobs()
+	require.Len(t, jobs, 5)
+
+	backup, check, sync, prune, cleanup := jobs[0], jobs[1], jobs[2], jobs[3], jobs[4]
+	require.False(t, backup.dependent())
+	require.Equal(t, []*job{check, cleanup}, backup.successors)
+	require.Equal(t, []*job{sync}, check.successors)
+	require.Equal(t, []*job{prune}, sync.successors)
+
+	require.Equal(t, "on success of backup", check.trigger())
+	require.Equal(t, "on success of check[0]", sync.trigger())
+	require.Equal(t, "after sync[0]", prune.trigger())
+	require.Equal(t, "on failure of backup", cleanup.trigger())
+
+	// dependent steps have no schedule of their own
+	require.True(t, check.next(time.Now()).IsZero())
+	plan := config.Plan(time.Now(), 3)
+	require.Len(t, plan[0].Runs, 3)
+	require.Empty(t, plan[1].Runs)
+	require.Equal(t, "on success of backup", plan[1].After)
+
+	boom := errors.New("boom")
+	require.True(t, conditionSuccess.matches(nil))
+	require.False(t, conditionSuccess.matches(boom))
+	require.True(t, conditionFailure.matches(boom))
+	require.False(t, conditionFailure.matches(nil))
+	require.True(t, conditionAlways.matches(boom))
+}
+
+func TestStepDependenciesErrors(t *testing.T) {
+	for name, tasks := range map[string]string{
+		"unknown step": `
+      backup:
+        path: /etc
+        interval: 24h
+      check:
+        - path: /
+          after: sync`,
+		"ambiguous step": `
+      backup:
+        path: /etc
+        interval: 24h
+      check:
+        - path: /
+          interval: 1h
+        - path: /etc
+          interval: 1h
+      sync:
+        - peer: /mnt/offsite
+          after: check`,
+		"several conditions": `
+      backup:
+        path: /etc
+        interval: 24h
+      check:
+        - path: /
+          on_success: backup
+          on_failure: backup`,
+		"scheduled dependent": `
+      backup:
+        path: /etc
+        interval: 24h
+      check:
+        - path: /
+          interval: 1h
+          after: backup`,
+		"cycle": `
+      check:
+        - path: /
+          after: check[1]
+        - path: /etc
+          after: check[0]`,
+		"no trigger": `
+      check:
+        - path: /`,
+	} {
+		_, err := ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: etc
+      repository: /var/backups` + tasks + "\n"))
+		require.Error(t, err, name)
+	}
+}
+
+func TestJobAccess(t *testing.T) {
+	config, err := ParseConfigBytes([]byte(`
+agent:
+  max_concurrent_tasks: 2
+  maintenance:
+    - repository: /var/backups
+      interval: 24h
+      retention: 720h
+  tasks:
+    - name: etc
+      repository: /var/backups
+      priority: 10
+      backup:
+        path: /etc
+        interval: 1h
+      prune:
+        - policy: weekly
+          on_success: backup
+    - name: home
+      repository: /var/backups
+      backup:
+        path: /home
+        interval: 1h
+        retention: 720h
+`))
+	require.NoError(t, err)
+	require.Equal(t, 2, config.Agent.MaxConcurrentTasks)
+
+	jobs := config.jobs()
+	require.Len(t, jobs, 4)
+	require.True(t, jobs[0].exclusive)
+	require.False(t, jobs[1].exclusive)
+	require.Equal(t, 10, jobs[1].priority)
+	require.True(t, jobs[2].exclusive)
+	require.Equal(t, 10, jobs[2].priority)
+	require.True(t, jobs[3].exclusive)
+	require.Zero(t, jobs[3].priority)
+
+	_, err = ParseConfigBytes([]byte(`
+agent:
+  max_concurrent_tasks: -1
+  tasks:
+    - name: etc
+      repository: /var/backups
+      backup:
+        path: /etc
+        interval: 1h
+`))
+	require.Error(t, err)
+}
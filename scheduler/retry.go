@@ -0,0 +1,139 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/PlakarKorp/plakar/agent"
+)
+
+const (
+	defaultRetryDelay   = 30 * time.Second
+	defaultRetryBackoff = 2.0
+	defaultRetryMax     = time.Hour
+)
+
+// RetryConfig controls how the failed runs of a task are retried before
+// waiting for its next due date. The delay before the nth retry is
+// initial_delay * backoff_factor^(n-1), capped to max_delay and randomly
+// spread by up to jitter times itself in both directions.
+type RetryConfig struct {
+	MaxAttempts   int           `mapstructure:"max_attempts" validate:"gte=0"`
+	InitialDelay  time.Duration `mapstructure:"initial_delay" validate:"gte=0"`
+	BackoffFactor float64       `mapstructure:"backoff_factor" validate:"omitempty,gte=1"`
+	MaxDelay      time.Duration `mapstructure:"max_delay" validate:"gte=0"`
+	Jitter        float64       `validate:"gte=0,lte=1"`
+}
+
+// attempts returns how many times a run is attempted at most.
+func (r *RetryConfig) attempts() int {
+	if r == nil || r.MaxAttempts < 1 {
+		return 1
+	}
+	return r.MaxAttempts
+}
+
+// delay returns how long to wait after the given failed attempt, rnd
+// returning a random number in [0, 1).
+func (r *RetryConfig) delay(attempt int, rnd func() float64) time.Duration {
+	initial := r.InitialDelay
+	if initial == 0 {
+		initial = defaultRetryDelay
+	}
+	factor := r.BackoffFactor
+	if factor == 0 {
+		factor = defaultRetryBackoff
+	}
+	max := r.MaxDelay
+	if max == 0 {
+		max = defaultRetryMax
+	}
+
+	d := float64(initial) * math.Pow(factor, float64(attempt-1))
+	d = math.Min(d, float64(max))
+	d += d * r.Jitter * (2*rnd() - 1)
+	return time.Duration(d)
+}
+
+// permanentError is a failure that retrying cannot fix, such as a task
+// referring to a repository or a policy that isn't configured.
+type permanentError struct {
+	err error
+}
+
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// agentError is the failure of a command run through the agent, of
+// which only the exit status and the message are known.
+type agentError struct {
+	status int
+	err    error
+}
+
+func rpcError(retval int, err error) error {
+	if retval == 0 && err == nil {
+		return nil
+	}
+	return &agentError{status: retval, err: err}
+}
+
+func (e *agentError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %d", e.status)
+	}
+	return e.err.Error()
+}
+
+func (e *agentError) Unwrap() error {
+	return e.err
+}
+
+// retryable reports whether a failed run is worth trying again. Errors are
+// assumed to be transient, such as an unreachable storage or a repository
+// locked by another process, unless they are known to denote a problem of
+// configuration: those of the tasks, and the ones the agent reports with
+// a distinct exit status, such as a wrong passphrase or a missing source.
+func retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, errAlreadyRunning) {
+		return false
+	}
+
+	var perr *permanentError
+	if errors.As(err, &perr) {
+		return false
+	}
+	var aerr *agentError
+	if errors.As(err, &aerr) {
+		switch aerr.status {
+		case agent.ExitUsage, agent.ExitNoInput, agent.ExitConfig:
+			return false
+		}
+	}
+	return true
+}
+
+// sleep waits for d, it returns false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,118 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/agent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryDelay(t *testing.T) {
+	var r *RetryConfig
+	require.Equal(t, 1, r.attempts())
+
+	r = &RetryConfig{MaxAttempts: 4}
+	require.Equal(t, 4, r.attempts())
+
+	half := func() float64 { return 0.5 }
+	require.Equal(t, 30*time.Second, r.delay(1, half))
+	require.Equal(t, time.Minute, r.delay(2, half))
+	require.Equal(t, 2*time.Minute, r.delay(3, half))
+	require.Equal(t, time.Hour, r.delay(20, half))
+
+	r = &RetryConfig{
+		MaxAttempts:   3,
+		InitialDelay:  10 * time.Second,
+		BackoffFactor: 3,
+		MaxDelay:      time.Minute,
+		Jitter:        0.5,
+	}
+	require.Equal(t, 10*time.Second, r.delay(1, half))
+	require.Equal(t, 30*time.Second, r.delay(2, half))
+	require.Equal(t, time.Minute, r.delay(3, half))
+	require.Equal(t, 5*time.Second, r.delay(1, func() float64 { return 0 }))
+	require.Equal(t, 45*time.Second, r.delay(2, func() float64 { return 1 }))
+}
+
+func TestRetryable(t *testing.T) {
+	require.False(t, retryable(nil))
+	require.False(t, retryable(context.Canceled))
+	require.False(t, retryable(fmt.Errorf("wrapped: %w", errAlreadyRunning)))
+	require.False(t, retryable(rpcError(1, context.Canceled)))
+
+	require.True(t, retryable(errors.New("dial tcp 10.0.0.1:22: connect: connection refused")))
+	require.True(t, retryable(rpcError(1, errors.New("repository is locked by another process"))))
+	require.True(t, retryable(rpcError(1, nil)))
+
+	require.False(t, retryable(permanent(errors.New("policy \"weekly\" not found"))))
+	require.False(t, retryable(rpcError(agent.ExitUsage, errors.New("unknown command received [frobnicate]"))))
+}
+
+func TestRetryableMessages(t *testing.T) {
+	// an unmounted or unreachable store is worth waiting for, whatever
+	// its error says
+	require.True(t, retryable(rpcError(agent.ExitUnavailable,
+		errors.New("Failed to open storage: open /mnt/backups/CONFIG: no such file or directory"))))
+	require.True(t, retryable(rpcError(agent.ExitUnavailable,
+		errors.New("Failed to open storage: bucket not found"))))
+	require.True(t, retryable(errors.New("open /srv/data: no such file or directory")))
+
+	// while a wrong passphrase fails the same at every attempt, even if
+	// the agent reports it as a mere exit status
+	require.False(t, retryable(rpcError(agent.ExitConfig, nil)))
+	require.False(t, retryable(rpcError(agent.ExitConfig,
+		errors.New("Failed to setup secret: failed to verify key"))))
+	require.Equal(t, "exit status 78", rpcError(agent.ExitConfig, nil).Error())
+}
+
+func TestRetryableMissingSource(t *testing.T) {
+	// what the agent reports for a backup of a deleted directory
+	err := rpcError(agent.ExitNoInput,
+		errors.New("failed to create an importer for /srv/gone: lstat /srv/gone: no such file or directory"))
+	require.False(t, retryable(err))
+	require.EqualError(t, err, "failed to create an importer for /srv/gone: lstat /srv/gone: no such file or directory")
+
+	// unlike a failure of the backup itself
+	require.True(t, retryable(rpcError(1, errors.New("failed to create snapshot: i/o timeout"))))
+}
+
+func TestRetryConfig(t *testing.T) {
+	config, err := ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: etc
+      repository: /var/backups
+      retry:
+        max_attempts: 5
+        initial_delay: 1m
+        backoff_factor: 1.5
+        jitter: 0.2
+      backup:
+        path: /etc
+        interval: 24h
+`))
+	require.NoError(t, err)
+	require.Equal(t, &RetryConfig{
+		MaxAttempts:   5,
+		InitialDelay:  time.Minute,
+		BackoffFactor: 1.5,
+		Jitter:        0.2,
+	}, config.jobs()[0].retry)
+
+	_, err = ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: etc
+      repository: /var/backups
+      retry:
+        backoff_factor: 0.5
+      backup:
+        path: /etc
+        interval: 24h
+`))
+	require.Error(t, err)
+}
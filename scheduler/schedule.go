@@ -0,0 +1,389 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a calendar-based trigger for a task. It is expressed either as
+// a standard five-field cron expression ("minute hour day-of-month month
+// day-of-week"), as one of the predefined macros (@hourly, @daily, ...), or
+// as a reference to a named window ("@nightly") in which case the task runs
+// when the window opens.
+//
+// An optional "TZ=<zone>" prefix sets the timezone in which the expression is
+// evaluated, it defaults to the agent timezone or the local one.
+type Schedule struct {
+	spec     string
+	location *time.Location
+	window   string
+
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// Set when the day-of-month or day-of-week field is a wildcard; cron
+	// only requires both to match when neither is restricted.
+	domStar bool
+	dowStar bool
+}
+
+type cronField struct {
+	min, max int
+	names    []string
+}
+
+var (
+	minuteField = cronField{0, 59, nil}
+	hourField   = cronField{0, 23, nil}
+	domField    = cronField{1, 31, nil}
+	monthField  = cronField{1, 12, []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	dowField    = cronField{0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+var scheduleMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseSchedule parses a schedule specification. References to named windows
+// are left unresolved until Configuration.resolveSchedules() binds them.
+func ParseSchedule(spec string) (*Schedule, error) {
+	s := &Schedule{spec: strings.TrimSpace(spec)}
+	expr := s.spec
+
+	if tz, rest, ok := strings.Cut(expr, " "); ok && (strings.HasPrefix(tz, "TZ=") || strings.HasPrefix(tz, "CRON_TZ=")) {
+		_, name, _ := strings.Cut(tz, "=")
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", name, err)
+		}
+		s.location = loc
+		expr = strings.TrimSpace(rest)
+	}
+
+	if expr == "" {
+		return nil, fmt.Errorf("empty schedule")
+	}
+
+	if strings.HasPrefix(expr, "@") {
+		macro, ok := scheduleMacros[strings.ToLower(expr)]
+		if !ok {
+			s.window = expr[1:]
+			if s.window == "" {
+				return nil, fmt.Errorf("invalid schedule %q", spec)
+			}
+			return s, nil
+		}
+		expr = macro
+	}
+
+	if err := s.parseCron(expr); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+	return s, nil
+}
+
+func (s *Schedule) parseCron(expr string) error {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	var err error
+	if s.minute, err = minuteField.parse(fields[0]); err != nil {
+		return fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, err = hourField.parse(fields[1]); err != nil {
+		return fmt.Errorf("hour: %w", err)
+	}
+	if s.dom, err = domField.parse(fields[2]); err != nil {
+		return fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, err = monthField.parse(fields[3]); err != nil {
+		return fmt.Errorf("month: %w", err)
+	}
+	if s.dow, err = dowField.parse(fields[4]); err != nil {
+		return fmt.Errorf("day of week: %w", err)
+	}
+
+	// 7 is an alias for sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow = (s.dow | 1) &^ (1 << 7)
+	}
+
+	s.domStar = fields[2] == "*" || fields[2] == "?"
+	s.dowStar = fields[4] == "*" || fields[4] == "?"
+	return nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if name != "" && strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range [%d-%d]", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (f cronField) parse(field string) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+
+		var lo, hi int
+		switch {
+		case rng == "*" || rng == "?":
+			lo, hi = f.min, f.max
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(a); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(b); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			v, err := f.value(rng)
+			if err != nil {
+				return 0, err
+			}
+			lo, hi = v, v
+			if hasStep {
+				hi = f.max
+			}
+		}
+
+		for i := lo; i <= hi; i += step {
+			set |= 1 << uint(i)
+		}
+	}
+
+	return set, nil
+}
+
+// Window returns the name of the window the schedule refers to, if any.
+func (s *Schedule) Window() string {
+	return s.window
+}
+
+// Location returns the timezone in which the schedule is evaluated.
+func (s *Schedule) Location() *time.Location {
+	if s.location == nil {
+		return time.Local
+	}
+	return s.location
+}
+
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (s *Schedule) match(t time.Time) bool {
+	return s.month&(1<<uint(t.Month())) != 0 && s.matchDay(t) &&
+		s.hour&(1<<uint(t.Hour())) != 0 && s.minute&(1<<uint(t.Minute())) != 0
+}
+
+// offsetChange returns when the offset of the timezone of t last changed,
+// and by how much: positive when the clocks went forward.
+func offsetChange(t time.Time) (time.Time, time.Duration) {
+	start, _ := t.ZoneBounds()
+	if start.IsZero() {
+		return start, 0
+	}
+	_, offset := t.Zone()
+	_, before := start.Add(-time.Second).Zone()
+	return start, time.Duration(offset-before) * time.Second
+}
+
+// matchSkipped reports whether one of the times skipped by the clocks going
+// forward right at t matches the schedule.
+func (s *Schedule) matchSkipped(t time.Time) bool {
+	start, delta := offsetChange(t)
+	if delta <= 0 || !t.Equal(start) {
+		return false
+	}
+
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+	for u := wall.Add(-delta); u.Before(wall); u = u.Add(time.Minute) {
+		if s.match(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the first time strictly after t matching the schedule, or the
+// zero time if there is none within the next five years.
+//
+// Like cron, the times skipped when the clocks go forward fire right after
+// the change, and the times repeated when they go back only fire once
+// unless the schedule runs every hour.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := s.Location()
+	t = t.In(loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.matchSkipped(t) {
+			return t
+		}
+
+		var next time.Time
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.matchDay(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			next = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			next = t.Add(time.Minute)
+		default:
+			start, delta := offsetChange(t)
+			if delta >= 0 || !t.Before(start.Add(-delta)) || s.hour == 1<<24-1 {
+				return t
+			}
+			// already fired before the clocks went back
+			next = start.Add(-delta)
+		}
+
+		// never jump over a change of offset, to notice the times it skips
+		if _, end := t.ZoneBounds(); !end.IsZero() && next.After(end) {
+			next = end
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// Window is a named, recurring time range in which tasks may be scheduled,
+// for instance "mon-fri 01:00-05:00". It only constrains when tasks start:
+// those still running when it closes are not interrupted.
+type Window struct {
+	Name     string `validate:"required"`
+	Days     string
+	Start    string `validate:"required"`
+	End      string `validate:"required"`
+	Timezone string
+
+	days     uint64
+	start    int
+	end      int
+	location *time.Location
+}
+
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w *Window) setup(defaultLocation *time.Location) error {
+	var err error
+
+	days := w.Days
+	if days == "" {
+		days = "*"
+	}
+	if w.days, err = dowField.parse(days); err != nil {
+		return fmt.Errorf("window %s: days: %w", w.Name, err)
+	}
+	if w.days&(1<<7) != 0 {
+		w.days = (w.days | 1) &^ (1 << 7)
+	}
+
+	if w.start, err = parseTimeOfDay(w.Start); err != nil {
+		return fmt.Errorf("window %s: %w", w.Name, err)
+	}
+	if w.end, err = parseTimeOfDay(w.End); err != nil {
+		return fmt.Errorf("window %s: %w", w.Name, err)
+	}
+	if w.start == w.end {
+		return fmt.Errorf("window %s: start and end are identical", w.Name)
+	}
+
+	w.location = defaultLocation
+	if w.Timezone != "" {
+		if w.location, err = time.LoadLocation(w.Timezone); err != nil {
+			return fmt.Errorf("window %s: invalid timezone %q: %w", w.Name, w.Timezone, err)
+		}
+	}
+	return nil
+}
+
+// Contains reports whether t falls inside one of the window occurrences.
+// Windows crossing midnight belong to the day they open.
+func (w *Window) Contains(t time.Time) bool {
+	t = t.In(w.location)
+	minutes := t.Hour()*60 + t.Minute()
+
+	if w.start < w.end {
+		return w.days&(1<<uint(t.Weekday())) != 0 && minutes >= w.start && minutes < w.end
+	}
+
+	if minutes >= w.start {
+		return w.days&(1<<uint(t.Weekday())) != 0
+	}
+	if minutes < w.end {
+		yesterday := (t.Weekday() + 6) % 7
+		return w.days&(1<<uint(yesterday)) != 0
+	}
+	return false
+}
+
+// schedule converts the window into a schedule firing when it opens.
+func (w *Window) schedule(spec string) *Schedule {
+	return &Schedule{
+		spec:     spec,
+		location: w.location,
+		window:   w.Name,
+		minute:   1 << uint(w.start%60),
+		hour:     1 << uint(w.start/60),
+		dom:      1<<32 - 2,
+		month:    1<<13 - 2,
+		dow:      w.days,
+		domStar:  true,
+	}
+}
@@ -0,0 +1,242 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseSchedule(t *testing.T, spec string) *Schedule {
+	s, err := ParseSchedule(spec)
+	require.NoError(t, err)
+	if s.location == nil {
+		s.location = time.UTC
+	}
+	return s
+}
+
+func TestScheduleNext(t *testing.T) {
+	// Wednesday
+	from := time.Date(2025, 10, 15, 10, 0, 30, 0, time.UTC)
+
+	s := mustParseSchedule(t, "30 2 * * mon-fri")
+	require.Equal(t, time.Date(2025, 10, 16, 2, 30, 0, 0, time.UTC), s.Next(from))
+	// friday to monday
+	require.Equal(t, time.Date(2025, 10, 20, 2, 30, 0, 0, time.UTC),
+		s.Next(time.Date(2025, 10, 17, 2, 30, 0, 0, time.UTC)))
+
+	s = mustParseSchedule(t, "*/15 * * * *")
+	require.Equal(t, time.Date(2025, 10, 15, 10, 15, 0, 0, time.UTC), s.Next(from))
+
+	s = mustParseSchedule(t, "@monthly")
+	require.Equal(t, time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC), s.Next(from))
+
+	s = mustParseSchedule(t, "0 0 29 feb *")
+	require.Equal(t, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC), s.Next(from))
+
+	// both day fields restricted: either matches
+	s = mustParseSchedule(t, "0 12 1 * sun")
+	require.Equal(t, time.Date(2025, 10, 19, 12, 0, 0, 0, time.UTC), s.Next(from))
+
+	// 7 is sunday too
+	s = mustParseSchedule(t, "0 12 * * 7")
+	require.Equal(t, time.Date(2025, 10, 19, 12, 0, 0, 0, time.UTC), s.Next(from))
+
+	s = mustParseSchedule(t, "0 0 31 2 *")
+	require.True(t, s.Next(from).IsZero())
+}
+
+func TestScheduleTimezone(t *testing.T) {
+	s, err := ParseSchedule("TZ=Europe/Paris 30 2 * * *")
+	require.NoError(t, err)
+
+	from := time.Date(2025, 10, 15, 12, 0, 0, 0, time.UTC)
+	require.Equal(t, time.Date(2025, 10, 16, 0, 30, 0, 0, time.UTC), s.Next(from).UTC())
+
+	_, err = ParseSchedule("TZ=Nowhere/Atlantis 30 2 * * *")
+	require.Error(t, err)
+}
+
+// nextTimes calls Next for every minute in [from, to) and returns the
+// distinct times it fires at, checking that they never go back.
+func nextTimes(t *testing.T, s *Schedule, from, to time.Time) []time.Time {
+	var times []time.Time
+	for u := from; u.Before(to); u = u.Add(time.Minute) {
+		next := s.Next(u)
+		require.True(t, next.After(u), "Next(%s) = %s", u, next)
+		if len(times) == 0 || !next.Equal(times[len(times)-1]) {
+			if len(times) != 0 {
+				require.True(t, next.After(times[len(times)-1]), "Next(%s) = %s", u, next)
+			}
+			times = append(times, next)
+		}
+	}
+	return times
+}
+
+func TestScheduleDST(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+	cet := time.FixedZone("CET", 3600)
+	cest := time.FixedZone("CEST", 2*3600)
+
+	parse := func(spec string) *Schedule {
+		s := mustParseSchedule(t, spec)
+		s.location = paris
+		return s
+	}
+	utc := func(times []time.Time) []time.Time {
+		for i := range times {
+			times[i] = times[i].UTC()
+		}
+		return times
+	}
+	dates := func(times ...time.Time) []time.Time {
+		return utc(times)
+	}
+
+	// the clocks go forward from 02:00 to 03:00 on March 29th, 2026
+	from := time.Date(2026, 3, 28, 0, 0, 0, 0, cet)
+	to := time.Date(2026, 3, 30, 0, 0, 0, 0, cest)
+
+	require.Equal(t, dates(
+		time.Date(2026, 3, 28, 2, 30, 0, 0, cet),
+		time.Date(2026, 3, 29, 3, 0, 0, 0, cest),
+		time.Date(2026, 3, 30, 2, 30, 0, 0, cest),
+	), utc(nextTimes(t, parse("30 2 * * *"), from, to)))
+
+	require.Equal(t, dates(
+		time.Date(2026, 3, 29, 1, 0, 0, 0, cet),
+		time.Date(2026, 3, 29, 3, 0, 0, 0, cest),
+		time.Date(2026, 3, 29, 4, 0, 0, 0, cest),
+	), utc(nextTimes(t, parse("0 * * * *"),
+		time.Date(2026, 3, 29, 0, 30, 0, 0, cet), time.Date(2026, 3, 29, 3, 30, 0, 0, cest))))
+
+	// not affected by the change
+	require.Equal(t, dates(
+		time.Date(2026, 3, 28, 3, 30, 0, 0, cet),
+		time.Date(2026, 3, 29, 3, 30, 0, 0, cest),
+		time.Date(2026, 3, 30, 3, 30, 0, 0, cest),
+	), utc(nextTimes(t, parse("30 3 * * *"), from, to)))
+
+	// the clocks go back from 03:00 to 02:00 on October 25th, 2026
+	from = time.Date(2026, 10, 24, 0, 0, 0, 0, cest)
+	to = time.Date(2026, 10, 26, 0, 0, 0, 0, cet)
+
+	require.Equal(t, dates(
+		time.Date(2026, 10, 24, 2, 30, 0, 0, cest),
+		time.Date(2026, 10, 25, 2, 30, 0, 0, cest),
+		time.Date(2026, 10, 26, 2, 30, 0, 0, cet),
+	), utc(nextTimes(t, parse("30 2 * * *"), from, to)))
+
+	// every hour, the repeated one included
+	require.Equal(t, dates(
+		time.Date(2026, 10, 25, 2, 0, 0, 0, cest),
+		time.Date(2026, 10, 25, 2, 30, 0, 0, cest),
+		time.Date(2026, 10, 25, 2, 0, 0, 0, cet),
+		time.Date(2026, 10, 25, 2, 30, 0, 0, cet),
+		time.Date(2026, 10, 25, 3, 0, 0, 0, cet),
+	), utc(nextTimes(t, parse("*/30 * * * *"),
+		time.Date(2026, 10, 25, 1, 45, 0, 0, cest), time.Date(2026, 10, 25, 2, 45, 0, 0, cet))))
+
+	// a window opening in the repeated hour opens once
+	w := Window{Name: "night", Start: "02:15", End: "04:00"}
+	require.NoError(t, w.setup(paris))
+	require.Equal(t, dates(
+		time.Date(2026, 10, 24, 2, 15, 0, 0, cest),
+		time.Date(2026, 10, 25, 2, 15, 0, 0, cest),
+		time.Date(2026, 10, 26, 2, 15, 0, 0, cet),
+	), utc(nextTimes(t, w.schedule("@night"), from, to)))
+}
+
+func TestScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"@",
+	} {
+		_, err := ParseSchedule(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	w := Window{Name: "nightly", Days: "mon-fri", Start: "22:00", End: "04:00"}
+	require.NoError(t, w.setup(time.UTC))
+
+	// friday night, past midnight
+	require.True(t, w.Contains(time.Date(2025, 10, 18, 3, 0, 0, 0, time.UTC)))
+	// saturday night
+	require.False(t, w.Contains(time.Date(2025, 10, 18, 23, 0, 0, 0, time.UTC)))
+	require.False(t, w.Contains(time.Date(2025, 10, 15, 12, 0, 0, 0, time.UTC)))
+
+	s := w.schedule("@nightly")
+	require.Equal(t, time.Date(2025, 10, 20, 22, 0, 0, 0, time.UTC),
+		s.Next(time.Date(2025, 10, 17, 22, 0, 0, 0, time.UTC)))
+
+	w = Window{Name: "broken", Start: "25:00", End: "04:00"}
+	require.Error(t, w.setup(time.UTC))
+}
+
+func TestParseConfigSchedule(t *testing.T) {
+	config, err := ParseConfigBytes([]byte(`
+agent:
+  timezone: UTC
+  windows:
+    - name: nightly
+      days: mon-fri
+      start: "01:00"
+      end: "05:00"
+  tasks:
+    - name: backup
+      repository: /var/backups
+      backup:
+        path: /etc
+        schedule: "@nightly"
+      check:
+        - path: /
+          schedule: "0 6 * * *"
+`))
+	require.NoError(t, err)
+
+	from := time.Date(2025, 10, 17, 12, 0, 0, 0, time.UTC)
+	plan := config.Plan(from, 2)
+	require.Len(t, plan, 2)
+	require.Equal(t, "backup", plan[0].Kind)
+	require.Equal(t, []time.Time{
+		time.Date(2025, 10, 20, 1, 0, 0, 0, time.UTC),
+		time.Date(2025, 10, 21, 1, 0, 0, 0, time.UTC),
+	}, plan[0].Runs)
+	require.Equal(t, time.Date(2025, 10, 18, 6, 0, 0, 0, time.UTC), plan[1].Runs[0])
+
+	_, err = ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: backup
+      repository: /var/backups
+      backup:
+        path: /etc
+        schedule: "@unknown"
+`))
+	require.Error(t, err)
+
+	_, err = ParseConfigBytes([]byte(`
+agent:
+  tasks:
+    - name: backup
+      repository: /var/backups
+      backup:
+        path: /etc
+        interval: 1h
+        schedule: "@daily"
+`))
+	require.Error(t, err)
+}
@@ -1,6 +1,11 @@
 package scheduler
 
 import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -8,13 +13,37 @@ import (
 	"github.com/PlakarKorp/plakar/reporting"
 )
 
+// Runs more than this late are considered missed and subject to the job's
+// catch-up policy.
+const catchupGrace = time.Minute
+
+// Upper bound on the number of missed runs replayed by the "all" policy.
+const maxCatchupRuns = 100
+
 type Scheduler struct {
 	config   *Configuration
 	ctx      *appcontext.AppContext
 	wg       sync.WaitGroup
 	reporter *reporting.Reporter
+	state    *State
+	limiter  *limiter
+
+	mtx     sync.Mutex
+	started bool
+	runners map[string]*runner
 }
 
+// runner drives a job: it waits for it to be due, runs it, and listens for
+// manual triggers and stop requests.
+type runner struct {
+	job     *job
+	stop    chan struct{}
+	done    chan struct{}
+	trigger chan struct{}
+}
+
+var errAlreadyRunning = errors.New("already running")
+
 func stringToDuration(s string) (time.Duration, error) {
 	d, err := time.ParseDuration(s)
 	if err != nil {
@@ -24,38 +53,338 @@ func stringToDuration(s string) (time.Duration, error) {
 }
 
 func NewScheduler(ctx *appcontext.AppContext, config *Configuration) *Scheduler {
+	state, err := LoadState(StatePath(ctx))
+	if err != nil {
+		ctx.GetLogger().Warn("could not load scheduler state, starting afresh: %s", err)
+		state = NewState(StatePath(ctx))
+	}
+
 	return &Scheduler{
-		ctx:    ctx,
-		config: config,
-		wg:     sync.WaitGroup{},
+		ctx:     ctx,
+		config:  config,
+		wg:      sync.WaitGroup{},
+		state:   state,
+		limiter: newLimiter(config.Agent.MaxConcurrentTasks),
+		runners: make(map[string]*runner),
 	}
 }
 
+// StatePath returns where the scheduler persists its state.
+func StatePath(ctx *appcontext.AppContext) string {
+	return filepath.Join(ctx.CacheDir, "scheduler", "state.json")
+}
+
 func (s *Scheduler) Run() {
 	s.reporter = reporting.NewReporter(s.ctx)
 
-	for _, cleanupCfg := range s.config.Agent.Maintenance {
-		go s.maintenanceTask(cleanupCfg)
+	s.mtx.Lock()
+	s.started = true
+	for _, j := range s.config.jobs() {
+		s.spawn(j, nil)
+	}
+	s.mtx.Unlock()
+
+	<-s.ctx.Done()
+	s.wg.Wait()
+	s.reporter.StopAndWait()
+}
+
+// Reload replaces the configuration of a running scheduler. Jobs that are
+// running finish their current run before their replacement takes over.
+func (s *Scheduler) Reload(config *Configuration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.config = config
+	s.limiter.setMax(config.Agent.MaxConcurrentTasks)
+	if !s.started || s.ctx.Err() != nil {
+		return
+	}
+
+	previous := s.runners
+	s.runners = make(map[string]*runner)
+	for _, r := range previous {
+		close(r.stop)
+	}
+	for _, j := range config.jobs() {
+		s.spawn(j, previous[j.id])
+	}
+}
+
+// spawn starts the runner of a job, after the one it replaces has exited.
+// Must be called with s.mtx held.
+func (s *Scheduler) spawn(j *job, previous *runner) {
+	switch cfg := j.config.(type) {
+	case *MaintenanceConfig:
+		j.run = s.maintenanceTask(*cfg)
+	case *BackupConfig:
+		j.run = s.backupTask(*j.taskset, *cfg)
+	case *CheckConfig:
+		j.run = s.checkTask(*j.taskset, *cfg)
+	case *RestoreConfig:
+		j.run = s.restoreTask(*j.taskset, *cfg)
+	case *SyncConfig:
+		j.run = s.syncTask(*j.taskset, *cfg)
+	case *PruneConfig:
+		j.run = s.pruneTask(*j.taskset, *cfg)
+	}
+
+	r := &runner{
+		job:     j,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		trigger: make(chan struct{}, 1),
 	}
+	s.runners[j.id] = r
 
-	for _, tasksetCfg := range s.config.Agent.Tasks {
-		if tasksetCfg.Backup != nil {
-			go s.backupTask(tasksetCfg, *tasksetCfg.Backup)
+	s.wg.Add(1)
+	go func() {
+		if previous != nil {
+			<-previous.done
 		}
+		s.loop(r)
+	}()
+}
+
+type wakeup int
 
-		for _, checkCfg := range tasksetCfg.Check {
-			go s.checkTask(tasksetCfg, checkCfg)
+const (
+	wakeupDue wakeup = iota
+	wakeupTrigger
+	wakeupStop
+)
+
+// waitUntil sleeps until t, a manual trigger or a stop request. It wakes up
+// regularly and compares against the wall clock, so that the time spent with
+// the host suspended is accounted for. A zero t only waits for a trigger or a
+// stop request.
+func (s *Scheduler) waitUntil(r *runner, t time.Time) wakeup {
+	if t.IsZero() {
+		select {
+		case <-s.ctx.Done():
+			return wakeupStop
+		case <-r.stop:
+			return wakeupStop
+		case <-r.trigger:
+			return wakeupTrigger
 		}
+	}
 
-		for _, restoreCfg := range tasksetCfg.Restore {
-			go s.restoreTask(tasksetCfg, restoreCfg)
+	t = t.Round(0)
+	for {
+		d := time.Until(t)
+		if d <= 0 {
+			return wakeupDue
 		}
+		select {
+		case <-s.ctx.Done():
+			return wakeupStop
+		case <-r.stop:
+			return wakeupStop
+		case <-r.trigger:
+			return wakeupTrigger
+		case <-time.After(min(d, time.Minute)):
+		}
+	}
+}
+
+// firstDue returns the due date of the job when the scheduler starts, which
+// is the one persisted by a previous run unless its trigger changed since.
+func (s *Scheduler) firstDue(j *job) time.Time {
+	if js, ok := s.state.Get(j.id); ok && js.Trigger == j.trigger() && !js.NextDue.IsZero() {
+		return js.NextDue
+	}
+	return j.next(time.Now())
+}
+
+// missedRuns returns how many times the job should run now that it is due
+// since due, according to its catch-up policy.
+func (j *job) missedRuns(due time.Time, now time.Time) int {
+	if now.Sub(due) <= catchupGrace {
+		return 1
+	}
 
-		for _, syncCfg := range tasksetCfg.Sync {
-			go s.syncTask(tasksetCfg, syncCfg)
+	switch j.catchup {
+	case CatchupSkip:
+		return 0
+	case CatchupAll:
+		n := 0
+		for t := due; !t.IsZero() && !t.After(now) && n < maxCatchupRuns; t = j.next(t) {
+			n++
 		}
+		return n
+	default:
+		return 1
 	}
+}
 
-	<-s.ctx.Done()
-	s.reporter.StopAndWait()
+func (s *Scheduler) loop(r *runner) {
+	defer s.wg.Done()
+	defer close(r.done)
+
+	j := r.job
+	due := s.firstDue(j)
+	s.setNextDue(j, due)
+
+	for {
+		switch s.waitUntil(r, due) {
+		case wakeupStop:
+			return
+		case wakeupTrigger:
+			s.ctx.GetLogger().Info("%s: triggered manually", j.id)
+			s.runChain(j)
+			continue
+		}
+
+		if js, _ := s.state.Get(j.id); js.Paused {
+			s.ctx.GetLogger().Info("%s: paused, skipping run", j.id)
+		} else {
+			n := j.missedRuns(due, time.Now())
+			if n == 0 {
+				s.ctx.GetLogger().Info("%s: skipping run missed since %s", j.id, due.Format(time.RFC3339))
+			} else if n > 1 {
+				s.ctx.GetLogger().Info("%s: catching up %d runs missed since %s", j.id, n, due.Format(time.RFC3339))
+			}
+
+			for range n {
+				if s.ctx.Err() != nil {
+					return
+				}
+				s.runChain(j)
+			}
+		}
+
+		due = j.next(time.Now())
+		s.setNextDue(j, due)
+	}
+}
+
+// runChain runs the job and, in turn, the steps following it whose condition
+// is met by the outcome of their predecessor. When steps follow, the whole
+// chain is reported as a single pipeline task.
+func (s *Scheduler) runChain(j *job) {
+	if len(j.successors) == 0 {
+		s.execute(j)
+		return
+	}
+
+	report := s.reporter.NewReport()
+	report.TaskStart("pipeline", j.task)
+	report.WithRepositoryName(j.repository)
+
+	var failures []string
+	var walk func(j *job)
+	walk = func(j *job) {
+		err := s.execute(j)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", j.kind, err))
+		}
+		if errors.Is(err, errAlreadyRunning) {
+			return
+		}
+
+		for _, next := range j.successors {
+			if s.ctx.Err() != nil {
+				return
+			}
+			if !next.condition.matches(err) {
+				continue
+			}
+			if js, _ := s.state.Get(next.id); js.Paused {
+				s.ctx.GetLogger().Info("%s: paused, skipping run", next.id)
+				continue
+			}
+			s.ctx.GetLogger().Info("%s: running %s", next.id, next.trigger())
+			walk(next)
+		}
+	}
+	walk(j)
+
+	if len(failures) != 0 {
+		report.TaskFailed(0, "%s", strings.Join(failures, "; "))
+	} else {
+		report.TaskDone()
+	}
+}
+
+// execute runs the job, retrying it according to its retry policy, and
+// records the outcome.
+func (s *Scheduler) execute(j *job) error {
+	if !j.running.CompareAndSwap(false, true) {
+		s.ctx.GetLogger().Warn("%s: already running, skipping run", j.id)
+		return errAlreadyRunning
+	}
+	defer j.running.Store(false)
+
+	start := time.Now()
+	attempts := j.retry.attempts()
+
+	var err error
+	attempt := 1
+	for {
+		err = s.attempt(j, attempt)
+		if s.ctx.Err() != nil {
+			// interrupted by the scheduler shutting down
+			return err
+		}
+		if err == nil || attempt >= attempts || !retryable(err) {
+			break
+		}
+
+		delay := j.retry.delay(attempt, rand.Float64)
+		s.ctx.GetLogger().Warn("%s: attempt %d/%d failed: %s, retrying in %s",
+			j.id, attempt, attempts, err, delay.Round(time.Second))
+		if !sleep(s.ctx, delay) {
+			return err
+		}
+		attempt++
+	}
+	duration := time.Since(start)
+
+	status := JobSuccess
+	if err != nil {
+		status = JobFailure
+	}
+	jobRuns.Inc(j.task, j.kind, string(status))
+
+	s.updateState(j, func(js *JobState) {
+		js.LastRun = start
+		js.LastDuration = duration
+		js.LastAttempts = attempt
+		js.LastStatus = status
+		if err != nil {
+			js.LastError = err.Error()
+		} else {
+			js.LastSuccess = start
+			js.LastError = ""
+		}
+	})
+	return err
+}
+
+// attempt runs the job once it is allowed to by the limiter. The slot is not
+// held while waiting to retry, so that other jobs may run meanwhile.
+func (s *Scheduler) attempt(j *job, attempt int) error {
+	j.queued.Store(true)
+	release, err := s.limiter.acquire(s.ctx, j.repository, j.exclusive, j.priority)
+	j.queued.Store(false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return j.run(attempt)
+}
+
+func (s *Scheduler) setNextDue(j *job, due time.Time) {
+	s.updateState(j, func(js *JobState) {
+		js.Trigger = j.trigger()
+		js.NextDue = due
+	})
+}
+
+func (s *Scheduler) updateState(j *job, fn func(*JobState)) {
+	if err := s.state.Update(j.id, fn); err != nil {
+		s.ctx.GetLogger().Warn("could not save scheduler state: %s", err)
+	}
 }
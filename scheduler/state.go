@@ -0,0 +1,120 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type JobStatus string
+
+const (
+	JobSuccess JobStatus = "success"
+	JobFailure JobStatus = "failure"
+)
+
+// JobState is what the scheduler remembers about a job across restarts.
+type JobState struct {
+	Trigger      string        `json:"trigger"`
+	LastRun      time.Time     `json:"last_run"`
+	LastSuccess  time.Time     `json:"last_success,omitempty"`
+	LastDuration time.Duration `json:"last_duration"`
+	LastAttempts int           `json:"last_attempts,omitempty"`
+	LastStatus   JobStatus     `json:"last_status,omitempty"`
+	LastError    string        `json:"last_error,omitempty"`
+	NextDue      time.Time     `json:"next_due"`
+	Paused       bool          `json:"paused,omitempty"`
+}
+
+// State is the on-disk record of the jobs' last runs and next due dates. It
+// is rewritten atomically after every change.
+type State struct {
+	path string
+	mtx  sync.Mutex
+	Jobs map[string]*JobState `json:"jobs"`
+}
+
+func NewState(path string) *State {
+	return &State{
+		path: path,
+		Jobs: make(map[string]*JobState),
+	}
+}
+
+// LoadState reads the state stored at path, a missing file yields an empty
+// state.
+func LoadState(path string) (*State, error) {
+	state := NewState(path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return state, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to decode scheduler state: %w", err)
+	}
+	if state.Jobs == nil {
+		state.Jobs = make(map[string]*JobState)
+	}
+	return state, nil
+}
+
+func (st *State) save() error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(st.path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(st.path), filepath.Base(st.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), st.path)
+}
+
+// Get returns a copy of the state of job id.
+func (st *State) Get(id string) (JobState, bool) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	js, ok := st.Jobs[id]
+	if !ok {
+		return JobState{}, false
+	}
+	return *js, true
+}
+
+// Update applies fn to the state of job id and persists the result.
+func (st *State) Update(id string, fn func(*JobState)) error {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	js, ok := st.Jobs[id]
+	if !ok {
+		js = &JobState{}
+		st.Jobs[id] = js
+	}
+	fn(js)
+	return st.save()
+}
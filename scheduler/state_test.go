@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatePersistence(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "scheduler_state")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "scheduler", "state.json")
+
+	state, err := LoadState(path)
+	require.NoError(t, err)
+	_, ok := state.Get("etc/backup")
+	require.False(t, ok)
+
+	due := time.Date(2025, 10, 16, 2, 30, 0, 0, time.UTC)
+	err = state.Update("etc/backup", func(js *JobState) {
+		js.Trigger = "30 2 * * *"
+		js.NextDue = due
+		js.LastStatus = JobFailure
+		js.LastError = "boom"
+	})
+	require.NoError(t, err)
+
+	state, err = LoadState(path)
+	require.NoError(t, err)
+	js, ok := state.Get("etc/backup")
+	require.True(t, ok)
+	require.True(t, due.Equal(js.NextDue))
+	require.Equal(t, JobFailure, js.LastStatus)
+	require.Equal(t, "boom", js.LastError)
+
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0600))
+	_, err = LoadState(path)
+	require.Error(t, err)
+}
+
+func TestMissedRuns(t *testing.T) {
+	due := time.Date(2025, 10, 16, 0, 0, 0, 0, time.UTC)
+	now := due.Add(3*time.Hour + 30*time.Minute)
+
+	j := newJob(nil, "etc", "backup", "/var/backups", time.Hour, nil, "", nil)
+	require.Equal(t, CatchupOnce, j.catchup)
+	require.Equal(t, 1, j.missedRuns(due, due.Add(10*time.Second)))
+	require.Equal(t, 1, j.missedRuns(due, now))
+
+	j.catchup = CatchupSkip
+	require.Equal(t, 1, j.missedRuns(due, due.Add(10*time.Second)))
+	require.Equal(t, 0, j.missedRuns(due, now))
+
+	j.catchup = CatchupAll
+	require.Equal(t, 4, j.missedRuns(due, now))
+
+	j.interval = time.Second
+	require.Equal(t, maxCatchupRuns, j.missedRuns(due, now))
+}
@@ -1,6 +1,8 @@
 package scheduler
 
 import (
+	"fmt"
+	"path/filepath"
 	"time"
 
 	"github.com/PlakarKorp/kloset/locate"
@@ -9,12 +11,14 @@ import (
 	"github.com/PlakarKorp/plakar/subcommands/backup"
 	"github.com/PlakarKorp/plakar/subcommands/check"
 	"github.com/PlakarKorp/plakar/subcommands/maintenance"
+	"github.com/PlakarKorp/plakar/subcommands/prune"
 	"github.com/PlakarKorp/plakar/subcommands/restore"
 	"github.com/PlakarKorp/plakar/subcommands/rm"
 	"github.com/PlakarKorp/plakar/subcommands/sync"
+	"github.com/PlakarKorp/plakar/utils"
 )
 
-func (s *Scheduler) backupTask(taskset Task, task BackupConfig) {
+func (s *Scheduler) backupTask(taskset Task, task BackupConfig) func(attempt int) error {
 	backupSubcommand := &backup.Backup{}
 	backupSubcommand.Flags = subcommands.AgentSupport
 	backupSubcommand.Silent = true
@@ -34,52 +38,49 @@ func (s *Scheduler) backupTask(taskset Task, task BackupConfig) {
 	rmSubcommand.Flags = subcommands.AgentSupport
 	rmSubcommand.LocateOptions = locate.NewDefaultLocateOptions(locate.WithJob(task.Name))
 
-	for {
-		tick := time.After(task.Interval)
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-tick:
-
-			var excludes []string
-			if task.IgnoreFile != "" {
-				lines, err := backup.LoadIgnoreFile(task.IgnoreFile)
-				if err != nil {
-					s.ctx.GetLogger().Error("Failed to load ignore file: %s", err)
-					continue
-				}
-				for _, line := range lines {
-					excludes = append(excludes, line)
-				}
+	return func(attempt int) error {
+		backupSubcommand.SetAttempt(attempt)
+		rmSubcommand.SetAttempt(attempt)
+
+		var excludes []string
+		if task.IgnoreFile != "" {
+			lines, err := backup.LoadIgnoreFile(task.IgnoreFile)
+			if err != nil {
+				s.ctx.GetLogger().Error("Failed to load ignore file: %s", err)
+				return err
 			}
-			for _, line := range task.Ignore {
+			for _, line := range lines {
 				excludes = append(excludes, line)
 			}
-			backupSubcommand.Excludes = excludes
+		}
+		for _, line := range task.Ignore {
+			excludes = append(excludes, line)
+		}
+		backupSubcommand.Excludes = excludes
 
-			storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
-			if err != nil {
-				s.ctx.GetLogger().Error("Error getting repository config: %s", err)
-				continue
-			}
+		storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error getting repository config: %s", err)
+			return permanent(err)
+		}
 
-			if retval, err := agent.ExecuteRPC(s.ctx, []string{"backup"}, backupSubcommand, storeConfig); err != nil || retval != 0 {
-				s.ctx.GetLogger().Error("Error creating backup: %s", err)
-				continue
-			}
+		if err := rpcError(agent.ExecuteRPC(s.ctx, []string{"backup"}, backupSubcommand, storeConfig)); err != nil {
+			s.ctx.GetLogger().Error("Error creating backup: %s", err)
+			return err
+		}
 
-			if task.Retention != 0 {
-				rmSubcommand.LocateOptions.Filters.Before = time.Now().Add(-task.Retention)
-				if retval, err := agent.ExecuteRPC(s.ctx, []string{"rm"}, rmSubcommand, storeConfig); err != nil || retval != 0 {
-					s.ctx.GetLogger().Error("Error removing obsolete backups: %s", err)
-					continue
-				}
+		if task.Retention != 0 {
+			rmSubcommand.LocateOptions.Filters.Before = time.Now().Add(-task.Retention)
+			if err := rpcError(agent.ExecuteRPC(s.ctx, []string{"rm"}, rmSubcommand, storeConfig)); err != nil {
+				s.ctx.GetLogger().Error("Error removing obsolete backups: %s", err)
+				return err
 			}
 		}
+		return nil
 	}
 }
 
-func (s *Scheduler) checkTask(taskset Task, task CheckConfig) {
+func (s *Scheduler) checkTask(taskset Task, task CheckConfig) func(attempt int) error {
 	checkSubcommand := &check.Check{}
 	checkSubcommand.Flags = subcommands.AgentSupport
 	checkSubcommand.LocateOptions = locate.NewDefaultLocateOptions(
@@ -91,27 +92,24 @@ func (s *Scheduler) checkTask(taskset Task, task CheckConfig) {
 		checkSubcommand.Snapshots = []string{":" + task.Path}
 	}
 
-	for {
-		tick := time.After(task.Interval)
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-tick:
-			storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
-			if err != nil {
-				s.ctx.GetLogger().Error("Error getting repository config: %s", err)
-				continue
-			}
+	return func(attempt int) error {
+		checkSubcommand.SetAttempt(attempt)
 
-			retval, err := agent.ExecuteRPC(s.ctx, []string{"check"}, checkSubcommand, storeConfig)
-			if err != nil || retval != 0 {
-				s.ctx.GetLogger().Error("Error executing check: %s", err)
-			}
+		storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error getting repository config: %s", err)
+			return permanent(err)
 		}
+
+		err = rpcError(agent.ExecuteRPC(s.ctx, []string{"check"}, checkSubcommand, storeConfig))
+		if err != nil {
+			s.ctx.GetLogger().Error("Error executing check: %s", err)
+		}
+		return err
 	}
 }
 
-func (s *Scheduler) restoreTask(taskset Task, task RestoreConfig) {
+func (s *Scheduler) restoreTask(taskset Task, task RestoreConfig) func(attempt int) error {
 	restoreSubcommand := &restore.Restore{}
 	restoreSubcommand.Flags = subcommands.AgentSupport
 	restoreSubcommand.OptJob = taskset.Name
@@ -121,27 +119,24 @@ func (s *Scheduler) restoreTask(taskset Task, task RestoreConfig) {
 		restoreSubcommand.Snapshots = []string{":" + task.Path}
 	}
 
-	for {
-		tick := time.After(task.Interval)
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-tick:
-			storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
-			if err != nil {
-				s.ctx.GetLogger().Error("Error getting repository config: %s", err)
-				continue
-			}
+	return func(attempt int) error {
+		restoreSubcommand.SetAttempt(attempt)
 
-			retval, err := agent.ExecuteRPC(s.ctx, []string{"restore"}, restoreSubcommand, storeConfig)
-			if err != nil || retval != 0 {
-				s.ctx.GetLogger().Error("Error executing restore: %s", err)
-			}
+		storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error getting repository config: %s", err)
+			return permanent(err)
 		}
+
+		err = rpcError(agent.ExecuteRPC(s.ctx, []string{"restore"}, restoreSubcommand, storeConfig))
+		if err != nil {
+			s.ctx.GetLogger().Error("Error executing restore: %s", err)
+		}
+		return err
 	}
 }
 
-func (s *Scheduler) syncTask(taskset Task, task SyncConfig) {
+func (s *Scheduler) syncTask(taskset Task, task SyncConfig) func(attempt int) error {
 	syncSubcommand := &sync.Sync{}
 	syncSubcommand.Flags = subcommands.AgentSupport
 	syncSubcommand.PeerRepositoryLocation = task.Peer
@@ -152,9 +147,9 @@ func (s *Scheduler) syncTask(taskset Task, task SyncConfig) {
 	} else if task.Direction == SyncDirectionWith {
 		syncSubcommand.Direction = "with"
 	} else {
-		//return fmt.Errorf("invalid sync direction: %s", task.Direction)
-		s.ctx.Cancel()
-		return
+		return func(attempt int) error {
+			return permanent(fmt.Errorf("invalid sync direction: %s", task.Direction))
+		}
 	}
 	//	if taskset.Repository.Passphrase != "" {
 	//		syncSubcommand.DestinationRepositorySecret = []byte(taskset.Repository.Passphrase)
@@ -164,29 +159,26 @@ func (s *Scheduler) syncTask(taskset Task, task SyncConfig) {
 	//	syncSubcommand.Target = task.Target
 	//	syncSubcommand.Silent = true
 
-	for {
-		tick := time.After(task.Interval)
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-tick:
-			storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
-			if err != nil {
-				s.ctx.GetLogger().Error("Error getting repository config: %s", err)
-				continue
-			}
+	return func(attempt int) error {
+		syncSubcommand.SetAttempt(attempt)
 
-			retval, err := agent.ExecuteRPC(s.ctx, []string{"sync"}, syncSubcommand, storeConfig)
-			if err != nil || retval != 0 {
-				s.ctx.GetLogger().Error("sync: %s", err)
-			} else {
-				s.ctx.GetLogger().Info("sync: synchronization succeeded")
-			}
+		storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error getting repository config: %s", err)
+			return permanent(err)
+		}
+
+		err = rpcError(agent.ExecuteRPC(s.ctx, []string{"sync"}, syncSubcommand, storeConfig))
+		if err != nil {
+			s.ctx.GetLogger().Error("sync: %s", err)
+		} else {
+			s.ctx.GetLogger().Info("sync: synchronization succeeded")
 		}
+		return err
 	}
 }
 
-func (s *Scheduler) maintenanceTask(task MaintenanceConfig) {
+func (s *Scheduler) maintenanceTask(task MaintenanceConfig) func(attempt int) error {
 	maintenanceSubcommand := &maintenance.Maintenance{}
 	maintenanceSubcommand.Flags = subcommands.AgentSupport
 	rmSubcommand := &rm.Rm{}
@@ -194,36 +186,74 @@ func (s *Scheduler) maintenanceTask(task MaintenanceConfig) {
 	rmSubcommand.Flags = subcommands.AgentSupport
 	rmSubcommand.LocateOptions = locate.NewDefaultLocateOptions(locate.WithJob("maintenance"))
 
-	for {
-		tick := time.After(task.Interval)
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-tick:
-			storeConfig, err := s.ctx.Config.GetRepository(task.Repository)
-			if err != nil {
-				s.ctx.GetLogger().Error("Error getting repository config: %s", err)
-				continue
-			}
+	return func(attempt int) error {
+		maintenanceSubcommand.SetAttempt(attempt)
+		rmSubcommand.SetAttempt(attempt)
 
-			retval, err := agent.ExecuteRPC(s.ctx, []string{"maintenance"}, maintenanceSubcommand, storeConfig)
-			if err != nil || retval != 0 {
-				s.ctx.GetLogger().Error("Error executing maintenance: %s", err)
-				continue
+		storeConfig, err := s.ctx.Config.GetRepository(task.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error getting repository config: %s", err)
+			return permanent(err)
+		}
+
+		err = rpcError(agent.ExecuteRPC(s.ctx, []string{"maintenance"}, maintenanceSubcommand, storeConfig))
+		if err != nil {
+			s.ctx.GetLogger().Error("Error executing maintenance: %s", err)
+			return err
+		} else {
+			s.ctx.GetLogger().Info("maintenance of repository %s succeeded", task.Repository)
+		}
+
+		if task.Retention != 0 {
+			rmSubcommand.LocateOptions.Filters.Before = time.Now().Add(-task.Retention)
+			err := rpcError(agent.ExecuteRPC(s.ctx, []string{"rm"}, rmSubcommand, storeConfig))
+			if err != nil {
+				s.ctx.GetLogger().Error("Error removing obsolete backups: %s", err)
+				return err
 			} else {
-				s.ctx.GetLogger().Info("maintenance of repository %s succeeded", task.Repository)
+				s.ctx.GetLogger().Info("Retention purge succeeded")
 			}
+		}
+		return nil
+	}
+}
 
-			if task.Retention != 0 {
-				rmSubcommand.LocateOptions.Filters.Before = time.Now().Add(-task.Retention)
-				retval, err := agent.ExecuteRPC(s.ctx, []string{"rm"}, rmSubcommand, storeConfig)
-				if err != nil || retval != 0 {
-					s.ctx.GetLogger().Error("Error removing obsolete backups: %s", err)
-					continue
-				} else {
-					s.ctx.GetLogger().Info("Retention purge succeeded")
-				}
-			}
+func (s *Scheduler) pruneTask(taskset Task, task PruneConfig) func(attempt int) error {
+	pruneSubcommand := &prune.Prune{}
+	pruneSubcommand.Flags = subcommands.AgentSupport
+	pruneSubcommand.Apply = true
+
+	return func(attempt int) error {
+		pruneSubcommand.SetAttempt(attempt)
+
+		policies, err := utils.LoadPolicyConfigFile(filepath.Join(s.ctx.ConfigDir, "policies.yml"))
+		if err != nil {
+			s.ctx.GetLogger().Error("prune: failed to load policies config: %s", err)
+			return permanent(err)
+		}
+		if !policies.Has(task.Policy) {
+			err := fmt.Errorf("policy %q not found", task.Policy)
+			s.ctx.GetLogger().Error("prune: %s", err)
+			return permanent(err)
+		}
+
+		// the policy only applies to the snapshots of this task
+		pruneSubcommand.LocateOptions = locate.NewDefaultLocateOptions()
+		policies.ApplyConfig(task.Policy, pruneSubcommand.LocateOptions)
+		pruneSubcommand.LocateOptions.Filters.Job = taskset.Name
+
+		storeConfig, err := s.ctx.Config.GetRepository(taskset.Repository)
+		if err != nil {
+			s.ctx.GetLogger().Error("Error getting repository config: %s", err)
+			return permanent(err)
+		}
+
+		err = rpcError(agent.ExecuteRPC(s.ctx, []string{"prune"}, pruneSubcommand, storeConfig))
+		if err != nil {
+			s.ctx.GetLogger().Error("prune: %s", err)
+		} else {
+			s.ctx.GetLogger().Info("prune: policy %s applied to %s", task.Policy, taskset.Repository)
 		}
+		return err
 	}
 }
@@ -0,0 +1,238 @@
+package httpd
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Permission is what an authenticated client may do on the store.
+type Permission int
+
+const (
+	// PermissionRead only allows fetching data.
+	PermissionRead Permission = iota + 1
+	// PermissionAppend also allows adding data and managing locks, but
+	// never deleting states or packfiles.
+	PermissionAppend
+	// PermissionFull allows everything the server allows.
+	PermissionFull
+)
+
+func ParsePermission(s string) (Permission, error) {
+	switch s {
+	case "read":
+		return PermissionRead, nil
+	case "append":
+		return PermissionAppend, nil
+	case "full":
+		return PermissionFull, nil
+	default:
+		return 0, fmt.Errorf("unknown permission %q, must be read, append or full", s)
+	}
+}
+
+func (p Permission) String() string {
+	switch p {
+	case PermissionRead:
+		return "read"
+	case PermissionAppend:
+		return "append"
+	case PermissionFull:
+		return "full"
+	default:
+		return "none"
+	}
+}
+
+// AuthConfig lists the credentials accepted by the server, as read from the
+// file given to plakar server -auth.
+type AuthConfig struct {
+	Tokens       []TokenConfig       `yaml:"tokens"`
+	Certificates []CertificateConfig `yaml:"certificates"`
+}
+
+type TokenConfig struct {
+	Name       string `yaml:"name"`
+	Token      string `yaml:"token"`
+	Permission string `yaml:"permission"`
+}
+
+// CertificateConfig grants a permission to the clients presenting a
+// certificate, signed by the client CA, for the given common name.
+type CertificateConfig struct {
+	Name       string `yaml:"name"`
+	CommonName string `yaml:"common_name"`
+	Permission string `yaml:"permission"`
+}
+
+type principal struct {
+	name       string
+	permission Permission
+}
+
+type tokenPrincipal struct {
+	principal
+	token []byte
+}
+
+// Auth authenticates the requests made to the server.
+type Auth struct {
+	tokens       []tokenPrincipal
+	certificates map[string]principal
+
+	// anyCertificate grants full access to every client presenting a
+	// valid certificate, when mTLS is used without an auth file.
+	anyCertificate bool
+}
+
+func LoadAuthFile(path string) (*Auth, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config AuthConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	auth, err := NewAuth(&config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return auth, nil
+}
+
+func NewAuth(config *AuthConfig) (*Auth, error) {
+	auth := &Auth{
+		certificates: make(map[string]principal),
+	}
+
+	for i, token := range config.Tokens {
+		permission, err := ParsePermission(token.Permission)
+		if err != nil {
+			return nil, fmt.Errorf("token #%d: %w", i+1, err)
+		}
+		if token.Token == "" {
+			return nil, fmt.Errorf("token #%d: empty token", i+1)
+		}
+		name := token.Name
+		if name == "" {
+			name = fmt.Sprintf("token[%d]", i)
+		}
+		auth.tokens = append(auth.tokens, tokenPrincipal{
+			principal: principal{name: name, permission: permission},
+			token:     []byte(token.Token),
+		})
+	}
+
+	for i, cert := range config.Certificates {
+		permission, err := ParsePermission(cert.Permission)
+		if err != nil {
+			return nil, fmt.Errorf("certificate #%d: %w", i+1, err)
+		}
+		if cert.CommonName == "" {
+			return nil, fmt.Errorf("certificate #%d: missing common_name", i+1)
+		}
+		name := cert.Name
+		if name == "" {
+			name = cert.CommonName
+		}
+		auth.certificates[cert.CommonName] = principal{name: name, permission: permission}
+	}
+
+	return auth, nil
+}
+
+// CertificateAuth grants full access to every client presenting a valid
+// certificate.
+func CertificateAuth() *Auth {
+	return &Auth{
+		certificates:   make(map[string]principal),
+		anyCertificate: true,
+	}
+}
+
+// HasCertificates reports whether some permissions are granted to client
+// certificates, which then must be verified.
+func (auth *Auth) HasCertificates() bool {
+	return auth.anyCertificate || len(auth.certificates) != 0
+}
+
+// authenticate returns who made the request, trying the bearer token first
+// and then the verified client certificate.
+func (auth *Auth) authenticate(r *http.Request) (*principal, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return nil, fmt.Errorf("unsupported authorization scheme")
+		}
+
+		var found *principal
+		for i := range auth.tokens {
+			// go over every token to not leak which one matched
+			if subtle.ConstantTimeCompare([]byte(token), auth.tokens[i].token) == 1 {
+				found = &auth.tokens[i].principal
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("invalid token")
+		}
+		return found, nil
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) != 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		if auth.anyCertificate {
+			return &principal{name: cert.Subject.CommonName, permission: PermissionFull}, nil
+		}
+		if p, ok := auth.certificates[cert.Subject.CommonName]; ok {
+			return &p, nil
+		}
+		return nil, fmt.Errorf("certificate %q is not allowed", cert.Subject.CommonName)
+	}
+
+	return nil, fmt.Errorf("missing credentials")
+}
+
+type principalKey struct{}
+
+// anonymous is who makes the requests when authentication is disabled.
+var anonymous = &principal{name: "anonymous", permission: PermissionFull}
+
+// requestPrincipal returns who made a request that went through require.
+func requestPrincipal(r *http.Request) *principal {
+	if p, ok := r.Context().Value(principalKey{}).(*principal); ok {
+		return p
+	}
+	return anonymous
+}
+
+// require wraps a handler so that it is only served to clients holding at
+// least the given permission. A nil auth lets every request through.
+func (s *server) require(permission Permission, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.auth == nil {
+			handler(w, r)
+			return
+		}
+
+		p, err := s.auth.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="plakar"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if p.permission < permission {
+			http.Error(w, fmt.Sprintf("%s: %s permission required", p.name, permission), http.StatusForbidden)
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
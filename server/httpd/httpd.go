@@ -3,10 +3,13 @@ package httpd
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/storage"
@@ -14,9 +17,47 @@ import (
 )
 
 type server struct {
-	store    storage.Store
-	ctx      context.Context
-	noDelete bool
+	store      storage.Store
+	ctx        context.Context
+	noDelete   bool
+	appendOnly bool
+	retention  time.Duration
+	ledger     *ledger
+	usage      *usage
+	auth       *Auth
+}
+
+// Options controls how the store is exposed.
+type Options struct {
+	// NoDelete rejects the deletion of states and packfiles.
+	NoDelete bool
+
+	// AppendOnly rejects the deletion of states and packfiles, and
+	// ignores the uploads of those that already exist instead of
+	// overwriting them.
+	AppendOnly bool
+
+	// Retention, if set, makes the states and packfiles immutable until
+	// they are that old.
+	Retention time.Duration
+
+	// LedgerFile keeps when each object was first seen, which the
+	// retention period is measured against. Without it, every object
+	// is considered new when the server starts.
+	LedgerFile string
+
+	// Quota limits what the clients may store.
+	Quota network.Quota
+
+	// UsageFile keeps the history of the usage of the store.
+	UsageFile string
+
+	// TLS, if set, serves HTTPS instead of plain HTTP.
+	TLS *tls.Config
+
+	// Auth, if set, requires the clients to authenticate and restricts
+	// them to their permission.
+	Auth *Auth
 }
 
 func (s *server) openRepository(w http.ResponseWriter, r *http.Request) {
@@ -34,6 +75,8 @@ func (s *server) openRepository(w http.ResponseWriter, r *http.Request) {
 
 	var resOpen network.ResOpen
 	resOpen.Configuration = serializedConfig
+	resOpen.Policy = s.policy(requestPrincipal(r))
+	resOpen.Version = min(max(reqOpen.Version, network.ProtocolV1), network.ProtocolVersion)
 	resOpen.Err = ""
 	if err := json.NewEncoder(w).Encode(resOpen); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -70,8 +113,20 @@ func (s *server) putState(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var resPutIndex network.ResPutState
+	if s.exists(kindState, reqPutState.MAC) {
+		// never overwritten, the client gets what it asked for anyway
+		if err := json.NewEncoder(w).Encode(resPutIndex); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
 	data := reqPutState.Data
-	_, err := s.store.PutState(r.Context(), reqPutState.MAC, bytes.NewBuffer(data))
+	_, err := s.storeObject(r.Context(), kindState, reqPutState.MAC, int64(len(data)), bytes.NewBuffer(data))
+	if errors.Is(err, network.ErrQuotaExceeded) {
+		storeError(w, err)
+		return
+	}
 	if err != nil {
 		resPutIndex.Err = err.Error()
 	}
@@ -109,19 +164,22 @@ func (s *server) getState(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *server) deleteState(w http.ResponseWriter, r *http.Request) {
-	if s.noDelete {
-		http.Error(w, fmt.Errorf("not allowed to delete").Error(), http.StatusForbidden)
-		return
-	}
-
 	var reqDeleteState network.ReqDeleteState
 	if err := json.NewDecoder(r.Body).Decode(&reqDeleteState); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if err := s.canDelete(kindState, reqDeleteState.MAC); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	var resDeleteState network.ResDeleteState
 	err := s.store.DeleteState(r.Context(), reqDeleteState.MAC)
+	if err == nil {
+		err = s.deleted(kindState, reqDeleteState.MAC)
+	}
 	if err != nil {
 		resDeleteState.Err = err.Error()
 	}
@@ -160,7 +218,19 @@ func (s *server) putPackfile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var resPutPackfile network.ResPutPackfile
-	_, err := s.store.PutPackfile(r.Context(), reqPutPackfile.MAC, bytes.NewBuffer(reqPutPackfile.Data))
+	if s.exists(kindPackfile, reqPutPackfile.MAC) {
+		if err := json.NewEncoder(w).Encode(resPutPackfile); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	data := reqPutPackfile.Data
+	_, err := s.storeObject(r.Context(), kindPackfile, reqPutPackfile.MAC, int64(len(data)), bytes.NewBuffer(data))
+	if errors.Is(err, network.ErrQuotaExceeded) {
+		storeError(w, err)
+		return
+	}
 	if err != nil {
 		resPutPackfile.Err = err.Error()
 	}
@@ -224,19 +294,22 @@ func (s *server) GetPackfileBlob(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *server) deletePackfile(w http.ResponseWriter, r *http.Request) {
-	if s.noDelete {
-		http.Error(w, fmt.Errorf("not allowed to delete").Error(), http.StatusForbidden)
-		return
-	}
-
 	var reqDeletePackfile network.ReqDeletePackfile
 	if err := json.NewDecoder(r.Body).Decode(&reqDeletePackfile); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if err := s.canDelete(kindPackfile, reqDeletePackfile.MAC); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	var resDeletePackfile network.ResDeletePackfile
 	err := s.store.DeletePackfile(r.Context(), reqDeletePackfile.MAC)
+	if err == nil {
+		err = s.deleted(kindPackfile, reqDeletePackfile.MAC)
+	}
 	if err != nil {
 		resDeletePackfile.Err = err.Error()
 	}
@@ -329,38 +402,88 @@ func (s *server) deleteLock(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func Server(ctx context.Context, repo *repository.Repository, addr string, noDelete bool) error {
-	s := server{
-		store:    repo.Store(),
-		ctx:      ctx,
-		noDelete: noDelete,
-	}
-
+// handler routes the requests of the protocol to the store.
+func (s *server) handler() http.Handler {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("GET /", s.openRepository)
+	mux.HandleFunc("GET /", s.require(PermissionRead, s.openRepository))
+
+	mux.HandleFunc("GET /states", s.require(PermissionRead, s.getStates))
+	mux.HandleFunc("PUT /state", s.require(PermissionAppend, s.putState))
+	mux.HandleFunc("GET /state", s.require(PermissionRead, s.getState))
+	mux.HandleFunc("DELETE /state", s.require(PermissionFull, s.deleteState))
 
-	mux.HandleFunc("GET /states", s.getStates)
-	mux.HandleFunc("PUT /state", s.putState)
-	mux.HandleFunc("GET /state", s.getState)
-	mux.HandleFunc("DELETE /state", s.deleteState)
+	mux.HandleFunc("GET /packfiles", s.require(PermissionRead, s.getPackfiles))
+	mux.HandleFunc("PUT /packfile", s.require(PermissionAppend, s.putPackfile))
+	mux.HandleFunc("GET /packfile", s.require(PermissionRead, s.getPackfile))
+	mux.HandleFunc("GET /packfile/blob", s.require(PermissionRead, s.GetPackfileBlob))
+	mux.HandleFunc("DELETE /packfile", s.require(PermissionFull, s.deletePackfile))
 
-	mux.HandleFunc("GET /packfiles", s.getPackfiles)
-	mux.HandleFunc("PUT /packfile", s.putPackfile)
-	mux.HandleFunc("GET /packfile", s.getPackfile)
-	mux.HandleFunc("GET /packfile/blob", s.GetPackfileBlob)
-	mux.HandleFunc("DELETE /packfile", s.deletePackfile)
+	mux.HandleFunc("GET /locks", s.require(PermissionRead, s.getLocks))
+	mux.HandleFunc("PUT /lock", s.require(PermissionAppend, s.putLock))
+	mux.HandleFunc("GET /lock", s.require(PermissionRead, s.getLock))
+	mux.HandleFunc("DELETE /lock", s.require(PermissionAppend, s.deleteLock))
 
-	mux.HandleFunc("GET /locks", s.getLocks)
-	mux.HandleFunc("PUT /lock", s.putLock)
-	mux.HandleFunc("GET /lock", s.getLock)
-	mux.HandleFunc("DELETE /lock", s.deleteLock)
+	mux.HandleFunc("GET /usage", s.require(PermissionRead, s.getUsage))
+
+	for _, kind := range []string{kindState, kindPackfile, kindLock} {
+		deletePermission := PermissionFull
+		if kind == kindLock {
+			deletePermission = PermissionAppend
+		}
+		mux.HandleFunc("GET /v2/"+kind+"s", s.require(PermissionRead, s.list(kind)))
+		mux.HandleFunc("GET /v2/"+kind, s.require(PermissionRead, s.get(kind)))
+		mux.HandleFunc("PUT /v2/"+kind, s.require(PermissionAppend, s.put(kind)))
+		mux.HandleFunc("DELETE /v2/"+kind, s.require(deletePermission, s.delete(kind)))
+	}
+
+	return mux
+}
 
-	server := &http.Server{Addr: addr, Handler: mux}
+func newServer(ctx context.Context, store storage.Store, opts *Options) (*server, error) {
+	s := &server{
+		store:      store,
+		ctx:        ctx,
+		noDelete:   opts.NoDelete,
+		appendOnly: opts.AppendOnly,
+		retention:  opts.Retention,
+		auth:       opts.Auth,
+		usage:      newUsage(store, opts.Quota, opts.UsageFile),
+	}
+	s.usage.warmup(ctx)
+
+	if s.appendOnly || s.retention != 0 {
+		ledger, err := loadLedger(opts.LedgerFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ledger: %w", err)
+		}
+		if err := ledger.sync(ctx, s.store); err != nil {
+			return nil, fmt.Errorf("failed to record existing objects: %w", err)
+		}
+		s.ledger = ledger
+	}
+
+	return s, nil
+}
+
+// listen serves handler on addr until ctx is done.
+func listen(ctx context.Context, addr string, config *tls.Config, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler, TLSConfig: config}
 	go func() {
-		<-repo.AppContext().Done()
-		server.Shutdown(repo.AppContext().Context)
+		<-ctx.Done()
+		server.Shutdown(ctx)
 	}()
 
+	if config != nil {
+		return server.ListenAndServeTLS("", "")
+	}
 	return server.ListenAndServe()
 }
+
+func Server(ctx context.Context, repo *repository.Repository, addr string, opts *Options) error {
+	s, err := newServer(ctx, repo.Store(), opts)
+	if err != nil {
+		return err
+	}
+	return listen(repo.AppContext(), addr, opts.TLS, s.handler())
+}
@@ -0,0 +1,482 @@
+package httpd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/network"
+	"github.com/PlakarKorp/plakar/network/client"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is a storage.Store keeping everything in memory.
+type memStore struct {
+	mtx       sync.Mutex
+	config    []byte
+	states    map[objects.MAC][]byte
+	packfiles map[objects.MAC][]byte
+	locks     map[objects.MAC][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		config:    []byte("config"),
+		states:    make(map[objects.MAC][]byte),
+		packfiles: make(map[objects.MAC][]byte),
+		locks:     make(map[objects.MAC][]byte),
+	}
+}
+
+func (m *memStore) list(objs map[objects.MAC][]byte) []objects.MAC {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	var macs []objects.MAC
+	for mac := range objs {
+		macs = append(macs, mac)
+	}
+	sort.Slice(macs, func(i, j int) bool { return bytes.Compare(macs[i][:], macs[j][:]) < 0 })
+	return macs
+}
+
+func (m *memStore) put(objs map[objects.MAC][]byte, mac objects.MAC, rd io.Reader) (int64, error) {
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return 0, err
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	objs[mac] = data
+	return int64(len(data)), nil
+}
+
+func (m *memStore) get(objs map[objects.MAC][]byte, mac objects.MAC) (io.ReadCloser, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	data, ok := objs[mac]
+	if !ok {
+		return nil, fmt.Errorf("%x: not found", mac)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memStore) delete(objs map[objects.MAC][]byte, mac objects.MAC) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	delete(objs, mac)
+	return nil
+}
+
+func (m *memStore) Create(ctx context.Context, config []byte) error { m.config = config; return nil }
+func (m *memStore) Open(ctx context.Context) ([]byte, error)        { return m.config, nil }
+func (m *memStore) Location(ctx context.Context) (string, error)    { return "mem://", nil }
+func (m *memStore) Mode(ctx context.Context) (storage.Mode, error) {
+	return storage.ModeRead | storage.ModeWrite, nil
+}
+func (m *memStore) Size(ctx context.Context) (int64, error) { return -1, nil }
+
+func (m *memStore) GetStates(ctx context.Context) ([]objects.MAC, error) {
+	return m.list(m.states), nil
+}
+func (m *memStore) PutState(ctx context.Context, mac objects.MAC, rd io.Reader) (int64, error) {
+	return m.put(m.states, mac, rd)
+}
+func (m *memStore) GetState(ctx context.Context, mac objects.MAC) (io.ReadCloser, error) {
+	return m.get(m.states, mac)
+}
+func (m *memStore) DeleteState(ctx context.Context, mac objects.MAC) error {
+	return m.delete(m.states, mac)
+}
+
+func (m *memStore) GetPackfiles(ctx context.Context) ([]objects.MAC, error) {
+	return m.list(m.packfiles), nil
+}
+func (m *memStore) PutPackfile(ctx context.Context, mac objects.MAC, rd io.Reader) (int64, error) {
+	return m.put(m.packfiles, mac, rd)
+}
+func (m *memStore) GetPackfile(ctx context.Context, mac objects.MAC) (io.ReadCloser, error) {
+	return m.get(m.packfiles, mac)
+}
+func (m *memStore) GetPackfileBlob(ctx context.Context, mac objects.MAC, offset uint64, length uint32) (io.ReadCloser, error) {
+	rd, err := m.get(m.packfiles, mac)
+	if err != nil {
+		return nil, err
+	}
+	data, _ := io.ReadAll(rd)
+	if offset+uint64(length) > uint64(len(data)) {
+		return nil, fmt.Errorf("out of bounds")
+	}
+	return io.NopCloser(bytes.NewReader(data[offset : offset+uint64(length)])), nil
+}
+func (m *memStore) DeletePackfile(ctx context.Context, mac objects.MAC) error {
+	return m.delete(m.packfiles, mac)
+}
+
+func (m *memStore) GetLocks(ctx context.Context) ([]objects.MAC, error) {
+	return m.list(m.locks), nil
+}
+func (m *memStore) PutLock(ctx context.Context, mac objects.MAC, rd io.Reader) (int64, error) {
+	return m.put(m.locks, mac, rd)
+}
+func (m *memStore) GetLock(ctx context.Context, mac objects.MAC) (io.ReadCloser, error) {
+	return m.get(m.locks, mac)
+}
+func (m *memStore) DeleteLock(ctx context.Context, mac objects.MAC) error {
+	return m.delete(m.locks, mac)
+}
+
+func (m *memStore) Close(ctx context.Context) error { return nil }
+
+func request(t *testing.T, h http.Handler, method, path, token string, req any) *httptest.ResponseRecorder {
+	body, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestPermissions(t *testing.T) {
+	auth, err := NewAuth(&AuthConfig{
+		Tokens: []TokenConfig{
+			{Name: "reader", Token: "r", Permission: "read"},
+			{Name: "appender", Token: "a", Permission: "append"},
+			{Name: "admin", Token: "f", Permission: "full"},
+		},
+	})
+	require.NoError(t, err)
+
+	s := &server{store: newMemStore(), ctx: context.Background(), auth: auth}
+	s.usage = newUsage(s.store, network.Quota{}, "")
+	h := s.handler()
+
+	mac := objects.MAC{1}
+
+	w := request(t, h, "GET", "/", "", network.ReqOpen{})
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+
+	w = request(t, h, "GET", "/", "bogus", network.ReqOpen{})
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = request(t, h, "GET", "/", "r", network.ReqOpen{})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = request(t, h, "PUT", "/packfile", "r", network.ReqPutPackfile{MAC: mac, Data: []byte("data")})
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	w = request(t, h, "PUT", "/packfile", "a", network.ReqPutPackfile{MAC: mac, Data: []byte("data")})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = request(t, h, "DELETE", "/lock", "a", network.ReqDeleteLock{Mac: mac})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = request(t, h, "DELETE", "/packfile", "a", network.ReqDeletePackfile{MAC: mac})
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Contains(t, w.Body.String(), "full permission required")
+
+	w = request(t, h, "DELETE", "/packfile", "f", network.ReqDeletePackfile{MAC: mac})
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestNewAuth(t *testing.T) {
+	_, err := NewAuth(&AuthConfig{Tokens: []TokenConfig{{Token: "x", Permission: "write"}}})
+	require.ErrorContains(t, err, "unknown permission")
+
+	_, err = NewAuth(&AuthConfig{Tokens: []TokenConfig{{Permission: "read"}}})
+	require.ErrorContains(t, err, "empty token")
+
+	_, err = NewAuth(&AuthConfig{Certificates: []CertificateConfig{{Permission: "read"}}})
+	require.ErrorContains(t, err, "missing common_name")
+
+	auth, err := NewAuth(&AuthConfig{Certificates: []CertificateConfig{{CommonName: "backup", Permission: "append"}}})
+	require.NoError(t, err)
+	require.True(t, auth.HasCertificates())
+}
+
+func TestClientTLS(t *testing.T) {
+	auth, err := NewAuth(&AuthConfig{
+		Tokens: []TokenConfig{{Token: "secret", Permission: "append"}},
+	})
+	require.NoError(t, err)
+
+	cert, err := SelfSignedCertificate([]string{"127.0.0.1"})
+	require.NoError(t, err)
+
+	s := &server{store: newMemStore(), ctx: context.Background(), auth: auth}
+	s.usage = newUsage(s.store, network.Quota{}, "")
+	ts := httptest.NewUnstartedServer(s.handler())
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	ts.StartTLS()
+	defer ts.Close()
+
+	ctx := context.Background()
+	location := "plakar+" + ts.URL
+
+	store, err := client.NewStore(ctx, "plakar+https", map[string]string{
+		"location":        location,
+		"token":           "secret",
+		"tls_fingerprint": Fingerprint(cert.Certificate[0]),
+	})
+	require.NoError(t, err)
+
+	config, err := store.Open(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte("config"), config)
+
+	mac := objects.MAC{2}
+	_, err = store.PutPackfile(ctx, mac, strings.NewReader("hello, world"))
+	require.NoError(t, err)
+
+	rd, err := store.GetPackfileBlob(ctx, mac, 7, 5)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(data))
+
+	err = store.DeletePackfile(ctx, mac)
+	require.ErrorContains(t, err, "does not allow deletions")
+
+	// a server presenting another certificate is refused
+	store, err = client.NewStore(ctx, "plakar+https", map[string]string{
+		"location":        location,
+		"token":           "secret",
+		"tls_fingerprint": strings.Repeat("00", 32),
+	})
+	require.NoError(t, err)
+	_, err = store.Open(ctx)
+	require.ErrorContains(t, err, "fingerprint")
+}
+
+func TestAppendOnly(t *testing.T) {
+	store := newMemStore()
+	ledger, err := loadLedger("")
+	require.NoError(t, err)
+
+	s := &server{store: store, ctx: context.Background(), appendOnly: true, ledger: ledger}
+	s.usage = newUsage(s.store, network.Quota{}, "")
+	h := s.handler()
+
+	w := request(t, h, "GET", "/", "", network.ReqOpen{})
+	require.Equal(t, http.StatusOK, w.Code)
+	var res network.ResOpen
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	require.True(t, res.Policy.AppendOnly)
+	require.False(t, res.Policy.Delete)
+
+	mac := objects.MAC{3}
+	w = request(t, h, "PUT", "/packfile", "", network.ReqPutPackfile{MAC: mac, Data: []byte("original")})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = request(t, h, "PUT", "/packfile", "", network.ReqPutPackfile{MAC: mac, Data: []byte("tampered")})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, []byte("original"), store.packfiles[mac])
+
+	w = request(t, h, "DELETE", "/packfile", "", network.ReqDeletePackfile{MAC: mac})
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Contains(t, w.Body.String(), "append-only")
+
+	w = request(t, h, "PUT", "/state", "", network.ReqPutState{MAC: mac, Data: []byte("state")})
+	require.Equal(t, http.StatusOK, w.Code)
+	w = request(t, h, "DELETE", "/state", "", network.ReqDeleteState{MAC: mac})
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	w = request(t, h, "PUT", "/lock", "", network.ReqPutLock{Mac: mac, Data: []byte("lock")})
+	require.Equal(t, http.StatusOK, w.Code)
+	w = request(t, h, "DELETE", "/lock", "", network.ReqDeleteLock{Mac: mac})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, store.locks)
+}
+
+func TestRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger")
+
+	old, young := objects.MAC{4}, objects.MAC{5}
+	past := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.WriteFile(path, []byte(entry(past, "+", ledgerKey{kindPackfile, old})), 0600))
+
+	store := newMemStore()
+	store.packfiles[old] = []byte("old")
+	store.packfiles[young] = []byte("young")
+
+	ledger, err := loadLedger(path)
+	require.NoError(t, err)
+	require.NoError(t, ledger.sync(context.Background(), store))
+
+	s := &server{store: store, ctx: context.Background(), retention: 24 * time.Hour, ledger: ledger}
+	s.usage = newUsage(s.store, network.Quota{}, "")
+	h := s.handler()
+
+	w := request(t, h, "DELETE", "/packfile", "", network.ReqDeletePackfile{MAC: young})
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Contains(t, w.Body.String(), "retained until")
+
+	w = request(t, h, "DELETE", "/packfile", "", network.ReqDeletePackfile{MAC: old})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotContains(t, store.packfiles, old)
+
+	// the ledger survives a restart
+	ledger, err = loadLedger(path)
+	require.NoError(t, err)
+	_, ok := ledger.created(kindPackfile, old)
+	require.False(t, ok)
+	created, ok := ledger.created(kindPackfile, young)
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now(), created, time.Minute)
+}
+
+func TestRetentionWithoutDelete(t *testing.T) {
+	store := newMemStore()
+	ledger, err := loadLedger(filepath.Join(t.TempDir(), "ledger"))
+	require.NoError(t, err)
+
+	s := &server{store: store, ctx: context.Background(), noDelete: true, retention: time.Hour, ledger: ledger}
+	s.usage = newUsage(s.store, network.Quota{}, "")
+	h := s.handler()
+
+	mac := objects.MAC{7}
+	w := request(t, h, "PUT", "/packfile", "", network.ReqPutPackfile{MAC: mac, Data: []byte("first")})
+	require.Equal(t, http.StatusOK, w.Code)
+	w = request(t, h, "PUT", "/packfile", "", network.ReqPutPackfile{MAC: mac, Data: []byte("second")})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, []byte("first"), store.packfiles[mac])
+
+	w = request(t, h, "DELETE", "/packfile", "", network.ReqDeletePackfile{MAC: mac})
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Contains(t, store.packfiles, mac)
+}
+
+func testStoreRoundtrip(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+
+	config, err := store.Open(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte("config"), config)
+
+	mac := objects.MAC{6}
+	n, err := store.PutPackfile(ctx, mac, strings.NewReader("hello, world"))
+	require.NoError(t, err)
+	require.Equal(t, int64(12), n)
+
+	macs, err := store.GetPackfiles(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []objects.MAC{mac}, macs)
+
+	rd, err := store.GetPackfile(ctx, mac)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.NoError(t, rd.Close())
+	require.Equal(t, "hello, world", string(data))
+
+	rd, err = store.GetPackfileBlob(ctx, mac, 7, 5)
+	require.NoError(t, err)
+	data, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.NoError(t, rd.Close())
+	require.Equal(t, "world", string(data))
+
+	_, err = store.PutState(ctx, mac, bytes.NewReader(nil))
+	require.NoError(t, err)
+	rd, err = store.GetState(ctx, mac)
+	require.NoError(t, err)
+	data, err = io.ReadAll(rd)
+	require.NoError(t, err)
+	require.NoError(t, rd.Close())
+	require.Empty(t, data)
+
+	_, err = store.PutLock(ctx, mac, strings.NewReader("lock"))
+	require.NoError(t, err)
+	locks, err := store.GetLocks(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []objects.MAC{mac}, locks)
+	require.NoError(t, store.DeleteLock(ctx, mac))
+	locks, err = store.GetLocks(ctx)
+	require.NoError(t, err)
+	require.Empty(t, locks)
+
+	require.NoError(t, store.DeletePackfile(ctx, mac))
+	_, err = store.GetPackfile(ctx, mac)
+	require.Error(t, err)
+}
+
+func TestProtocolV2(t *testing.T) {
+	s := &server{store: newMemStore(), ctx: context.Background()}
+	s.usage = newUsage(s.store, network.Quota{}, "")
+
+	var versions []string
+	mux := s.handler()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		versions = append(versions, r.URL.Path)
+		mux.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	store, err := client.NewStore(context.Background(), "plakar+http", map[string]string{
+		"location": "plakar+" + ts.URL,
+	})
+	require.NoError(t, err)
+	testStoreRoundtrip(t, store)
+
+	for _, path := range versions[1:] {
+		require.True(t, strings.HasPrefix(path, "/v2/"), path)
+	}
+}
+
+func TestProtocolV1Compat(t *testing.T) {
+	s := &server{store: newMemStore(), ctx: context.Background()}
+	s.usage = newUsage(s.store, network.Quota{}, "")
+
+	// a server predating version 2 neither answers with a version nor
+	// knows about the version 2 routes
+	mux := s.handler()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v2/") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path == "/" {
+			json.NewEncoder(w).Encode(struct{ Configuration []byte }{[]byte("config")})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	store, err := client.NewStore(context.Background(), "plakar+http", map[string]string{
+		"location": "plakar+" + ts.URL,
+	})
+	require.NoError(t, err)
+	testStoreRoundtrip(t, store)
+}
+
+func TestParseRange(t *testing.T) {
+	offset, length, err := parseRange("bytes=7-11")
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), offset)
+	require.Equal(t, uint32(5), length)
+
+	for _, header := range []string{"bytes=7-", "bytes=-5", "bytes=11-7", "bytes=0-1,4-5", "items=0-1"} {
+		_, _, err := parseRange(header)
+		require.Error(t, err, header)
+	}
+}
@@ -0,0 +1,170 @@
+package httpd
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+)
+
+const (
+	kindState    = "state"
+	kindPackfile = "packfile"
+	kindLock     = "lock"
+)
+
+type ledgerKey struct {
+	kind string
+	mac  objects.MAC
+}
+
+// ledger remembers when the server first saw each state and packfile, as
+// stores don't tell how old an object is. It is kept in an append-only
+// file of "<unix time> <+|-> <kind> <mac>" lines, and is what the
+// retention period is measured against.
+type ledger struct {
+	mtx     sync.Mutex
+	path    string
+	objects map[ledgerKey]time.Time
+}
+
+func loadLedger(path string) (*ledger, error) {
+	l := &ledger{
+		path:    path,
+		objects: make(map[ledgerKey]time.Time),
+	}
+	if path == "" {
+		return l, nil
+	}
+
+	fp, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	scanner := bufio.NewScanner(fp)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("%s:%d: malformed entry", path, n)
+		}
+		ts, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: bad timestamp: %w", path, n, err)
+		}
+		raw, err := hex.DecodeString(fields[3])
+		if err != nil || len(raw) != len(objects.MAC{}) {
+			return nil, fmt.Errorf("%s:%d: bad MAC", path, n)
+		}
+		key := ledgerKey{kind: fields[2], mac: objects.MAC(raw)}
+
+		switch fields[1] {
+		case "+":
+			l.objects[key] = time.Unix(ts, 0)
+		case "-":
+			delete(l.objects, key)
+		default:
+			return nil, fmt.Errorf("%s:%d: bad operation %q", path, n, fields[1])
+		}
+	}
+	return l, scanner.Err()
+}
+
+func (l *ledger) write(entries []string) error {
+	if l.path == "" || len(entries) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return err
+	}
+	fp, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fp.WriteString(strings.Join(entries, "")); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+func entry(t time.Time, op string, key ledgerKey) string {
+	return fmt.Sprintf("%d %s %s %x\n", t.Unix(), op, key.kind, key.mac)
+}
+
+// sync records the objects of the store the ledger doesn't know about yet,
+// as if they were created now, so that they too are protected for the
+// whole retention period.
+func (l *ledger) sync(ctx context.Context, store storage.Store) error {
+	states, err := store.GetStates(ctx)
+	if err != nil {
+		return err
+	}
+	packfiles, err := store.GetPackfiles(ctx)
+	if err != nil {
+		return err
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := time.Now()
+	var entries []string
+	add := func(kind string, macs []objects.MAC) {
+		for _, mac := range macs {
+			key := ledgerKey{kind: kind, mac: mac}
+			if _, ok := l.objects[key]; !ok {
+				l.objects[key] = now
+				entries = append(entries, entry(now, "+", key))
+			}
+		}
+	}
+	add(kindState, states)
+	add(kindPackfile, packfiles)
+
+	return l.write(entries)
+}
+
+// created returns when the object was first seen, if it is known.
+func (l *ledger) created(kind string, mac objects.MAC) (time.Time, bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	t, ok := l.objects[ledgerKey{kind: kind, mac: mac}]
+	return t, ok
+}
+
+func (l *ledger) add(kind string, mac objects.MAC) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	key := ledgerKey{kind: kind, mac: mac}
+	if _, ok := l.objects[key]; ok {
+		return nil
+	}
+	now := time.Now()
+	l.objects[key] = now
+	return l.write([]string{entry(now, "+", key)})
+}
+
+func (l *ledger) remove(kind string, mac objects.MAC) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	key := ledgerKey{kind: kind, mac: mac}
+	delete(l.objects, key)
+	return l.write([]string{entry(time.Now(), "-", key)})
+}
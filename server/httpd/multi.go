@@ -0,0 +1,237 @@
+package httpd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/network"
+	"github.com/dustin/go-humanize"
+	"go.yaml.in/yaml/v3"
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Config describes the repositories hosted by a server, as read from the
+// file given to plakar server -config.
+type Config struct {
+	Repositories []RepositoryConfig `yaml:"repositories"`
+}
+
+// RepositoryConfig describes a repository served under /r/<name>/.
+type RepositoryConfig struct {
+	Name string `yaml:"name"`
+
+	// Store is the location of the store, or @name for a configured
+	// store.
+	Store string `yaml:"store"`
+
+	AllowDelete bool        `yaml:"allow_delete"`
+	AppendOnly  bool        `yaml:"append_only"`
+	Retention   string      `yaml:"retention"`
+	Quota       QuotaConfig `yaml:"quota"`
+
+	AuthConfig `yaml:",inline"`
+}
+
+type QuotaConfig struct {
+	// Size is a number of bytes, like 500GB or 1TiB.
+	Size    string `yaml:"size"`
+	Objects int64  `yaml:"objects"`
+}
+
+// ParseQuota parses a quota given as a size and a number of objects,
+// either of them being empty or zero when not limited.
+func ParseQuota(size string, objects int64) (network.Quota, error) {
+	var quota network.Quota
+	if size != "" {
+		bytes, err := humanize.ParseBytes(size)
+		if err != nil {
+			return quota, fmt.Errorf("invalid quota size: %w", err)
+		}
+		if bytes == 0 || bytes > math.MaxInt64 {
+			return quota, fmt.Errorf("invalid quota size %q", size)
+		}
+		quota.Bytes = int64(bytes)
+	}
+	if objects < 0 {
+		return quota, fmt.Errorf("invalid quota objects: must be positive")
+	}
+	quota.Objects = objects
+	return quota, nil
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &config, nil
+}
+
+func (config *Config) validate() error {
+	if len(config.Repositories) == 0 {
+		return fmt.Errorf("no repositories")
+	}
+
+	seen := make(map[string]bool)
+	for _, repo := range config.Repositories {
+		if !validName.MatchString(repo.Name) {
+			return fmt.Errorf("invalid repository name %q", repo.Name)
+		}
+		if seen[repo.Name] {
+			return fmt.Errorf("repository %s: defined twice", repo.Name)
+		}
+		seen[repo.Name] = true
+
+		if repo.Store == "" {
+			return fmt.Errorf("repository %s: missing store", repo.Name)
+		}
+		if repo.AppendOnly && repo.AllowDelete {
+			return fmt.Errorf("repository %s: append_only and allow_delete are mutually exclusive", repo.Name)
+		}
+		if _, err := repo.RetentionPeriod(); err != nil {
+			return fmt.Errorf("repository %s: %w", repo.Name, err)
+		}
+		if _, err := ParseQuota(repo.Quota.Size, repo.Quota.Objects); err != nil {
+			return fmt.Errorf("repository %s: %w", repo.Name, err)
+		}
+	}
+	return nil
+}
+
+func (repo *RepositoryConfig) RetentionPeriod() (time.Duration, error) {
+	if repo.Retention == "" {
+		return 0, nil
+	}
+	retention, err := time.ParseDuration(repo.Retention)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention: %w", err)
+	}
+	if retention < 0 {
+		return 0, fmt.Errorf("invalid retention: must be positive")
+	}
+	return retention, nil
+}
+
+// Repository is a store to serve under /r/<Name>/.
+type Repository struct {
+	Name    string
+	Store   storage.Store
+	Options Options
+}
+
+type multiServer struct {
+	names        []string
+	repositories map[string]*server
+}
+
+func newMultiServer(ctx context.Context, repositories []Repository) (*multiServer, error) {
+	m := &multiServer{
+		repositories: make(map[string]*server),
+	}
+	for _, repo := range repositories {
+		s, err := newServer(ctx, repo.Store, &repo.Options)
+		if err != nil {
+			return nil, fmt.Errorf("repository %s: %w", repo.Name, err)
+		}
+		m.names = append(m.names, repo.Name)
+		m.repositories[repo.Name] = s
+	}
+	sort.Strings(m.names)
+	return m, nil
+}
+
+func (m *multiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /{$}", m.index)
+	for name, s := range m.repositories {
+		prefix := "/r/" + name
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, s.handler()))
+	}
+
+	return mux
+}
+
+// index lists the repositories the client can access, or opens the one
+// named in the request.
+func (m *multiServer) index(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var reqOpen network.ReqOpen
+	if len(bytes.TrimSpace(body)) != 0 {
+		if err := json.Unmarshal(body, &reqOpen); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if reqOpen.Repository != "" {
+		s, ok := m.repositories[reqOpen.Repository]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown repository %q", reqOpen.Repository), http.StatusNotFound)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		s.require(PermissionRead, s.openRepository)(w, r)
+		return
+	}
+
+	res := network.ResIndex{
+		Repositories: []network.RepositoryInfo{},
+		Err:          "this server hosts several repositories, a repository name is required",
+	}
+	for _, name := range m.names {
+		s := m.repositories[name]
+
+		p := anonymous
+		if s.auth != nil {
+			if p, err = s.auth.authenticate(r); err != nil {
+				continue
+			}
+		}
+		res.Repositories = append(res.Repositories, network.RepositoryInfo{
+			Name:   name,
+			Policy: s.policy(p),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// MultiServer serves several repositories under /r/<name>/, each with its
+// own options, until ctx is done.
+func MultiServer(ctx context.Context, addr string, config *tls.Config, repositories []Repository) error {
+	m, err := newMultiServer(ctx, repositories)
+	if err != nil {
+		return err
+	}
+	return listen(ctx, addr, config, m.handler())
+}
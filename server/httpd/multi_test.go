@@ -0,0 +1,172 @@
+package httpd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/network"
+	"github.com/PlakarKorp/plakar/network/client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiServer(t *testing.T) {
+	auth, err := NewAuth(&AuthConfig{Tokens: []TokenConfig{{Token: "alice", Permission: "append"}}})
+	require.NoError(t, err)
+
+	alice, bob := newMemStore(), newMemStore()
+	m, err := newMultiServer(context.Background(), []Repository{
+		{Name: "bob", Store: bob, Options: Options{NoDelete: true}},
+		{Name: "alice", Store: alice, Options: Options{NoDelete: true, Auth: auth}},
+	})
+	require.NoError(t, err)
+	h := m.handler()
+
+	index := func(token string) []string {
+		w := request(t, h, "GET", "/", token, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var res network.ResIndex
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		require.NotEmpty(t, res.Err)
+
+		var names []string
+		for _, repo := range res.Repositories {
+			names = append(names, repo.Name)
+		}
+		return names
+	}
+	require.Equal(t, []string{"bob"}, index(""))
+	require.Equal(t, []string{"alice", "bob"}, index("alice"))
+
+	w := request(t, h, "GET", "/", "", network.ReqOpen{Repository: "alice"})
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	w = request(t, h, "GET", "/", "", network.ReqOpen{Repository: "carol"})
+	require.Equal(t, http.StatusNotFound, w.Code)
+	w = request(t, h, "GET", "/", "alice", network.ReqOpen{Repository: "alice"})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	ctx := context.Background()
+	store, err := client.NewStore(ctx, "plakar+http", map[string]string{
+		"location":   "plakar+" + ts.URL,
+		"repository": "alice",
+		"token":      "alice",
+	})
+	require.NoError(t, err)
+	_, err = store.Open(ctx)
+	require.NoError(t, err)
+	_, err = store.PutPackfile(ctx, objects.MAC{7}, strings.NewReader("alice's"))
+	require.NoError(t, err)
+	require.Len(t, alice.packfiles, 1)
+	require.Empty(t, bob.packfiles)
+
+	// the name can also be part of the location
+	store, err = client.NewStore(ctx, "plakar+http", map[string]string{
+		"location": "plakar+" + ts.URL + "/r/bob",
+	})
+	require.NoError(t, err)
+	_, err = store.Open(ctx)
+	require.NoError(t, err)
+	macs, err := store.GetPackfiles(ctx)
+	require.NoError(t, err)
+	require.Empty(t, macs)
+
+	store, err = client.NewStore(ctx, "plakar+http", map[string]string{
+		"location": "plakar+" + ts.URL,
+	})
+	require.NoError(t, err)
+	_, err = store.Open(ctx)
+	require.ErrorContains(t, err, "repository name is required")
+}
+
+func TestLoadConfig(t *testing.T) {
+	load := func(content string) error {
+		path := filepath.Join(t.TempDir(), "server.yml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		_, err := LoadConfig(path)
+		return err
+	}
+
+	require.NoError(t, load(`
+repositories:
+  - name: alice
+    store: "@alice"
+    append_only: true
+    tokens:
+      - token: secret
+        permission: append
+  - name: bob
+    store: fs:/var/backups/bob
+    allow_delete: true
+    retention: 720h
+    quota:
+      size: 2TB
+      objects: 1000
+`))
+
+	// retention without deletions still keeps the objects from being
+	// overwritten
+	require.NoError(t, load(`
+repositories:
+  - name: alice
+    store: "@alice"
+    retention: 720h
+`))
+
+	require.ErrorContains(t, load("repositories: []"), "no repositories")
+	require.ErrorContains(t, load(`
+repositories:
+  - name: ../alice
+    store: "@alice"
+`), "invalid repository name")
+	require.ErrorContains(t, load(`
+repositories:
+  - name: alice
+    store: "@alice"
+  - name: alice
+    store: "@bob"
+`), "defined twice")
+	require.ErrorContains(t, load(`
+repositories:
+  - name: alice
+    store: "@alice"
+    retention: -720h
+`), "invalid retention")
+	require.ErrorContains(t, load(`
+repositories:
+  - name: alice
+    store: "@alice"
+    allow_delete: true
+    append_only: true
+`), "mutually exclusive")
+	require.ErrorContains(t, load(`
+repositories:
+  - name: alice
+    store: "@alice"
+    quota:
+      size: lots
+`), "invalid quota size")
+}
+
+func TestParseQuota(t *testing.T) {
+	quota, err := ParseQuota("1KiB", 10)
+	require.NoError(t, err)
+	require.Equal(t, network.Quota{Bytes: 1024, Objects: 10}, quota)
+
+	quota, err = ParseQuota("", 0)
+	require.NoError(t, err)
+	require.Equal(t, network.Quota{}, quota)
+
+	_, err = ParseQuota("0", 0)
+	require.Error(t, err)
+	_, err = ParseQuota("", -1)
+	require.Error(t, err)
+}
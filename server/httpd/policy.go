@@ -0,0 +1,69 @@
+package httpd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/network"
+)
+
+// policy describes what the server lets the given client do.
+func (s *server) policy(p *principal) network.Policy {
+	return network.Policy{
+		Permission: p.permission.String(),
+		AppendOnly: s.appendOnly,
+		Delete:     !s.noDelete && !s.appendOnly && p.permission >= PermissionFull,
+		Retention:  s.retention,
+	}
+}
+
+// exists reports whether an immutable object is already stored, in which
+// case it must not be overwritten.
+func (s *server) exists(kind string, mac objects.MAC) bool {
+	if s.ledger == nil || kind == kindLock {
+		return false
+	}
+	_, ok := s.ledger.created(kind, mac)
+	return ok
+}
+
+func (s *server) created(kind string, mac objects.MAC) error {
+	if s.ledger == nil || kind == kindLock {
+		return nil
+	}
+	return s.ledger.add(kind, mac)
+}
+
+func (s *server) deleted(kind string, mac objects.MAC) error {
+	if kind == kindLock {
+		return nil
+	}
+	s.usage.remove(kind, mac)
+	if s.ledger == nil {
+		return nil
+	}
+	return s.ledger.remove(kind, mac)
+}
+
+func (s *server) canDelete(kind string, mac objects.MAC) error {
+	if kind == kindLock {
+		return nil
+	}
+	if s.appendOnly {
+		return fmt.Errorf("not allowed to delete: store is append-only")
+	}
+	if s.noDelete {
+		return fmt.Errorf("not allowed to delete")
+	}
+
+	if s.ledger != nil {
+		if created, ok := s.ledger.created(kind, mac); ok {
+			if until := created.Add(s.retention); time.Now().Before(until) {
+				return fmt.Errorf("not allowed to delete: %s %x is retained until %s",
+					kind, mac, until.UTC().Format(time.RFC3339))
+			}
+		}
+	}
+	return nil
+}
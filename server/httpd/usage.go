@@ -0,0 +1,375 @@
+package httpd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/network"
+	"github.com/dustin/go-humanize"
+)
+
+// usageSampleInterval is how often the usage is recorded in the history.
+const usageSampleInterval = time.Hour
+
+// usage accounts for what is stored in a repository to enforce its quota
+// and report its growth. It is loaded from the store when first needed,
+// and kept up to date from the requests going through the server.
+type usage struct {
+	mtx   sync.Mutex
+	store storage.Store
+	quota network.Quota
+
+	// path is the file keeping the history of the usage, as JSON lines
+	path string
+
+	loaded bool
+	bytes  int64
+
+	// sizes of the stored objects, -1 for the ones that predate the
+	// server, and the number of objects being stored
+	objects map[ledgerKey]int64
+	pending int64
+
+	// stale is set when bytes must be computed again from the store
+	// because an object of unknown size was deleted
+	stale bool
+
+	history []network.UsageSample
+}
+
+// reservation is an object being stored. It is only counted in the usage
+// if it was loaded when the reservation was made.
+type reservation struct {
+	key     ledgerKey
+	size    int64
+	existed bool
+	counted bool
+}
+
+func newUsage(store storage.Store, quota network.Quota, path string) *usage {
+	return &usage{
+		store: store,
+		quota: quota,
+		path:  path,
+	}
+}
+
+// warmup loads the usage in the background, so that its history starts
+// with the server.
+func (u *usage) warmup(ctx context.Context) {
+	go func() {
+		u.mtx.Lock()
+		defer u.mtx.Unlock()
+		u.load(ctx)
+	}()
+}
+
+func (u *usage) enforced() bool {
+	return u.quota.Bytes != 0 || u.quota.Objects != 0
+}
+
+// load must be called with u.mtx held.
+func (u *usage) load(ctx context.Context) error {
+	if u.loaded {
+		if u.stale {
+			if size, err := u.store.Size(ctx); err == nil && size >= 0 {
+				u.bytes = size
+				u.stale = false
+			}
+		}
+		return nil
+	}
+
+	states, err := u.store.GetStates(ctx)
+	if err != nil {
+		return err
+	}
+	packfiles, err := u.store.GetPackfiles(ctx)
+	if err != nil {
+		return err
+	}
+	size, err := u.store.Size(ctx)
+	if err != nil {
+		return err
+	}
+
+	u.objects = make(map[ledgerKey]int64)
+	for _, mac := range states {
+		u.objects[ledgerKey{kind: kindState, mac: mac}] = -1
+	}
+	for _, mac := range packfiles {
+		u.objects[ledgerKey{kind: kindPackfile, mac: mac}] = -1
+	}
+	// stores that can't tell their size only account for new objects
+	u.bytes = max(size, 0)
+
+	if err := u.loadHistory(); err != nil {
+		return err
+	}
+	u.loaded = true
+	u.sample()
+	return nil
+}
+
+func (u *usage) loadHistory() error {
+	if u.path == "" {
+		return nil
+	}
+
+	fp, err := os.Open(u.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		var sample network.UsageSample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			return fmt.Errorf("%s: %w", u.path, err)
+		}
+		u.history = append(u.history, sample)
+	}
+	return scanner.Err()
+}
+
+// current must be called with u.mtx held.
+func (u *usage) current() network.UsageSample {
+	sample := network.UsageSample{
+		Time:  time.Now(),
+		Bytes: u.bytes,
+	}
+	for key := range u.objects {
+		if key.kind == kindState {
+			sample.States++
+		} else {
+			sample.Packfiles++
+		}
+	}
+	return sample
+}
+
+// sample records the current usage in the history unless it was recently
+// done. Must be called with u.mtx held.
+func (u *usage) sample() {
+	if n := len(u.history); n != 0 && time.Since(u.history[n-1].Time) < usageSampleInterval {
+		return
+	}
+
+	sample := u.current()
+	u.history = append(u.history, sample)
+
+	if u.path == "" {
+		return
+	}
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(u.path), 0700); err != nil {
+		return
+	}
+	fp, err := os.OpenFile(u.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	fp.Write(append(data, '\n'))
+	fp.Close()
+}
+
+// reserve accounts for an object about to be stored, failing if that
+// would exceed the quota. The size is -1 when unknown, in which case the
+// returned limit is the most that can be stored, or -1 if there is none.
+// The reservation must be released once the object is stored.
+func (u *usage) reserve(ctx context.Context, kind string, mac objects.MAC, size int64) (*reservation, int64, error) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	res := &reservation{key: ledgerKey{kind: kind, mac: mac}, size: max(size, 0)}
+	if !u.enforced() && !u.loaded {
+		return res, -1, nil
+	}
+	if err := u.load(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	_, res.existed = u.objects[res.key]
+	if !res.existed && u.quota.Objects != 0 && int64(len(u.objects))+u.pending >= u.quota.Objects {
+		return nil, 0, fmt.Errorf("%w: the repository is limited to %d objects",
+			network.ErrQuotaExceeded, u.quota.Objects)
+	}
+
+	limit := int64(-1)
+	if u.quota.Bytes != 0 {
+		free := max(u.quota.Bytes-u.bytes, 0)
+		if size > free || (size < 0 && free == 0) {
+			return nil, 0, fmt.Errorf("%w: the repository is limited to %s and %s are used",
+				network.ErrQuotaExceeded, humanize.IBytes(uint64(u.quota.Bytes)), humanize.IBytes(uint64(u.bytes)))
+		}
+		if size < 0 {
+			limit = free
+		}
+	}
+
+	u.bytes += res.size
+	if !res.existed {
+		u.pending++
+	}
+	res.counted = true
+	return res, limit, nil
+}
+
+// release ends a reservation, accounting for the stored bytes if the
+// object was successfully stored.
+func (u *usage) release(res *reservation, stored int64, err error) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	if !res.counted {
+		// the usage may have been loaded meanwhile, from a store that
+		// already had the object or not
+		if err == nil && u.loaded {
+			if _, ok := u.objects[res.key]; !ok {
+				u.objects[res.key] = stored
+				u.bytes += stored
+			}
+			u.sample()
+		}
+		return
+	}
+
+	u.bytes -= res.size
+	if !res.existed {
+		u.pending--
+	}
+	if err != nil {
+		return
+	}
+
+	if old, ok := u.objects[res.key]; ok && old > 0 {
+		u.bytes -= old
+	}
+	u.objects[res.key] = stored
+	u.bytes += stored
+	u.sample()
+}
+
+func (u *usage) remove(kind string, mac objects.MAC) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	if !u.loaded {
+		return
+	}
+
+	key := ledgerKey{kind: kind, mac: mac}
+	if size, ok := u.objects[key]; ok {
+		if size >= 0 {
+			u.bytes -= size
+		} else {
+			u.stale = true
+		}
+		delete(u.objects, key)
+	}
+	u.sample()
+}
+
+func (u *usage) report(ctx context.Context) (network.ResGetUsage, error) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	if err := u.load(ctx); err != nil {
+		return network.ResGetUsage{}, err
+	}
+	return network.ResGetUsage{
+		Current: u.current(),
+		Quota:   u.quota,
+		History: append([]network.UsageSample(nil), u.history...),
+	}, nil
+}
+
+// quotaReader fails the upload of an object of unknown size once it goes
+// beyond what the quota allows.
+type quotaReader struct {
+	rd    io.Reader
+	limit int64
+	n     int64
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.rd.Read(p)
+	q.n += int64(n)
+	if q.n > q.limit {
+		return n, fmt.Errorf("%w: the object doesn't fit in the space left", network.ErrQuotaExceeded)
+	}
+	return n, err
+}
+
+// storeObject stores an object within the quota of the repository. The
+// size is -1 when unknown.
+func (s *server) storeObject(ctx context.Context, kind string, mac objects.MAC, size int64, rd io.Reader) (int64, error) {
+	if kind == kindLock {
+		return s.putObject(ctx, kind, mac, rd)
+	}
+
+	res, limit, err := s.usage.reserve(ctx, kind, mac, size)
+	if err != nil {
+		return 0, err
+	}
+
+	var qr *quotaReader
+	if limit >= 0 {
+		qr = &quotaReader{rd: rd, limit: limit}
+		rd = qr
+	}
+
+	n, err := s.putObject(ctx, kind, mac, rd)
+	if err != nil && qr != nil && qr.n > qr.limit {
+		// the store may not have kept the error we returned
+		err = fmt.Errorf("%w: the object doesn't fit in the space left", network.ErrQuotaExceeded)
+	}
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("short write: stored %d bytes out of %d", n, size)
+	}
+	if err == nil {
+		err = s.created(kind, mac)
+	}
+	s.usage.release(res, n, err)
+	return n, err
+}
+
+// storeError replies with the error of storeObject, telling quota errors
+// apart so that clients can report them.
+func storeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, network.ErrQuotaExceeded) {
+		status = http.StatusInsufficientStorage
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func (s *server) getUsage(w http.ResponseWriter, r *http.Request) {
+	res, err := s.usage.report(r.Context())
+	if err != nil {
+		res.Err = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,195 @@
+package httpd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/network"
+	"github.com/PlakarKorp/plakar/network/client"
+	"github.com/stretchr/testify/require"
+)
+
+func getUsage(t *testing.T, h http.Handler) network.ResGetUsage {
+	w := request(t, h, "GET", "/usage", "", network.ReqGetUsage{})
+	require.Equal(t, http.StatusOK, w.Code)
+	var res network.ResGetUsage
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	require.Empty(t, res.Err)
+	return res
+}
+
+func TestQuota(t *testing.T) {
+	store := newMemStore()
+	s, err := newServer(context.Background(), store, &Options{
+		Quota:     network.Quota{Bytes: 16, Objects: 3},
+		UsageFile: filepath.Join(t.TempDir(), "usage"),
+	})
+	require.NoError(t, err)
+	h := s.handler()
+
+	w := request(t, h, "PUT", "/packfile", "", network.ReqPutPackfile{MAC: objects.MAC{1}, Data: []byte("0123456789")})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = request(t, h, "PUT", "/packfile", "", network.ReqPutPackfile{MAC: objects.MAC{2}, Data: []byte("0123456789")})
+	require.Equal(t, http.StatusInsufficientStorage, w.Code)
+	require.Contains(t, w.Body.String(), "quota exceeded")
+	require.Len(t, store.packfiles, 1)
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	ctx := context.Background()
+	cl, err := client.NewStore(ctx, "plakar+http", map[string]string{"location": "plakar+" + ts.URL})
+	require.NoError(t, err)
+	_, err = cl.Open(ctx)
+	require.NoError(t, err)
+
+	// the size of this one is only known once it's read
+	rd := io.MultiReader(strings.NewReader("0123"), strings.NewReader("456789"))
+	_, err = cl.PutPackfile(ctx, objects.MAC{3}, rd)
+	require.True(t, errors.Is(err, network.ErrQuotaExceeded), err)
+
+	_, err = cl.PutState(ctx, objects.MAC{4}, strings.NewReader("0123"))
+	require.NoError(t, err)
+	_, err = cl.PutState(ctx, objects.MAC{5}, strings.NewReader("0"))
+	require.NoError(t, err)
+	_, err = cl.PutState(ctx, objects.MAC{6}, strings.NewReader("0"))
+	require.ErrorIs(t, err, network.ErrQuotaExceeded)
+	require.ErrorContains(t, err, "limited to 3 objects")
+
+	usage := getUsage(t, h)
+	require.Equal(t, int64(15), usage.Current.Bytes)
+	require.Equal(t, 1, usage.Current.Packfiles)
+	require.Equal(t, 2, usage.Current.States)
+	require.Equal(t, network.Quota{Bytes: 16, Objects: 3}, usage.Quota)
+	require.Len(t, usage.History, 1)
+
+	// deleting makes room again
+	w = request(t, h, "DELETE", "/packfile", "", network.ReqDeletePackfile{MAC: objects.MAC{1}})
+	require.Equal(t, http.StatusOK, w.Code)
+	_, err = cl.PutPackfile(ctx, objects.MAC{3}, strings.NewReader("0123456789"))
+	require.NoError(t, err)
+	require.Equal(t, int64(15), getUsage(t, h).Current.Bytes)
+}
+
+func TestUsageHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage")
+
+	store := newMemStore()
+	store.states[objects.MAC{1}] = []byte("state")
+
+	u := newUsage(store, network.Quota{}, path)
+	res, err := u.report(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, res.Current.States)
+	require.Len(t, res.History, 1)
+
+	// a new server picks up the history
+	u = newUsage(store, network.Quota{}, path)
+	res, err = u.report(context.Background())
+	require.NoError(t, err)
+	require.Len(t, res.History, 1)
+	require.Equal(t, 1, res.History[0].States)
+}
+
+func TestUsageLoadedDuringUpload(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	u := newUsage(store, network.Quota{}, "")
+
+	load := func() {
+		u.mtx.Lock()
+		defer u.mtx.Unlock()
+		require.NoError(t, u.load(ctx))
+	}
+
+	// reserved before the usage is loaded, stored after
+	res, _, err := u.reserve(ctx, kindPackfile, objects.MAC{1}, 10)
+	require.NoError(t, err)
+	load()
+	_, err = store.PutPackfile(ctx, objects.MAC{1}, strings.NewReader("0123456789"))
+	require.NoError(t, err)
+	u.release(res, 10, nil)
+	require.Equal(t, int64(10), u.bytes)
+	require.Zero(t, u.pending)
+
+	// stored before the usage is loaded, and thus already accounted for
+	u = newUsage(store, network.Quota{}, "")
+	res, _, err = u.reserve(ctx, kindPackfile, objects.MAC{2}, 4)
+	require.NoError(t, err)
+	_, err = store.PutPackfile(ctx, objects.MAC{2}, strings.NewReader("0123"))
+	require.NoError(t, err)
+	load()
+	u.release(res, 4, nil)
+	require.Zero(t, u.bytes)
+	require.Zero(t, u.pending)
+	require.Equal(t, int64(-1), u.objects[ledgerKey{kind: kindPackfile, mac: objects.MAC{2}}])
+
+	res, _, err = u.reserve(ctx, kindPackfile, objects.MAC{3}, 4)
+	require.NoError(t, err)
+	u.release(res, 0, errors.New("failed"))
+	require.Zero(t, u.bytes)
+	require.Zero(t, u.pending)
+}
+
+// slowStore holds the uploads long enough for the usage to be loaded
+// meanwhile.
+type slowStore struct {
+	*memStore
+}
+
+func (s slowStore) PutPackfile(ctx context.Context, mac objects.MAC, rd io.Reader) (int64, error) {
+	time.Sleep(time.Millisecond)
+	return s.memStore.PutPackfile(ctx, mac, rd)
+}
+
+func TestUsageWarmupDuringUploads(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	for i := range 50 {
+		store.packfiles[objects.MAC{0, byte(i)}] = []byte("old")
+	}
+
+	s := &server{store: slowStore{store}, ctx: ctx}
+	s.usage = newUsage(s.store, network.Quota{}, "")
+
+	var wg sync.WaitGroup
+	for i := range 100 {
+		if i == 50 {
+			s.usage.warmup(ctx)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data := strings.Repeat("x", i+1)
+			_, err := s.storeObject(ctx, kindPackfile, objects.MAC{1, byte(i)}, int64(len(data)), strings.NewReader(data))
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	res, err := s.usage.report(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 150, res.Current.Packfiles)
+
+	// the store can't tell its size, so only the new objects count
+	s.usage.mtx.Lock()
+	defer s.usage.mtx.Unlock()
+	require.Zero(t, s.usage.pending)
+	var known int64
+	for _, size := range s.usage.objects {
+		known += max(size, 0)
+	}
+	require.Equal(t, known, s.usage.bytes)
+	require.LessOrEqual(t, known, int64(100*101/2))
+}
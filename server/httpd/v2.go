@@ -0,0 +1,209 @@
+package httpd
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/network"
+)
+
+// Version 2 of the protocol streams the objects as raw bodies instead of
+// embedding them in JSON, with the MAC in the network.MACHeader header.
+// Packfile blobs are fetched with a range request.
+
+func requestMAC(r *http.Request) (objects.MAC, error) {
+	var mac objects.MAC
+
+	header := r.Header.Get(network.MACHeader)
+	if header == "" {
+		return mac, fmt.Errorf("missing %s header", network.MACHeader)
+	}
+	raw, err := hex.DecodeString(header)
+	if err != nil || len(raw) != len(mac) {
+		return mac, fmt.Errorf("invalid %s header", network.MACHeader)
+	}
+	copy(mac[:], raw)
+	return mac, nil
+}
+
+// parseRange parses a single "bytes=start-end" range, the only form the
+// clients send.
+func parseRange(header string) (offset uint64, length uint32, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("unsupported range %q", header)
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %q", header)
+	}
+	start, err := strconv.ParseUint(first, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range %q", header)
+	}
+	end, err := strconv.ParseUint(last, 10, 64)
+	if err != nil || end < start || end-start >= 1<<32 {
+		return 0, 0, fmt.Errorf("invalid range %q", header)
+	}
+	return start, uint32(end - start + 1), nil
+}
+
+func (s *server) listObjects(ctx context.Context, kind string) ([]objects.MAC, error) {
+	switch kind {
+	case kindState:
+		return s.store.GetStates(ctx)
+	case kindPackfile:
+		return s.store.GetPackfiles(ctx)
+	default:
+		return s.store.GetLocks(ctx)
+	}
+}
+
+func (s *server) getObject(ctx context.Context, kind string, mac objects.MAC) (io.ReadCloser, error) {
+	switch kind {
+	case kindState:
+		return s.store.GetState(ctx, mac)
+	case kindPackfile:
+		return s.store.GetPackfile(ctx, mac)
+	default:
+		return s.store.GetLock(ctx, mac)
+	}
+}
+
+func (s *server) putObject(ctx context.Context, kind string, mac objects.MAC, rd io.Reader) (int64, error) {
+	switch kind {
+	case kindState:
+		return s.store.PutState(ctx, mac, rd)
+	case kindPackfile:
+		return s.store.PutPackfile(ctx, mac, rd)
+	default:
+		return s.store.PutLock(ctx, mac, rd)
+	}
+}
+
+func (s *server) deleteObject(ctx context.Context, kind string, mac objects.MAC) error {
+	switch kind {
+	case kindState:
+		return s.store.DeleteState(ctx, mac)
+	case kindPackfile:
+		return s.store.DeletePackfile(ctx, mac)
+	default:
+		return s.store.DeleteLock(ctx, mac)
+	}
+}
+
+func (s *server) list(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		macs, err := s.listObjects(r.Context(), kind)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if macs == nil {
+			macs = []objects.MAC{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(macs); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (s *server) get(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mac, err := requestMAC(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		status := http.StatusOK
+		var rd io.ReadCloser
+		if header := r.Header.Get("Range"); header != "" && kind == kindPackfile {
+			offset, length, err := parseRange(header)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			rd, err = s.store.GetPackfileBlob(r.Context(), mac, offset, length)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", offset, offset+uint64(length)-1))
+			w.Header().Set("Content-Length", strconv.FormatUint(uint64(length), 10))
+		} else {
+			rd, err = s.getObject(r.Context(), kind, mac)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		defer rd.Close()
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set(network.MACHeader, hex.EncodeToString(mac[:]))
+		w.WriteHeader(status)
+
+		// the status is out, a failure can only cut the body short and
+		// the client will notice the missing bytes
+		io.Copy(w, rd)
+	}
+}
+
+func (s *server) put(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mac, err := requestMAC(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if s.exists(kind, mac) {
+			// never overwritten, drain the body so the connection is reused
+			io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if _, err := s.storeObject(r.Context(), kind, mac, r.ContentLength, r.Body); err != nil {
+			storeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *server) delete(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mac, err := requestMAC(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := s.canDelete(kind, mac); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		err = s.deleteObject(r.Context(), kind, mac)
+		if err == nil {
+			err = s.deleted(kind, mac)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -37,6 +37,7 @@ import (
 	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/plakar/agent"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/metrics"
 	"github.com/PlakarKorp/plakar/subcommands"
 	psync "github.com/PlakarKorp/plakar/subcommands/sync"
 	"github.com/PlakarKorp/plakar/task"
@@ -45,13 +46,21 @@ import (
 	"github.com/vmihailenco/msgpack/v5"
 )
 
+var (
+	agentInflight = metrics.Default.NewGauge("plakar_agent_inflight_requests",
+		"Requests being served by the agent.")
+	agentRequests = metrics.Default.NewCounter("plakar_agent_requests_total",
+		"Requests served by the agent.")
+)
+
 type AgentStart struct {
 	subcommands.SubcommandBase
 
 	socketPath string
 	listener   net.Listener
 
-	teardown time.Duration
+	teardown    time.Duration
+	metricsAddr string
 }
 
 func (cmd *AgentStart) Parse(ctx *appcontext.AppContext, args []string) error {
@@ -73,6 +82,7 @@ func (cmd *AgentStart) Parse(ctx *appcontext.AppContext, args []string) error {
 	}
 
 	flags.DurationVar(&cmd.teardown, "teardown", 5*time.Second, "delay before tearing down the agent")
+	flags.StringVar(&cmd.metricsAddr, "metrics", "", "address to expose Prometheus metrics on")
 	flags.Parse(args)
 	if flags.NArg() != 0 {
 		return fmt.Errorf("too many arguments")
@@ -165,6 +175,13 @@ func (cmd *AgentStart) ListenAndServe(ctx *appcontext.AppContext) error {
 		listener.Close()
 	}()
 
+	if cmd.metricsAddr != "" {
+		if err := metrics.Serve(ctx, cmd.metricsAddr); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+
 	var inflight atomic.Int64
 	var nextID atomic.Int64
 	for {
@@ -183,12 +200,16 @@ func (cmd *AgentStart) ListenAndServe(ctx *appcontext.AppContext) error {
 		}
 
 		inflight.Add(1)
+		agentInflight.Add(1)
+		agentRequests.Inc()
 
 		go func() {
 			myid := nextID.Add(1)
 			defer func() {
+				agentInflight.Add(-1)
 				n := inflight.Add(-1)
-				if n == 0 {
+				// an agent exposing metrics stays up to be scraped
+				if n == 0 && cmd.metricsAddr == "" {
 					time.Sleep(cmd.teardown)
 					if nextID.Load() == myid && inflight.Load() == 0 {
 						listener.Close()
@@ -211,6 +232,7 @@ func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 	mu := sync.Mutex{}
 
 	var encodingErrorOccurred bool
+	var detached atomic.Bool
 	encoder := msgpack.NewEncoder(conn)
 	decoder := msgpack.NewDecoder(conn)
 
@@ -263,6 +285,15 @@ func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 		})
 	}
 
+	// exit reports a command that couldn't be started.
+	exit := func(status int, err error) {
+		write(agent.Packet{
+			Type:     "exit",
+			ExitCode: status,
+			Err:      err.Error(),
+		})
+	}
+
 	clientContext.Stdin = &CustomReader{stdinchan, encoder, &mu, ctx, nil}
 	clientContext.Stdout = &CustomWriter{processFunc: processStdout}
 	clientContext.Stderr = &CustomWriter{processFunc: processStderr}
@@ -278,7 +309,7 @@ func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 			return
 		}
 		ctx.GetLogger().Warn("Failed to decode RPC: %v", err)
-		fmt.Fprintf(clientContext.Stderr, "%s\n", err)
+		exit(agent.ExitUsage, err)
 		return
 	}
 
@@ -287,6 +318,9 @@ func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 		for {
 			var pkt agent.Packet
 			if err := decoder.Decode(&pkt); err != nil {
+				if detached.Load() {
+					return
+				}
 				if !isDisconnectError(err) {
 					processStderr(fmt.Sprintf("failed to decode: %s", err))
 				}
@@ -302,12 +336,12 @@ func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 	subcommand, _, _ := subcommands.Lookup(name)
 	if subcommand == nil {
 		ctx.GetLogger().Warn("unknown command received: %s", name)
-		fmt.Fprintf(clientContext.Stderr, "unknown command received %s\n", name)
+		exit(agent.ExitUsage, fmt.Errorf("unknown command received %s", name))
 		return
 	}
 	if err := msgpack.Unmarshal(request, &subcommand); err != nil {
 		ctx.GetLogger().Warn("Failed to decode client request: %v", err)
-		fmt.Fprintf(clientContext.Stderr, "Failed to decode client request: %s\n", err)
+		exit(agent.ExitUsage, fmt.Errorf("Failed to decode client request: %w", err))
 		return
 	}
 
@@ -329,7 +363,7 @@ func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 		repo, err = repository.Inexistent(clientContext.GetInner(), storeConfig)
 		if err != nil {
 			clientContext.GetLogger().Warn("Failed to open raw storage: %v", err)
-			fmt.Fprintf(clientContext.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
+			exit(agent.ExitUnavailable, err)
 			return
 		}
 		defer repo.Close()
@@ -338,35 +372,47 @@ func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 		store, serializedConfig, err = storage.Open(clientContext.GetInner(), storeConfig)
 		if err != nil {
 			clientContext.GetLogger().Warn("Failed to open storage: %v", err)
-			fmt.Fprintf(clientContext.Stderr, "Failed to open storage: %s\n", err)
+			exit(agent.ExitUnavailable, fmt.Errorf("Failed to open storage: %w", err))
 			return
 		}
 		defer store.Close(ctx)
 		err := setupSecret(clientContext, subcommand, storeConfig, serializedConfig)
 		if err != nil {
 			clientContext.GetLogger().Warn("Failed to setup secret: %v", err)
-			fmt.Fprintf(clientContext.Stderr, "Failed to stup secret: %s\n", err)
+			exit(agent.ExitConfig, fmt.Errorf("Failed to setup secret: %w", err))
 			return
 		}
 
 		repo, err = repository.New(clientContext.GetInner(), clientContext.GetSecret(), store, serializedConfig)
 		if err != nil {
 			clientContext.GetLogger().Warn("Failed to open repository: %v", err)
-			fmt.Fprintf(clientContext.Stderr, "Failed to open repository: %s\n", err)
+			exit(agent.ExitUnavailable, fmt.Errorf("Failed to open repository: %w", err))
 			return
 		}
 		defer repo.Close()
 	}
 
 	if synccmd, ok := subcommand.(*psync.Sync); ok {
-		if err := setupPeerSecret(clientContext, synccmd); err != nil {
+		if err := synccmd.SetupPeerSecret(clientContext); err != nil {
 			clientContext.GetLogger().Warn("Failed to setup peer secret: %v", err)
-			fmt.Fprintf(clientContext.Stderr, "Failed to setup peer secret: %s\n", err)
+			exit(agent.ExitConfig, fmt.Errorf("Failed to setup peer secret: %w", err))
 			return
 		}
 	}
 
+	clientContext.SetDetach(func() {
+		if detached.CompareAndSwap(false, true) {
+			write(agent.Packet{Type: "detach"})
+		}
+	})
+
 	status, err := task.RunCommand(clientContext, subcommand, repo, "@agent")
+	if detached.Load() {
+		if err != nil {
+			ctx.GetLogger().Warn("%s: %v", strings.Join(name, " "), err)
+		}
+		return
+	}
 
 	errStr := ""
 	if err != nil {
@@ -427,63 +473,6 @@ func setupSecret(ctx *appcontext.AppContext, cmd subcommands.Subcommand, storeCo
 	return nil
 }
 
-func setupPeerSecret(ctx *appcontext.AppContext, cmd *psync.Sync) error {
-	storeConfig, err := ctx.Config.GetRepository(cmd.PeerRepositoryLocation)
-	if err != nil {
-		return fmt.Errorf("peer repository: %w", err)
-	}
-
-	peerStore, peerStoreSerializedConfig, err := storage.Open(ctx.GetInner(), storeConfig)
-	if err != nil {
-		return fmt.Errorf("failed to open peer storage: %w", err)
-	}
-	peerStore.Close(ctx)
-
-	peerStoreConfig, err := storage.NewConfigurationFromWrappedBytes(peerStoreSerializedConfig)
-	if err != nil {
-		return fmt.Errorf("failed to parse peer configuration: %w", err)
-	}
-
-	if peerStoreConfig.Encryption == nil {
-		return nil
-	}
-
-	getKey := func() ([]byte, error) {
-		if key := cmd.PeerRepositorySecret; key != nil {
-			return key, nil
-		}
-
-		passphrase, ok := storeConfig["passphrase"]
-		if !ok {
-			cmd, ok := storeConfig["passphrase_cmd"]
-			if !ok {
-				return nil, fmt.Errorf("no passphrase specified")
-			}
-			passphrase, err = utils.GetPassphraseFromCommand(cmd)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read passphrase from command: %w", err)
-			}
-		}
-
-		key, err := encryption.DeriveKey(peerStoreConfig.Encryption.KDFParams, []byte(passphrase))
-		if err != nil {
-			return nil, fmt.Errorf("failed to derive key: %w", err)
-		}
-		return key, nil
-	}
-
-	key, err := getKey()
-	if err != nil {
-		return err
-	}
-	if !encryption.VerifyCanary(peerStoreConfig.Encryption, key) {
-		return fmt.Errorf("failed to verify key")
-	}
-
-	cmd.PeerRepositorySecret = key
-	return nil
-}
-
 type CustomWriter struct {
 	processFunc func(string) // Function to handle the log lines
 }
@@ -25,12 +25,13 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/google/uuid"
 )
 
 func init() {
-	subcommands.Register(func() subcommands.Subcommand { return &Check{} }, subcommands.AgentSupport, "check")
+	subcommands.Register(func() subcommands.Subcommand { return &Check{} }, subcommands.AgentSupport|subcommands.FormatSupport, "check")
 }
 
 func (cmd *Check) Parse(ctx *appcontext.AppContext, args []string) error {
@@ -74,9 +75,17 @@ type Check struct {
 	Silent        bool
 }
 
-func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (status int, err error) {
+	enc := output.NewEncoder(ctx.Stdout, cmd.Format)
+	defer func() {
+		if cerr := enc.Close(); cerr != nil && err == nil {
+			status, err = 1, cerr
+		}
+	}()
+
+	// the files checked successfully aren't reported in the records.
 	if !cmd.Silent {
-		go eventsProcessorStdio(ctx, cmd.Quiet)
+		go eventsProcessorStdio(ctx, cmd.Quiet || enc.Structured())
 	}
 
 	var snapshots []string
@@ -130,24 +139,45 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 
 		snap.SetCheckCache(checkCache)
 
+		indexID := snap.Header.GetIndexID()
+		result := &output.CheckResult{
+			Snapshot: hex.EncodeToString(indexID[:]),
+			ShortID:  hex.EncodeToString(snap.Header.GetIndexShortID()),
+			Path:     pathname,
+			Status:   output.CheckOK,
+		}
+
 		var failed bool
 		if !cmd.NoVerify && snap.Header.Identity.Identifier != uuid.Nil {
 			if ok, err := snap.Verify(); err != nil {
 				ctx.GetLogger().Warn("%s", err)
 			} else if !ok {
 				ctx.GetLogger().Info("snapshot %x signature verification failed", snap.Header.Identifier)
+				result.Signature = output.SignatureFailed
 				failed = true
 			} else {
 				ctx.GetLogger().Info("snapshot %x signature verification succeeded", snap.Header.Identifier)
+				result.Signature = output.SignatureVerified
 			}
 		}
 
 		if err := snap.Check(pathname, opts); err != nil {
 			ctx.GetLogger().Warn("check failed for snapshot %x: %s",
 				snap.Header.GetIndexID(), err)
+			result.Error = err.Error()
 			failed = true
 		}
 
+		if failed {
+			result.Status = output.CheckFailed
+		}
+		if enc.Structured() {
+			if err := enc.Encode(result); err != nil {
+				snap.Close()
+				return 1, err
+			}
+		}
+
 		if failed {
 			failures++
 		} else {
@@ -3,6 +3,7 @@ package check
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -12,6 +13,7 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
 	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/stretchr/testify/require"
 )
@@ -107,3 +109,32 @@ func TestExecuteCmdCheckSpecificSnapshot(t *testing.T) {
 	lastline := lines[len(lines)-1]
 	require.Contains(t, lastline, fmt.Sprintf("info: check: verification of %s:%s completed successfully", hex.EncodeToString(snap.Header.GetIndexShortID()[:]), snap.Header.GetSource(0).Importer.Directory))
 }
+
+func TestExecuteCmdCheckJSON(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	// main disables the informational messages in structured output
+	ctx.GetLogger().EnabledInfo = false
+
+	subcommand := &Check{}
+	err := subcommand.Parse(ctx, []string{})
+	require.NoError(t, err)
+	subcommand.SetFormat(output.FormatJSON)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	var results []output.CheckResult
+	require.NoError(t, json.Unmarshal(bufOut.Bytes(), &results))
+	require.Equal(t, 1, len(results))
+
+	indexID := snap.Header.GetIndexID()
+	require.Equal(t, hex.EncodeToString(indexID[:]), results[0].Snapshot)
+	require.Equal(t, output.CheckOK, results[0].Status)
+	require.Empty(t, results[0].Error)
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/alecthomas/chroma/quick"
@@ -38,7 +39,7 @@ import (
 )
 
 func init() {
-	subcommands.Register(func() subcommands.Subcommand { return &Diff{} }, subcommands.AgentSupport, "diff")
+	subcommands.Register(func() subcommands.Subcommand { return &Diff{} }, subcommands.AgentSupport|subcommands.FormatSupport, "diff")
 }
 
 func (cmd *Diff) Parse(ctx *appcontext.AppContext, args []string) error {
@@ -112,8 +113,6 @@ func (cmd *Diff) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 		id2 = fmt.Sprintf("%x", snap2.Header.GetIndexShortID())
 	}
 
-	var diff string
-
 	if pathname1 == "" && pathname2 == "" {
 		pathname1 = "/"
 		pathname2 = "/"
@@ -123,26 +122,56 @@ func (cmd *Diff) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 		pathname2 = pathname1
 	}
 
-	diff, err = cmd.diff_pathnames(ctx, id1, vfs1, pathname1, id2, vfs2, pathname2)
+	out := &diffOutput{}
+	err = cmd.diff_pathnames(ctx, out, id1, vfs1, pathname1, id2, vfs2, pathname2)
 	if err != nil {
 		return 1, fmt.Errorf("diff: could not diff pathnames: %w", err)
 	}
 
-	if cmd.Highlight {
-		err = quick.Highlight(ctx.Stdout, diff, "diff", "terminal", "dracula")
+	enc := output.NewEncoder(ctx.Stdout, cmd.Format)
+	if enc.Structured() {
+		for _, rec := range out.records {
+			rec.From = id1
+			rec.To = id2
+			if err := enc.Encode(rec); err != nil {
+				return 1, err
+			}
+		}
+		if err := enc.Close(); err != nil {
+			return 1, err
+		}
+	} else if cmd.Highlight {
+		err = quick.Highlight(ctx.Stdout, out.text.String(), "diff", "terminal", "dracula")
 		if err != nil {
 			return 1, fmt.Errorf("diff: could not highlight diff: %w", err)
 		}
 	} else {
-		fmt.Fprintf(ctx.Stdout, "%s", diff)
+		fmt.Fprintf(ctx.Stdout, "%s", out.text.String())
 	}
 	return 0, nil
 }
 
-func (cmd *Diff) diff_pathnames(ctx *appcontext.AppContext, id1 string, vfs1 fs.FS, pathname1 string, id2 string, vfs2 fs.FS, pathname2 string) (string, error) {
+// diffOutput collects the differences, both as the text printed by
+// default and as records for the structured output formats.
+type diffOutput struct {
+	text    strings.Builder
+	records []*output.DiffEntry
+}
+
+func (out *diffOutput) printf(format string, args ...any) {
+	fmt.Fprintf(&out.text, format, args...)
+}
+
+func (out *diffOutput) record(pathname string, change string) *output.DiffEntry {
+	rec := &output.DiffEntry{Path: pathname, Change: change}
+	out.records = append(out.records, rec)
+	return rec
+}
+
+func (cmd *Diff) diff_pathnames(ctx *appcontext.AppContext, out *diffOutput, id1 string, vfs1 fs.FS, pathname1 string, id2 string, vfs2 fs.FS, pathname2 string) error {
 	fsobj1, err := vfs1.Open(pathname1)
 	if err != nil {
-		return "", fmt.Errorf("could not open path %s in snapshot %s: %w", pathname1, id1, err)
+		return fmt.Errorf("could not open path %s in snapshot %s: %w", pathname1, id1, err)
 	}
 	defer fsobj1.Close()
 
@@ -153,32 +182,32 @@ func (cmd *Diff) diff_pathnames(ctx *appcontext.AppContext, id1 string, vfs1 fs.
 
 	fsobj2, err := vfs2.Open(pathname2)
 	if err != nil {
-		return "", fmt.Errorf("could not open path %s in snapshot %s: %w", pathname2, id2, err)
+		return fmt.Errorf("could not open path %s in snapshot %s: %w", pathname2, id2, err)
 	}
 	defer fsobj2.Close()
 
 	st1, err := fsobj1.Stat()
 	if err != nil {
-		return "", fmt.Errorf("could not stat path %s: %w", id1, err)
+		return fmt.Errorf("could not stat path %s: %w", id1, err)
 	}
 	st2, err := fsobj2.Stat()
 	if err != nil {
-		return "", fmt.Errorf("could not stat path %ss: %w", id2, err)
+		return fmt.Errorf("could not stat path %ss: %w", id2, err)
 	}
 
 	if st1.IsDir() && st2.IsDir() {
 		if cmd.Recursive {
-			return cmd.diff_directories_recursive(ctx, vfs1, pathname1, vfs2, pathname1)
+			return cmd.diff_directories_recursive(ctx, out, vfs1, pathname1, vfs2, pathname1)
 		}
-		return cmd.diff_directories_flat(ctx, pathname1, fsobj1, pathname2, fsobj2)
+		return cmd.diff_directories_flat(ctx, out, pathname1, fsobj1, pathname2, fsobj2)
 	} else if st1.IsDir() || st2.IsDir() {
-		return "", fmt.Errorf("can't diff different file types")
+		return fmt.Errorf("can't diff different file types")
 	} else {
-		return cmd.diff_readers(id1, pathname1, fsobj1, id2, pathname2, fsobj2)
+		return cmd.diff_readers(out, id1, pathname1, fsobj1, id2, pathname2, fsobj2)
 	}
 }
 
-func (cmd *Diff) diff_directories_flat(_ *appcontext.AppContext, pathname1 string, fsobj1 fs.File, pathname2 string, fsobj2 fs.File) (string, error) {
+func (cmd *Diff) diff_directories_flat(_ *appcontext.AppContext, out *diffOutput, pathname1 string, fsobj1 fs.File, pathname2 string, fsobj2 fs.File) error {
 	// non VFS have their / stripped, reintroduce it
 	if !strings.HasPrefix(pathname2, "/") {
 		pathname2 = "/" + pathname2 // Ensure pathname starts with a slash
@@ -187,16 +216,16 @@ func (cmd *Diff) diff_directories_flat(_ *appcontext.AppContext, pathname1 strin
 	dir1, ok1 := fsobj1.(fs.ReadDirFile)
 	dir2, ok2 := fsobj2.(fs.ReadDirFile)
 	if !ok1 || !ok2 {
-		return "", fmt.Errorf("both fs.File must implement fs.ReadDirFile")
+		return fmt.Errorf("both fs.File must implement fs.ReadDirFile")
 	}
 
 	entries1, err1 := dir1.ReadDir(-1)
 	entries2, err2 := dir2.ReadDir(-1)
 	if err1 != nil {
-		return "", fmt.Errorf("error reading directory 1: %w", err1)
+		return fmt.Errorf("error reading directory 1: %w", err1)
 	}
 	if err2 != nil {
-		return "", fmt.Errorf("error reading directory 2: %w", err2)
+		return fmt.Errorf("error reading directory 2: %w", err2)
 	}
 
 	map1 := map[string]fs.DirEntry{}
@@ -208,38 +237,43 @@ func (cmd *Diff) diff_directories_flat(_ *appcontext.AppContext, pathname1 strin
 		map2[e.Name()] = e
 	}
 
-	var output strings.Builder
 	visited := map[string]bool{}
 
+	// the entries are visited in no particular order
+	records := len(out.records)
 	for name, e1 := range map1 {
 		visited[name] = true
 		if e2, ok := map2[name]; ok {
 			if e1.IsDir() && e2.IsDir() {
-				output.WriteString(fmt.Sprintf("Common subdirectories: %s and %s\n", name, name))
+				out.printf("Common subdirectories: %s and %s\n", name, name)
 			} else if e1.IsDir() != e2.IsDir() {
-				output.WriteString(fmt.Sprintf("File type mismatch: %s (dir=%v) vs %s (dir=%v)\n", name, e1.IsDir(), name, e2.IsDir()))
+				out.printf("File type mismatch: %s (dir=%v) vs %s (dir=%v)\n", name, e1.IsDir(), name, e2.IsDir())
+				out.record(path.Join(pathname1, name), output.DiffType)
 			}
 		} else {
-			output.WriteString(fmt.Sprintf("Only in %s: %s\n", pathname1, name))
+			out.printf("Only in %s: %s\n", pathname1, name)
+			out.record(path.Join(pathname1, name), output.DiffRemoved)
 		}
 	}
 	for name := range map2 {
 		if !visited[name] {
-			output.WriteString(fmt.Sprintf("Only in %s: %s\n", pathname2, name))
+			out.printf("Only in %s: %s\n", pathname2, name)
+			out.record(path.Join(pathname2, name), output.DiffAdded)
 		}
 	}
+	sort.Slice(out.records[records:], func(i, j int) bool {
+		return out.records[records+i].Path < out.records[records+j].Path
+	})
 
-	return output.String(), nil
+	return nil
 }
 
-func (cmd *Diff) diff_directories_recursive(ctx *appcontext.AppContext, fs1 fs.FS, path1 string, fs2 fs.FS, path2 string) (string, error) {
-	var output strings.Builder
-
+func (cmd *Diff) diff_directories_recursive(ctx *appcontext.AppContext, out *diffOutput, fs1 fs.FS, path1 string, fs2 fs.FS, path2 string) error {
 	entries1, err1 := fs.ReadDir(fs1, path1)
 	entries2, err2 := fs.ReadDir(fs2, path2)
 
 	if err1 != nil && err2 != nil {
-		return "", fmt.Errorf("cannot read both directories: %w / %w", err1, err2)
+		return fmt.Errorf("cannot read both directories: %w / %w", err1, err2)
 	}
 
 	map1 := make(map[string]fs.DirEntry)
@@ -280,38 +314,39 @@ func (cmd *Diff) diff_directories_recursive(ctx *appcontext.AppContext, fs1 fs.F
 
 		switch {
 		case ok1 && !ok2:
-			output.WriteString(fmt.Sprintf("Only in %s: %s\n", path1, name))
+			out.printf("Only in %s: %s\n", path1, name)
+			out.record(full1, output.DiffRemoved)
 
 		case !ok1 && ok2:
-			output.WriteString(fmt.Sprintf("Only in %s: %s\n", path2, name))
+			out.printf("Only in %s: %s\n", path2, name)
+			out.record(path.Join("/", full2), output.DiffAdded)
 
 		case ok1 && ok2:
 			if e1.IsDir() && e2.IsDir() {
-				output.WriteString(fmt.Sprintf("Common subdirectories: %s and %s\n", full1, full2))
-				sub, err := cmd.diff_directories_recursive(ctx, fs1, full1, fs2, full2)
-				if err != nil {
-					return "", err
+				out.printf("Common subdirectories: %s and %s\n", full1, full2)
+				if err := cmd.diff_directories_recursive(ctx, out, fs1, full1, fs2, full2); err != nil {
+					return err
 				}
-				output.WriteString(sub)
 
 			} else if e1.IsDir() != e2.IsDir() {
-				output.WriteString(fmt.Sprintf("File type mismatch: %s vs %s\n", full1, full2))
+				out.printf("File type mismatch: %s vs %s\n", full1, full2)
+				out.record(full1, output.DiffType)
 			}
 		}
 	}
 
-	return output.String(), nil
+	return nil
 
 }
 
-func (cmd *Diff) diff_readers(id1 string, pathname1 string, rd1 io.Reader, id2 string, pathname2 string, rd2 io.Reader) (string, error) {
+func (cmd *Diff) diff_readers(out *diffOutput, id1 string, pathname1 string, rd1 io.Reader, id2 string, pathname2 string, rd2 io.Reader) error {
 	buf1, err := io.ReadAll(rd1)
 	if err != nil {
-		return "", err
+		return err
 	}
 	buf2, err := io.ReadAll(rd2)
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	// non VFS have their / stripped, reintroduce it
@@ -332,7 +367,11 @@ func (cmd *Diff) diff_readers(id1 string, pathname1 string, rd1 io.Reader, id2 s
 	}
 	text, err := difflib.GetUnifiedDiffString(diff)
 	if err != nil {
-		return "", err
+		return err
+	}
+	if text != "" {
+		out.printf("%s", text)
+		out.record(pathname1, output.DiffModified).Diff = text
 	}
-	return text, nil
+	return nil
 }
@@ -3,11 +3,15 @@ package diff
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path"
+	"strings"
 	"testing"
 
 	_ "github.com/PlakarKorp/integration-fs/exporter"
+	"github.com/PlakarKorp/plakar/output"
 	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/stretchr/testify/require"
 )
@@ -112,3 +116,98 @@ func TestExecuteCmdDiffFiles(t *testing.T) {
 -hello dummy
 +hello dummy!!`)
 }
+
+func TestExecuteCmdDiffNDJSON(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("subdir/foo.txt", 0644, "hello foo"),
+	})
+	defer snap.Close()
+
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("subdir/bar.txt", 0644, "hello bar"),
+	})
+	defer snap2.Close()
+
+	indexId1 := snap.Header.GetIndexShortID()
+	indexId2 := snap2.Header.GetIndexShortID()
+	snapPath1 := fmt.Sprintf("%s:/subdir", hex.EncodeToString(indexId1[:]))
+	snapPath2 := fmt.Sprintf("%s:/subdir", hex.EncodeToString(indexId2[:]))
+	args := []string{"-recursive", snapPath1, snapPath2}
+
+	subcommand := &Diff{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+	subcommand.SetFormat(output.FormatNDJSON)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	changes := map[string]string{}
+	for _, line := range strings.Split(strings.Trim(bufOut.String(), "\n"), "\n") {
+		var rec output.DiffEntry
+		require.NoError(t, json.Unmarshal([]byte(line), &rec))
+		require.Equal(t, hex.EncodeToString(indexId1), rec.From)
+		require.Equal(t, hex.EncodeToString(indexId2), rec.To)
+		changes[path.Base(rec.Path)] = rec.Change
+	}
+	require.Equal(t, map[string]string{
+		"foo.txt": output.DiffRemoved,
+		"bar.txt": output.DiffAdded,
+	}, changes)
+}
+
+func TestExecuteCmdDiffFlatNDJSON(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/a.txt", 0644, "hello a"),
+		ptesting.NewMockFile("subdir/c.txt", 0644, "hello c"),
+		ptesting.NewMockFile("subdir/e.txt", 0644, "hello e"),
+	})
+	defer snap.Close()
+
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/b.txt", 0644, "hello b"),
+		ptesting.NewMockFile("subdir/d.txt", 0644, "hello d"),
+		ptesting.NewMockFile("subdir/f.txt", 0644, "hello f"),
+	})
+	defer snap2.Close()
+
+	indexId1 := snap.Header.GetIndexShortID()
+	indexId2 := snap2.Header.GetIndexShortID()
+	snapPath1 := fmt.Sprintf("%s:/subdir", hex.EncodeToString(indexId1[:]))
+	snapPath2 := fmt.Sprintf("%s:/subdir", hex.EncodeToString(indexId2[:]))
+
+	subcommand := &Diff{}
+	err := subcommand.Parse(ctx, []string{snapPath1, snapPath2})
+	require.NoError(t, err)
+	subcommand.SetFormat(output.FormatNDJSON)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// the records are sorted by path
+	var paths []string
+	for _, line := range strings.Split(strings.Trim(bufOut.String(), "\n"), "\n") {
+		var rec output.DiffEntry
+		require.NoError(t, json.Unmarshal([]byte(line), &rec))
+		paths = append(paths, path.Base(rec.Path))
+	}
+	require.Equal(t, []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt", "f.txt"}, paths)
+}
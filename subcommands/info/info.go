@@ -22,11 +22,12 @@ import (
 
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
 	"github.com/PlakarKorp/plakar/subcommands"
 )
 
 func init() {
-	subcommands.Register(func() subcommands.Subcommand { return &Info{} }, subcommands.AgentSupport, "info")
+	subcommands.Register(func() subcommands.Subcommand { return &Info{} }, subcommands.AgentSupport|subcommands.FormatSupport, "info")
 }
 
 type Info struct {
@@ -57,6 +58,10 @@ func (cmd *Info) Parse(ctx *appcontext.AppContext, args []string) error {
 }
 
 func (cmd *Info) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	if output.Structured(cmd.Format) && (cmd.SnapshotID == "" || cmd.Errors) {
+		return 1, fmt.Errorf("info: only snapshots can be described in %s format", cmd.Format)
+	}
+
 	if cmd.SnapshotID == "" {
 		return cmd.executeRepository(ctx, repo)
 	}
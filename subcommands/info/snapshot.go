@@ -10,6 +10,7 @@ import (
 	"github.com/PlakarKorp/kloset/locate"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
 	"github.com/dustin/go-humanize"
 	"github.com/google/uuid"
 )
@@ -23,6 +24,17 @@ func (cmd *Info) executeSnapshot(ctx *appcontext.AppContext, repo *repository.Re
 
 	header := snap.Header
 
+	enc := output.NewEncoder(ctx.Stdout, cmd.Format)
+	if enc.Structured() {
+		if err := enc.Encode(output.NewSnapshot(header)); err != nil {
+			return 1, err
+		}
+		if err := enc.Close(); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+
 	indexID := header.GetIndexID()
 	fmt.Fprintf(ctx.Stdout, "Version: %s\n", repo.Configuration().Version)
 	fmt.Fprintf(ctx.Stdout, "SnapshotID: %s\n", hex.EncodeToString(indexID[:]))
@@ -17,6 +17,7 @@
 package locate
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"path"
@@ -26,12 +27,13 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 )
 
 func init() {
-	subcommands.Register(func() subcommands.Subcommand { return &Locate{} }, subcommands.AgentSupport, "locate")
+	subcommands.Register(func() subcommands.Subcommand { return &Locate{} }, subcommands.AgentSupport|subcommands.FormatSupport, "locate")
 }
 
 func (cmd *Locate) Parse(ctx *appcontext.AppContext, args []string) error {
@@ -66,7 +68,7 @@ type Locate struct {
 	Patterns      []string
 }
 
-func (cmd *Locate) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+func (cmd *Locate) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (status int, err error) {
 	var snapshots []objects.MAC
 	if len(cmd.Snapshot) == 0 {
 		snapshotIDs, err := plocate.LocateSnapshotIDs(repo, cmd.LocateOptions)
@@ -79,6 +81,12 @@ func (cmd *Locate) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 		snapshots = append(snapshots, snapshotIDs...)
 	}
 
+	enc := output.NewEncoder(ctx.Stdout, cmd.Format)
+	defer func() {
+		if cerr := enc.Close(); cerr != nil && err == nil {
+			status, err = 1, cerr
+		}
+	}()
 	for _, snapshotID := range snapshots {
 		snap, err := snapshot.Load(repo, snapshotID)
 		if err != nil {
@@ -115,6 +123,18 @@ func (cmd *Locate) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 						continue
 					}
 				}
+				if enc.Structured() {
+					err := enc.Encode(&output.LocateHit{
+						Snapshot: hex.EncodeToString(snap.Header.Identifier[:]),
+						ShortID:  hex.EncodeToString(snap.Header.Identifier[0:4]),
+						Path:     pathname,
+					})
+					if err != nil {
+						snap.Close()
+						return 1, err
+					}
+					continue
+				}
 				fmt.Fprintf(ctx.Stdout, "%x:%s\n", snap.Header.Identifier[0:4], utils.SanitizeText(pathname))
 			}
 		}
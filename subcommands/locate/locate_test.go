@@ -3,6 +3,7 @@ package locate
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"os"
 	"strings"
 	"testing"
@@ -11,6 +12,7 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
 	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/stretchr/testify/require"
 )
@@ -85,3 +87,32 @@ func TestExecuteCmdLocateWithSnapshotId(t *testing.T) {
 	lines := strings.Split(strings.Trim(output, "\n"), "\n")
 	require.Equal(t, 1, len(lines))
 }
+
+func TestExecuteCmdLocateJSON(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	args := []string{"*.txt"}
+
+	subcommand := &Locate{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+	subcommand.SetFormat(output.FormatJSON)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	var hits []output.LocateHit
+	require.NoError(t, json.Unmarshal(bufOut.Bytes(), &hits))
+	require.Equal(t, 3, len(hits))
+	for _, hit := range hits {
+		indexID := snap.Header.GetIndexID()
+		require.Equal(t, hex.EncodeToString(indexID[:]), hit.Snapshot)
+		require.Equal(t, hex.EncodeToString(snap.Header.GetIndexShortID()), hit.ShortID)
+		require.True(t, strings.HasSuffix(hit.Path, ".txt"))
+	}
+}
@@ -19,36 +19,125 @@ package login
 import (
 	"flag"
 	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/PlakarKorp/go-human2duration"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
 	plogin "github.com/PlakarKorp/plakar/login"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
 )
 
+type pathFlags []string
+
+func (p *pathFlags) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *pathFlags) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
 type TokenCreate struct {
 	subcommands.SubcommandBase
+
+	Name    string
+	Scopes  []string
+	Paths   []string
+	Expires time.Time
+}
+
+// parseExpiry accepts a duration from now, a date or never.
+func parseExpiry(input string) (time.Time, error) {
+	if input == "never" {
+		return time.Time{}, nil
+	}
+	if d, err := human2duration.ParseDuration(input); err == nil {
+		return time.Now().Add(d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, input, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry: %q", input)
 }
 
 func (cmd *TokenCreate) Parse(ctx *appcontext.AppContext, args []string) error {
+	var opt_scope string
+	var opt_expires string
+	var opt_paths pathFlags
+
 	flags := flag.NewFlagSet("token-create", flag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Fprintf(flags.Output(), "Usage: token create\n")
+		fmt.Fprintf(flags.Output(), "Usage: token create [OPTIONS] [name]\n")
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
 	}
+	flags.StringVar(&opt_scope, "scope", utils.ScopeBrowse, "comma-separated list of scopes: "+strings.Join(utils.APITokenScopes, ", "))
+	flags.StringVar(&opt_expires, "expires", "30d", "expiry of the token, as a duration, a date or never")
+	flags.Var(&opt_paths, "path", "restrict the token to a path prefix within the snapshots, can be specified multiple times")
 	flags.Parse(args)
 
-	if flags.NArg() > 0 {
+	if flags.NArg() > 1 {
 		return fmt.Errorf("Too many arguments")
 	}
+
+	// without a name, derive a token for the plakar services
+	if flags.NArg() == 0 {
+		return nil
+	}
+
+	cmd.Name = flags.Arg(0)
+	if cmd.Name == "" || strings.HasPrefix(cmd.Name, "@") || strings.ContainsAny(cmd.Name, " \t\n:") {
+		return fmt.Errorf("invalid token name %q", cmd.Name)
+	}
+	cmd.Scopes = strings.Split(opt_scope, ",")
+	cmd.Paths = opt_paths
+
+	expires, err := parseExpiry(opt_expires)
+	if err != nil {
+		return err
+	}
+	cmd.Expires = expires
+
 	return nil
 }
 
 func (cmd *TokenCreate) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
-	token, err := plogin.DeriveToken(ctx)
+	if cmd.Name == "" {
+		token, err := plogin.DeriveToken(ctx)
+		if err != nil {
+			return 1, err
+		}
+
+		fmt.Fprintln(ctx.Stdout, token)
+		return 0, nil
+	}
+
+	configFile := filepath.Join(ctx.ConfigDir, "tokens.yml")
+	config, err := utils.LoadTokensConfigFile(configFile)
 	if err != nil {
+		return 1, fmt.Errorf("failed to load config file: %w", err)
+	}
+	if config.Has(cmd.Name) {
+		return 1, fmt.Errorf("token %q already exists", cmd.Name)
+	}
+
+	secret, token, err := utils.NewAPIToken(cmd.Scopes, cmd.Paths, cmd.Expires)
+	if err != nil {
+		return 1, err
+	}
+	config.Add(cmd.Name, token)
+	if err := config.SaveToFile(configFile); err != nil {
 		return 1, err
 	}
 
-	fmt.Fprintln(ctx.Stdout, token)
+	// the secret isn't kept, it can't be shown again
+	fmt.Fprintln(ctx.Stdout, secret)
 	return 0, nil
 }
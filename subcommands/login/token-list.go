@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package login
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+type TokenList struct {
+	subcommands.SubcommandBase
+}
+
+func (cmd *TokenList) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("token-list", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: token list\n")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	return nil
+}
+
+func (cmd *TokenList) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	config, err := utils.LoadTokensConfigFile(filepath.Join(ctx.ConfigDir, "tokens.yml"))
+	if err != nil {
+		return 1, fmt.Errorf("failed to load config file: %w", err)
+	}
+
+	names := make([]string, 0, len(config.Tokens))
+	for name := range config.Tokens {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		token := config.Tokens[name]
+
+		expires := "never"
+		if token.Expired() {
+			expires = "expired"
+		} else if !token.Expires.IsZero() {
+			expires = token.Expires.UTC().Format(time.RFC3339)
+		}
+
+		paths := "/"
+		if len(token.Paths) != 0 {
+			paths = strings.Join(token.Paths, ",")
+		}
+
+		fmt.Fprintf(ctx.Stdout, "%s %s %s %s\n", name, strings.Join(token.Scopes, ","), expires, paths)
+	}
+	return 0, nil
+}
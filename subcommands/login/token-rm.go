@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package login
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+type TokenRm struct {
+	subcommands.SubcommandBase
+
+	Names []string
+}
+
+func (cmd *TokenRm) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("token-rm", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: token rm name...\n")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no token specified")
+	}
+	cmd.Names = flags.Args()
+	return nil
+}
+
+func (cmd *TokenRm) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	configFile := filepath.Join(ctx.ConfigDir, "tokens.yml")
+	config, err := utils.LoadTokensConfigFile(configFile)
+	if err != nil {
+		return 1, fmt.Errorf("failed to load config file: %w", err)
+	}
+
+	for _, name := range cmd.Names {
+		if !config.Has(name) {
+			return 1, fmt.Errorf("token %q does not exist", name)
+		}
+		config.Remove(name)
+	}
+
+	// plakar ui notices the change and stops accepting the tokens
+	if err := config.SaveToFile(configFile); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
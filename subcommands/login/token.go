@@ -27,6 +27,8 @@ import (
 
 func init() {
 	subcommands.Register(func() subcommands.Subcommand { return &TokenCreate{} }, subcommands.BeforeRepositoryOpen, "token", "create")
+	subcommands.Register(func() subcommands.Subcommand { return &TokenList{} }, subcommands.BeforeRepositoryOpen, "token", "list")
+	subcommands.Register(func() subcommands.Subcommand { return &TokenRm{} }, subcommands.BeforeRepositoryOpen, "token", "rm")
 	subcommands.Register(func() subcommands.Subcommand { return &Token{} }, subcommands.BeforeRepositoryOpen, "token")
 }
 
@@ -37,7 +39,9 @@ type Token struct {
 func (_ *Token) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags := flag.NewFlagSet("token", flag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Fprintf(flags.Output(), "Usage: %s create\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "Usage: %s create [OPTIONS] [name]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s list\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s rm name...\n", flags.Name())
 	}
 	flags.Parse(args)
 
@@ -31,13 +31,14 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/output"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/dustin/go-humanize"
 )
 
 func init() {
-	subcommands.Register(func() subcommands.Subcommand { return &Ls{} }, subcommands.AgentSupport, "ls")
+	subcommands.Register(func() subcommands.Subcommand { return &Ls{} }, subcommands.AgentSupport|subcommands.FormatSupport, "ls")
 }
 
 func (cmd *Ls) Parse(ctx *appcontext.AppContext, args []string) error {
@@ -95,18 +96,33 @@ func (cmd *Ls) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 	return 0, nil
 }
 
-func (cmd *Ls) list_snapshots(ctx *appcontext.AppContext, repo *repository.Repository) error {
+func (cmd *Ls) list_snapshots(ctx *appcontext.AppContext, repo *repository.Repository) (err error) {
 	snapshotIDs, err := locate.LocateSnapshotIDs(repo, cmd.LocateOptions)
 	if err != nil {
 		return fmt.Errorf("ls: could not fetch snapshots list: %w", err)
 	}
 
+	enc := output.NewEncoder(ctx.Stdout, cmd.Format)
+	defer func() {
+		if cerr := enc.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
 	for _, snapshotID := range snapshotIDs {
 		snap, err := snapshot.Load(repo, snapshotID)
 		if err != nil {
 			return fmt.Errorf("ls: could not fetch snapshot: %w", err)
 		}
 
+		if enc.Structured() {
+			err := enc.Encode(output.NewSnapshot(snap.Header))
+			snap.Close()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
 		tags := ""
 		if cmd.ShowTags && len(snap.Header.Tags) > 0 {
 			tagList := strings.Join(snap.Header.Tags, ",")
@@ -139,7 +155,7 @@ func (cmd *Ls) list_snapshots(ctx *appcontext.AppContext, repo *repository.Repos
 	return nil
 }
 
-func (cmd *Ls) list_snapshot(ctx *appcontext.AppContext, repo *repository.Repository, snapshotPath string, recursive bool) error {
+func (cmd *Ls) list_snapshot(ctx *appcontext.AppContext, repo *repository.Repository, snapshotPath string, recursive bool) (err error) {
 	snap, pathname, err := locate.OpenSnapshotByPath(repo, snapshotPath)
 	if err != nil {
 		return err
@@ -151,8 +167,17 @@ func (cmd *Ls) list_snapshot(ctx *appcontext.AppContext, repo *repository.Reposi
 		return err
 	}
 
+	indexID := snap.Header.GetIndexID()
+	snapshotID := hex.EncodeToString(indexID[:])
+
+	enc := output.NewEncoder(ctx.Stdout, cmd.Format)
+	defer func() {
+		if cerr := enc.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
 	resolved := false
-	return pvfs.WalkDir(pathname, func(path string, d *vfs.Entry, err error) error {
+	err = pvfs.WalkDir(pathname, func(path string, d *vfs.Entry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -196,23 +221,45 @@ func (cmd *Ls) list_snapshot(ctx *appcontext.AppContext, repo *repository.Reposi
 			entryname = d.Name()
 		}
 
-		var linkTarget string
-		if sb.Mode()&fs.ModeSymlink != 0 {
-			linkTarget = fmt.Sprintf(" -> %s", utils.SanitizeText(d.SymlinkTarget))
-		}
+		if enc.Structured() {
+			err := enc.Encode(&output.Entry{
+				Snapshot: snapshotID,
+				Path:     path,
+				Name:     d.Name(),
+				Type:     output.FileType(sb.Mode()),
+				Mode:     sb.Mode().String(),
+				Size:     sb.Size(),
+				ModTime:  sb.ModTime().UTC(),
+				UID:      d.Stat().Uid(),
+				GID:      d.Stat().Gid(),
+				User:     username,
+				Group:    groupname,
+				Nlink:    d.Stat().Nlink(),
+				Target:   d.SymlinkTarget,
+			})
+			if err != nil {
+				return err
+			}
+		} else {
+			var linkTarget string
+			if sb.Mode()&fs.ModeSymlink != 0 {
+				linkTarget = fmt.Sprintf(" -> %s", utils.SanitizeText(d.SymlinkTarget))
+			}
 
-		fmt.Fprintf(ctx.Stdout, "%s %s % 8s % 8s % 8s %s%s\n",
-			sb.ModTime().UTC().Format(time.RFC3339),
-			sb.Mode(),
-			username,
-			groupname,
-			humanize.IBytes(uint64(sb.Size())),
-			utils.SanitizeText(entryname),
-			linkTarget)
+			fmt.Fprintf(ctx.Stdout, "%s %s % 8s % 8s % 8s %s%s\n",
+				sb.ModTime().UTC().Format(time.RFC3339),
+				sb.Mode(),
+				username,
+				groupname,
+				humanize.IBytes(uint64(sb.Size())),
+				utils.SanitizeText(entryname),
+				linkTarget)
+		}
 
 		if !recursive && pathname != path && sb.IsDir() {
 			return fs.SkipDir
 		}
 		return nil
 	})
+	return err
 }
@@ -50,9 +50,20 @@ func (cmd *Maintenance) Parse(ctx *appcontext.AppContext, args []string) error {
 	return nil
 }
 
+// Stats counts what a maintenance run did.
+type Stats struct {
+	ColouredPackfiles int
+	OrphanedPackfiles int
+	SweptPackfiles    int
+	RemovedBlobs      int
+	ReclaimedBytes    int64
+}
+
 type Maintenance struct {
 	subcommands.SubcommandBase
 
+	Stats Stats
+
 	repository    *repository.Repository
 	maintenanceID objects.MAC
 	cutoff        time.Time
@@ -206,6 +217,8 @@ func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.Ma
 	}
 
 	fmt.Fprintf(ctx.Stdout, "maintenance: Coloured %d packfiles (%d orphaned) for deletion\n", coloredPackfiles, orphanedPackfiles)
+	cmd.Stats.ColouredPackfiles = coloredPackfiles
+	cmd.Stats.OrphanedPackfiles = orphanedPackfiles
 
 	if coloredPackfiles > 0 {
 		duration, err := time.ParseDuration(os.Getenv("PLAKAR_GRACEPERIOD"))
@@ -268,6 +281,7 @@ func (cmd *Maintenance) sweepPass(ctx *appcontext.AppContext, cache *caching.Mai
 
 	// Second garbage collect dangling blobs in our state. This is the blobs we
 	// just orphaned plus potential orphan blobs from aborted backups etc.
+	packfileSizes := map[objects.MAC]int64{}
 	for blob, err := range cmd.repository.ListOrphanBlobs() {
 		if err != nil {
 			fmt.Fprintf(ctx.Stderr, "maintenance: Failed to fetch orphaned blob\n")
@@ -275,6 +289,9 @@ func (cmd *Maintenance) sweepPass(ctx *appcontext.AppContext, cache *caching.Mai
 		}
 
 		blobRemoved++
+		if _, ok := toDelete[blob.Location.Packfile]; ok {
+			packfileSizes[blob.Location.Packfile] += int64(blob.Location.Length)
+		}
 		if err := cmd.repository.RemoveBlob(blob.Type, blob.Blob, blob.Location.Packfile); err != nil {
 			// No hurt in this failing, we just have cruft left around, but they are unreachable anyway.
 			fmt.Fprintf(ctx.Stderr, "maintenance: garbage orphaned blobs pass failed to remove blob %x, type %s\n", blob.Blob, blob.Type)
@@ -282,6 +299,8 @@ func (cmd *Maintenance) sweepPass(ctx *appcontext.AppContext, cache *caching.Mai
 	}
 
 	fmt.Fprintf(ctx.Stdout, "maintenance: %d blobs and %d packfiles were removed\n", blobRemoved, len(toDelete))
+	cmd.Stats.RemovedBlobs = blobRemoved
+	cmd.Stats.SweptPackfiles = len(toDelete)
 
 	if len(toDelete) > 0 {
 		if err := cmd.repository.PutCurrentState(); err != nil {
@@ -293,7 +312,9 @@ func (cmd *Maintenance) sweepPass(ctx *appcontext.AppContext, cache *caching.Mai
 		for packfileMAC := range toDelete {
 			if err := cmd.repository.DeletePackfile(packfileMAC); err != nil {
 				fmt.Fprintf(ctx.Stderr, "maintenance: Sweep pass failed to delete packfile %x, skipping it\n", packfileMAC)
+				continue
 			}
+			cmd.Stats.ReclaimedBytes += packfileSizes[packfileMAC]
 		}
 	}
 
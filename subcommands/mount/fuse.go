@@ -21,8 +21,12 @@ package mount
 
 import (
 	"fmt"
+	"path"
 
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/plakarfs"
 	"github.com/anacrolix/fuse"
@@ -30,12 +34,34 @@ import (
 )
 
 func (cmd *Mount) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
-	c, err := fuse.Mount(
-		cmd.Mountpoint,
+	opts := plakarfs.DefaultOptions()
+	opts.UID = cmd.UID
+	opts.GID = cmd.GID
+	opts.UIDMap = cmd.UIDMap
+	opts.GIDMap = cmd.GIDMap
+	opts.AttrTTL = cmd.AttrTTL
+	opts.EntryTTL = cmd.EntryTTL
+	opts.RefreshInterval = cmd.Refresh
+
+	if cmd.Snapshot != "" || !cmd.LocateOptions.Empty() {
+		snapshotID, pathname, err := cmd.locate(repo)
+		if err != nil {
+			return 1, fmt.Errorf("mount: %w", err)
+		}
+		opts.Snapshot = snapshotID
+		opts.Path = pathname
+	}
+
+	mountOptions := []fuse.MountOption{
 		fuse.FSName("plakar"),
 		fuse.Subtype("plakarfs"),
 		fuse.LocalVolume(),
-	)
+	}
+	if cmd.AllowOther {
+		mountOptions = append(mountOptions, fuse.AllowOther())
+	}
+
+	c, err := fuse.Mount(cmd.Mountpoint, mountOptions...)
 	if err != nil {
 		return 1, fmt.Errorf("mount: %v", err)
 	}
@@ -52,7 +78,19 @@ func (cmd *Mount) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 		fuse.Unmount(cmd.Mountpoint)
 	}()
 
-	err = fs.Serve(c, plakarfs.NewFS(repo, cmd.Mountpoint))
+	if cmd.Daemon {
+		go func() {
+			<-c.Ready
+			if c.MountError != nil {
+				return
+			}
+			if !ctx.Detach() {
+				ctx.GetLogger().Warn("not running in the agent, staying in the foreground")
+			}
+		}()
+	}
+
+	err = fs.Serve(c, plakarfs.NewFS(repo, cmd.Mountpoint, opts))
 	if err != nil {
 		return 1, err
 	}
@@ -62,3 +100,46 @@ func (cmd *Mount) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 	}
 	return 0, nil
 }
+
+// locate returns the snapshot and the directory in it to expose at the
+// root of the mountpoint.
+func (cmd *Mount) locate(repo *repository.Repository) (objects.MAC, string, error) {
+	prefix, pathname := locate.ParseSnapshotPath(cmd.Snapshot)
+
+	cmd.LocateOptions.Filters.Latest = true
+	if prefix != "" {
+		cmd.LocateOptions.Filters.IDs = []string{prefix}
+	}
+
+	snapshotIDs, err := locate.LocateSnapshotIDs(repo, cmd.LocateOptions)
+	if err != nil {
+		return objects.MAC{}, "", fmt.Errorf("could not fetch snapshots list: %w", err)
+	}
+	if len(snapshotIDs) == 0 {
+		return objects.MAC{}, "", fmt.Errorf("no snapshots found")
+	} else if len(snapshotIDs) > 1 {
+		return objects.MAC{}, "", fmt.Errorf("multiple snapshots found, please specify one")
+	}
+
+	snap, err := snapshot.Load(repo, snapshotIDs[0])
+	if err != nil {
+		return objects.MAC{}, "", err
+	}
+	defer snap.Close()
+
+	snapfs, err := snap.Filesystem()
+	if err != nil {
+		return objects.MAC{}, "", err
+	}
+
+	pathname = path.Clean("/" + pathname)
+	entry, err := snapfs.GetEntry(pathname)
+	if err != nil {
+		return objects.MAC{}, "", fmt.Errorf("%s: %w", pathname, err)
+	}
+	if !entry.Stat().IsDir() {
+		return objects.MAC{}, "", fmt.Errorf("%s: not a directory", pathname)
+	}
+
+	return snapshotIDs[0], pathname, nil
+}
@@ -19,7 +19,11 @@ package mount
 import (
 	"flag"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/PlakarKorp/kloset/locate"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/subcommands"
 )
@@ -29,24 +33,90 @@ func init() {
 }
 
 func (cmd *Mount) Parse(ctx *appcontext.AppContext, args []string) error {
+	cmd.LocateOptions = locate.NewDefaultLocateOptions()
+	cmd.UIDMap = make(map[uint32]uint32)
+	cmd.GIDMap = make(map[uint32]uint32)
+
 	flags := flag.NewFlagSet("mount", flag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Fprintf(flags.Output(), "Usage: %s PATH\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] [SNAPSHOT[:PATH]] PATH\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
 	}
+
+	flags.BoolVar(&cmd.AllowOther, "allow-other", false, "allow other users to access the filesystem")
+	flags.BoolVar(&cmd.Daemon, "daemon", false, "run the mount in the agent and return once mounted")
+	flags.IntVar(&cmd.UID, "uid", -1, "owner of all the files")
+	flags.IntVar(&cmd.GID, "gid", -1, "group of all the files")
+	flags.Var(idMap(cmd.UIDMap), "map-uid", "map the owner `FROM:TO` (repeat)")
+	flags.Var(idMap(cmd.GIDMap), "map-gid", "map the group `FROM:TO` (repeat)")
+	flags.DurationVar(&cmd.AttrTTL, "attr-ttl", time.Minute, "how long the kernel caches file attributes")
+	flags.DurationVar(&cmd.EntryTTL, "entry-ttl", time.Minute, "how long the kernel caches lookups")
+	flags.DurationVar(&cmd.Refresh, "refresh", time.Minute, "how often the list of snapshots is refreshed")
+	cmd.LocateOptions.InstallLocateFlags(flags)
 	flags.Parse(args)
 
-	if flags.NArg() != 1 {
+	switch flags.NArg() {
+	case 1:
+		cmd.Mountpoint = flags.Arg(0)
+	case 2:
+		cmd.Snapshot = flags.Arg(0)
+		cmd.Mountpoint = flags.Arg(1)
+	default:
 		return fmt.Errorf("need mountpoint")
 	}
 
+	if cmd.AttrTTL < 0 || cmd.EntryTTL < 0 || cmd.Refresh < 0 {
+		return fmt.Errorf("durations can't be negative")
+	}
+
 	cmd.RepositorySecret = ctx.GetSecret()
-	cmd.Mountpoint = flags.Arg(0)
 
 	return nil
 }
 
+// idMap is a flag accumulating FROM:TO mappings of user or group ids.
+type idMap map[uint32]uint32
+
+func (m idMap) String() string {
+	var mappings []string
+	for from, to := range m {
+		mappings = append(mappings, fmt.Sprintf("%d:%d", from, to))
+	}
+	return strings.Join(mappings, ",")
+}
+
+func (m idMap) Set(value string) error {
+	from, to, ok := strings.Cut(value, ":")
+	if !ok {
+		return fmt.Errorf("invalid mapping %q, expected FROM:TO", value)
+	}
+	fromID, err := strconv.ParseUint(from, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid mapping %q: %w", value, err)
+	}
+	toID, err := strconv.ParseUint(to, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid mapping %q: %w", value, err)
+	}
+	m[uint32(fromID)] = uint32(toID)
+	return nil
+}
+
 type Mount struct {
 	subcommands.SubcommandBase
 
-	Mountpoint string
+	LocateOptions *locate.LocateOptions
+	Mountpoint    string
+	Snapshot      string
+
+	AllowOther bool
+	Daemon     bool
+	UID        int
+	GID        int
+	UIDMap     map[uint32]uint32
+	GIDMap     map[uint32]uint32
+	AttrTTL    time.Duration
+	EntryTTL   time.Duration
+	Refresh    time.Duration
 }
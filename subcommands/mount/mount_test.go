@@ -82,3 +82,31 @@ func TestExecuteCmdMountDefault(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "hello dummy", string(content))
 }
+
+func TestParseCmdMountOptions(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	_, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	args := []string{"-allow-other", "-uid", "1000", "-map-gid", "0:100", "-map-gid", "10:110",
+		"-attr-ttl", "5s", "-tag", "prod", "abcd:/etc", "/mnt"}
+
+	subcommand := &Mount{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+	require.True(t, subcommand.AllowOther)
+	require.False(t, subcommand.Daemon)
+	require.Equal(t, 1000, subcommand.UID)
+	require.Equal(t, -1, subcommand.GID)
+	require.Equal(t, map[uint32]uint32{0: 100, 10: 110}, subcommand.GIDMap)
+	require.Equal(t, 5*time.Second, subcommand.AttrTTL)
+	require.Equal(t, time.Minute, subcommand.EntryTTL)
+	require.Equal(t, []string{"prod"}, subcommand.LocateOptions.Filters.Tags)
+	require.Equal(t, "abcd:/etc", subcommand.Snapshot)
+	require.Equal(t, "/mnt", subcommand.Mountpoint)
+
+	subcommand = &Mount{}
+	err = subcommand.Parse(ctx, []string{"a", "b", "c"})
+	require.Error(t, err)
+}
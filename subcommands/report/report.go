@@ -0,0 +1,169 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package report
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/reporting"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &ReportList{} },
+		subcommands.BeforeRepositoryOpen, "report", "list")
+	subcommands.Register(func() subcommands.Subcommand { return &ReportFlush{} },
+		subcommands.BeforeRepositoryOpen, "report", "flush")
+	subcommands.Register(func() subcommands.Subcommand { return &ReportPurge{} },
+		subcommands.BeforeRepositoryOpen, "report", "purge")
+	subcommands.Register(func() subcommands.Subcommand { return &Report{} },
+		subcommands.BeforeRepositoryOpen, "report")
+}
+
+type Report struct {
+	subcommands.SubcommandBase
+}
+
+func (cmd *Report) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s list | flush | purge\n", flags.Name())
+	}
+	flags.Parse(args)
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("invalid argument: %s", flags.Arg(0))
+	}
+	return fmt.Errorf("no action specified")
+}
+
+func (cmd *Report) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	return 1, fmt.Errorf("no action specified")
+}
+
+type ReportList struct {
+	subcommands.SubcommandBase
+}
+
+func (cmd *ReportList) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("report list", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s\n", flags.Name())
+	}
+	flags.Parse(args)
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("invalid argument: %s", flags.Arg(0))
+	}
+	return nil
+}
+
+func (cmd *ReportList) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	entries, err := reporting.NewSpool(reporting.SpoolDir(ctx)).List()
+	if err != nil {
+		return 1, err
+	}
+
+	for _, entry := range entries {
+		task := "-"
+		if t := entry.Report.Task; t != nil {
+			task = fmt.Sprintf("%s %s %s", t.Type, t.Name, t.Status)
+		}
+		fmt.Fprintf(ctx.Stdout, "%s %s %s, %d attempts, pending %s, next attempt %s\n",
+			entry.ID, entry.Created.Local().Format(time.RFC3339), task, entry.Attempts,
+			strings.Join(entry.Pending, ","), entry.NextAttempt.Local().Format(time.RFC3339))
+		if entry.LastError != "" {
+			fmt.Fprintf(ctx.Stdout, "  error: %s\n", entry.LastError)
+		}
+	}
+	return 0, nil
+}
+
+type ReportFlush struct {
+	subcommands.SubcommandBase
+}
+
+func (cmd *ReportFlush) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("report flush", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s\n", flags.Name())
+	}
+	flags.Parse(args)
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("invalid argument: %s", flags.Arg(0))
+	}
+	return nil
+}
+
+func (cmd *ReportFlush) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	remaining, err := reporting.Flush(ctx)
+	if err != nil {
+		return 1, err
+	}
+	if remaining != 0 {
+		return 1, fmt.Errorf("%d reports could not be delivered", remaining)
+	}
+	return 0, nil
+}
+
+type ReportPurge struct {
+	subcommands.SubcommandBase
+
+	IDs []string
+}
+
+func (cmd *ReportPurge) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("report purge", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [ID ...]\n", flags.Name())
+	}
+	flags.Parse(args)
+
+	cmd.IDs = flags.Args()
+	return nil
+}
+
+func (cmd *ReportPurge) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	spool := reporting.NewSpool(reporting.SpoolDir(ctx))
+
+	ids := cmd.IDs
+	if len(ids) == 0 {
+		entries, err := spool.List()
+		if err != nil {
+			return 1, err
+		}
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+	}
+
+	for _, id := range ids {
+		if _, err := spool.Load(id); err != nil {
+			return 1, fmt.Errorf("no such report: %s", id)
+		}
+		if err := spool.Remove(id); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
@@ -41,6 +41,9 @@ type Rm struct {
 	LocateOptions *locate.LocateOptions
 
 	Apply bool
+
+	// Removed lists the snapshots removed by Execute.
+	Removed []objects.MAC
 }
 
 func init() {
@@ -143,6 +146,7 @@ func (cmd *Rm) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 
 	// execution
 	errors := 0
+	var mu sync.Mutex
 	wg := sync.WaitGroup{}
 	for _, matchID := range matches {
 		wg.Add(1)
@@ -150,9 +154,14 @@ func (cmd *Rm) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 			defer wg.Done()
 			if err := repo.DeleteSnapshot(snapshotID); err != nil {
 				ctx.GetLogger().Error("%s", err)
+				mu.Lock()
 				errors++
+				mu.Unlock()
 				return
 			}
+			mu.Lock()
+			cmd.Removed = append(cmd.Removed, snapshotID)
+			mu.Unlock()
 			ctx.GetLogger().Info("rm: removal of %x completed successfully", snapshotID[:4])
 		}(matchID)
 	}
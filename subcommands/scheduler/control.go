@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package scheduler
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/agent"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/scheduler"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+// control is a command that the scheduler runs on behalf of the clients
+// of its socket, which talk to it with the protocol of the agent.
+type control interface {
+	subcommands.Subcommand
+	control(ctx *appcontext.AppContext) (int, error)
+}
+
+// forward has the running scheduler execute the command.
+func forward(ctx *appcontext.AppContext, name []string, cmd control) (int, error) {
+	cl, err := agent.Dial(filepath.Join(ctx.CacheDir, "scheduler.sock"), false)
+	if err != nil {
+		return 1, fmt.Errorf("failed to connect to scheduler: %w", err)
+	}
+	defer cl.Close()
+
+	return cl.Execute(ctx, name, cmd, map[string]string{})
+}
+
+type SchedulerList struct {
+	subcommands.SubcommandBase
+}
+
+func (cmd *SchedulerList) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("scheduler list", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s\n", flags.Name())
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	return nil
+}
+
+func (cmd *SchedulerList) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	return forward(ctx, []string{"scheduler", "list"}, cmd)
+}
+
+func (cmd *SchedulerList) control(ctx *appcontext.AppContext) (int, error) {
+	sched, err := currentScheduler()
+	if err != nil {
+		return 1, err
+	}
+	tasks := sched.Tasks()
+
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.Local().Format(time.RFC3339)
+	}
+
+	for _, task := range tasks {
+		status := "idle"
+		if task.Queued {
+			status = "queued"
+		} else if task.Running {
+			status = "running"
+		} else if task.Paused {
+			status = "paused"
+		}
+
+		last := formatTime(task.LastRun)
+		if task.LastStatus != "" {
+			if task.LastAttempts > 1 {
+				last += fmt.Sprintf(" (%s after %d attempts)", task.LastStatus, task.LastAttempts)
+			} else {
+				last += " (" + string(task.LastStatus) + ")"
+			}
+		}
+
+		fmt.Fprintf(ctx.Stdout, "%s: %s, schedule %q at %s, last run %s, next run %s\n",
+			task.ID, status, task.Trigger, task.Repository, last, formatTime(task.NextDue))
+		if task.LastError != "" {
+			fmt.Fprintf(ctx.Stdout, "  error: %s\n", task.LastError)
+		}
+	}
+	return 0, nil
+}
+
+// SchedulerControl implements the commands acting on a single task: run,
+// pause and resume.
+type SchedulerControl struct {
+	subcommands.SubcommandBase
+
+	Action string
+	Task   string
+}
+
+func (cmd *SchedulerControl) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("scheduler "+cmd.Action, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s TASK\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nTASK is either a task name or a task identifier as shown by 'plakar scheduler list'.\n")
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("a single task must be specified")
+	}
+
+	cmd.Task = flags.Arg(0)
+	return nil
+}
+
+func (cmd *SchedulerControl) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	return forward(ctx, []string{"scheduler", cmd.Action}, cmd)
+}
+
+func (cmd *SchedulerControl) control(ctx *appcontext.AppContext) (int, error) {
+	sched, err := currentScheduler()
+	if err != nil {
+		return 1, err
+	}
+
+	switch cmd.Action {
+	case "run":
+		err = sched.Trigger(cmd.Task)
+	case "pause":
+		err = sched.Pause(cmd.Task)
+	case "resume":
+		err = sched.Resume(cmd.Task)
+	default:
+		err = fmt.Errorf("unknown action: %s", cmd.Action)
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+type SchedulerReload struct {
+	subcommands.SubcommandBase
+
+	// Tasks is the new configuration, the scheduler reloads the one it
+	// was started with if it is empty.
+	Tasks []byte
+}
+
+func (cmd *SchedulerReload) Parse(ctx *appcontext.AppContext, args []string) error {
+	var opt_tasks string
+
+	flags := flag.NewFlagSet("scheduler reload", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&opt_tasks, "tasks", "", "tasks configuration file, defaults to the one the scheduler was started with")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	if opt_tasks != "" {
+		configBytes, err := loadTasksConfiguration(opt_tasks)
+		if err != nil {
+			return err
+		}
+		if _, err := scheduler.ParseConfigBytes(configBytes); err != nil {
+			return err
+		}
+		cmd.Tasks = configBytes
+	}
+
+	return nil
+}
+
+func (cmd *SchedulerReload) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	return forward(ctx, []string{"scheduler", "reload"}, cmd)
+}
+
+func (cmd *SchedulerReload) control(ctx *appcontext.AppContext) (int, error) {
+	return reloadTasks(cmd.Tasks)
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package scheduler
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/scheduler"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+// loadTasksConfiguration reads the tasks configuration either from a local
+// file or from an http(s) URL.
+func loadTasksConfiguration(location string) ([]byte, error) {
+	var rd io.Reader
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		resp, err := http.Get(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download configuration file from %q: %w", location, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			return nil, fmt.Errorf("failed to download configuration file from %q: status code %d", location, resp.StatusCode)
+		}
+
+		rd = resp.Body
+	} else {
+		absolutePath, err := filepath.Abs(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for configuration file: %w", err)
+		}
+		fp, err := os.Open(absolutePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open configuration file %q: %w", absolutePath, err)
+		}
+		defer fp.Close()
+		rd = fp
+	}
+
+	configBytes, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	return configBytes, nil
+}
+
+type SchedulerPlan struct {
+	subcommands.SubcommandBase
+
+	Count  int
+	config *scheduler.Configuration
+}
+
+func (cmd *SchedulerPlan) Parse(ctx *appcontext.AppContext, args []string) error {
+	var opt_tasks string
+
+	flags := flag.NewFlagSet("scheduler plan", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&opt_tasks, "tasks", "", "tasks configuration file")
+	flags.IntVar(&cmd.Count, "n", 5, "number of upcoming runs to display per task")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	if opt_tasks == "" {
+		return fmt.Errorf("no tasks configuration file provided")
+	}
+	if cmd.Count <= 0 {
+		return fmt.Errorf("invalid -n value %d", cmd.Count)
+	}
+
+	configBytes, err := loadTasksConfiguration(opt_tasks)
+	if err != nil {
+		return err
+	}
+	cmd.config, err = scheduler.ParseConfigBytes(configBytes)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (cmd *SchedulerPlan) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	for _, planned := range cmd.config.Plan(time.Now(), cmd.Count) {
+		fmt.Fprintf(ctx.Stdout, "%s %s at %s\n", planned.Task, planned.Kind, planned.Repository)
+		if planned.After != "" {
+			fmt.Fprintf(ctx.Stdout, "  %s\n", planned.After)
+		}
+		for _, t := range planned.Runs {
+			fmt.Fprintf(ctx.Stdout, "  %s\n", t.Format(time.RFC3339))
+		}
+	}
+	return 0, nil
+}
@@ -30,6 +30,18 @@ func init() {
 		subcommands.BeforeRepositoryOpen, "scheduler", "start")
 	subcommands.Register(func() subcommands.Subcommand { return &SchedulerStop{} },
 		subcommands.BeforeRepositoryOpen, "scheduler", "stop")
+	subcommands.Register(func() subcommands.Subcommand { return &SchedulerPlan{} },
+		subcommands.BeforeRepositoryOpen, "scheduler", "plan")
+	subcommands.Register(func() subcommands.Subcommand { return &SchedulerList{} },
+		subcommands.BeforeRepositoryOpen, "scheduler", "list")
+	subcommands.Register(func() subcommands.Subcommand { return &SchedulerControl{Action: "run"} },
+		subcommands.BeforeRepositoryOpen, "scheduler", "run")
+	subcommands.Register(func() subcommands.Subcommand { return &SchedulerControl{Action: "pause"} },
+		subcommands.BeforeRepositoryOpen, "scheduler", "pause")
+	subcommands.Register(func() subcommands.Subcommand { return &SchedulerControl{Action: "resume"} },
+		subcommands.BeforeRepositoryOpen, "scheduler", "resume")
+	subcommands.Register(func() subcommands.Subcommand { return &SchedulerReload{} },
+		subcommands.BeforeRepositoryOpen, "scheduler", "reload")
 	subcommands.Register(func() subcommands.Subcommand { return &Scheduler{} },
 		subcommands.BeforeRepositoryOpen, "scheduler")
 }
@@ -41,7 +53,7 @@ type Scheduler struct {
 func (cmd *Scheduler) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags := flag.NewFlagSet("scheduler", flag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Fprintf(flags.Output(), "Usage: %s start | stop\n",
+		fmt.Fprintf(flags.Output(), "Usage: %s start | stop | plan | list | run | pause | resume | reload\n",
 			flags.Name())
 	}
 	flags.Parse(args)
@@ -17,11 +17,11 @@
 package scheduler
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
 	"net"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -29,7 +29,9 @@ import (
 	"sync/atomic"
 
 	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/agent"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/metrics"
 	"github.com/PlakarKorp/plakar/scheduler"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
@@ -53,6 +55,7 @@ func (cmd *SchedulerStart) Parse(ctx *appcontext.AppContext, args []string) erro
 	flags.BoolVar(&opt_foreground, "foreground", false, "run in foreground")
 	flags.StringVar(&opt_logfile, "log", "", "log file")
 	flags.StringVar(&opt_tasks, "tasks", "", "tasks configuration file")
+	flags.StringVar(&cmd.metricsAddr, "metrics", "", "address to expose Prometheus metrics on")
 	flags.Parse(args)
 	if flags.NArg() != 0 {
 		return fmt.Errorf("too many arguments")
@@ -61,42 +64,24 @@ func (cmd *SchedulerStart) Parse(ctx *appcontext.AppContext, args []string) erro
 	if opt_tasks == "" {
 		return fmt.Errorf("no tasks configuration file provided")
 	}
-
-	var rd io.Reader
-	if strings.HasPrefix(opt_tasks, "http://") || strings.HasPrefix(opt_tasks, "https://") {
-		resp, err := http.Get(opt_tasks)
-		if err != nil {
-			return fmt.Errorf("failed to download configuration file from %q: %w", opt_tasks, err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != 200 {
-			return fmt.Errorf("failed to download configuration file from %q: status code %d", opt_tasks, resp.StatusCode)
-		}
-
-		rd = resp.Body
-	} else {
+	if !strings.HasPrefix(opt_tasks, "http://") && !strings.HasPrefix(opt_tasks, "https://") {
 		absolutePath, err := filepath.Abs(opt_tasks)
 		if err != nil {
 			return fmt.Errorf("failed to get absolute path for configuration file: %w", err)
 		}
-		fp, err := os.Open(absolutePath)
-		if err != nil {
-			return fmt.Errorf("failed to open configuration file %q: %w", absolutePath, err)
-		}
-		defer fp.Close()
-		rd = fp
+		opt_tasks = absolutePath
 	}
 
-	configBytes, err := io.ReadAll(rd)
+	configBytes, err := loadTasksConfiguration(opt_tasks)
 	if err != nil {
-		return fmt.Errorf("failed to read configuration file: %w", err)
+		return err
 	}
 	_, err = scheduler.ParseConfigBytes(configBytes)
 	if err != nil {
 		return err
 	}
 	cmd.schedConfigBytes = configBytes
+	cmd.tasksLocation = opt_tasks
 
 	if !opt_foreground && os.Getenv("REEXEC") == "" {
 		err := daemonize(os.Args)
@@ -134,7 +119,9 @@ type SchedulerContext struct {
 	agentCtx        *appcontext.AppContext
 	schedulerCtx    *appcontext.AppContext
 	schedulerConfig *scheduler.Configuration
+	scheduler       *scheduler.Scheduler
 	schedulerState  schedulerState
+	tasksLocation   string
 	mtx             sync.Mutex
 }
 
@@ -142,16 +129,25 @@ type SchedulerStart struct {
 	subcommands.SubcommandBase
 	socketPath       string
 	schedConfigBytes []byte
+	tasksLocation    string
+	metricsAddr      string
 }
 
 func (cmd *SchedulerStart) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
 	schedulerContextSingleton = &SchedulerContext{
-		agentCtx: ctx,
+		agentCtx:      ctx,
+		tasksLocation: cmd.tasksLocation,
 	}
 
 	configureTasks(cmd.schedConfigBytes)
 	startTasks()
 
+	if cmd.metricsAddr != "" {
+		if err := metrics.Serve(ctx, cmd.metricsAddr, collectMetrics); err != nil {
+			return 1, err
+		}
+	}
+
 	if err := cmd.ListenAndServe(ctx); err != nil {
 		return 1, err
 	}
@@ -213,7 +209,9 @@ func (cmd *SchedulerStart) ListenAndServe(ctx *appcontext.AppContext) error {
 	}
 }
 
-func handleClient(_ *appcontext.AppContext, conn net.Conn) {
+// handleClient runs the command sent by a client, with the protocol of
+// the agent, relaying its output.
+func handleClient(ctx *appcontext.AppContext, conn net.Conn) {
 	defer conn.Close()
 
 	encoder := msgpack.NewEncoder(conn)
@@ -229,30 +227,59 @@ func handleClient(_ *appcontext.AppContext, conn net.Conn) {
 		return
 	}
 
-	// depending on packet, call proper handler
+	var mtx sync.Mutex
+	write := func(packet agent.Packet) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		encoder.Encode(&packet)
+	}
 
-	var request scheduler.Request
-	if err := decoder.Decode(&request); err != nil {
-		return
+	status, err := execute(ctx, decoder, write)
+	packet := agent.Packet{
+		Type:     "exit",
+		ExitCode: status,
+	}
+	if err != nil {
+		packet.Err = err.Error()
 	}
+	write(packet)
+}
 
-	var response scheduler.Response
-	switch request.Type {
-	case "stop":
-		if _, err := terminate(); err != nil {
-			response.ExitCode = 1
-			response.Err = err.Error()
-		} else {
-			response.ExitCode = 0
-		}
-	default:
-		response.ExitCode = 1
-		response.Err = fmt.Sprintf("unknown command: %s", request.Type)
+func execute(ctx *appcontext.AppContext, decoder *msgpack.Decoder, write func(agent.Packet)) (int, error) {
+	name, _, request, err := subcommands.DecodeRPC(decoder)
+	if err != nil {
+		return agent.ExitUsage, err
 	}
 
-	if err := encoder.Encode(response); err != nil {
-		return
+	subcommand, _, _ := subcommands.Lookup(name)
+	cmd, ok := subcommand.(control)
+	if !ok {
+		return agent.ExitUsage, fmt.Errorf("unknown command: %s", strings.Join(name, " "))
+	}
+	if err := msgpack.Unmarshal(request, &cmd); err != nil {
+		return agent.ExitUsage, fmt.Errorf("failed to decode client request: %w", err)
 	}
+
+	clientContext := appcontext.NewAppContextFrom(ctx)
+	defer clientContext.Close()
+	clientContext.Stdout = &packetWriter{typ: "stdout", write: write}
+	clientContext.Stderr = &packetWriter{typ: "stderr", write: write}
+
+	return cmd.control(clientContext)
+}
+
+// packetWriter sends the output of a command to the client.
+type packetWriter struct {
+	typ   string
+	write func(agent.Packet)
+}
+
+func (w *packetWriter) Write(p []byte) (int, error) {
+	w.write(agent.Packet{
+		Type: w.typ,
+		Data: bytes.Clone(p),
+	})
+	return len(p), nil
 }
 
 func startTasks() (int, error) {
@@ -269,7 +296,8 @@ func startTasks() (int, error) {
 
 	// this needs to execute in the agent context, not the client context
 	schedulerContextSingleton.schedulerCtx = appcontext.NewAppContextFrom(schedulerContextSingleton.agentCtx)
-	go scheduler.NewScheduler(schedulerContextSingleton.schedulerCtx, schedulerContextSingleton.schedulerConfig).Run()
+	schedulerContextSingleton.scheduler = scheduler.NewScheduler(schedulerContextSingleton.schedulerCtx, schedulerContextSingleton.schedulerConfig)
+	go schedulerContextSingleton.scheduler.Run()
 
 	schedulerContextSingleton.schedulerState = AGENT_SCHEDULER_RUNNING
 
@@ -289,12 +317,41 @@ func configureTasks(schedConfigBytes []byte) (int, error) {
 	schedulerContextSingleton.mtx.Lock()
 	defer schedulerContextSingleton.mtx.Unlock()
 
-	if schedulerContextSingleton.schedulerCtx != nil {
-		schedulerContextSingleton.schedulerCtx.Cancel()
-		schedulerContextSingleton.schedulerCtx = appcontext.NewAppContextFrom(schedulerContextSingleton.agentCtx)
-		go scheduler.NewScheduler(schedulerContextSingleton.schedulerCtx, schedConfig).Run()
+	if schedulerContextSingleton.scheduler != nil {
+		schedulerContextSingleton.scheduler.Reload(schedConfig)
 	}
 
 	schedulerContextSingleton.schedulerConfig = schedConfig
 	return 0, nil
 }
+
+// reloadTasks hot-reloads the tasks configuration, either the one provided
+// or the one found where the scheduler was started from.
+func reloadTasks(schedConfigBytes []byte) (int, error) {
+	if len(schedConfigBytes) == 0 {
+		var err error
+		schedConfigBytes, err = loadTasksConfiguration(schedulerContextSingleton.tasksLocation)
+		if err != nil {
+			return 1, err
+		}
+	}
+	return configureTasks(schedConfigBytes)
+}
+
+func currentScheduler() (*scheduler.Scheduler, error) {
+	schedulerContextSingleton.mtx.Lock()
+	defer schedulerContextSingleton.mtx.Unlock()
+
+	if schedulerContextSingleton.scheduler == nil {
+		return nil, fmt.Errorf("agent scheduler is not running")
+	}
+	return schedulerContextSingleton.scheduler, nil
+}
+
+func collectMetrics(w *metrics.Writer) error {
+	sched, err := currentScheduler()
+	if err != nil {
+		return nil
+	}
+	return sched.Collect(w)
+}
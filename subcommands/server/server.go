@@ -17,11 +17,17 @@
 package server
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"net"
+	"path/filepath"
+	"time"
 
 	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/storage"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/network"
 	"github.com/PlakarKorp/plakar/server/httpd"
 	"github.com/PlakarKorp/plakar/subcommands"
 )
@@ -32,6 +38,8 @@ func init() {
 
 func (cmd *Server) Parse(ctx *appcontext.AppContext, args []string) error {
 	var opt_allowdelete bool
+	var opt_quotaSize string
+	var opt_quotaObjects int64
 	flags := flag.NewFlagSet("server", flag.ExitOnError)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
@@ -41,8 +49,55 @@ func (cmd *Server) Parse(ctx *appcontext.AppContext, args []string) error {
 
 	flags.StringVar(&cmd.ListenAddr, "listen", "localhost:9876", "address to listen on")
 	flags.BoolVar(&opt_allowdelete, "allow-delete", false, "enable delete operations")
+	flags.BoolVar(&cmd.AppendOnly, "append-only", false, "never delete nor overwrite states and packfiles")
+	flags.DurationVar(&cmd.Retention, "retention", 0, "keep states and packfiles immutable until they are this old")
+	flags.StringVar(&opt_quotaSize, "quota-size", "", "maximum size of the store, like 500GB")
+	flags.Int64Var(&opt_quotaObjects, "quota-objects", 0, "maximum number of states and packfiles in the store")
+	flags.StringVar(&cmd.TLS.CertFile, "cert", "", "TLS certificate file")
+	flags.StringVar(&cmd.TLS.KeyFile, "key", "", "TLS private key file")
+	flags.BoolVar(&cmd.TLS.SelfSigned, "tls", false, "serve TLS with a self-signed certificate if -cert is not given")
+	flags.StringVar(&cmd.TLS.ClientCAFile, "client-ca", "", "CA certificates file to verify client certificates against")
+	flags.StringVar(&cmd.AuthFile, "auth", "", "file granting permissions to tokens and client certificates")
+	flags.StringVar(&cmd.ConfigFile, "config", "", "file describing several repositories to serve")
 	flags.Parse(args)
 
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	if cmd.ConfigFile != "" {
+		var conflict string
+		flags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "allow-delete", "append-only", "retention", "auth", "quota-size", "quota-objects":
+				conflict = f.Name
+			}
+		})
+		if conflict != "" {
+			return fmt.Errorf("-%s cannot be used with -config, set it for each repository instead", conflict)
+		}
+	}
+
+	if (cmd.TLS.CertFile == "") != (cmd.TLS.KeyFile == "") {
+		return fmt.Errorf("-cert and -key must be given together")
+	}
+	if cmd.TLS.ClientCAFile != "" && !cmd.TLS.Enabled() {
+		return fmt.Errorf("-client-ca requires TLS, use -cert or -tls")
+	}
+
+	if cmd.AppendOnly && opt_allowdelete {
+		return fmt.Errorf("-append-only and -allow-delete are mutually exclusive")
+	}
+	if cmd.Retention < 0 {
+		return fmt.Errorf("-retention must be positive")
+	}
+
+	quota, err := httpd.ParseQuota(opt_quotaSize, opt_quotaObjects)
+	if err != nil {
+		return err
+	}
+	cmd.Quota = quota
+
 	noDelete := true
 	if opt_allowdelete {
 		noDelete = false
@@ -59,13 +114,200 @@ type Server struct {
 
 	ListenAddr string
 	NoDelete   bool
+	AppendOnly bool
+	Retention  time.Duration
+	Quota      network.Quota
+	TLS        httpd.TLSOptions
+	AuthFile   string
+	ConfigFile string
+}
+
+// tlsConfig returns the TLS configuration to serve with, or nil to serve
+// plain HTTP.
+func (cmd *Server) tlsConfig() (*tls.Config, error) {
+	if !cmd.TLS.Enabled() {
+		return nil, nil
+	}
+
+	host, _, err := net.SplitHostPort(cmd.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
+	return cmd.TLS.Config([]string{host, "localhost", "127.0.0.1", "::1"})
+}
+
+func (cmd *Server) checkAuth(auth *httpd.Auth, source string) error {
+	if auth.HasCertificates() && cmd.TLS.ClientCAFile == "" {
+		return fmt.Errorf("%s: client certificates require -client-ca", source)
+	}
+	return nil
+}
+
+func (cmd *Server) options() (*httpd.Options, error) {
+	opts := &httpd.Options{
+		NoDelete:   cmd.NoDelete,
+		AppendOnly: cmd.AppendOnly,
+		Retention:  cmd.Retention,
+		Quota:      cmd.Quota,
+	}
+
+	if cmd.AuthFile != "" {
+		auth, err := httpd.LoadAuthFile(cmd.AuthFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.checkAuth(auth, cmd.AuthFile); err != nil {
+			return nil, err
+		}
+		opts.Auth = auth
+	}
+
+	config, err := cmd.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config != nil && cmd.TLS.ClientCAFile != "" && opts.Auth == nil {
+		// without an auth file, the client certificate is the credential
+		opts.Auth = httpd.CertificateAuth()
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	opts.TLS = config
+
+	return opts, nil
+}
+
+// statePath returns where to keep what the server records about the store,
+// named after the repository it holds.
+func statePath(ctx *appcontext.AppContext, store storage.Store) (string, error) {
+	serializedConfig, err := store.Open(ctx)
+	if err != nil {
+		return "", err
+	}
+	config, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(ctx.CacheDir, "server", config.RepositoryID.String()), nil
+}
+
+func setStateFiles(ctx *appcontext.AppContext, store storage.Store, opts *httpd.Options) error {
+	path, err := statePath(ctx, store)
+	if err != nil {
+		return err
+	}
+	if opts.AppendOnly || opts.Retention != 0 {
+		opts.LedgerFile = path + ".ledger"
+	}
+	opts.UsageFile = path + ".usage"
+	return nil
+}
+
+func (cmd *Server) logListening(ctx *appcontext.AppContext, config *tls.Config) {
+	scheme := "http"
+	if config != nil {
+		scheme = "https"
+		if cmd.TLS.CertFile == "" {
+			ctx.GetLogger().Info("using a self-signed certificate with fingerprint %s",
+				httpd.Fingerprint(config.Certificates[0].Certificate[0]))
+		}
+	}
+	ctx.GetLogger().Info("listening on %s://%s", scheme, cmd.ListenAddr)
 }
 
 func (cmd *Server) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
-	ctx.GetLogger().Info("listening on http://%s", cmd.ListenAddr)
-	err := httpd.Server(ctx, repo, cmd.ListenAddr, cmd.NoDelete)
+	if cmd.ConfigFile != "" {
+		return cmd.serveRepositories(ctx)
+	}
+
+	opts, err := cmd.options()
+	if err != nil {
+		return 1, err
+	}
+	if err := setStateFiles(ctx, repo.Store(), opts); err != nil {
+		return 1, err
+	}
+
+	cmd.logListening(ctx, opts.TLS)
+	err = httpd.Server(ctx, repo, cmd.ListenAddr, opts)
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// serveRepositories serves the repositories described in the config file.
+func (cmd *Server) serveRepositories(ctx *appcontext.AppContext) (int, error) {
+	config, err := httpd.LoadConfig(cmd.ConfigFile)
+	if err != nil {
+		return 1, err
+	}
+
+	tlsConfig, err := cmd.tlsConfig()
 	if err != nil {
 		return 1, err
 	}
+
+	var repositories []httpd.Repository
+	for _, rc := range config.Repositories {
+		repo, err := cmd.repository(ctx, &rc)
+		if err != nil {
+			return 1, fmt.Errorf("%s: repository %s: %w", cmd.ConfigFile, rc.Name, err)
+		}
+		defer repo.Store.Close(ctx)
+
+		ctx.GetLogger().Info("serving %s at /r/%s", rc.Store, rc.Name)
+		repositories = append(repositories, *repo)
+	}
+
+	cmd.logListening(ctx, tlsConfig)
+	if err := httpd.MultiServer(ctx, cmd.ListenAddr, tlsConfig, repositories); err != nil {
+		return 1, err
+	}
 	return 0, nil
 }
+
+func (cmd *Server) repository(ctx *appcontext.AppContext, rc *httpd.RepositoryConfig) (*httpd.Repository, error) {
+	storeConfig, err := ctx.Config.GetRepository(rc.Store)
+	if err != nil {
+		return nil, err
+	}
+	store, err := storage.New(ctx.GetInner(), storeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	retention, _ := rc.RetentionPeriod()
+	quota, _ := httpd.ParseQuota(rc.Quota.Size, rc.Quota.Objects)
+	repo := &httpd.Repository{
+		Name:  rc.Name,
+		Store: store,
+		Options: httpd.Options{
+			NoDelete:   !rc.AllowDelete,
+			AppendOnly: rc.AppendOnly,
+			Retention:  retention,
+			Quota:      quota,
+		},
+	}
+
+	if len(rc.Tokens) != 0 || len(rc.Certificates) != 0 {
+		auth, err := httpd.NewAuth(&rc.AuthConfig)
+		if err != nil {
+			store.Close(ctx)
+			return nil, err
+		}
+		if err := cmd.checkAuth(auth, cmd.ConfigFile); err != nil {
+			store.Close(ctx)
+			return nil, err
+		}
+		repo.Options.Auth = auth
+	} else if cmd.TLS.ClientCAFile != "" {
+		repo.Options.Auth = httpd.CertificateAuth()
+	}
+
+	if err := setStateFiles(ctx, store, &repo.Options); err != nil {
+		store.Close(ctx)
+		return nil, err
+	}
+
+	return repo, nil
+}
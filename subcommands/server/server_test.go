@@ -29,6 +29,8 @@ func TestExecuteCmdServerDefault(t *testing.T) {
 	bufErr := bytes.NewBuffer(nil)
 
 	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	// the usage of the repository is recorded in the cache
+	ctx.CacheDir = t.TempDir()
 	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
 		ptesting.NewMockDir("subdir"),
 		ptesting.NewMockDir("another_subdir"),
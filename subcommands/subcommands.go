@@ -18,6 +18,7 @@ const (
 	BeforeRepositoryOpen
 	AgentSupport
 	IgnoreVersion
+	FormatSupport
 )
 
 type Subcommand interface {
@@ -35,6 +36,12 @@ type Subcommand interface {
 	SetLogInfo(bool)
 	GetLogTraces() string
 	SetLogTraces(string)
+
+	GetAttempt() int
+	SetAttempt(int)
+
+	GetFormat() string
+	SetFormat(string)
 }
 
 type SubcommandBase struct {
@@ -46,6 +53,13 @@ type SubcommandBase struct {
 	// XXX - rework that post-release
 	LogInfo   bool
 	LogTraces string
+
+	// Attempt numbers the runs of a command retried by the scheduler.
+	Attempt int
+
+	// Format is the output format requested with -json or -format,
+	// for the commands registered with FormatSupport.
+	Format string
 }
 
 func (cmd *SubcommandBase) setFlags(flags CommandFlags) {
@@ -88,6 +102,22 @@ func (cmd *SubcommandBase) SetLogTraces(traces string) {
 	cmd.LogTraces = traces
 }
 
+func (cmd *SubcommandBase) GetAttempt() int {
+	return cmd.Attempt
+}
+
+func (cmd *SubcommandBase) SetAttempt(attempt int) {
+	cmd.Attempt = attempt
+}
+
+func (cmd *SubcommandBase) GetFormat() string {
+	return cmd.Format
+}
+
+func (cmd *SubcommandBase) SetFormat(format string) {
+	cmd.Format = format
+}
+
 func (cmd *SubcommandBase) GetRepositorySecret() []byte {
 	return cmd.RepositorySecret
 }
@@ -42,6 +42,9 @@ type Sync struct {
 	PackfileTempStorage string
 
 	SrcLocateOptions *locate.LocateOptions
+
+	// Synchronized lists the snapshots transferred by Execute.
+	Synchronized []objects.MAC
 }
 
 func init() {
@@ -167,6 +170,66 @@ func (cmd *Sync) Parse(ctx *appcontext.AppContext, args []string) error {
 	return nil
 }
 
+// SetupPeerSecret derives the key of the peer repository from its
+// configuration, for when the command was not parsed in this process and
+// the key could not be prompted for.
+func (cmd *Sync) SetupPeerSecret(ctx *appcontext.AppContext) error {
+	storeConfig, err := ctx.Config.GetRepository(cmd.PeerRepositoryLocation)
+	if err != nil {
+		return fmt.Errorf("peer repository: %w", err)
+	}
+
+	peerStore, peerStoreSerializedConfig, err := storage.Open(ctx.GetInner(), storeConfig)
+	if err != nil {
+		return fmt.Errorf("failed to open peer storage: %w", err)
+	}
+	peerStore.Close(ctx)
+
+	peerStoreConfig, err := storage.NewConfigurationFromWrappedBytes(peerStoreSerializedConfig)
+	if err != nil {
+		return fmt.Errorf("failed to parse peer configuration: %w", err)
+	}
+
+	if peerStoreConfig.Encryption == nil {
+		return nil
+	}
+
+	getKey := func() ([]byte, error) {
+		if key := cmd.PeerRepositorySecret; key != nil {
+			return key, nil
+		}
+
+		passphrase, ok := storeConfig["passphrase"]
+		if !ok {
+			cmd, ok := storeConfig["passphrase_cmd"]
+			if !ok {
+				return nil, fmt.Errorf("no passphrase specified")
+			}
+			passphrase, err = utils.GetPassphraseFromCommand(cmd)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read passphrase from command: %w", err)
+			}
+		}
+
+		key, err := encryption.DeriveKey(peerStoreConfig.Encryption.KDFParams, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key: %w", err)
+		}
+		return key, nil
+	}
+
+	key, err := getKey()
+	if err != nil {
+		return err
+	}
+	if !encryption.VerifyCanary(peerStoreConfig.Encryption, key) {
+		return fmt.Errorf("failed to verify key")
+	}
+
+	cmd.PeerRepositorySecret = key
+	return nil
+}
+
 func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
 	storeConfig, err := ctx.Config.GetRepository(cmd.PeerRepositoryLocation)
 	if err != nil {
@@ -274,6 +337,7 @@ func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 				snapshotID[:4], srcLocation, err)
 		} else {
 			srcSynced++
+			cmd.Synchronized = append(cmd.Synchronized, snapshotID)
 		}
 	}
 
@@ -301,6 +365,7 @@ func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 					snapshotID[:4], dstLocation, err)
 			} else {
 				dstSynced++
+				cmd.Synchronized = append(cmd.Synchronized, snapshotID)
 			}
 		}
 		ctx.GetLogger().Info("sync: synchronization between %s and %s completed: %d snapshots synchronized",
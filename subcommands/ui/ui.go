@@ -22,6 +22,7 @@ package ui
 import (
 	"flag"
 	"fmt"
+	"path/filepath"
 
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
@@ -46,12 +47,21 @@ func (cmd *Ui) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags.BoolVar(&cmd.Cors, "cors", false, "enable CORS")
 	flags.BoolVar(&cmd.NoAuth, "no-auth", false, "don't use authentication")
 	flags.BoolVar(&cmd.NoSpawn, "no-spawn", false, "don't spawn browser")
+	flags.StringVar(&cmd.RestoreRoot, "restore-root", "", "directory the tokens without the admin scope restore to")
 	flags.Parse(args)
 
 	if flags.NArg() > 0 {
 		return fmt.Errorf("Too many arguments")
 	}
 
+	if cmd.RestoreRoot != "" {
+		root, err := filepath.Abs(cmd.RestoreRoot)
+		if err != nil {
+			return fmt.Errorf("invalid restore root: %w", err)
+		}
+		cmd.RestoreRoot = root
+	}
+
 	cmd.RepositorySecret = ctx.GetSecret()
 
 	return nil
@@ -60,17 +70,19 @@ func (cmd *Ui) Parse(ctx *appcontext.AppContext, args []string) error {
 type Ui struct {
 	subcommands.SubcommandBase
 
-	Addr    string
-	Cors    bool
-	NoAuth  bool
-	NoSpawn bool
+	Addr        string
+	Cors        bool
+	NoAuth      bool
+	NoSpawn     bool
+	RestoreRoot string
 }
 
 func (cmd *Ui) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
 	ui_opts := v2.UiOptions{
-		NoSpawn: cmd.NoSpawn,
-		Cors:    cmd.Cors,
-		Token:   "",
+		NoSpawn:     cmd.NoSpawn,
+		Cors:        cmd.Cors,
+		Token:       "",
+		RestoreRoot: cmd.RestoreRoot,
 	}
 
 	if !cmd.NoAuth {
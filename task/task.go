@@ -1,19 +1,33 @@
 package task
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/metrics"
 	"github.com/PlakarKorp/plakar/reporting"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/subcommands/backup"
 	"github.com/PlakarKorp/plakar/subcommands/check"
 	"github.com/PlakarKorp/plakar/subcommands/maintenance"
+	"github.com/PlakarKorp/plakar/subcommands/prune"
 	"github.com/PlakarKorp/plakar/subcommands/restore"
 	"github.com/PlakarKorp/plakar/subcommands/rm"
 	"github.com/PlakarKorp/plakar/subcommands/sync"
 )
 
+var (
+	taskRuns = metrics.Default.NewCounter("plakar_tasks_total",
+		"Tasks run, by kind and status.", "kind", "status")
+	taskDuration = metrics.Default.NewHistogram("plakar_task_duration_seconds",
+		"Duration of the tasks, by kind and status.", metrics.DurationBuckets, "kind", "status")
+	taskLastSuccess = metrics.Default.NewGauge("plakar_task_last_success_timestamp_seconds",
+		"When a task of a kind last succeeded on a repository.", "kind", "repository")
+)
+
 func RunCommand(ctx *appcontext.AppContext, cmd subcommands.Subcommand, repo *repository.Repository, taskName string) (int, error) {
 	location := ""
 	var err error
@@ -40,6 +54,8 @@ func RunCommand(ctx *appcontext.AppContext, cmd subcommands.Subcommand, repo *re
 		taskKind = "sync"
 	case *rm.Rm:
 		taskKind = "rm"
+	case *prune.Prune:
+		taskKind = "prune"
 	case *maintenance.Maintenance:
 		taskKind = "maintenance"
 	default:
@@ -47,11 +63,20 @@ func RunCommand(ctx *appcontext.AppContext, cmd subcommands.Subcommand, repo *re
 	}
 
 	report.TaskStart(taskKind, taskName)
+	if attempt := cmd.GetAttempt(); attempt > 0 {
+		report.WithAttempt(attempt)
+	}
 	if repo != nil {
 		report.WithRepositoryName(location)
 		report.WithRepository(repo)
 	}
 
+	collector := reporting.NewCollector(ctx.Events())
+	var wbytes int64
+	if repo != nil {
+		wbytes = repo.WBytes()
+	}
+
 	var status int
 	var snapshotID objects.MAC
 	var warning error
@@ -65,6 +90,38 @@ func RunCommand(ctx *appcontext.AppContext, cmd subcommands.Subcommand, repo *re
 		status, err = cmd.Execute(ctx, repo)
 	}
 
+	duration := time.Since(report.Task.StartTime)
+	collector.Stop()
+	switch cmd := cmd.(type) {
+	case *backup.Backup:
+		stats := collector.Backup()
+		if repo != nil {
+			stats.BytesWritten = repo.WBytes() - wbytes
+		}
+		report.WithBackup(stats)
+	case *check.Check:
+		report.WithCheck(collector.Check())
+	case *sync.Sync:
+		report.WithSync(&reporting.ReportSync{
+			Count:     len(cmd.Synchronized),
+			Snapshots: snapshotIDs(cmd.Synchronized),
+		})
+	case *rm.Rm:
+		report.WithRm(&reporting.ReportRm{
+			Count:     len(cmd.Removed),
+			Snapshots: snapshotIDs(cmd.Removed),
+		})
+	case *maintenance.Maintenance:
+		report.WithMaintenance(&reporting.ReportMaintenance{
+			ColouredPackfiles: cmd.Stats.ColouredPackfiles,
+			OrphanedPackfiles: cmd.Stats.OrphanedPackfiles,
+			SweptPackfiles:    cmd.Stats.SweptPackfiles,
+			RemovedBlobs:      cmd.Stats.RemovedBlobs,
+			ReclaimedBytes:    cmd.Stats.ReclaimedBytes,
+		})
+	}
+	report.WithResources(collector.Resources())
+
 	if status == 0 {
 		if warning != nil {
 			report.TaskWarning("warning: %s", warning)
@@ -77,5 +134,26 @@ func RunCommand(ctx *appcontext.AppContext, cmd subcommands.Subcommand, repo *re
 
 	reporter.StopAndWait()
 
+	if taskKind != "" {
+		outcome := "failure"
+		if status == 0 && warning != nil {
+			outcome = "warning"
+		} else if status == 0 {
+			outcome = "success"
+			taskLastSuccess.Set(float64(time.Now().Unix()), taskKind, location)
+		}
+		taskRuns.Inc(taskKind, outcome)
+		taskDuration.Observe(duration.Seconds(), taskKind, outcome)
+	}
+
 	return status, err
 }
+
+// snapshotIDs returns the snapshot identifiers as reported.
+func snapshotIDs(macs []objects.MAC) []string {
+	ids := make([]string, 0, len(macs))
+	for _, mac := range macs {
+		ids = append(ids, fmt.Sprintf("%x", mac[:]))
+	}
+	return ids
+}
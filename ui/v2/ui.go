@@ -36,6 +36,7 @@ type UiOptions struct {
 	NoSpawn        bool
 	Cors           bool
 	Token          string
+	RestoreRoot    string
 }
 
 //go:embed all:frontend/*
@@ -43,7 +44,7 @@ var content embed.FS
 
 func Ui(repo *repository.Repository, ctx *appcontext.AppContext, addr string, opts *UiOptions) error {
 	server := http.NewServeMux()
-	api.SetupRoutes(server, repo, ctx, opts.Token)
+	api.SetupRoutes(server, repo, ctx, opts.Token, opts.RestoreRoot)
 
 	statics, err := fs.Sub(content, "frontend")
 	if err != nil {
@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// The scopes of the API tokens. Download and restore include browse, and
+// admin includes them all.
+const (
+	ScopeBrowse   = "browse"
+	ScopeDownload = "download"
+	ScopeRestore  = "restore"
+	ScopeAdmin    = "admin"
+)
+
+var APITokenScopes = []string{ScopeBrowse, ScopeDownload, ScopeRestore, ScopeAdmin}
+
+const apiTokenPrefix = "plakar_"
+
+// APIToken grants access to the API served by plakar ui. Only a hash of
+// its secret is kept.
+type APIToken struct {
+	Hash    string    `yaml:"hash"`
+	Scopes  []string  `yaml:"scopes"`
+	Paths   []string  `yaml:"paths,omitempty"`
+	Created time.Time `yaml:"created"`
+	Expires time.Time `yaml:"expires,omitempty"`
+}
+
+func hashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIToken returns a token and its secret, which is only known to the
+// caller. A zero expires never expires, and no paths means the whole
+// snapshots are reachable.
+func NewAPIToken(scopes []string, paths []string, expires time.Time) (string, *APIToken, error) {
+	for _, scope := range scopes {
+		if !slices.Contains(APITokenScopes, scope) {
+			return "", nil, fmt.Errorf("invalid scope %q, valid scopes are %s", scope, strings.Join(APITokenScopes, ", "))
+		}
+	}
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("no scope specified")
+	}
+
+	var cleaned []string
+	for _, p := range paths {
+		if !strings.HasPrefix(p, "/") {
+			return "", nil, fmt.Errorf("invalid path %q, paths must be absolute", p)
+		}
+		cleaned = append(cleaned, path.Clean(p))
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	secret := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+
+	return secret, &APIToken{
+		Hash:    hashAPIToken(secret),
+		Scopes:  scopes,
+		Paths:   cleaned,
+		Created: time.Now(),
+		Expires: expires,
+	}, nil
+}
+
+// Matches tells in constant time whether secret is the one of the token.
+func (t *APIToken) Matches(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashAPIToken(secret)), []byte(t.Hash)) == 1
+}
+
+func (t *APIToken) Expired() bool {
+	return !t.Expires.IsZero() && time.Now().After(t.Expires)
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	if slices.Contains(t.Scopes, ScopeAdmin) || slices.Contains(t.Scopes, scope) {
+		return true
+	}
+	if scope == ScopeBrowse {
+		return slices.Contains(t.Scopes, ScopeDownload) || slices.Contains(t.Scopes, ScopeRestore)
+	}
+	return false
+}
+
+// AllowsPath tells whether a path within a snapshot is reachable with the
+// token.
+func (t *APIToken) AllowsPath(pathname string) bool {
+	if len(t.Paths) == 0 {
+		return true
+	}
+	pathname = path.Clean("/" + pathname)
+	for _, prefix := range t.Paths {
+		if prefix == "/" || pathname == prefix || strings.HasPrefix(pathname, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type tokensConfig struct {
+	Version string               `yaml:"version"`
+	Tokens  map[string]*APIToken `yaml:"tokens"`
+}
+
+func (c *tokensConfig) Has(name string) bool {
+	_, ok := c.Tokens[name]
+	return ok
+}
+
+func (c *tokensConfig) Get(name string) (*APIToken, bool) {
+	token, ok := c.Tokens[name]
+	return token, ok
+}
+
+func (c *tokensConfig) Add(name string, token *APIToken) {
+	c.Tokens[name] = token
+}
+
+func (c *tokensConfig) Remove(name string) {
+	delete(c.Tokens, name)
+}
+
+// Lookup returns the name and the token matching a secret.
+func (c *tokensConfig) Lookup(secret string) (string, *APIToken, bool) {
+	for name, token := range c.Tokens {
+		if token.Matches(secret) {
+			return name, token, true
+		}
+	}
+	return "", nil, false
+}
+
+func (c *tokensConfig) SaveToFile(filename string) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	err = yaml.NewEncoder(tmpFile).Encode(c)
+	tmpFile.Close()
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), filename)
+	}
+	os.Remove(tmpFile.Name())
+	return err
+}
+
+func (c *tokensConfig) Load(rd io.Reader) error {
+	return yaml.NewDecoder(rd).Decode(c)
+}
+
+func LoadTokensConfigFile(filename string) (*tokensConfig, error) {
+	var cfg tokensConfig
+	cfg.Version = "v1.0.0"
+	cfg.Tokens = make(map[string]*APIToken)
+
+	rd, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &cfg, nil
+		}
+		return nil, err
+	}
+	defer rd.Close()
+
+	if err := cfg.Load(rd); err != nil {
+		return nil, err
+	}
+	if cfg.Tokens == nil {
+		cfg.Tokens = make(map[string]*APIToken)
+	}
+
+	return &cfg, nil
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAPIToken(t *testing.T) {
+	secret, token, err := NewAPIToken([]string{ScopeDownload}, []string{"/home/alice/"}, time.Time{})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(secret, apiTokenPrefix))
+	require.NotContains(t, token.Hash, secret)
+	require.True(t, token.Matches(secret))
+	require.False(t, token.Matches(secret+"x"))
+	require.Equal(t, []string{"/home/alice"}, token.Paths)
+	require.False(t, token.Expired())
+
+	_, _, err = NewAPIToken([]string{"root"}, nil, time.Time{})
+	require.Error(t, err)
+	_, _, err = NewAPIToken(nil, nil, time.Time{})
+	require.Error(t, err)
+	_, _, err = NewAPIToken([]string{ScopeBrowse}, []string{"relative"}, time.Time{})
+	require.Error(t, err)
+
+	_, token, err = NewAPIToken([]string{ScopeBrowse}, nil, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.True(t, token.Expired())
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	browse := &APIToken{Scopes: []string{ScopeBrowse}}
+	require.True(t, browse.HasScope(ScopeBrowse))
+	require.False(t, browse.HasScope(ScopeDownload))
+	require.False(t, browse.HasScope(ScopeAdmin))
+
+	restore := &APIToken{Scopes: []string{ScopeRestore}}
+	require.True(t, restore.HasScope(ScopeBrowse))
+	require.True(t, restore.HasScope(ScopeRestore))
+	require.False(t, restore.HasScope(ScopeDownload))
+
+	admin := &APIToken{Scopes: []string{ScopeAdmin}}
+	for _, scope := range APITokenScopes {
+		require.True(t, admin.HasScope(scope))
+	}
+}
+
+func TestAPITokenAllowsPath(t *testing.T) {
+	token := &APIToken{}
+	require.True(t, token.AllowsPath("/"))
+
+	token.Paths = []string{"/home/alice", "/etc/hosts"}
+	require.True(t, token.AllowsPath("/home/alice"))
+	require.True(t, token.AllowsPath("/home/alice/docs/a.txt"))
+	require.True(t, token.AllowsPath("home/alice/"))
+	require.True(t, token.AllowsPath("/etc/hosts"))
+	require.False(t, token.AllowsPath("/home/alicia"))
+	require.False(t, token.AllowsPath("/home"))
+	require.False(t, token.AllowsPath("/"))
+	require.False(t, token.AllowsPath(""))
+	require.False(t, token.AllowsPath("/home/alice/../bob"))
+}
+
+func TestTokensConfigFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tokens.yml")
+
+	cfg, err := LoadTokensConfigFile(filename)
+	require.NoError(t, err)
+	require.Empty(t, cfg.Tokens)
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	secret, token, err := NewAPIToken([]string{ScopeBrowse, ScopeDownload}, []string{"/srv"}, expires)
+	require.NoError(t, err)
+	cfg.Add("alice", token)
+	_, other, err := NewAPIToken([]string{ScopeAdmin}, nil, time.Time{})
+	require.NoError(t, err)
+	cfg.Add("bob", other)
+	require.NoError(t, cfg.SaveToFile(filename))
+
+	cfg, err = LoadTokensConfigFile(filename)
+	require.NoError(t, err)
+	require.True(t, cfg.Has("alice"))
+
+	name, loaded, ok := cfg.Lookup(secret)
+	require.True(t, ok)
+	require.Equal(t, "alice", name)
+	require.Equal(t, token.Scopes, loaded.Scopes)
+	require.Equal(t, token.Paths, loaded.Paths)
+	require.True(t, expires.Equal(loaded.Expires))
+
+	bob, ok := cfg.Get("bob")
+	require.True(t, ok)
+	require.True(t, bob.Expires.IsZero())
+
+	cfg.Remove("alice")
+	require.NoError(t, cfg.SaveToFile(filename))
+	cfg, err = LoadTokensConfigFile(filename)
+	require.NoError(t, err)
+	_, _, ok = cfg.Lookup(secret)
+	require.False(t, ok)
+}